@@ -0,0 +1,244 @@
+// Copyright Contributors to the Open Cluster Management project
+
+// kubectl-kac is the kubectl plugin to inspect and edit the KlusterletAddonConfigs of the managed clusters on the
+// hub, it is run as "kubectl kac <command>" if it is in the PATH.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	clusterinfov1beta1 "github.com/stolostron/cluster-lifecycle-api/clusterinfo/v1beta1"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	managedclusterv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+	clusterv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
+	manifestworkv1 "open-cluster-management.io/api/work/v1"
+
+	"github.com/stolostron/klusterlet-addon-controller/pkg/apis"
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/cli"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/migration"
+	"github.com/stolostron/klusterlet-addon-controller/version"
+)
+
+const usage = `Inspect and edit the KlusterletAddonConfigs of the managed clusters.
+
+Usage:
+  kubectl kac [--kubeconfig=<path>] [--context=<name>] <command> [flags] [args]
+
+Commands:
+  list [-l <selector>]
+      List the state of the addons of the clusters.
+  enable <addon>... (-l <selector> | --all) [--dry-run]
+      Enable the addons of the clusters.
+  disable <addon>... (-l <selector> | --all) [--dry-run]
+      Disable the addons of the clusters.
+  values <cluster> [<addon>]
+      Show the decoded values of the addons of a cluster.
+  explain <cluster> <addon>
+      Explain why an addon of a cluster is skipped or held by the controller.
+  plan <cluster> [--hub-version=<version>] [<controller flags>]
+      Show the changes the controller would apply to the addons of a cluster.
+  export [-l <selector>]
+      Export the KlusterletAddonConfigs of the clusters as yaml.
+  import -f <file> [--dry-run]
+      Create or update the KlusterletAddonConfigs from yaml, the file - is stdin.
+  migrate [<addon>...] [--namespace=<namespace>] [--cluster-set=<name>] [--apply | --switch-over]
+          [--timeout=<duration>] [<controller flags>]
+      Migrate the addons of the KlusterletAddonConfigs to the Placements of the ClusterManagementAddOns. The plan is
+      shown unless --apply creates the Placements, or --switch-over also switches the install strategies once the
+      Placements select the same clusters. The controller flags decide the hosted mode and the default image
+      pull secret and policy of the addons.
+`
+
+func main() {
+	var kubeContext string
+	flag.StringVar(&kubeContext, "context", "", "The name of the kubeconfig context to use.")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(context.Background(), kubeContext, flag.Arg(0), flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, kubeContext, command string, args []string) error {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	selector := fs.String("l", "", "The label selector of the managed clusters.")
+	all := fs.Bool("all", false, "Select all the managed clusters.")
+	dryRun := fs.Bool("dry-run", false, "Only show the changes without applying them.")
+	file := fs.String("f", "", "The file of the KlusterletAddonConfigs, - is stdin.")
+	hubVersion := fs.String("hub-version", os.Getenv("HUB_VERSION"),
+		"The version of the hub which selects the image manifest.")
+	namespace := fs.String("namespace", migration.DefaultNamespace,
+		"The namespace of the Placements and the AddOnDeploymentConfigs of the migration.")
+	clusterSet := fs.String("cluster-set", migration.DefaultClusterSet,
+		"The ManagedClusterSet of the clusters bound to the namespace of the migration.")
+	apply := fs.Bool("apply", false, "Create the Placements and the AddOnDeploymentConfigs of the migration.")
+	switchOver := fs.Bool("switch-over", false,
+		"Apply the migration, and switch the install strategies once the Placements select the same clusters.")
+	timeout := fs.Duration("timeout", 5*time.Minute, "The timeout of the Placements to select the clusters.")
+	controllerOpts := common.NewOptions()
+	if command == "plan" || command == "migrate" {
+		controllerOpts.AddFlags(fs)
+	}
+	positional, err := parseArgs(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	clusterSelector, err := labels.Parse(*selector)
+	if err != nil {
+		return fmt.Errorf("invalid selector %q: %v", *selector, err)
+	}
+
+	cmd, err := newCommand(kubeContext)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "list":
+		return cmd.List(ctx, clusterSelector)
+	case "enable", "disable":
+		if len(positional) == 0 {
+			return fmt.Errorf("no addons to %s", command)
+		}
+		if clusterSelector.Empty() && !*all {
+			return fmt.Errorf("select the clusters with -l <selector>, or all the clusters with --all")
+		}
+		return cmd.SetAddons(ctx, clusterSelector, splitAddons(positional), command == "enable", *dryRun)
+	case "values":
+		if len(positional) == 0 || len(positional) > 2 {
+			return fmt.Errorf("usage: values <cluster> [<addon>]")
+		}
+		return cmd.Values(ctx, positional[0], strings.Join(positional[1:], ""))
+	case "explain":
+		if len(positional) != 2 {
+			return fmt.Errorf("usage: explain <cluster> <addon>")
+		}
+		return cmd.Explain(ctx, positional[0], positional[1])
+	case "plan":
+		if len(positional) != 1 {
+			return fmt.Errorf("usage: plan <cluster> [<controller flags>]")
+		}
+		if err := controllerOpts.Validate(); err != nil {
+			return err
+		}
+		// the images of the addons are overridden by the image manifest of the hub version
+		version.Version = *hubVersion
+		if err := agentv1.LoadImages(cmd.Client); err != nil {
+			return err
+		}
+		return cmd.Plan(ctx, positional[0], controllerOpts)
+	case "export":
+		return cmd.Export(ctx, clusterSelector)
+	case "import":
+		in, err := openFile(*file)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		return cmd.Import(ctx, in, *dryRun)
+	case "migrate":
+		if err := controllerOpts.Validate(); err != nil {
+			return err
+		}
+		opts := migration.Options{Namespace: *namespace, ClusterSet: *clusterSet, Addons: splitAddons(positional),
+			Controller: controllerOpts}
+		return cmd.Migrate(ctx, opts, *apply, *switchOver, *timeout)
+	}
+	return fmt.Errorf("unknown command %q, run kubectl kac --help for the commands", command)
+}
+
+// parseArgs parses the flags which are before, after or between the positional arguments, and returns the
+// positional arguments
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// splitAddons returns the addon names of the arguments, an argument can be a comma separated list of addons
+func splitAddons(args []string) []string {
+	addons := []string{}
+	for _, arg := range args {
+		for _, addon := range strings.Split(arg, ",") {
+			if addon = strings.TrimSpace(addon); len(addon) != 0 {
+				addons = append(addons, addon)
+			}
+		}
+	}
+	return addons
+}
+
+func openFile(file string) (io.ReadCloser, error) {
+	switch file {
+	case "":
+		return nil, fmt.Errorf("the file of the KlusterletAddonConfigs is required, set it with -f <file>")
+	case "-":
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(file)
+}
+
+func newCommand(kubeContext string) (*cli.Command, error) {
+	cfg, err := config.GetConfigWithContext(kubeContext)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the kubeconfig: %v", err)
+	}
+
+	scheme := runtime.NewScheme()
+	for _, addToScheme := range []func(*runtime.Scheme) error{
+		clientgoscheme.AddToScheme,
+		apis.AddToScheme,
+		managedclusterv1.AddToScheme,
+		addonv1alpha1.AddToScheme,
+		manifestworkv1.AddToScheme,
+		clusterinfov1beta1.AddToScheme,
+		clusterv1beta1.AddToScheme,
+		clusterv1beta2.AddToScheme,
+	} {
+		if err := addToScheme(scheme); err != nil {
+			return nil, err
+		}
+	}
+
+	runtimeClient, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize a client connection to the cluster: %v", err)
+	}
+	return &cli.Command{Client: runtimeClient, Out: os.Stdout}, nil
+}
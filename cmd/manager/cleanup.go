@@ -0,0 +1,114 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	"github.com/stolostron/klusterlet-addon-controller/pkg/cleanup"
+)
+
+// cleanupCommand is the subcommand which removes the klusterlet addon resources instead of running the controller
+const cleanupCommand = "cleanup"
+
+const cleanupUsage = `Remove the klusterlet addon resources.
+
+Usage:
+  klusterlet-addon-controller cleanup self-destruct [flags]
+      Remove the klusterlet and the legacy klusterlet addon components from the managed cluster.
+  klusterlet-addon-controller cleanup hub-detach --cluster=<name|all> [flags]
+      Remove the managed clusters and their klusterlet addon resources from the hub.
+
+Flags:
+`
+
+// runCleanup runs the cleanup subcommand with the arguments after the subcommand name
+func runCleanup(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet(cleanupCommand, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), cleanupUsage)
+		fs.PrintDefaults()
+	}
+	opts := cleanup.NewOptions()
+	opts.AddFlags(fs)
+	operatorNamespace := fs.String("operator-namespace", envOrDefault("OPERATOR_NAMESPACE",
+		cleanup.DefaultOperatorNamespace), "The namespace of the legacy klusterlet addon operator, for self-destruct.")
+	klusterletNamespace := fs.String("klusterlet-namespace", envOrDefault("KLUSTERLET_NAMESPACE",
+		cleanup.DefaultKlusterletNamespace), "The namespace of the klusterlet agents, for self-destruct.")
+	serviceAccount := fs.String("service-account", os.Getenv("SERVICE_ACCOUNT"),
+		"The namespace/name of the service account running the cleanup, it and the ClusterRoleBinding of the same "+
+			"name are removed at last, for self-destruct.")
+	clusterName := fs.String("cluster", "", "The name of the managed cluster, or all, for hub-detach.")
+
+	if len(args) == 0 {
+		fs.Usage()
+		return fmt.Errorf("the cleanup command is required")
+	}
+	command := args[0]
+	err := fs.Parse(args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	cfg, err := config.GetConfig()
+	if err != nil {
+		return fmt.Errorf("unable to get config: %v", err)
+	}
+	runtimeClient, err := client.New(cfg, client.Options{})
+	if err != nil {
+		return fmt.Errorf("failed to initialize a client connection to the cluster: %v", err)
+	}
+	cleaner := cleanup.NewCleaner(runtimeClient, os.Stdout, opts)
+
+	switch command {
+	case "self-destruct":
+		serviceAccountName := types.NamespacedName{}
+		if len(*serviceAccount) != 0 {
+			parts := strings.Split(*serviceAccount, "/")
+			if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+				return fmt.Errorf("the service account %q is not in the format namespace/name", *serviceAccount)
+			}
+			serviceAccountName = types.NamespacedName{Namespace: parts[0], Name: parts[1]}
+		}
+		return cleaner.SelfDestruct(ctx, *operatorNamespace, *klusterletNamespace, serviceAccountName)
+	case "hub-detach":
+		switch *clusterName {
+		case "":
+			return fmt.Errorf("the cluster is required, set it with --cluster=<name|all>")
+		case "all":
+			clusterNames, err := cleaner.ManagedClusterNames(ctx)
+			if err != nil {
+				return err
+			}
+			return cleaner.HubDetach(ctx, clusterNames...)
+		}
+		return cleaner.HubDetach(ctx, *clusterName)
+	}
+	fs.Usage()
+	return fmt.Errorf("unknown cleanup command %q", command)
+}
+
+func envOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); len(value) != 0 {
+		return value
+	}
+	return defaultValue
+}
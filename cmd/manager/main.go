@@ -13,13 +13,22 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"strings"
+	// the time zones of the maintenance windows are loaded without the time zone database of the image
+	_ "time/tzdata"
 
 	ocinfrav1 "github.com/openshift/api/config/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -28,13 +37,16 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
+	clusterinfov1beta1 "github.com/stolostron/cluster-lifecycle-api/clusterinfo/v1beta1"
 	"github.com/stolostron/klusterlet-addon-controller/pkg/apis"
 	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
 	"github.com/stolostron/klusterlet-addon-controller/pkg/controller"
 	"github.com/stolostron/klusterlet-addon-controller/version"
 
 	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
 	managedclusterv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
 	manifestworkv1 "open-cluster-management.io/api/work/v1"
 )
 
@@ -43,6 +55,10 @@ var (
 	metricsHost       = "0.0.0.0"
 	metricsPort int32 = 8383
 )
+
+// watchNamespaceEnvVar is the env of the namespaces watched by the manager
+const watchNamespaceEnvVar = "WATCH_NAMESPACE"
+
 var (
 	setupLog = logf.Log.WithName("setup")
 )
@@ -55,13 +71,29 @@ func printVersion() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == cleanupCommand {
+		ctrl.SetLogger(zap.New())
+		if err := runCleanup(signals.SetupSignalHandler(), os.Args[2:]); err != nil {
+			setupLog.Error(err, "cleanup failed")
+			os.Exit(1)
+		}
+		return
+	}
+
 	var metricsAddr string
+	controllerOpts := common.NewOptions()
 
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	controllerOpts.AddFlags(flag.CommandLine)
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New())
 
+	if err := controllerOpts.Validate(); err != nil {
+		setupLog.Error(err, "invalid controller options")
+		os.Exit(1)
+	}
+
 	// if the controller is deployed by MCH, the env HUB_VERSION is required to set the MCH version.
 	// otherwise the env HUB_VERSION is not required.
 	version.Version = os.Getenv("HUB_VERSION")
@@ -107,8 +139,9 @@ func main() {
 		Metrics: metricsserver.Options{
 			BindAddress: fmt.Sprintf("%s:%d", metricsHost, metricsPort),
 		},
+		Cache:            newCacheOptions(getWatchNamespaces(), controllerOpts),
 		LeaderElection:   true,
-		LeaderElectionID: "klusterlet-addon-controller-lock",
+		LeaderElectionID: controllerOpts.Shard.LeaderElectionID("klusterlet-addon-controller-lock"),
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -128,11 +161,21 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := clusterv1beta2.AddToScheme(mgr.GetScheme()); err != nil {
+		log.Error(err, "")
+		os.Exit(1)
+	}
+
 	if err := manifestworkv1.AddToScheme(mgr.GetScheme()); err != nil {
 		log.Error(err, "")
 		os.Exit(1)
 	}
 
+	if err := clusterinfov1beta1.AddToScheme(mgr.GetScheme()); err != nil {
+		log.Error(err, "")
+		os.Exit(1)
+	}
+
 	if err := ocinfrav1.AddToScheme(mgr.GetScheme()); err != nil {
 		log.Error(err, "")
 		os.Exit(1)
@@ -149,7 +192,7 @@ func main() {
 	}
 
 	// Setup all Controllers
-	if err := controller.AddToManager(mgr, kubeClient, dynamicClient); err != nil {
+	if err := controller.AddToManager(mgr, kubeClient, dynamicClient, controllerOpts); err != nil {
 		log.Error(err, "")
 		os.Exit(1)
 	}
@@ -163,6 +206,89 @@ func main() {
 	}
 }
 
+// getWatchNamespaces returns the namespaces set in the env WATCH_NAMESPACE, the namespaces are separated by
+// comma. An empty list is returned if the env is not set, the manager watches all namespaces in this case.
+func getWatchNamespaces() []string {
+	var namespaces []string
+	for _, namespace := range strings.Split(os.Getenv(watchNamespaceEnvVar), ",") {
+		if namespace = strings.TrimSpace(namespace); len(namespace) != 0 {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+// newCacheOptions restricts the cache to the given namespaces, and to the ManagedClusters of the shard when
+// the shard has a label selector. The fields not read by the controllers are removed from the cached objects.
+func newCacheOptions(namespaces []string, controllerOpts *common.Options) cache.Options {
+	clusterByObject := cache.ByObject{Transform: common.TransformManagedCluster()}
+	if selector := controllerOpts.Shard.LabelSelector(); selector != nil {
+		log.Info("Restrict the cache to ManagedClusters of the shard", "selector", selector.String())
+		clusterByObject.Label = selector
+	}
+
+	imagePullSecretWorkRequirement, err := labels.NewRequirement(common.LabelImagePullSecretCluster,
+		selection.Exists, nil)
+	if err != nil {
+		log.Error(err, "")
+		os.Exit(1)
+	}
+
+	opts := cache.Options{
+		DefaultTransform: common.TransformStripManagedFields(),
+		ByObject: map[client.Object]cache.ByObject{
+			&managedclusterv1.ManagedCluster{}: clusterByObject,
+			&addonv1alpha1.ManagedClusterAddOn{}: {
+				Transform: common.TransformManagedClusterAddOn(agentv1.KlusterletAddons),
+			},
+			// only the ManifestWorks of the image pull secrets are read by the controllers
+			&manifestworkv1.ManifestWork{}: {
+				Label: labels.NewSelector().Add(*imagePullSecretWorkRequirement),
+			},
+			// only the architectures of the nodes are read by the controllers
+			&clusterinfov1beta1.ManagedClusterInfo{}: {
+				Transform: common.TransformManagedClusterInfo(),
+			},
+			// only the image pull secrets are read by the controllers, the referenced secrets of the other types
+			// are read from the API server to report them as unsupported
+			&corev1.Secret{}: {
+				Field: fields.OneTermEqualSelector("type", string(corev1.SecretTypeDockerConfigJson)),
+			},
+		},
+	}
+
+	// only the compatibility and maintenance windows ConfigMaps are read by the controllers, the ConfigMaps of a
+	// namespace are selected by name if only one ConfigMap is in the namespace
+	configMapNames := map[string][]string{}
+	for _, configMap := range []types.NamespacedName{controllerOpts.CompatibilityConfigMapName(),
+		controllerOpts.MaintenanceWindowsConfigMapName()} {
+		if len(configMap.Name) != 0 {
+			configMapNames[configMap.Namespace] = append(configMapNames[configMap.Namespace], configMap.Name)
+		}
+	}
+	if len(configMapNames) != 0 {
+		configMapNamespaces := map[string]cache.Config{}
+		for namespace, names := range configMapNames {
+			configMapNamespaces[namespace] = cache.Config{}
+			if len(names) == 1 {
+				configMapNamespaces[namespace] = cache.Config{
+					FieldSelector: fields.OneTermEqualSelector("metadata.name", names[0]),
+				}
+			}
+		}
+		opts.ByObject[&corev1.ConfigMap{}] = cache.ByObject{Namespaces: configMapNamespaces}
+	}
+
+	if len(namespaces) != 0 {
+		log.Info("Restrict the cache to namespaces", "namespaces", namespaces)
+		opts.DefaultNamespaces = map[string]cache.Config{}
+		for _, namespace := range namespaces {
+			opts.DefaultNamespaces[namespace] = cache.Config{}
+		}
+	}
+	return opts
+}
+
 func newRuntimeClient(conf *rest.Config) (client.Client, error) {
 	kubeClient, err := client.New(conf, client.Options{})
 	if err != nil {
@@ -0,0 +1,104 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package v1
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ChangeHistoryLimit is the maximum number of the records in the history of a KlusterletAddonConfig
+const ChangeHistoryLimit = 20
+
+// AppendChanges returns the history with the records appended, the records already in the history are skipped and
+// the oldest records are dropped if the history exceeds ChangeHistoryLimit
+func AppendChanges(history []ChangeRecord, records ...ChangeRecord) []ChangeRecord {
+	for _, record := range records {
+		recorded := false
+		for _, existing := range history {
+			if equality.Semantic.DeepEqual(existing, record) {
+				recorded = true
+				break
+			}
+		}
+		if !recorded {
+			history = append(history, record)
+		}
+	}
+	if len(history) > ChangeHistoryLimit {
+		history = history[len(history)-ChangeHistoryLimit:]
+	}
+	return history
+}
+
+// SpecChanges returns the records of the changes from the old spec to the new spec of the KlusterletAddonConfig,
+// the old KlusterletAddonConfig is nil if it is created. The addons enabled or disabled, the proxy policies set and
+// the configurations changed are recorded, the changes of the global configurations are recorded without an addon.
+func SpecChanges(oldConfig, newConfig *KlusterletAddonConfig, source string, now metav1.Time) []ChangeRecord {
+	records := []ChangeRecord{}
+	record := func(action ChangeAction, addonName, message string) {
+		records = append(records, ChangeRecord{
+			Action:    action,
+			AddonName: addonName,
+			Source:    source,
+			Time:      now,
+			Message:   message,
+		})
+	}
+
+	addonNames := []string{}
+	for addonName, managed := range KlusterletAddons {
+		if managed {
+			addonNames = append(addonNames, addonName)
+		}
+	}
+	sort.Strings(addonNames)
+
+	for _, addonName := range addonNames {
+		newAgentConfig := GetAddonAgentConfig(addonName, newConfig)
+		if oldConfig == nil {
+			if newAgentConfig.Enabled {
+				record(ChangeActionAddonEnabled, addonName, "the KlusterletAddonConfig is created")
+			}
+			continue
+		}
+
+		oldAgentConfig := GetAddonAgentConfig(addonName, oldConfig)
+		switch {
+		case !oldAgentConfig.Enabled && newAgentConfig.Enabled:
+			record(ChangeActionAddonEnabled, addonName, "")
+		case oldAgentConfig.Enabled && !newAgentConfig.Enabled:
+			record(ChangeActionAddonDisabled, addonName, "")
+		}
+		if oldAgentConfig.ProxyPolicy != newAgentConfig.ProxyPolicy {
+			record(ChangeActionProxyPolicySet, addonName, fmt.Sprintf("the proxy policy is changed from %q to %q",
+				oldAgentConfig.ProxyPolicy, newAgentConfig.ProxyPolicy))
+		}
+
+		oldValues, newValues := oldAgentConfig.DeepCopy(), newAgentConfig.DeepCopy()
+		oldValues.Enabled, newValues.Enabled = false, false
+		oldValues.ProxyPolicy, newValues.ProxyPolicy = "", ""
+		if !equality.Semantic.DeepEqual(oldValues, newValues) {
+			record(ChangeActionValuesChanged, addonName, "the configurations of the addon are changed")
+		}
+	}
+
+	if oldConfig != nil && !equality.Semantic.DeepEqual(globalSpec(oldConfig), globalSpec(newConfig)) {
+		record(ChangeActionValuesChanged, "", "the global configurations are changed")
+	}
+	return records
+}
+
+// globalSpec returns the spec of the KlusterletAddonConfig without the configurations of the addons
+func globalSpec(config *KlusterletAddonConfig) *KlusterletAddonConfigSpec {
+	spec := config.Spec.DeepCopy()
+	spec.SearchCollectorConfig = KlusterletAddonAgentConfigSpec{}
+	spec.PolicyController = KlusterletAddonAgentConfigSpec{}
+	spec.ApplicationManagerConfig = KlusterletAddonAgentConfigSpec{}
+	spec.CertPolicyControllerConfig = KlusterletAddonAgentConfigSpec{}
+	spec.IAMPolicyControllerConfig = KlusterletAddonAgentConfigSpec{}
+	return spec
+}
@@ -0,0 +1,145 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package v1
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAppendChanges(t *testing.T) {
+	now := metav1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	newRecords := func(from, to int) []ChangeRecord {
+		records := []ChangeRecord{}
+		for i := from; i < to; i++ {
+			records = append(records, ChangeRecord{Action: ChangeActionValuesChanged, Source: fmt.Sprint(i), Time: now})
+		}
+		return records
+	}
+
+	tests := []struct {
+		name     string
+		history  []ChangeRecord
+		records  []ChangeRecord
+		expected []ChangeRecord
+	}{
+		{
+			name:     "records are appended",
+			history:  newRecords(0, 2),
+			records:  newRecords(2, 3),
+			expected: newRecords(0, 3),
+		},
+		{
+			name:     "records already in the history are skipped",
+			history:  newRecords(0, 2),
+			records:  newRecords(1, 3),
+			expected: newRecords(0, 3),
+		},
+		{
+			name:     "oldest records are dropped",
+			history:  newRecords(0, ChangeHistoryLimit),
+			records:  newRecords(ChangeHistoryLimit, ChangeHistoryLimit+2),
+			expected: newRecords(2, ChangeHistoryLimit+2),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := AppendChanges(tt.history, tt.records...); !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("expected %v, but got %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSpecChanges(t *testing.T) {
+	now := metav1.Now()
+	newConfig := func(mutate func(config *KlusterletAddonConfig)) *KlusterletAddonConfig {
+		config := &KlusterletAddonConfig{
+			Spec: KlusterletAddonConfigSpec{
+				ApplicationManagerConfig: KlusterletAddonAgentConfigSpec{Enabled: true},
+				SearchCollectorConfig:    KlusterletAddonAgentConfigSpec{Enabled: true},
+			},
+		}
+		if mutate != nil {
+			mutate(config)
+		}
+		return config
+	}
+	record := func(action ChangeAction, addonName string) string {
+		return fmt.Sprintf("%s/%s", action, addonName)
+	}
+
+	tests := []struct {
+		name      string
+		oldConfig *KlusterletAddonConfig
+		newConfig *KlusterletAddonConfig
+		expected  []string
+	}{
+		{
+			name:      "created",
+			newConfig: newConfig(nil),
+			expected: []string{
+				record(ChangeActionAddonEnabled, ApplicationAddonName),
+				record(ChangeActionAddonEnabled, SearchAddonName),
+			},
+		},
+		{
+			name:      "not changed",
+			oldConfig: newConfig(nil),
+			newConfig: newConfig(nil),
+			expected:  []string{},
+		},
+		{
+			name:      "addons enabled and disabled",
+			oldConfig: newConfig(nil),
+			newConfig: newConfig(func(config *KlusterletAddonConfig) {
+				config.Spec.SearchCollectorConfig.Enabled = false
+				config.Spec.CertPolicyControllerConfig.Enabled = true
+			}),
+			expected: []string{
+				record(ChangeActionAddonEnabled, CertPolicyAddonName),
+				record(ChangeActionAddonDisabled, SearchAddonName),
+			},
+		},
+		{
+			name:      "proxy policy set",
+			oldConfig: newConfig(nil),
+			newConfig: newConfig(func(config *KlusterletAddonConfig) {
+				config.Spec.ApplicationManagerConfig.ProxyPolicy = ProxyPolicyOCPGlobalProxy
+			}),
+			expected: []string{record(ChangeActionProxyPolicySet, ApplicationAddonName)},
+		},
+		{
+			name:      "configurations of the addon and global configurations changed",
+			oldConfig: newConfig(nil),
+			newConfig: newConfig(func(config *KlusterletAddonConfig) {
+				config.Spec.SearchCollectorConfig.NodePlacement = &NodePlacement{
+					NodeSelector: map[string]string{"infra": "true"},
+				}
+				config.Spec.ProxyConfig.HTTPProxy = "http://proxy"
+			}),
+			expected: []string{
+				record(ChangeActionValuesChanged, SearchAddonName),
+				record(ChangeActionValuesChanged, ""),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := []string{}
+			for _, r := range SpecChanges(tt.oldConfig, tt.newConfig, "user1", now) {
+				if r.Source != "user1" || !r.Time.Equal(&now) {
+					t.Errorf("unexpected source or time of the record %v", r)
+				}
+				actual = append(actual, record(r.Action, r.AddonName))
+			}
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("expected %v, but got %v", tt.expected, actual)
+			}
+		})
+	}
+}
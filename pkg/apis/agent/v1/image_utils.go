@@ -10,8 +10,11 @@ package v1
 
 import (
 	"context"
+	stderrors "errors"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -48,10 +51,23 @@ var versionList []string
 
 type manifest struct {
 	Images map[string]string
+	// ArchImages is the map of the architectures to the images of the architecture
+	ArchImages map[string]map[string]string
 }
 
+// Architectures are the architectures which can have their own images in the image manifest. The image of an
+// architecture is set by the key <image key>.<architecture>, for example search_collector.arm64.
+var Architectures = []string{"amd64", "arm64", "ppc64le", "s390x"}
+
+// ErrNoImageForArchitecture is returned if the image manifest has no image of a component for the architectures
+// of the managed cluster
+var ErrNoImageForArchitecture = stderrors.New("no image for the architecture")
+
 var manifests map[string]manifest
 
+// manifestsLock guards versionList and manifests, the images are read by the concurrent reconciles
+var manifestsLock sync.RWMutex
+
 // GetImage returns the image.  for the specified component return error if information not found
 func (config *AddonAgentConfig) GetImage(component string) (imageRepository string, err error) {
 	m, err := getManifest(version.Version)
@@ -82,8 +98,65 @@ func GetImage(managedCluster *clusterv1.ManagedCluster, component string) (strin
 	return imageregistry.OverrideImageByAnnotation(managedCluster.GetAnnotations(), image)
 }
 
+// HasArchitectureImages returns true if the image manifest has any architecture image of the component
+func HasArchitectureImages(component string) bool {
+	m, err := getManifest(version.Version)
+	if err != nil {
+		return false
+	}
+
+	for _, images := range m.ArchImages {
+		if _, ok := images[component]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// GetImageForArchitectures returns the image of the component for the architectures of the managed cluster. The
+// image of the architecture is used if the cluster has only one architecture and the image manifest has the image
+// of the architecture, otherwise the image of the component is used. ErrNoImageForArchitecture is returned if
+// neither exists but the manifest has the images of other architectures.
+func GetImageForArchitectures(managedCluster *clusterv1.ManagedCluster, component string,
+	architectures []string) (string, error) {
+	m, err := getManifest(version.Version)
+	if err != nil {
+		return "", err
+	}
+
+	image := m.Images[component]
+	if len(architectures) == 1 {
+		if archImage, ok := m.ArchImages[architectures[0]][component]; ok {
+			image = archImage
+		}
+	}
+
+	if image == "" {
+		if HasArchitectureImages(component) {
+			return "", fmt.Errorf("%w %v of image %s", ErrNoImageForArchitecture, architectures, component)
+		}
+		return "", fmt.Errorf("addon image not found")
+	}
+
+	return imageregistry.OverrideImageByAnnotation(managedCluster.GetAnnotations(), image)
+}
+
+// splitArchitectureImageKey returns the image key and the architecture of the key in the image manifest,
+// the architecture is empty if the key is not an architecture image key.
+func splitArchitectureImageKey(key string) (string, string) {
+	for _, arch := range Architectures {
+		if imageKey, found := strings.CutSuffix(key, "."+arch); found {
+			return imageKey, arch
+		}
+	}
+	return key, ""
+}
+
 // getManifest returns the manifest that is best matching the required version
 func getManifest(version string) (*manifest, error) {
+	manifestsLock.RLock()
+	defer manifestsLock.RUnlock()
+
 	if len(versionList) == 0 || manifests == nil {
 		return nil, fmt.Errorf("image manifest not loaded")
 	}
@@ -97,15 +170,18 @@ func getManifest(version string) (*manifest, error) {
 
 // LoadImages - loads image manifests from configmap, if configmap is not found get from env
 func LoadImages(k8s client.Client) error {
-	manifests = make(map[string]manifest)
-	m := manifest{Images: make(map[string]string)}
 	configmapList := &corev1.ConfigMapList{}
-
 	err := k8s.List(context.TODO(), configmapList, client.MatchingLabels{"ocm-configmap-type": "image-manifest"})
 	if err != nil && !errors.IsNotFound(err) {
 		return err
 	}
 
+	manifestsLock.Lock()
+	defer manifestsLock.Unlock()
+
+	manifests = make(map[string]manifest)
+	m := manifest{Images: make(map[string]string)}
+
 	if len(configmapList.Items) == 0 {
 		for envImageName, imageName := range EnvImageNameMap {
 			image := os.Getenv(envImageName)
@@ -125,7 +201,18 @@ func LoadImages(k8s client.Client) error {
 
 	for _, cm := range configmapList.Items {
 		omcVersion := cm.Labels[ocmVersionLabel]
-		m.Images = cm.Data
+		m := manifest{Images: map[string]string{}, ArchImages: map[string]map[string]string{}}
+		for key, image := range cm.Data {
+			imageKey, arch := splitArchitectureImageKey(key)
+			if len(arch) == 0 {
+				m.Images[key] = image
+				continue
+			}
+			if _, ok := m.ArchImages[arch]; !ok {
+				m.ArchImages[arch] = map[string]string{}
+			}
+			m.ArchImages[arch][imageKey] = image
+		}
 		manifests[omcVersion] = m
 		versionList = append(versionList, omcVersion)
 	}
@@ -9,6 +9,7 @@
 package v1
 
 import (
+	stderrors "errors"
 	"os"
 	"testing"
 
@@ -326,3 +327,104 @@ func TestGetImageWithManifestFromEnv(t *testing.T) {
 		})
 	}
 }
+
+func TestGetImageForArchitectures(t *testing.T) {
+	version.Version = "x.y.z"
+	testConfigMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-configmap-x.y.z",
+			Namespace: "test-namespace",
+			Labels: map[string]string{
+				"ocm-configmap-type":  "image-manifest",
+				"ocm-release-version": "x.y.z",
+			},
+		},
+		Data: map[string]string{
+			"search_collector":              "sample-registry/search-collector@sha256:multi-arch",
+			"search_collector.arm64":        "sample-registry/search-collector@sha256:arm64",
+			"cert_policy_controller.amd64":  "sample-registry/cert-policy-controller@sha256:amd64",
+			"cert_policy_controller.s390x":  "sample-registry/cert-policy-controller@sha256:s390x",
+			"config_policy_controller":      "sample-registry/config-policy-controller@sha256:multi-arch",
+			"governance_policy_framework.x": "sample-registry/framework@sha256:unknown-arch",
+		},
+	}
+
+	if err := LoadImages(fake.NewFakeClient(testConfigMap)); err != nil {
+		t.Fatalf("failed to load images: %v", err)
+	}
+
+	cluster := &clusterv1.ManagedCluster{}
+	tests := []struct {
+		name          string
+		component     string
+		architectures []string
+		expected      string
+		wantArchErr   bool
+		wantErr       bool
+	}{
+		{
+			name:          "architecture image",
+			component:     "search_collector",
+			architectures: []string{"arm64"},
+			expected:      "sample-registry/search-collector@sha256:arm64",
+		},
+		{
+			name:          "multi-arch image for other architecture",
+			component:     "search_collector",
+			architectures: []string{"ppc64le"},
+			expected:      "sample-registry/search-collector@sha256:multi-arch",
+		},
+		{
+			name:          "multi-arch image for the cluster with many architectures",
+			component:     "search_collector",
+			architectures: []string{"amd64", "arm64"},
+			expected:      "sample-registry/search-collector@sha256:multi-arch",
+		},
+		{
+			name:          "single-arch image",
+			component:     "cert_policy_controller",
+			architectures: []string{"s390x"},
+			expected:      "sample-registry/cert-policy-controller@sha256:s390x",
+		},
+		{
+			name:          "no image for the architecture",
+			component:     "cert_policy_controller",
+			architectures: []string{"arm64"},
+			wantArchErr:   true,
+		},
+		{
+			name:        "no image for the unknown architecture",
+			component:   "cert_policy_controller",
+			wantArchErr: true,
+		},
+		{
+			name:      "the key with unknown architecture is a component",
+			component: "governance_policy_framework.x",
+			expected:  "sample-registry/framework@sha256:unknown-arch",
+		},
+		{
+			name:      "component not found",
+			component: "notExistsComponent",
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			image, err := GetImageForArchitectures(cluster, tt.component, tt.architectures)
+			if tt.wantArchErr != stderrors.Is(err, ErrNoImageForArchitecture) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.wantErr || tt.wantArchErr {
+				if err == nil {
+					t.Errorf("expected error, but got nil")
+				}
+				return
+			}
+			assert.Equal(t, tt.expected, image, "image should match")
+		})
+	}
+
+	assert.True(t, HasArchitectureImages("cert_policy_controller"))
+	assert.False(t, HasArchitectureImages("config_policy_controller"))
+}
@@ -9,6 +9,7 @@
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -35,6 +36,49 @@ type KlusterletAddonConfigSpec struct {
 	// +optional
 	ClusterLabels map[string]string `json:"clusterLabels,omitempty"`
 
+	// DependencyPolicy defines how to handle a prerequisite addon which is disabled while its dependent addons are
+	// enabled. Reject keeps the prerequisite addon disabled and neither applies nor removes the dependent addons,
+	// Cascade disables the dependent addons as well, EnablePrerequisites keeps the prerequisite addon deployed.
+	// The default is Reject.
+	// +kubebuilder:validation:Enum=Reject;Cascade;EnablePrerequisites
+	// +optional
+	DependencyPolicy AddonDependencyPolicy `json:"dependencyPolicy,omitempty"`
+
+	// DryRun is the flag to plan the changes of the addons without applying them. default is false.
+	// The ManagedClusterAddOns are neither created, updated nor deleted, and the changes which would be applied
+	// are written to the plan of the status.
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// ImagePullPolicy is the image pull policy of all the addon agents. The default image pull policy of the hub
+	// is used if it is not set.
+	// +kubebuilder:validation:Enum=Always;Never;IfNotPresent
+	// +optional
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+
+	// ImagePullSecret is the name of the image pull secret of all the addon agents. The secret is in the namespace
+	// of the KlusterletAddonConfig, and is copied to the addon namespace on the managed cluster. The default image
+	// pull secret of the hub is used if it is not set.
+	// +optional
+	ImagePullSecret string `json:"imagePullSecret,omitempty"`
+
+	// MaintenanceWindow restricts the changes of the addons, which restart the addon agents, to the maintenance
+	// windows. The addons are enabled, disabled and their values are updated only inside the windows, and the
+	// changes are held outside the windows. It overrides the maintenance window named by the label of the
+	// ManagedCluster.
+	// +optional
+	MaintenanceWindow *MaintenanceWindow `json:"maintenanceWindow,omitempty"`
+
+	// NodePlacement defines the placement of all the addon agent pods. It overrides the node placement
+	// annotation of the ManagedCluster.
+	// +optional
+	NodePlacement *NodePlacement `json:"nodePlacement,omitempty"`
+
+	// Paused is the flag to pause the reconcile of all the addons. default is false.
+	// It replaces the annotation klusterletaddonconfig-pause, which is still supported.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+
 	// ProxyConfig defines the cluster-wide proxy configuration of the OCP managed cluster.
 	// +optional
 	ProxyConfig ProxyConfig `json:"proxyConfig,omitempty"`
@@ -74,6 +118,37 @@ type ProxyConfig struct {
 	NoProxy string `json:"noProxy,omitempty"`
 }
 
+// AddonDependencyPolicy defines how to handle a disabled prerequisite addon of the enabled addons
+type AddonDependencyPolicy string
+
+const (
+	// AddonDependencyPolicyReject keeps the prerequisite addon disabled, and neither applies nor removes its
+	// enabled dependent addons
+	AddonDependencyPolicyReject AddonDependencyPolicy = "Reject"
+	// AddonDependencyPolicyCascade disables the dependent addons of the disabled prerequisite addon
+	AddonDependencyPolicyCascade AddonDependencyPolicy = "Cascade"
+	// AddonDependencyPolicyEnablePrerequisites keeps the prerequisite addon deployed while its dependent addons are
+	// enabled
+	AddonDependencyPolicyEnablePrerequisites AddonDependencyPolicy = "EnablePrerequisites"
+)
+
+// MaintenanceWindow defines the recurring windows in which the addons can be changed
+type MaintenanceWindow struct {
+	// Schedule is the cron expression of the starts of the windows, with the five fields minute, hour, day of
+	// month, month and day of week, for example "0 2 * * 6" starts a window at 02:00 every Saturday.
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:Required
+	Schedule string `json:"schedule"`
+
+	// Duration is how long each window lasts, for example 4h.
+	// +kubebuilder:validation:Required
+	Duration metav1.Duration `json:"duration"`
+
+	// TimeZone is the IANA name of the time zone of the schedule, for example Europe/Berlin. The default is UTC.
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
 type ProxyPolicy string
 
 const (
@@ -88,6 +163,32 @@ type KlusterletAddonAgentConfigSpec struct {
 	// +optional
 	Enabled bool `json:"enabled"`
 
+	// ImagePullPolicy is the image pull policy of the addon agent. It overrides the ImagePullPolicy
+	// of the KlusterletAddonConfig.
+	// +kubebuilder:validation:Enum=Always;Never;IfNotPresent
+	// +optional
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+
+	// ImagePullSecret is the name of the image pull secret of the addon agent. It overrides the ImagePullSecret
+	// of the KlusterletAddonConfig.
+	// +optional
+	ImagePullSecret string `json:"imagePullSecret,omitempty"`
+
+	// ImageOverrides is the map of the image keys of the addon agent to the images, for example
+	// search_collector. It overrides the images of the image manifest and the ClusterImageRegistries.
+	// +optional
+	ImageOverrides map[string]string `json:"imageOverrides,omitempty"`
+
+	// NodePlacement defines the placement of the addon agent pods. It overrides the NodePlacement
+	// of the KlusterletAddonConfig.
+	// +optional
+	NodePlacement *NodePlacement `json:"nodePlacement,omitempty"`
+
+	// Paused is the flag to pause the reconcile of the addon. default is false.
+	// The ManagedClusterAddOns of a paused addon are neither created, updated nor deleted.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+
 	// ProxyPolicy defines the policy to set proxy for each addon agent. default is Disabled.
 	// Disabled means that the addon agent pods do not configure the proxy env variables.
 	// OCPGlobalProxy means that the addon agent pods use the cluster-wide proxy config of OCP cluster provisioned by ACM.
@@ -95,6 +196,39 @@ type KlusterletAddonAgentConfigSpec struct {
 	// +kubebuilder:validation:Enum=Disabled;OCPGlobalProxy;CustomProxy
 	// +optional
 	ProxyPolicy ProxyPolicy `json:"proxyPolicy,omitempty"`
+
+	// Resources defines the compute resources of the containers of the addon agent.
+	// The resources are validated by the controller, the addon is not updated if they are invalid.
+	// +optional
+	Resources []ContainerResourceRequirements `json:"resources,omitempty"`
+}
+
+// ContainerResourceRequirements defines the compute resources of a container of the addon agent
+type ContainerResourceRequirements struct {
+	// Name is the name of the container, "*" means all the containers of the addon agent.
+	// +kubebuilder:validation:Pattern=`^(\*|[a-z0-9]([-a-z0-9]*[a-z0-9])?)$`
+	// +required
+	Name string `json:"name"`
+
+	// Limits describes the maximum amount of compute resources allowed.
+	// +optional
+	Limits corev1.ResourceList `json:"limits,omitempty"`
+
+	// Requests describes the minimum amount of compute resources required.
+	// +optional
+	Requests corev1.ResourceList `json:"requests,omitempty"`
+}
+
+// NodePlacement defines the placement of the addon agent pods on the nodes of the managed cluster
+type NodePlacement struct {
+	// NodeSelector defines which nodes the addon agent pods are scheduled on.
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Tolerations is attached by the addon agent pods to tolerate any taint that matches
+	// the triple <key,value,effect> using the matching operator <operator>.
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
 }
 
 const (
@@ -104,6 +238,103 @@ const (
 	ReasonOCPGlobalProxyDetectedFail string = "OCPGlobalProxyNotDetectedFail"
 )
 
+const (
+	// AddonsPaused is true if the reconcile of any addon is paused, the paused addons are listed in the message
+	AddonsPaused                      string = "AddonsPaused"
+	ReasonKlusterletAddonConfigPaused string = "KlusterletAddonConfigPaused"
+	ReasonAddonsPaused                string = "AddonsPaused"
+	ReasonAddonsNotPaused             string = "AddonsNotPaused"
+)
+
+const (
+	// AddonResourcesInvalid is true if the resources of any addon are invalid, the addons are listed in the message
+	AddonResourcesInvalid       string = "AddonResourcesInvalid"
+	ReasonAddonResourcesInvalid string = "InvalidResources"
+	ReasonAddonResourcesValid   string = "ValidResources"
+)
+
+const (
+	// AddonArchitectureUnsupported is true if the image manifest has no image of any addon for the architecture of
+	// the managed cluster, the addons are listed in the message
+	AddonArchitectureUnsupported         string = "AddonArchitectureUnsupported"
+	ReasonNoImageForArchitecture         string = "NoImageForArchitecture"
+	ReasonImagesAvailableForArchitecture string = "ImagesAvailableForArchitecture"
+)
+
+const (
+	// AddonsIncompatible is true if any addons are incompatible with the product or platform of the managed cluster,
+	// the addons are listed in the message
+	AddonsIncompatible         string = "AddonsIncompatible"
+	ReasonIncompatiblePlatform string = "IncompatiblePlatform"
+	ReasonCompatiblePlatform   string = "CompatiblePlatform"
+)
+
+const (
+	// AddonsDeferred is true if the addons are not deployed because the managed cluster is not accepted, joined or
+	// available yet
+	AddonsDeferred            string = "AddonsDeferred"
+	ReasonClusterNotAvailable string = "ClusterNotAvailable"
+	ReasonClusterAvailable    string = "ClusterAvailable"
+)
+
+const (
+	// AddonsTornDown is true if the addons are removed because the managed cluster is unavailable longer than the
+	// grace period, it is false with the reason WithinUnavailableGracePeriod while the grace period is running
+	AddonsTornDown                      string = "AddonsTornDown"
+	ReasonUnavailableGracePeriodExpired string = "UnavailableGracePeriodExpired"
+	ReasonWithinUnavailableGracePeriod  string = "WithinUnavailableGracePeriod"
+)
+
+const (
+	// AddonsWaitingForPrerequisites is true if any addons are not created because their prerequisite addons are not
+	// available, the addons are listed in the message
+	AddonsWaitingForPrerequisites   string = "AddonsWaitingForPrerequisites"
+	ReasonPrerequisitesNotAvailable string = "PrerequisitesNotAvailable"
+	ReasonPrerequisitesAvailable    string = "PrerequisitesAvailable"
+)
+
+const (
+	// AddonDependencyConflict is true if any prerequisite addons are disabled while their dependent addons are
+	// enabled, the reason is the result of the dependency policy
+	AddonDependencyConflict           string = "AddonDependencyConflict"
+	ReasonDependentsRejected          string = "DependentsRejected"
+	ReasonPrerequisiteDisableRejected string = "PrerequisiteDisableRejected"
+	ReasonDependentsDisabled          string = "DependentsDisabled"
+	ReasonNoDependencyConflict        string = "NoDependencyConflict"
+)
+
+const (
+	// AddonModeMigrating is true if any addons are being moved between default mode and hosted mode, the addons
+	// and their progress are listed in the message
+	AddonModeMigrating            string = "AddonModeMigrating"
+	ReasonModeMigrationInProgress string = "ModeMigrationInProgress"
+	ReasonModeMigrationCompleted  string = "ModeMigrationCompleted"
+)
+
+const (
+	// AddonChangesPending is true if the changes of any addons are held because the maintenance window is closed,
+	// the addons and their pending changes are listed in the message
+	AddonChangesPending            string = "AddonChangesPending"
+	ReasonOutsideMaintenanceWindow string = "OutsideMaintenanceWindow"
+	ReasonNoPendingChanges         string = "NoPendingChanges"
+)
+
+const (
+	// AddonsRollingOut is true if the values updates of any addons are held or being rolled out by the rollout
+	// strategies of the ClusterManagementAddOns, the addons and their progress are listed in the message
+	AddonsRollingOut        string = "AddonsRollingOut"
+	ReasonRolloutInProgress string = "RolloutInProgress"
+	ReasonRolloutCompleted  string = "RolloutCompleted"
+)
+
+const (
+	// ManagedClusterAddOnApplyConflict is true if the fields applied to the managedClusterAddOns by the controller
+	// are managed by other field managers
+	ManagedClusterAddOnApplyConflict       string = "ManagedClusterAddOnApplyConflict"
+	ReasonManagedClusterAddOnApplyConflict string = "FieldManagerConflict"
+	ReasonManagedClusterAddOnApplied       string = "Applied"
+)
+
 // KlusterletAddonConfigStatus defines the observed state of KlusterletAddonConfig
 type KlusterletAddonConfigStatus struct {
 	// OCPGlobalProxy is the cluster-wide proxy config of the OCP cluster provisioned by ACM
@@ -113,6 +344,107 @@ type KlusterletAddonConfigStatus struct {
 	// Conditions contains condition information for the klusterletAddonConfig
 	// +optional
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// AddonImages contains the overridden images of the addon agents. The addons which use their default
+	// images are not listed.
+	// +optional
+	AddonImages []AddonImages `json:"addonImages,omitempty"`
+
+	// Plan contains the changes of the ManagedClusterAddOns planned in dry-run mode. It is removed once the
+	// dry-run mode is turned off.
+	// +optional
+	Plan *AddonsPlan `json:"plan,omitempty"`
+
+	// History contains the latest changes of the KlusterletAddonConfig and its addons, the oldest first. It is
+	// bounded, the oldest changes are dropped.
+	// +optional
+	History []ChangeRecord `json:"history,omitempty"`
+}
+
+// AddonImages is the overridden images of an addon agent
+type AddonImages struct {
+	// AddonName is the name of the addon
+	AddonName string `json:"addonName"`
+
+	// Images is the map of the image keys to the images of the addon agent
+	Images map[string]string `json:"images"`
+}
+
+// AddonsPlan is the changes of the ManagedClusterAddOns of a KlusterletAddonConfig computed in dry-run mode
+type AddonsPlan struct {
+	// ObservedGeneration is the generation of the KlusterletAddonConfig the plan is computed from
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Addons is the changes of the ManagedClusterAddOns, the addons which are not changed are not listed
+	// +optional
+	Addons []AddonPlan `json:"addons,omitempty"`
+}
+
+// AddonPlanAction is the action planned on a ManagedClusterAddOn
+type AddonPlanAction string
+
+const (
+	AddonPlanActionCreate AddonPlanAction = "Create"
+	AddonPlanActionUpdate AddonPlanAction = "Update"
+	AddonPlanActionDelete AddonPlanAction = "Delete"
+)
+
+// AddonPlan is the planned change of the ManagedClusterAddOn of an addon
+type AddonPlan struct {
+	// AddonName is the name of the addon
+	AddonName string `json:"addonName"`
+
+	// Action is the action on the ManagedClusterAddOn, Create, Update or Delete
+	Action AddonPlanAction `json:"action"`
+
+	// ValuesDiff is the changes of the values annotation of the ManagedClusterAddOn
+	// +optional
+	ValuesDiff []ValueChange `json:"valuesDiff,omitempty"`
+}
+
+// ValueChange is the change of a value in the values annotation of a ManagedClusterAddOn
+type ValueChange struct {
+	// Path is the path of the value, for example global.nodeSelector.zone
+	Path string `json:"path"`
+
+	// From is the json of the current value, it is empty if the value is added
+	// +optional
+	From string `json:"from,omitempty"`
+
+	// To is the json of the planned value, it is empty if the value is removed
+	// +optional
+	To string `json:"to,omitempty"`
+}
+
+// ChangeAction is the action of a change of the KlusterletAddonConfig
+type ChangeAction string
+
+const (
+	ChangeActionAddonEnabled   ChangeAction = "AddonEnabled"
+	ChangeActionAddonDisabled  ChangeAction = "AddonDisabled"
+	ChangeActionValuesChanged  ChangeAction = "ValuesChanged"
+	ChangeActionProxyPolicySet ChangeAction = "ProxyPolicySet"
+)
+
+// ChangeRecord is a change of the KlusterletAddonConfig or the values of its addons
+type ChangeRecord struct {
+	// Action is the action of the change, AddonEnabled, AddonDisabled, ValuesChanged or ProxyPolicySet
+	Action ChangeAction `json:"action"`
+
+	// AddonName is the name of the changed addon
+	// +optional
+	AddonName string `json:"addonName,omitempty"`
+
+	// Source is the controller or the user who made the change
+	Source string `json:"source"`
+
+	// Time is the time of the change
+	Time metav1.Time `json:"time"`
+
+	// Message is the details of the change
+	// +optional
+	Message string `json:"message,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
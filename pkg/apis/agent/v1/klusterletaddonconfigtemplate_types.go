@@ -0,0 +1,101 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// KlusterletAddonConfigTemplateSpec defines the managed clusters selected by the template and the fields of their
+// KlusterletAddonConfigs rendered from the template
+type KlusterletAddonConfigTemplateSpec struct {
+	// ClusterSets is the names of the ManagedClusterSets, the template selects the managed clusters in any of the
+	// ManagedClusterSets. The managed clusters are not restricted by the ManagedClusterSets if it is empty.
+	// +optional
+	ClusterSets []string `json:"clusterSets,omitempty"`
+
+	// ClusterSelector selects the managed clusters by their labels. The managed clusters are not restricted by
+	// their labels if it is not set. The template selects no managed clusters if neither ClusterSets nor
+	// ClusterSelector is set.
+	// +optional
+	ClusterSelector *metav1.LabelSelector `json:"clusterSelector,omitempty"`
+
+	// Priority decides which template sets a field of the KlusterletAddonConfig when the templates selecting the
+	// same managed cluster set the field to different values. The template with the higher priority wins, and the
+	// template with the lexicographically smaller name wins if the priorities are equal.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+
+	// Template is the partial spec of the KlusterletAddonConfig, only the fields set in the template are rendered
+	// to the KlusterletAddonConfigs. Maps are merged field by field, lists and other values are rendered as a whole.
+	// clusterName and clusterNamespace are always set to the name of the managed cluster.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Type=object
+	// +required
+	Template runtime.RawExtension `json:"template"`
+}
+
+// TemplateFieldConflict is a field of the KlusterletAddonConfig of a managed cluster which is set to different
+// values by the templates selecting the managed cluster
+type TemplateFieldConflict struct {
+	// ClusterName is the name of the managed cluster
+	ClusterName string `json:"clusterName"`
+
+	// Field is the path of the field in the KlusterletAddonConfig, for example spec.searchCollector.enabled
+	Field string `json:"field"`
+
+	// Templates is the names of the templates setting the field, the value of the first template is rendered
+	Templates []string `json:"templates"`
+}
+
+const (
+	// TemplateConflicted is true if the template sets any field to a different value than the other templates
+	// selecting the same managed clusters, the conflicts are listed in the status
+	TemplateConflicted             string = "TemplateConflicted"
+	ReasonTemplateFieldsConflicted string = "FieldsConflicted"
+	ReasonTemplateNoConflicts      string = "NoConflicts"
+)
+
+// KlusterletAddonConfigTemplateStatus defines the observed state of KlusterletAddonConfigTemplate
+type KlusterletAddonConfigTemplateStatus struct {
+	// Conditions contains condition information for the template
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// SelectedClusters is the number of the managed clusters selected by the template
+	// +optional
+	SelectedClusters int32 `json:"selectedClusters,omitempty"`
+
+	// Conflicts is the fields the template sets to different values than the other templates. At most 20
+	// conflicts are listed, the total number is in the message of the TemplateConflicted condition.
+	// +optional
+	Conflicts []TemplateFieldConflict `json:"conflicts,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// KlusterletAddonConfigTemplate is the Schema for the klusterletaddonconfigtemplates API, it renders the
+// KlusterletAddonConfigs of the selected managed clusters
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:path=klusterletaddonconfigtemplates,scope=Cluster
+type KlusterletAddonConfigTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   KlusterletAddonConfigTemplateSpec   `json:"spec,omitempty"`
+	Status KlusterletAddonConfigTemplateStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// KlusterletAddonConfigTemplateList contains a list of KlusterletAddonConfigTemplate
+type KlusterletAddonConfigTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KlusterletAddonConfigTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&KlusterletAddonConfigTemplate{}, &KlusterletAddonConfigTemplateList{})
+}
@@ -17,11 +17,12 @@ import (
 // GlobalValues defines the global values
 // +k8s:openapi-gen=true
 type GlobalValues struct {
-	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
-	ImagePullSecret string            `json:"imagePullSecret,omitempty"`
-	ImageOverrides  map[string]string `json:"imageOverrides,omitempty"`
-	NodeSelector    map[string]string `json:"nodeSelector,omitempty"`
-	ProxyConfig     map[string]string `json:"proxyConfig,omitempty"`
+	ImagePullPolicy corev1.PullPolicy   `json:"imagePullPolicy,omitempty"`
+	ImagePullSecret string              `json:"imagePullSecret,omitempty"`
+	ImageOverrides  map[string]string   `json:"imageOverrides,omitempty"`
+	NodeSelector    map[string]string   `json:"nodeSelector,omitempty"`
+	Tolerations     []corev1.Toleration `json:"tolerations,omitempty"`
+	ProxyConfig     map[string]string   `json:"proxyConfig,omitempty"`
 }
 
 const (
@@ -71,6 +72,28 @@ var KlusterletAddons = map[string]bool{
 	SearchAddonName:          true,
 }
 
+// GetAddonAgentConfig returns the configurations of the addon in the KlusterletAddonConfig, nil is returned if
+// the addon is not configured by the KlusterletAddonConfig
+func GetAddonAgentConfig(addonName string, config *KlusterletAddonConfig) *KlusterletAddonAgentConfigSpec {
+	switch addonName {
+	case ApplicationAddonName:
+		return &config.Spec.ApplicationManagerConfig
+	case CertPolicyAddonName:
+		return &config.Spec.CertPolicyControllerConfig
+	case ConfigPolicyAddonName, PolicyFrameworkAddonName:
+		return &config.Spec.PolicyController
+	case SearchAddonName:
+		return &config.Spec.SearchCollectorConfig
+	}
+	return nil
+}
+
+// KlusterletAddonDependencies is the prerequisite addons of each addon. The prerequisites are created before their
+// dependents, and a dependent addon is not created until its prerequisites are available.
+var KlusterletAddonDependencies = map[string][]string{
+	CertPolicyAddonName: {PolicyFrameworkAddonName},
+}
+
 // KlusterletAddonImageNames is the image key names for each addon agents in image-manifest configmap
 var KlusterletAddonImageNames = map[string][]string{
 	ApplicationAddonName:     {"multicluster_operators_subscription"},
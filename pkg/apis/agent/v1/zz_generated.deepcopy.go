@@ -5,6 +5,7 @@
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 	clusterv1 "open-cluster-management.io/api/cluster/v1"
@@ -42,6 +43,115 @@ func (in *AddonAgentConfig) DeepCopy() *AddonAgentConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AddonImages) DeepCopyInto(out *AddonImages) {
+	*out = *in
+	if in.Images != nil {
+		in, out := &in.Images, &out.Images
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AddonImages.
+func (in *AddonImages) DeepCopy() *AddonImages {
+	if in == nil {
+		return nil
+	}
+	out := new(AddonImages)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AddonPlan) DeepCopyInto(out *AddonPlan) {
+	*out = *in
+	if in.ValuesDiff != nil {
+		in, out := &in.ValuesDiff, &out.ValuesDiff
+		*out = make([]ValueChange, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AddonPlan.
+func (in *AddonPlan) DeepCopy() *AddonPlan {
+	if in == nil {
+		return nil
+	}
+	out := new(AddonPlan)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AddonsPlan) DeepCopyInto(out *AddonsPlan) {
+	*out = *in
+	if in.Addons != nil {
+		in, out := &in.Addons, &out.Addons
+		*out = make([]AddonPlan, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AddonsPlan.
+func (in *AddonsPlan) DeepCopy() *AddonsPlan {
+	if in == nil {
+		return nil
+	}
+	out := new(AddonsPlan)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChangeRecord) DeepCopyInto(out *ChangeRecord) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChangeRecord.
+func (in *ChangeRecord) DeepCopy() *ChangeRecord {
+	if in == nil {
+		return nil
+	}
+	out := new(ChangeRecord)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerResourceRequirements) DeepCopyInto(out *ContainerResourceRequirements) {
+	*out = *in
+	if in.Limits != nil {
+		in, out := &in.Limits, &out.Limits
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.Requests != nil {
+		in, out := &in.Requests, &out.Requests
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerResourceRequirements.
+func (in *ContainerResourceRequirements) DeepCopy() *ContainerResourceRequirements {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerResourceRequirements)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GlobalValues) DeepCopyInto(out *GlobalValues) {
 	*out = *in
@@ -59,6 +169,13 @@ func (in *GlobalValues) DeepCopyInto(out *GlobalValues) {
 			(*out)[key] = val
 		}
 	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]corev1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	if in.ProxyConfig != nil {
 		in, out := &in.ProxyConfig, &out.ProxyConfig
 		*out = make(map[string]string, len(*in))
@@ -81,6 +198,25 @@ func (in *GlobalValues) DeepCopy() *GlobalValues {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KlusterletAddonAgentConfigSpec) DeepCopyInto(out *KlusterletAddonAgentConfigSpec) {
 	*out = *in
+	if in.ImageOverrides != nil {
+		in, out := &in.ImageOverrides, &out.ImageOverrides
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.NodePlacement != nil {
+		in, out := &in.NodePlacement, &out.NodePlacement
+		*out = new(NodePlacement)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = make([]ContainerResourceRequirements, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KlusterletAddonAgentConfigSpec.
@@ -162,12 +298,22 @@ func (in *KlusterletAddonConfigSpec) DeepCopyInto(out *KlusterletAddonConfigSpec
 			(*out)[key] = val
 		}
 	}
+	if in.MaintenanceWindow != nil {
+		in, out := &in.MaintenanceWindow, &out.MaintenanceWindow
+		*out = new(MaintenanceWindow)
+		**out = **in
+	}
+	if in.NodePlacement != nil {
+		in, out := &in.NodePlacement, &out.NodePlacement
+		*out = new(NodePlacement)
+		(*in).DeepCopyInto(*out)
+	}
 	out.ProxyConfig = in.ProxyConfig
-	out.SearchCollectorConfig = in.SearchCollectorConfig
-	out.PolicyController = in.PolicyController
-	out.ApplicationManagerConfig = in.ApplicationManagerConfig
-	out.CertPolicyControllerConfig = in.CertPolicyControllerConfig
-	out.IAMPolicyControllerConfig = in.IAMPolicyControllerConfig
+	in.SearchCollectorConfig.DeepCopyInto(&out.SearchCollectorConfig)
+	in.PolicyController.DeepCopyInto(&out.PolicyController)
+	in.ApplicationManagerConfig.DeepCopyInto(&out.ApplicationManagerConfig)
+	in.CertPolicyControllerConfig.DeepCopyInto(&out.CertPolicyControllerConfig)
+	in.IAMPolicyControllerConfig.DeepCopyInto(&out.IAMPolicyControllerConfig)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KlusterletAddonConfigSpec.
@@ -191,6 +337,25 @@ func (in *KlusterletAddonConfigStatus) DeepCopyInto(out *KlusterletAddonConfigSt
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.AddonImages != nil {
+		in, out := &in.AddonImages, &out.AddonImages
+		*out = make([]AddonImages, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Plan != nil {
+		in, out := &in.Plan, &out.Plan
+		*out = new(AddonsPlan)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]ChangeRecord, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KlusterletAddonConfigStatus.
@@ -203,6 +368,165 @@ func (in *KlusterletAddonConfigStatus) DeepCopy() *KlusterletAddonConfigStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KlusterletAddonConfigTemplate) DeepCopyInto(out *KlusterletAddonConfigTemplate) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KlusterletAddonConfigTemplate.
+func (in *KlusterletAddonConfigTemplate) DeepCopy() *KlusterletAddonConfigTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(KlusterletAddonConfigTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KlusterletAddonConfigTemplate) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KlusterletAddonConfigTemplateList) DeepCopyInto(out *KlusterletAddonConfigTemplateList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]KlusterletAddonConfigTemplate, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KlusterletAddonConfigTemplateList.
+func (in *KlusterletAddonConfigTemplateList) DeepCopy() *KlusterletAddonConfigTemplateList {
+	if in == nil {
+		return nil
+	}
+	out := new(KlusterletAddonConfigTemplateList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KlusterletAddonConfigTemplateList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KlusterletAddonConfigTemplateSpec) DeepCopyInto(out *KlusterletAddonConfigTemplateSpec) {
+	*out = *in
+	if in.ClusterSets != nil {
+		in, out := &in.ClusterSets, &out.ClusterSets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ClusterSelector != nil {
+		in, out := &in.ClusterSelector, &out.ClusterSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	in.Template.DeepCopyInto(&out.Template)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KlusterletAddonConfigTemplateSpec.
+func (in *KlusterletAddonConfigTemplateSpec) DeepCopy() *KlusterletAddonConfigTemplateSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KlusterletAddonConfigTemplateSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KlusterletAddonConfigTemplateStatus) DeepCopyInto(out *KlusterletAddonConfigTemplateStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conflicts != nil {
+		in, out := &in.Conflicts, &out.Conflicts
+		*out = make([]TemplateFieldConflict, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KlusterletAddonConfigTemplateStatus.
+func (in *KlusterletAddonConfigTemplateStatus) DeepCopy() *KlusterletAddonConfigTemplateStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(KlusterletAddonConfigTemplateStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MaintenanceWindow) DeepCopyInto(out *MaintenanceWindow) {
+	*out = *in
+	out.Duration = in.Duration
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MaintenanceWindow.
+func (in *MaintenanceWindow) DeepCopy() *MaintenanceWindow {
+	if in == nil {
+		return nil
+	}
+	out := new(MaintenanceWindow)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodePlacement) DeepCopyInto(out *NodePlacement) {
+	*out = *in
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]corev1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodePlacement.
+func (in *NodePlacement) DeepCopy() *NodePlacement {
+	if in == nil {
+		return nil
+	}
+	out := new(NodePlacement)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ProxyConfig) DeepCopyInto(out *ProxyConfig) {
 	*out = *in
@@ -217,3 +541,38 @@ func (in *ProxyConfig) DeepCopy() *ProxyConfig {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TemplateFieldConflict) DeepCopyInto(out *TemplateFieldConflict) {
+	*out = *in
+	if in.Templates != nil {
+		in, out := &in.Templates, &out.Templates
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TemplateFieldConflict.
+func (in *TemplateFieldConflict) DeepCopy() *TemplateFieldConflict {
+	if in == nil {
+		return nil
+	}
+	out := new(TemplateFieldConflict)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ValueChange) DeepCopyInto(out *ValueChange) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ValueChange.
+func (in *ValueChange) DeepCopy() *ValueChange {
+	if in == nil {
+		return nil
+	}
+	out := new(ValueChange)
+	in.DeepCopyInto(out)
+	return out
+}
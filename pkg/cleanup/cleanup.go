@@ -0,0 +1,276 @@
+// Copyright Contributors to the Open Cluster Management project
+
+// Package cleanup removes the klusterlet addon resources left on the managed clusters and the hub, it replaces the
+// hub-detach.sh and self-destruct.sh scripts. The cleanup runs in ordered steps, every step is idempotent and is
+// retried on failure, and the dry-run mode only reads the resources.
+package cleanup
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Options is the configurations of the cleanup
+type Options struct {
+	// DryRun only shows the resources to remove
+	DryRun bool
+
+	// Retries is the number of the retries of a failed step, and RetryInterval is the interval between them
+	Retries       int
+	RetryInterval time.Duration
+
+	// DeleteTimeout is the duration to wait for a deleted resource to be removed before its finalizers are removed
+	DeleteTimeout time.Duration
+
+	// ClusterDeploymentTimeout is the duration to wait for a ClusterDeployment to be deprovisioned, the finalizers
+	// of the ClusterDeployments are never removed
+	ClusterDeploymentTimeout time.Duration
+}
+
+// NewOptions returns the Options with the default configurations
+func NewOptions() *Options {
+	return &Options{
+		Retries:                  3,
+		RetryInterval:            10 * time.Second,
+		DeleteTimeout:            60 * time.Second,
+		ClusterDeploymentTimeout: time.Hour,
+	}
+}
+
+// AddFlags registers the flags of the cleanup
+func (o *Options) AddFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&o.DryRun, "dry-run", o.DryRun, "Only show the resources to remove.")
+	fs.IntVar(&o.Retries, "retries", o.Retries, "The number of the retries of a failed step.")
+	fs.DurationVar(&o.RetryInterval, "retry-interval", o.RetryInterval, "The interval between the retries.")
+	fs.DurationVar(&o.DeleteTimeout, "delete-timeout", o.DeleteTimeout,
+		"The duration to wait for a deleted resource to be removed before its finalizers are removed.")
+	fs.DurationVar(&o.ClusterDeploymentTimeout, "cluster-deployment-timeout", o.ClusterDeploymentTimeout,
+		"The duration to wait for a ClusterDeployment to be deprovisioned.")
+}
+
+// Validate returns an error if the Options are invalid
+func (o *Options) Validate() error {
+	switch {
+	case o.Retries < 0:
+		return fmt.Errorf("retries must not be negative, but got %d", o.Retries)
+	case o.RetryInterval < 0 || o.DeleteTimeout <= 0 || o.ClusterDeploymentTimeout <= 0:
+		return fmt.Errorf("invalid durations, retry interval %v, delete timeout %v, cluster deployment timeout %v",
+			o.RetryInterval, o.DeleteTimeout, o.ClusterDeploymentTimeout)
+	}
+	return nil
+}
+
+// Cleaner removes the resources through the client, the progress is written to out
+type Cleaner struct {
+	client client.Client
+	opts   Options
+
+	// pollInterval is the interval to check if a deleted resource is removed
+	pollInterval time.Duration
+
+	outLock sync.Mutex
+	out     io.Writer
+}
+
+// NewCleaner returns a Cleaner with the options
+func NewCleaner(c client.Client, out io.Writer, opts *Options) *Cleaner {
+	return &Cleaner{
+		client:       c,
+		opts:         *opts,
+		pollInterval: time.Second,
+		out:          out,
+	}
+}
+
+// step is a step of the cleanup, it must be idempotent since it is retried on failure
+type step struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// run runs the steps in order, a failed step is retried and the later steps are not run if it still fails
+func (c *Cleaner) run(ctx context.Context, steps []step) error {
+	for i, s := range steps {
+		c.printf("[%d/%d] %s", i+1, len(steps), s.name)
+
+		var err error
+		for attempt := 0; attempt <= c.opts.Retries; attempt++ {
+			if attempt > 0 {
+				c.printf("retry %q (%d/%d) in %v", s.name, attempt, c.opts.Retries, c.opts.RetryInterval)
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(c.opts.RetryInterval):
+				}
+			}
+			if err = s.run(ctx); err == nil {
+				break
+			}
+			c.printf("failed to %s: %v", s.name, err)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to %s: %v", s.name, err)
+		}
+	}
+	return nil
+}
+
+func (c *Cleaner) printf(format string, args ...interface{}) {
+	c.outLock.Lock()
+	defer c.outLock.Unlock()
+	fmt.Fprintf(c.out, format+"\n", args...)
+}
+
+// object is the reference of a resource to remove
+type object struct {
+	gvk       schema.GroupVersionKind
+	namespace string
+	name      string
+}
+
+func (o object) String() string {
+	if len(o.namespace) == 0 {
+		return fmt.Sprintf("%s %s", o.gvk.Kind, o.name)
+	}
+	return fmt.Sprintf("%s %s/%s", o.gvk.Kind, o.namespace, o.name)
+}
+
+func (o object) new() *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(o.gvk)
+	u.SetNamespace(o.namespace)
+	u.SetName(o.name)
+	return u
+}
+
+// get returns the resource, nil is returned if the resource or its kind does not exist
+func (c *Cleaner) get(ctx context.Context, o object) (*unstructured.Unstructured, error) {
+	u := o.new()
+	err := c.client.Get(ctx, types.NamespacedName{Namespace: o.namespace, Name: o.name}, u)
+	if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// list returns the resources of the kind in the namespace, an empty list is returned if the kind does not exist
+func (c *Cleaner) list(ctx context.Context, gvk schema.GroupVersionKind, namespace string) ([]object, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	err := c.client.List(ctx, list, client.InNamespace(namespace))
+	if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []object{}
+	for _, item := range list.Items {
+		objects = append(objects, object{gvk: gvk, namespace: item.GetNamespace(), name: item.GetName()})
+	}
+	return objects, nil
+}
+
+// delete deletes the resource without waiting for it to be removed
+func (c *Cleaner) delete(ctx context.Context, o object) error {
+	u, err := c.get(ctx, o)
+	if err != nil || u == nil {
+		return err
+	}
+	if c.opts.DryRun {
+		c.printf("delete %s (dry run)", o)
+		return nil
+	}
+	if u.GetDeletionTimestamp().IsZero() {
+		c.printf("delete %s", o)
+	}
+	if err := c.client.Delete(ctx, u); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// deleteAndWait deletes the resource and waits for it to be removed, an error is returned if it is not removed
+// within the timeout
+func (c *Cleaner) deleteAndWait(ctx context.Context, o object, timeout time.Duration) error {
+	if err := c.delete(ctx, o); err != nil || c.opts.DryRun {
+		return err
+	}
+	return c.waitForRemoval(ctx, o, timeout)
+}
+
+// forceDelete deletes the resource, and removes its finalizers if it is not removed within the delete timeout
+func (c *Cleaner) forceDelete(ctx context.Context, o object) error {
+	err := c.deleteAndWait(ctx, o, c.opts.DeleteTimeout)
+	if err == nil || ctx.Err() != nil || !wait.Interrupted(err) {
+		return err
+	}
+
+	c.printf("remove the finalizers of %s", o)
+	if err := c.removeFinalizers(ctx, o); err != nil {
+		return err
+	}
+	return c.deleteAndWait(ctx, o, c.opts.DeleteTimeout)
+}
+
+func (c *Cleaner) waitForRemoval(ctx context.Context, o object, timeout time.Duration) error {
+	err := wait.PollUntilContextTimeout(ctx, c.pollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		u, err := c.get(ctx, o)
+		return u == nil, err
+	})
+	if wait.Interrupted(err) {
+		return fmt.Errorf("%s is not removed within %v: %w", o, timeout, err)
+	}
+	return err
+}
+
+func (c *Cleaner) removeFinalizers(ctx context.Context, o object) error {
+	u, err := c.get(ctx, o)
+	if err != nil || u == nil || len(u.GetFinalizers()) == 0 {
+		return err
+	}
+	if c.opts.DryRun {
+		c.printf("remove the finalizers of %s (dry run)", o)
+		return nil
+	}
+	patch := client.RawPatch(types.MergePatchType, []byte(`{"metadata":{"finalizers":null}}`))
+	if err := c.client.Patch(ctx, u, patch); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// annotate sets the annotation of the resource if it exists
+func (c *Cleaner) annotate(ctx context.Context, o object, key, value string) error {
+	u, err := c.get(ctx, o)
+	if err != nil || u == nil || u.GetAnnotations()[key] == value {
+		return err
+	}
+	if c.opts.DryRun {
+		c.printf("annotate %s %s=%s (dry run)", o, key, value)
+		return nil
+	}
+	c.printf("annotate %s %s=%s", o, key, value)
+	patch := client.RawPatch(types.MergePatchType,
+		[]byte(fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, key, value)))
+	if err := c.client.Patch(ctx, u, patch); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
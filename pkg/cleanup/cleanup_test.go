@@ -0,0 +1,383 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package cleanup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// namespacedKinds are the kinds of the namespaced resources of the tests, the other kinds are cluster scoped
+var namespacedKinds = map[schema.GroupVersionKind]bool{
+	klusterletAddonConfigGVK: true,
+	manifestWorkGVK:          true,
+	clusterDeploymentGVK:     true,
+	openshiftRoleBindingGVK:  true,
+	openshiftRoleGVK:         true,
+	serviceAccountGVK:        true,
+}
+
+// newFakeClient returns the fake client of the resources, the kinds not in the kinds are not served
+func newFakeClient(kinds []schema.GroupVersionKind, funcs interceptor.Funcs, objs ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	mapper := meta.NewDefaultRESTMapper(nil)
+	for _, gvk := range kinds {
+		scope := meta.RESTScopeRoot
+		if namespacedKinds[gvk] || legacyComponentKind(gvk) {
+			scope = meta.RESTScopeNamespace
+		}
+		mapper.Add(gvk, scope)
+		scheme.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+		scheme.AddKnownTypeWithName(gvk.GroupVersion().WithKind(gvk.Kind+"List"), &unstructured.UnstructuredList{})
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithRESTMapper(mapper).WithObjects(objs...).
+		WithInterceptorFuncs(funcs).Build()
+}
+
+func legacyComponentKind(gvk schema.GroupVersionKind) bool {
+	for _, kind := range legacyComponentKinds {
+		if kind == gvk {
+			return true
+		}
+	}
+	return false
+}
+
+func newObject(gvk schema.GroupVersionKind, namespace, name string, finalizers ...string) client.Object {
+	u := object{gvk: gvk, namespace: namespace, name: name}.new()
+	u.SetFinalizers(finalizers)
+	return u
+}
+
+func newCleaner(c client.Client, dryRun bool) (*Cleaner, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	opts := NewOptions()
+	opts.DryRun = dryRun
+	opts.Retries = 2
+	opts.RetryInterval = 0
+	opts.DeleteTimeout = 50 * time.Millisecond
+	opts.ClusterDeploymentTimeout = 50 * time.Millisecond
+	cleaner := NewCleaner(c, out, opts)
+	cleaner.pollInterval = 10 * time.Millisecond
+	return cleaner, out
+}
+
+// existing returns the resources which are not removed
+func existing(t *testing.T, c client.Client, objs ...client.Object) []string {
+	names := []string{}
+	for _, obj := range objs {
+		o := object{gvk: obj.GetObjectKind().GroupVersionKind(), namespace: obj.GetNamespace(), name: obj.GetName()}
+		u, err := (&Cleaner{client: c}).get(context.TODO(), o)
+		if err != nil {
+			t.Fatalf("failed to get %s: %v", o, err)
+		}
+		if u != nil {
+			names = append(names, o.String())
+		}
+	}
+	return names
+}
+
+func Test_SelfDestruct(t *testing.T) {
+	kinds := append([]schema.GroupVersionKind{klusterletGVK, namespaceGVK, crdGVK}, legacyComponentKinds...)
+	newObjects := func() []client.Object {
+		return []client.Object{
+			newObject(klusterletGVK, "", klusterletName, "operator.open-cluster-management.io/klusterlet-cleanup"),
+			newObject(namespaceGVK, "", DefaultKlusterletNamespace),
+			newObject(namespaceGVK, "", DefaultOperatorNamespace),
+			newObject(crdGVK, "", "applicationmanagers.agent.open-cluster-management.io"),
+			newObject(crdGVK, "", "searchcollectors.agent.open-cluster-management.io"),
+			newObject(legacyComponentKinds[0], DefaultOperatorNamespace, "klusterlet-addon-appmgr",
+				"agent.open-cluster-management.io/cleanup"),
+			newObject(legacyComponentKinds[3], DefaultOperatorNamespace, "klusterlet-addon-search"),
+		}
+	}
+
+	cases := []struct {
+		name             string
+		dryRun           bool
+		funcs            interceptor.Funcs
+		expectErr        bool
+		expectedExisting int
+		expectedOutput   []string
+	}{
+		{
+			name: "remove all",
+			expectedOutput: []string{
+				"[1/8] delete the Klusterlet",
+				"remove the finalizers of Klusterlet klusterlet",
+				"delete ApplicationManager open-cluster-management-agent-addon/klusterlet-addon-appmgr",
+				"remove the finalizers of ApplicationManager open-cluster-management-agent-addon/klusterlet-addon-appmgr",
+				"delete CustomResourceDefinition searchcollectors.agent.open-cluster-management.io",
+				"[8/8] delete the operator namespace open-cluster-management-agent-addon",
+			},
+		},
+		{
+			name:             "dry run",
+			dryRun:           true,
+			expectedExisting: 7,
+			expectedOutput: []string{
+				"delete Klusterlet klusterlet (dry run)",
+				"delete SearchCollector open-cluster-management-agent-addon/klusterlet-addon-search (dry run)",
+				"delete Namespace open-cluster-management-agent-addon (dry run)",
+			},
+		},
+		{
+			name: "retry the failed step",
+			funcs: failTimes(1, func(obj client.Object) bool {
+				return obj.GetObjectKind().GroupVersionKind() == crdGVK
+			}),
+			expectedOutput: []string{"retry \"delete the CustomResourceDefinition applicationmanagers"},
+		},
+		{
+			name: "stop at the failed step",
+			funcs: failTimes(3, func(obj client.Object) bool {
+				return obj.GetObjectKind().GroupVersionKind() == crdGVK
+			}),
+			expectErr: true,
+			// the CustomResourceDefinitions and the operator namespace are not removed
+			expectedExisting: 4,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			objs := newObjects()
+			fakeClient := newFakeClient(kinds, c.funcs, objs...)
+			cleaner, out := newCleaner(fakeClient, c.dryRun)
+
+			err := cleaner.SelfDestruct(context.TODO(), DefaultOperatorNamespace, DefaultKlusterletNamespace,
+				types.NamespacedName{})
+			if c.expectErr != (err != nil) {
+				t.Fatalf("expected error %v, but got %v", c.expectErr, err)
+			}
+			if names := existing(t, fakeClient, objs...); len(names) != c.expectedExisting {
+				t.Errorf("expected %d resources existing, but got %v", c.expectedExisting, names)
+			}
+			for _, expected := range c.expectedOutput {
+				if !strings.Contains(out.String(), expected) {
+					t.Errorf("expected output %q, but got:\n%s", expected, out.String())
+				}
+			}
+		})
+	}
+}
+
+func Test_SelfDestructIdempotent(t *testing.T) {
+	kinds := []schema.GroupVersionKind{klusterletGVK, namespaceGVK, crdGVK}
+	fakeClient := newFakeClient(kinds, interceptor.Funcs{},
+		newObject(klusterletGVK, "", klusterletName, "operator.open-cluster-management.io/klusterlet-cleanup"),
+		newObject(namespaceGVK, "", DefaultKlusterletNamespace))
+	cleaner, out := newCleaner(fakeClient, false)
+	if err := cleaner.SelfDestruct(context.TODO(), DefaultOperatorNamespace, DefaultKlusterletNamespace,
+		types.NamespacedName{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the legacy component kinds are not served, and nothing is left to remove in the second run
+	out.Reset()
+	if err := cleaner.SelfDestruct(context.TODO(), DefaultOperatorNamespace, DefaultKlusterletNamespace,
+		types.NamespacedName{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(out.String(), "failed") || strings.Contains(out.String(), "delete Klusterlet") ||
+		strings.Contains(out.String(), "delete Namespace") {
+		t.Errorf("expected nothing is removed, but got:\n%s", out.String())
+	}
+}
+
+func Test_SelfDestructServiceAccount(t *testing.T) {
+	kinds := []schema.GroupVersionKind{klusterletGVK, namespaceGVK, crdGVK, serviceAccountGVK, clusterRoleBindingGVK}
+	serviceAccount := types.NamespacedName{Namespace: "default", Name: "klusterlet-addon-self-destruct"}
+	newBinding := func() client.Object {
+		binding := newObject(clusterRoleBindingGVK, "", serviceAccount.Name)
+		binding.SetUID("binding-uid")
+		return binding
+	}
+
+	cases := []struct {
+		name                string
+		withBinding         bool
+		expectedExisting    []string
+		expectedOwnerRemain bool
+	}{
+		{
+			// the service account is removed by the garbage collector, which the fake client does not run
+			name:                "delete the ClusterRoleBinding owning the service account",
+			withBinding:         true,
+			expectedExisting:    []string{"ServiceAccount default/klusterlet-addon-self-destruct"},
+			expectedOwnerRemain: true,
+		},
+		{
+			name:             "delete the service account without the ClusterRoleBinding",
+			expectedExisting: []string{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			objs := []client.Object{newObject(serviceAccountGVK, serviceAccount.Namespace, serviceAccount.Name)}
+			if c.withBinding {
+				objs = append(objs, newBinding())
+			}
+			fakeClient := newFakeClient(kinds, interceptor.Funcs{}, objs...)
+			cleaner, out := newCleaner(fakeClient, false)
+
+			err := cleaner.SelfDestruct(context.TODO(), DefaultOperatorNamespace, DefaultKlusterletNamespace,
+				serviceAccount)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(out.String(),
+				"[9/9] delete the service account default/klusterlet-addon-self-destruct") {
+				t.Errorf("expected the service account is deleted at last, but got:\n%s", out.String())
+			}
+			names := existing(t, fakeClient, objs...)
+			if strings.Join(names, ",") != strings.Join(c.expectedExisting, ",") {
+				t.Errorf("expected %v existing, but got %v", c.expectedExisting, names)
+			}
+			if !c.expectedOwnerRemain {
+				return
+			}
+			sa, err := cleaner.get(context.TODO(), object{gvk: serviceAccountGVK,
+				namespace: serviceAccount.Namespace, name: serviceAccount.Name})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			owners := sa.GetOwnerReferences()
+			if len(owners) != 1 || owners[0].UID != "binding-uid" || owners[0].Kind != clusterRoleBindingGVK.Kind {
+				t.Errorf("expected the service account is owned by the ClusterRoleBinding, but got %v", owners)
+			}
+		})
+	}
+}
+
+func Test_HubDetach(t *testing.T) {
+	kinds := []schema.GroupVersionKind{klusterletAddonConfigGVK, manifestWorkGVK, clusterDeploymentGVK,
+		managedClusterGVK, openshiftRoleBindingGVK, openshiftRoleGVK}
+	newObjects := func(clusterName string, clusterDeploymentFinalizers ...string) []client.Object {
+		workRoleName := clusterName + ":managed-cluster-work"
+		return []client.Object{
+			newObject(klusterletAddonConfigGVK, clusterName, clusterName, "agent.open-cluster-management.io/cleanup"),
+			newObject(manifestWorkGVK, clusterName, clusterName+"-appmgr", "cluster.open-cluster-management.io/cleanup"),
+			newObject(manifestWorkGVK, clusterName, clusterName+"-search"),
+			newObject(clusterDeploymentGVK, clusterName, clusterName, clusterDeploymentFinalizers...),
+			newObject(managedClusterGVK, "", clusterName, "cluster.open-cluster-management.io/api-resource-cleanup"),
+			newObject(openshiftRoleBindingGVK, clusterName, workRoleName, "cluster.open-cluster-management.io/cleanup"),
+			newObject(openshiftRoleGVK, clusterName, workRoleName),
+		}
+	}
+
+	t.Run("detach clusters", func(t *testing.T) {
+		objs := append(newObjects("cluster1"), newObjects("cluster2")...)
+		var pausedBeforeDeletion bool
+		fakeClient := newFakeClient(kinds, interceptor.Funcs{
+			Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+				if obj.GetObjectKind().GroupVersionKind() == manifestWorkGVK && obj.GetNamespace() == "cluster1" {
+					config := object{gvk: klusterletAddonConfigGVK, namespace: "cluster1", name: "cluster1"}.new()
+					if err := c.Get(ctx, client.ObjectKeyFromObject(config), config); err != nil {
+						return err
+					}
+					pausedBeforeDeletion = config.GetAnnotations()[annotationPause] == "true"
+				}
+				return c.Delete(ctx, obj, opts...)
+			},
+		}, objs...)
+		cleaner, out := newCleaner(fakeClient, false)
+
+		clusterNames, err := cleaner.ManagedClusterNames(context.TODO())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strings.Join(clusterNames, ",") != "cluster1,cluster2" {
+			t.Errorf("expected the clusters cluster1 and cluster2, but got %v", clusterNames)
+		}
+		if err := cleaner.HubDetach(context.TODO(), clusterNames...); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// the ManifestWorks are deleted without removing their finalizers, they are removed by the work agents
+		expected := []string{"ManifestWork cluster1/cluster1-appmgr", "ManifestWork cluster2/cluster2-appmgr"}
+		if names := existing(t, fakeClient, objs...); strings.Join(names, ",") != strings.Join(expected, ",") {
+			t.Errorf("expected %v existing, but got %v\n%s", expected, names, out.String())
+		}
+		for _, clusterName := range []string{"cluster1", "cluster2"} {
+			work, err := cleaner.get(context.TODO(),
+				object{gvk: manifestWorkGVK, namespace: clusterName, name: clusterName + "-appmgr"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if work.GetDeletionTimestamp().IsZero() || len(work.GetFinalizers()) == 0 {
+				t.Errorf("expected the ManifestWork of %s is deleted with its finalizers", clusterName)
+			}
+		}
+		if !pausedBeforeDeletion {
+			t.Errorf("expected the KlusterletAddonConfig is paused before the ManifestWorks are deleted")
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		objs := newObjects("cluster1")
+		fakeClient := newFakeClient(kinds, interceptor.Funcs{}, objs...)
+		cleaner, out := newCleaner(fakeClient, true)
+		if err := cleaner.HubDetach(context.TODO(), "cluster1"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if names := existing(t, fakeClient, objs...); len(names) != len(objs) {
+			t.Errorf("expected nothing is removed, but got %v", names)
+		}
+		for _, expected := range []string{
+			"annotate KlusterletAddonConfig cluster1/cluster1 klusterletaddonconfig-pause=true (dry run)",
+			"delete ManifestWork cluster1/cluster1-appmgr (dry run)",
+			"delete ClusterDeployment cluster1/cluster1 (dry run)",
+			"delete RoleBinding cluster1/cluster1:managed-cluster-work (dry run)",
+		} {
+			if !strings.Contains(out.String(), expected) {
+				t.Errorf("expected output %q, but got:\n%s", expected, out.String())
+			}
+		}
+	})
+
+	t.Run("clusterdeployment not deprovisioned", func(t *testing.T) {
+		objs := append(newObjects("cluster1", "hive.openshift.io/deprovision"), newObjects("cluster2")...)
+		fakeClient := newFakeClient(kinds, interceptor.Funcs{}, objs...)
+		cleaner, _ := newCleaner(fakeClient, false)
+		err := cleaner.HubDetach(context.TODO(), "cluster1", "cluster2")
+		if err == nil || !strings.Contains(err.Error(), "cluster cluster1") {
+			t.Fatalf("expected error of cluster1, but got %v", err)
+		}
+		// the finalizers of the ClusterDeployment are not removed, and the ManagedCluster is kept
+		expected := []string{"ManifestWork cluster1/cluster1-appmgr", "ClusterDeployment cluster1/cluster1",
+			"ManagedCluster cluster1", "RoleBinding cluster1/cluster1:managed-cluster-work",
+			"Role cluster1/cluster1:managed-cluster-work", "ManifestWork cluster2/cluster2-appmgr"}
+		if names := existing(t, fakeClient, objs...); strings.Join(names, ",") != strings.Join(expected, ",") {
+			t.Errorf("expected %v existing, but got %v", expected, names)
+		}
+	})
+}
+
+// failTimes returns the interceptor which fails the deletion of the matched resources the given times
+func failTimes(times int, match func(obj client.Object) bool) interceptor.Funcs {
+	failed := 0
+	return interceptor.Funcs{
+		Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+			if match(obj) && failed < times {
+				failed++
+				return fmt.Errorf("injected error")
+			}
+			return c.Delete(ctx, obj, opts...)
+		},
+	}
+}
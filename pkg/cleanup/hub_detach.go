@@ -0,0 +1,137 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package cleanup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// annotationPause pauses the reconcile of the KlusterletAddonConfig
+const annotationPause = "klusterletaddonconfig-pause"
+
+var (
+	klusterletAddonConfigGVK = schema.GroupVersionKind{Group: "agent.open-cluster-management.io", Version: "v1",
+		Kind: "KlusterletAddonConfig"}
+	manifestWorkGVK = schema.GroupVersionKind{Group: "work.open-cluster-management.io", Version: "v1",
+		Kind: "ManifestWork"}
+	clusterDeploymentGVK = schema.GroupVersionKind{Group: "hive.openshift.io", Version: "v1",
+		Kind: "ClusterDeployment"}
+	managedClusterGVK = schema.GroupVersionKind{Group: "cluster.open-cluster-management.io", Version: "v1",
+		Kind: "ManagedCluster"}
+	openshiftRoleBindingGVK = schema.GroupVersionKind{Group: "authorization.openshift.io", Version: "v1",
+		Kind: "RoleBinding"}
+	openshiftRoleGVK = schema.GroupVersionKind{Group: "authorization.openshift.io", Version: "v1", Kind: "Role"}
+)
+
+// legacyManifestWorkSuffixes are the name suffixes of the ManifestWorks of the legacy klusterlet addon components
+var legacyManifestWorkSuffixes = []string{"appmgr", "certpolicyctrl", "policyctrl", "search", "workmgr"}
+
+// ManagedClusterNames returns the sorted names of all the managed clusters
+func (c *Cleaner) ManagedClusterNames(ctx context.Context) ([]string, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(managedClusterGVK.GroupVersion().WithKind(managedClusterGVK.Kind + "List"))
+	if err := c.client.List(ctx, list); err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, item := range list.Items {
+		names = append(names, item.GetName())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// HubDetach detaches the managed clusters from the hub concurrently, it replaces hub-detach.sh. The errors of
+// all the clusters are returned.
+func (c *Cleaner) HubDetach(ctx context.Context, clusterNames ...string) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(clusterNames))
+	for i, clusterName := range clusterNames {
+		wg.Add(1)
+		go func(i int, clusterName string) {
+			defer wg.Done()
+			if err := c.hubDetach(ctx, clusterName); err != nil {
+				errs[i] = fmt.Errorf("cluster %s: %v", clusterName, err)
+			}
+		}(i, clusterName)
+	}
+	wg.Wait()
+	return utilerrors.NewAggregate(errs)
+}
+
+// hubDetach removes the managed cluster and its klusterlet addon resources from the hub
+//  1. the KlusterletAddonConfig is paused, and the ManifestWorks of the legacy components are deleted
+//  2. the KlusterletAddonConfig is deleted and resumed to clean up, and force removed if it is not removed in time
+//  3. the ClusterDeployment is deleted and deprovisioned if the cluster is provisioned by hive
+//  4. the ManagedCluster, and the openshift Role and RoleBinding of the ManifestWorks are force removed
+func (c *Cleaner) hubDetach(ctx context.Context, clusterName string) error {
+	config := object{gvk: klusterletAddonConfigGVK, namespace: clusterName, name: clusterName}
+	workRoleName := fmt.Sprintf("%s:managed-cluster-work", clusterName)
+
+	steps := []step{
+		{
+			name: fmt.Sprintf("pause the KlusterletAddonConfig of cluster %s", clusterName),
+			run: func(ctx context.Context) error {
+				return c.annotate(ctx, config, annotationPause, "true")
+			},
+		},
+		{
+			name: fmt.Sprintf("delete the legacy ManifestWorks of cluster %s", clusterName),
+			run: func(ctx context.Context) error {
+				for _, suffix := range legacyManifestWorkSuffixes {
+					work := object{gvk: manifestWorkGVK, namespace: clusterName,
+						name: fmt.Sprintf("%s-%s", clusterName, suffix)}
+					if err := c.delete(ctx, work); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			name: fmt.Sprintf("delete the KlusterletAddonConfig of cluster %s", clusterName),
+			run: func(ctx context.Context) error {
+				if err := c.delete(ctx, config); err != nil {
+					return err
+				}
+				// the controller cleans up the deleted KlusterletAddonConfig once it is resumed
+				if err := c.annotate(ctx, config, annotationPause, "false"); err != nil {
+					return err
+				}
+				return c.forceDelete(ctx, config)
+			},
+		},
+		{
+			name: fmt.Sprintf("delete the ClusterDeployment of cluster %s", clusterName),
+			run: func(ctx context.Context) error {
+				return c.deleteAndWait(ctx,
+					object{gvk: clusterDeploymentGVK, namespace: clusterName, name: clusterName},
+					c.opts.ClusterDeploymentTimeout)
+			},
+		},
+		{
+			name: fmt.Sprintf("delete the ManagedCluster %s", clusterName),
+			run: func(ctx context.Context) error {
+				return c.forceDelete(ctx, object{gvk: managedClusterGVK, name: clusterName})
+			},
+		},
+		{
+			name: fmt.Sprintf("delete the work Role and RoleBinding of cluster %s", clusterName),
+			run: func(ctx context.Context) error {
+				if err := c.forceDelete(ctx, object{gvk: openshiftRoleBindingGVK, namespace: clusterName,
+					name: workRoleName}); err != nil {
+					return err
+				}
+				return c.forceDelete(ctx, object{gvk: openshiftRoleGVK, namespace: clusterName, name: workRoleName})
+			},
+		},
+	}
+	return c.run(ctx, steps)
+}
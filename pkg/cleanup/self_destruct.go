@@ -0,0 +1,174 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package cleanup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DefaultOperatorNamespace is the namespace of the legacy klusterlet addon operator on the managed cluster
+	DefaultOperatorNamespace = "open-cluster-management-agent-addon"
+	// DefaultKlusterletNamespace is the namespace of the klusterlet agents on the managed cluster
+	DefaultKlusterletNamespace = "open-cluster-management-agent"
+
+	klusterletName = "klusterlet"
+)
+
+var (
+	klusterletGVK = schema.GroupVersionKind{Group: "operator.open-cluster-management.io", Version: "v1",
+		Kind: "Klusterlet"}
+	namespaceGVK = schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}
+	crdGVK       = schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1",
+		Kind: "CustomResourceDefinition"}
+	serviceAccountGVK     = schema.GroupVersionKind{Version: "v1", Kind: "ServiceAccount"}
+	clusterRoleBindingGVK = schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1",
+		Kind: "ClusterRoleBinding"}
+)
+
+// legacyComponentKinds are the kinds of the components deployed by the legacy klusterlet addon operator, their
+// CustomResourceDefinitions are named <plural>.agent.open-cluster-management.io
+var legacyComponentKinds = []schema.GroupVersionKind{
+	{Group: "agent.open-cluster-management.io", Version: "v1", Kind: "ApplicationManager"},
+	{Group: "agent.open-cluster-management.io", Version: "v1", Kind: "CertPolicyController"},
+	{Group: "agent.open-cluster-management.io", Version: "v1", Kind: "PolicyController"},
+	{Group: "agent.open-cluster-management.io", Version: "v1", Kind: "SearchCollector"},
+	{Group: "agent.open-cluster-management.io", Version: "v1", Kind: "WorkManager"},
+}
+
+// legacyComponentCRDName returns the name of the CustomResourceDefinition of the legacy component kind
+func legacyComponentCRDName(gvk schema.GroupVersionKind) string {
+	return fmt.Sprintf("%ss.%s", strings.ToLower(gvk.Kind), gvk.Group)
+}
+
+// SelfDestruct removes the klusterlet and the legacy klusterlet addon components from the managed cluster, it
+// replaces self-destruct.sh
+//  1. the Klusterlet is deleted, and force removed if it is not removed in time
+//  2. the klusterlet namespace is deleted
+//  3. the resources of the legacy component CustomResourceDefinitions in the operator namespace are force removed,
+//     then the CustomResourceDefinitions are deleted
+//  4. the operator namespace is deleted
+//  5. the service account running the cleanup and its ClusterRoleBinding of the same name are deleted, if the
+//     service account is set
+func (c *Cleaner) SelfDestruct(ctx context.Context, operatorNamespace, klusterletNamespace string,
+	serviceAccount types.NamespacedName) error {
+	steps := []step{
+		{
+			name: "delete the Klusterlet",
+			run: func(ctx context.Context) error {
+				return c.forceDelete(ctx, object{gvk: klusterletGVK, name: klusterletName})
+			},
+		},
+		{
+			name: fmt.Sprintf("delete the klusterlet namespace %s", klusterletNamespace),
+			run: func(ctx context.Context) error {
+				return c.delete(ctx, object{gvk: namespaceGVK, name: klusterletNamespace})
+			},
+		},
+	}
+
+	for _, gvk := range legacyComponentKinds {
+		gvk := gvk
+		crd := object{gvk: crdGVK, name: legacyComponentCRDName(gvk)}
+		steps = append(steps, step{
+			name: fmt.Sprintf("delete the CustomResourceDefinition %s", crd.name),
+			run: func(ctx context.Context) error {
+				if u, err := c.get(ctx, crd); err != nil || u == nil {
+					return err
+				}
+				resources, err := c.list(ctx, gvk, operatorNamespace)
+				if err != nil {
+					return err
+				}
+				for _, resource := range resources {
+					if err := c.forceDelete(ctx, resource); err != nil {
+						return err
+					}
+				}
+				return c.delete(ctx, crd)
+			},
+		})
+	}
+
+	steps = append(steps, step{
+		name: fmt.Sprintf("delete the operator namespace %s", operatorNamespace),
+		run: func(ctx context.Context) error {
+			return c.delete(ctx, object{gvk: namespaceGVK, name: operatorNamespace})
+		},
+	})
+
+	if len(serviceAccount.Name) != 0 {
+		steps = append(steps, step{
+			name: fmt.Sprintf("delete the service account %s and its ClusterRoleBinding", serviceAccount),
+			run: func(ctx context.Context) error {
+				return c.deleteServiceAccount(ctx, serviceAccount)
+			},
+		})
+	}
+	return c.run(ctx, steps)
+}
+
+// deleteServiceAccount deletes the service account and the ClusterRoleBinding of the same name. The cleanup runs
+// as the service account, and it can not delete the other one after either is deleted. So the ClusterRoleBinding
+// is set as the owner of the service account, and the service account is removed by the garbage collector after
+// the ClusterRoleBinding is deleted.
+func (c *Cleaner) deleteServiceAccount(ctx context.Context, serviceAccount types.NamespacedName) error {
+	sa := object{gvk: serviceAccountGVK, namespace: serviceAccount.Namespace, name: serviceAccount.Name}
+	binding := object{gvk: clusterRoleBindingGVK, name: serviceAccount.Name}
+
+	u, err := c.get(ctx, binding)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return c.delete(ctx, sa)
+	}
+	if err := c.setOwner(ctx, sa, u); err != nil {
+		return err
+	}
+	return c.delete(ctx, binding)
+}
+
+// setOwner sets the owner reference of the resource if it exists
+func (c *Cleaner) setOwner(ctx context.Context, o object, owner *unstructured.Unstructured) error {
+	u, err := c.get(ctx, o)
+	if err != nil || u == nil {
+		return err
+	}
+	for _, ref := range u.GetOwnerReferences() {
+		if ref.UID == owner.GetUID() {
+			return nil
+		}
+	}
+	if c.opts.DryRun {
+		c.printf("set the owner of %s to %s (dry run)", o, owner.GetName())
+		return nil
+	}
+	c.printf("set the owner of %s to %s", o, owner.GetName())
+	patch, err := json.Marshal(map[string]interface{}{"metadata": map[string]interface{}{
+		"ownerReferences": append(u.GetOwnerReferences(), metav1.OwnerReference{
+			APIVersion: owner.GetAPIVersion(),
+			Kind:       owner.GetKind(),
+			Name:       owner.GetName(),
+			UID:        owner.GetUID(),
+		}),
+	}})
+	if err != nil {
+		return err
+	}
+	if err := c.client.Patch(ctx, u, client.RawPatch(types.MergePatchType, patch)); err != nil &&
+		!errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
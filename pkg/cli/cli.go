@@ -0,0 +1,94 @@
+// Copyright Contributors to the Open Cluster Management project
+
+// Package cli implements the commands of the kubectl-kac plugin, which inspects and edits the
+// KlusterletAddonConfigs of the managed clusters on the hub.
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+)
+
+// Command runs the commands of the plugin against the hub
+type Command struct {
+	// Client reads and writes the objects on the hub
+	Client client.Client
+	// Out is where the output of the commands is written
+	Out io.Writer
+}
+
+// managedAddons returns the names of the addons managed by the KlusterletAddonConfigs, sorted by name
+func managedAddons() []string {
+	addons := []string{}
+	for addonName, managed := range agentv1.KlusterletAddons {
+		if managed {
+			addons = append(addons, addonName)
+		}
+	}
+	sort.Strings(addons)
+	return addons
+}
+
+// validateAddon returns an error if the addon is not managed by the KlusterletAddonConfigs
+func validateAddon(addonName string) error {
+	if managed, ok := agentv1.KlusterletAddons[addonName]; ok && managed {
+		return nil
+	}
+	if reason := unmanagedAddonReason(addonName); len(reason) != 0 {
+		return fmt.Errorf("addon %s is not managed by the KlusterletAddonConfigs: %s", addonName, reason)
+	}
+	return fmt.Errorf("unknown addon %s, the addons are %v", addonName, managedAddons())
+}
+
+// unmanagedAddonReason returns why the addon is not managed by the KlusterletAddonConfigs, an empty string is
+// returned if the addon is managed or unknown
+func unmanagedAddonReason(addonName string) string {
+	switch addonName {
+	case agentv1.IamPolicyAddonName, agentv1.PolicyAddonName:
+		return "the addon is deprecated and removed, it is never deployed"
+	case agentv1.WorkManagerAddonName:
+		return "the addon is deployed with the klusterlet, it is not configured by the KlusterletAddonConfig"
+	}
+	return ""
+}
+
+// selectClusters returns the names of the managed clusters which match the selector, sorted by name
+func (c *Command) selectClusters(ctx context.Context, selector labels.Selector) ([]string, error) {
+	clusters := &mcv1.ManagedClusterList{}
+	if err := c.Client.List(ctx, clusters, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, fmt.Errorf("failed to list the managed clusters: %v", err)
+	}
+
+	names := []string{}
+	for _, cluster := range clusters.Items {
+		names = append(names, cluster.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// getKlusterletAddonConfig returns the KlusterletAddonConfig of the managed cluster, nil is returned if it does not
+// exist
+func (c *Command) getKlusterletAddonConfig(ctx context.Context,
+	clusterName string) (*agentv1.KlusterletAddonConfig, error) {
+	config := &agentv1.KlusterletAddonConfig{}
+	err := c.Client.Get(ctx, types.NamespacedName{Namespace: clusterName, Name: clusterName}, config)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the KlusterletAddonConfig of cluster %s: %v", clusterName, err)
+	}
+	return config, nil
+}
@@ -0,0 +1,248 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+)
+
+const (
+	kindKlusterletAddonConfig     = "KlusterletAddonConfig"
+	kindKlusterletAddonConfigList = "KlusterletAddonConfigList"
+	kindList                      = "List"
+)
+
+// SetAddons enables or disables the addons in the KlusterletAddonConfigs of the managed clusters which match the
+// selector. The KlusterletAddonConfigs are not changed in dry-run mode, only the changes are written.
+func (c *Command) SetAddons(ctx context.Context, selector labels.Selector, addonNames []string,
+	enabled, dryRun bool) error {
+	for _, addonName := range addonNames {
+		if err := validateAddon(addonName); err != nil {
+			return err
+		}
+	}
+
+	clusterNames, err := c.selectClusters(ctx, selector)
+	if err != nil {
+		return err
+	}
+	action := "disabled"
+	if enabled {
+		action = "enabled"
+	}
+	suffix := ""
+	if dryRun {
+		suffix = " (dry run)"
+	}
+
+	for _, clusterName := range clusterNames {
+		config, err := c.getKlusterletAddonConfig(ctx, clusterName)
+		if err != nil {
+			return err
+		}
+		if config == nil {
+			fmt.Fprintf(c.Out, "%s: skipped, the cluster has no KlusterletAddonConfig\n", clusterName)
+			continue
+		}
+
+		original := config.DeepCopy()
+		changed := []string{}
+		for _, addonName := range addonNames {
+			agentConfig := agentv1.GetAddonAgentConfig(addonName, config)
+			if agentConfig.Enabled != enabled {
+				agentConfig.Enabled = enabled
+				changed = append(changed, addonName)
+			}
+		}
+		if len(changed) == 0 {
+			fmt.Fprintf(c.Out, "%s: unchanged\n", clusterName)
+			continue
+		}
+
+		if !dryRun {
+			if err := c.Client.Patch(ctx, config, client.MergeFrom(original)); err != nil {
+				return fmt.Errorf("failed to update the KlusterletAddonConfig of cluster %s: %v", clusterName, err)
+			}
+		}
+		fmt.Fprintf(c.Out, "%s: %s %s%s\n", clusterName, strings.Join(changed, ", "), action, suffix)
+	}
+	return nil
+}
+
+// Export writes the KlusterletAddonConfigs of the managed clusters which match the selector as the yaml of a List,
+// without their status and the metadata set by the server
+func (c *Command) Export(ctx context.Context, selector labels.Selector) error {
+	clusterNames, err := c.selectClusters(ctx, selector)
+	if err != nil {
+		return err
+	}
+
+	items := []interface{}{}
+	for _, clusterName := range clusterNames {
+		config, err := c.getKlusterletAddonConfig(ctx, clusterName)
+		if err != nil {
+			return err
+		}
+		if config == nil {
+			continue
+		}
+
+		exported := &agentv1.KlusterletAddonConfig{Spec: config.Spec}
+		exported.SetGroupVersionKind(agentv1.SchemeGroupVersion.WithKind(kindKlusterletAddonConfig))
+		exported.Name = config.Name
+		exported.Namespace = config.Namespace
+		exported.Labels = config.Labels
+		exported.Annotations = config.Annotations
+		item, err := runtime.DefaultUnstructuredConverter.ToUnstructured(exported)
+		if err != nil {
+			return err
+		}
+		unstructured.RemoveNestedField(item, "metadata", "creationTimestamp")
+		unstructured.RemoveNestedField(item, "status")
+		items = append(items, item)
+	}
+
+	data, err := yaml.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       kindList,
+		"items":      items,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.Out.Write(data)
+	return err
+}
+
+// Import creates or updates the KlusterletAddonConfigs read from the yaml of a List, a KlusterletAddonConfigList or
+// single KlusterletAddonConfigs. The spec of an existing KlusterletAddonConfig is replaced, and the labels and
+// annotations are added to it. Nothing is changed in dry-run mode, only the changes are written.
+func (c *Command) Import(ctx context.Context, in io.Reader, dryRun bool) error {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	configs, err := decodeKlusterletAddonConfigs(data)
+	if err != nil {
+		return err
+	}
+	suffix := ""
+	if dryRun {
+		suffix = " (dry run)"
+	}
+
+	for _, config := range configs {
+		name := types.NamespacedName{Namespace: config.Namespace, Name: config.Name}
+		existing := &agentv1.KlusterletAddonConfig{}
+		err := c.Client.Get(ctx, name, existing)
+		switch {
+		case errors.IsNotFound(err):
+			if !dryRun {
+				if err := c.Client.Create(ctx, config); err != nil {
+					return fmt.Errorf("failed to create the KlusterletAddonConfig %s: %v", name, err)
+				}
+			}
+			fmt.Fprintf(c.Out, "%s: created%s\n", name, suffix)
+			continue
+		case err != nil:
+			return fmt.Errorf("failed to get the KlusterletAddonConfig %s: %v", name, err)
+		}
+
+		updated := existing.DeepCopy()
+		updated.Spec = config.Spec
+		for key, value := range config.Labels {
+			if updated.Labels == nil {
+				updated.Labels = map[string]string{}
+			}
+			updated.Labels[key] = value
+		}
+		for key, value := range config.Annotations {
+			if updated.Annotations == nil {
+				updated.Annotations = map[string]string{}
+			}
+			updated.Annotations[key] = value
+		}
+		if equality.Semantic.DeepEqual(existing, updated) {
+			fmt.Fprintf(c.Out, "%s: unchanged\n", name)
+			continue
+		}
+		if !dryRun {
+			if err := c.Client.Update(ctx, updated); err != nil {
+				return fmt.Errorf("failed to update the KlusterletAddonConfig %s: %v", name, err)
+			}
+		}
+		fmt.Fprintf(c.Out, "%s: updated%s\n", name, suffix)
+	}
+	return nil
+}
+
+// decodeKlusterletAddonConfigs decodes the KlusterletAddonConfigs from the yaml or json documents, the namespace of
+// a KlusterletAddonConfig is its name if it is not set
+func decodeKlusterletAddonConfigs(data []byte) ([]*agentv1.KlusterletAddonConfig, error) {
+	configs := []*agentv1.KlusterletAddonConfig{}
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("failed to decode the KlusterletAddonConfigs: %v", err)
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+
+		objs := []unstructured.Unstructured{*obj}
+		switch obj.GetKind() {
+		case kindList, kindKlusterletAddonConfigList:
+			list, err := obj.ToList()
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode the list: %v", err)
+			}
+			objs = list.Items
+		}
+
+		for _, item := range objs {
+			if item.GroupVersionKind() != agentv1.SchemeGroupVersion.WithKind(kindKlusterletAddonConfig) {
+				return nil, fmt.Errorf("%s %s is not a KlusterletAddonConfig of %s", item.GetKind(), item.GetName(),
+					agentv1.SchemeGroupVersion)
+			}
+			config := &agentv1.KlusterletAddonConfig{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, config); err != nil {
+				return nil, fmt.Errorf("failed to decode the KlusterletAddonConfig %s: %v", item.GetName(), err)
+			}
+			if len(config.Namespace) == 0 {
+				config.Namespace = config.Name
+			}
+			// the metadata set by the server, like the resource version, is dropped
+			configs = append(configs, &agentv1.KlusterletAddonConfig{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        config.Name,
+					Namespace:   config.Namespace,
+					Labels:      config.Labels,
+					Annotations: config.Annotations,
+				},
+				Spec: config.Spec,
+			})
+		}
+	}
+	return configs, nil
+}
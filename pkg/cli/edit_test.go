@@ -0,0 +1,160 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package cli
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+)
+
+func Test_SetAddons(t *testing.T) {
+	cases := []struct {
+		name           string
+		addons         []string
+		enabled        bool
+		dryRun         bool
+		expectErr      bool
+		expectedOutput []string
+		expectedCert   bool
+		expectedSearch bool
+	}{
+		{
+			name:           "enable addons",
+			addons:         []string{agentv1.CertPolicyAddonName, agentv1.SearchAddonName},
+			enabled:        true,
+			expectedOutput: []string{"cluster1: cert-policy-controller enabled", "cluster2: skipped"},
+			expectedCert:   true,
+			expectedSearch: true,
+		},
+		{
+			name:           "disable addons in dry-run mode",
+			addons:         []string{agentv1.SearchAddonName},
+			dryRun:         true,
+			expectedOutput: []string{"cluster1: search-collector disabled (dry run)"},
+			expectedSearch: true,
+		},
+		{
+			name:           "unchanged",
+			addons:         []string{agentv1.CertPolicyAddonName},
+			expectedOutput: []string{"cluster1: unchanged"},
+			expectedSearch: true,
+		},
+		{
+			name:           "deprecated addon",
+			addons:         []string{agentv1.IamPolicyAddonName},
+			enabled:        true,
+			expectErr:      true,
+			expectedSearch: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cmd, out := newTestCommand(
+				newManagedCluster("cluster1", map[string]string{"env": "prod"}),
+				newManagedCluster("cluster2", map[string]string{"env": "prod"}),
+				newKlusterletAddonConfig("cluster1"),
+			)
+
+			err := cmd.SetAddons(context.TODO(), labels.SelectorFromSet(labels.Set{"env": "prod"}), c.addons,
+				c.enabled, c.dryRun)
+			if c.expectErr != (err != nil) {
+				t.Fatalf("expected error %v, but got %v", c.expectErr, err)
+			}
+			for _, expected := range c.expectedOutput {
+				if !strings.Contains(out.String(), expected) {
+					t.Errorf("expected output %q, but got:\n%s", expected, out.String())
+				}
+			}
+
+			config := &agentv1.KlusterletAddonConfig{}
+			if err := cmd.Client.Get(context.TODO(), types.NamespacedName{Namespace: "cluster1", Name: "cluster1"},
+				config); err != nil {
+				t.Fatalf("failed to get klusterletaddonconfig: %v", err)
+			}
+			if config.Spec.CertPolicyControllerConfig.Enabled != c.expectedCert ||
+				config.Spec.SearchCollectorConfig.Enabled != c.expectedSearch {
+				t.Errorf("expected cert-policy-controller %v and search-collector %v, but got %v and %v",
+					c.expectedCert, c.expectedSearch, config.Spec.CertPolicyControllerConfig.Enabled,
+					config.Spec.SearchCollectorConfig.Enabled)
+			}
+		})
+	}
+}
+
+func Test_ExportImport(t *testing.T) {
+	config := newKlusterletAddonConfig("cluster1")
+	config.Labels = map[string]string{"team": "a"}
+	exporter, exported := newTestCommand(newManagedCluster("cluster1", nil), config)
+	if err := exporter.Export(context.TODO(), labels.Everything()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, unexpected := range []string{"status", "resourceVersion", "creationTimestamp"} {
+		if strings.Contains(exported.String(), unexpected) {
+			t.Errorf("expected %s is not exported, but got:\n%s", unexpected, exported.String())
+		}
+	}
+
+	// import to a hub without the KlusterletAddonConfig
+	importer, out := newTestCommand()
+	if err := importer.Import(context.TODO(), strings.NewReader(exported.String()), true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), "cluster1/cluster1: created (dry run)") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+	if err := importer.Import(context.TODO(), strings.NewReader(exported.String()), false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	imported := &agentv1.KlusterletAddonConfig{}
+	if err := importer.Client.Get(context.TODO(), types.NamespacedName{Namespace: "cluster1", Name: "cluster1"},
+		imported); err != nil {
+		t.Fatalf("failed to get klusterletaddonconfig: %v", err)
+	}
+	if !imported.Spec.SearchCollectorConfig.Enabled || imported.Labels["team"] != "a" {
+		t.Errorf("unexpected imported klusterletaddonconfig: %v", imported)
+	}
+
+	// import again with the changed spec
+	out.Reset()
+	if err := importer.Import(context.TODO(), strings.NewReader(exported.String()), false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), "cluster1/cluster1: unchanged") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+	changed := `apiVersion: agent.open-cluster-management.io/v1
+kind: KlusterletAddonConfig
+metadata:
+  name: cluster1
+spec:
+  searchCollector:
+    enabled: false
+`
+	out.Reset()
+	if err := importer.Import(context.TODO(), strings.NewReader(changed), false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), "cluster1/cluster1: updated") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+	if err := importer.Client.Get(context.TODO(), types.NamespacedName{Namespace: "cluster1", Name: "cluster1"},
+		imported); err != nil {
+		t.Fatalf("failed to get klusterletaddonconfig: %v", err)
+	}
+	if imported.Spec.SearchCollectorConfig.Enabled || imported.Labels["team"] != "a" {
+		t.Errorf("unexpected updated klusterletaddonconfig: %v", imported)
+	}
+
+	// the other kinds are rejected
+	if err := importer.Import(context.TODO(), strings.NewReader("apiVersion: v1\nkind: ConfigMap\n"),
+		false); err == nil {
+		t.Errorf("expected error for the other kinds")
+	}
+}
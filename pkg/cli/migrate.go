@@ -0,0 +1,126 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"github.com/stolostron/klusterlet-addon-controller/pkg/migration"
+)
+
+// verifyInterval is the interval to verify the decisions of the Placements before the switch over
+var verifyInterval = 5 * time.Second
+
+// Migrate plans the migration of the addons of the KlusterletAddonConfigs to the Placements of the
+// ClusterManagementAddOns. The blocked addons and the groups of the clusters are written, and the generated resources
+// are written as yaml unless they are applied. With switchOver, the install strategies of the ClusterManagementAddOns
+// are switched once the Placements select the clusters of the KlusterletAddonConfigs, or an error is returned after
+// the timeout.
+func (c *Command) Migrate(ctx context.Context, opts migration.Options, apply, switchOver bool,
+	timeout time.Duration) error {
+	migrator := migration.NewMigrator(c.Client, opts)
+	plan, err := migrator.Plan(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, addonName := range managedAddons() {
+		if reasons, ok := plan.Blocked[addonName]; ok {
+			fmt.Fprintf(c.Out, "%s: blocked\n  %s\n", addonName, strings.Join(reasons, "\n  "))
+		}
+	}
+	if len(plan.Addons) == 0 {
+		fmt.Fprintln(c.Out, "no addons to migrate")
+		return nil
+	}
+	fmt.Fprintf(c.Out, "migrated addons: %s\n", strings.Join(plan.Addons, ", "))
+	for _, group := range plan.Groups {
+		addons := []string{}
+		for _, groupAddon := range group.Addons {
+			if len(groupAddon.DeploymentConfig) != 0 {
+				addons = append(addons, fmt.Sprintf("%s(%s)", groupAddon.Name, groupAddon.DeploymentConfig))
+				continue
+			}
+			addons = append(addons, groupAddon.Name)
+		}
+		fmt.Fprintf(c.Out, "group %s: %s\n  clusters: %s\n", group.Name, strings.Join(addons, ", "),
+			strings.Join(group.Clusters, ", "))
+	}
+
+	if !apply && !switchOver {
+		return c.writePlan(plan)
+	}
+	if err := migrator.Apply(ctx, plan); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Out, "applied %d Placements and %d AddOnDeploymentConfigs\n", len(plan.Placements),
+		len(plan.DeploymentConfigs))
+	if !switchOver {
+		return nil
+	}
+
+	var mismatches []string
+	err = wait.PollUntilContextTimeout(ctx, verifyInterval, timeout, true,
+		func(ctx context.Context) (bool, error) {
+			mismatches, err = migrator.Verify(ctx, plan)
+			return err == nil && len(mismatches) == 0, err
+		})
+	if err != nil {
+		if len(mismatches) != 0 {
+			return fmt.Errorf("the Placements do not install the addons of the KlusterletAddonConfigs:\n  %s",
+				strings.Join(mismatches, "\n  "))
+		}
+		return err
+	}
+	if err := migrator.SwitchOver(ctx, plan); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Out, "switched the install strategies of %s to the Placements\n", strings.Join(plan.Addons, ", "))
+	return nil
+}
+
+// writePlan writes the resources of the plan as the yaml of a List, the install strategies are written as the
+// commented patches of the ClusterManagementAddOns
+func (c *Command) writePlan(plan *migration.Plan) error {
+	items := []client.Object{plan.ClusterSetBinding}
+	for _, placement := range plan.Placements {
+		items = append(items, placement)
+	}
+	for _, deploymentConfig := range plan.DeploymentConfigs {
+		items = append(items, deploymentConfig)
+	}
+	patches := map[string]interface{}{}
+	for _, addonName := range plan.Addons {
+		patches[addonName] = map[string]interface{}{
+			"spec": map[string]interface{}{"installStrategy": plan.InstallStrategies[addonName]},
+		}
+	}
+
+	data, err := yaml.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       kindList,
+		"items":      items,
+	})
+	if err != nil {
+		return err
+	}
+	patchData, err := yaml.Marshal(patches)
+	if err != nil {
+		return err
+	}
+	if _, err := c.Out.Write(data); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.Out, "# the patches of the ClusterManagementAddOns at the switch over")
+	for _, line := range strings.Split(strings.TrimSpace(string(patchData)), "\n") {
+		fmt.Fprintf(c.Out, "# %s\n", line)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package cli
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/migration"
+)
+
+func Test_Migrate(t *testing.T) {
+	verifyInterval = 10 * time.Millisecond
+	ctx := context.TODO()
+	opts := migration.Options{Addons: []string{agentv1.ApplicationAddonName, agentv1.SearchAddonName}}
+	cmd, out := newTestCommand(
+		&addonv1alpha1.ClusterManagementAddOn{ObjectMeta: metav1.ObjectMeta{Name: agentv1.ApplicationAddonName}},
+		newManagedCluster("cluster1", nil),
+		newManagedCluster("cluster2", nil),
+		newKlusterletAddonConfig("cluster1"),
+		newKlusterletAddonConfig("cluster2"),
+	)
+
+	// the plan is written without changing the hub
+	if err := cmd.Migrate(ctx, opts, false, false, time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, expected := range []string{
+		"search-collector: blocked\n  the ClusterManagementAddOn does not exist",
+		"migrated addons: application-manager",
+		"clusters: cluster1, cluster2",
+		"kind: Placement",
+		"#     installStrategy:",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected %q in the output, but got:\n%s", expected, out.String())
+		}
+	}
+	if err := cmd.Client.Get(ctx, types.NamespacedName{Name: migration.DefaultClusterSet,
+		Namespace: migration.DefaultNamespace}, &clusterv1beta1.Placement{}); err == nil {
+		t.Errorf("expected no resources created without apply")
+	}
+
+	// the switch over times out without the decisions of the Placements
+	out.Reset()
+	err := cmd.Migrate(ctx, opts, false, true, 50*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "cluster1: the KlusterletAddonConfig installs [application-manager]") {
+		t.Fatalf("expected the mismatch of cluster1, but got %v", err)
+	}
+
+	plan, err := migration.NewMigrator(cmd.Client, opts).Plan(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decision := &clusterv1beta1.PlacementDecision{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: migration.DefaultNamespace,
+			Name:      "decision",
+			Labels:    map[string]string{clusterv1beta1.PlacementLabel: plan.Groups[0].Name},
+		},
+		Status: clusterv1beta1.PlacementDecisionStatus{Decisions: []clusterv1beta1.ClusterDecision{
+			{ClusterName: "cluster1"}, {ClusterName: "cluster2"},
+		}},
+	}
+	if err := cmd.Client.Create(ctx, decision); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out.Reset()
+	if err := cmd.Migrate(ctx, opts, false, true, time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), "switched the install strategies of application-manager") {
+		t.Errorf("expected the switch over in the output, but got:\n%s", out.String())
+	}
+	cma := &addonv1alpha1.ClusterManagementAddOn{}
+	if err := cmd.Client.Get(ctx, types.NamespacedName{Name: agentv1.ApplicationAddonName}, cma); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cma.Spec.InstallStrategy.Type != addonv1alpha1.AddonInstallStrategyPlacements {
+		t.Errorf("expected the Placements install strategy, but got %v", cma.Spec.InstallStrategy)
+	}
+}
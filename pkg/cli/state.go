@@ -0,0 +1,236 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+)
+
+const (
+	// the states of the ManagedClusterAddOn of an addon
+	addonStateAvailable    = "Available"
+	addonStateUnavailable  = "Unavailable"
+	addonStateUnknown      = "Unknown"
+	addonStateDeleting     = "Deleting"
+	addonStateNotInstalled = "NotInstalled"
+
+	// the reasons an addon is skipped or held which are not reported by the conditions of the KlusterletAddonConfig
+	reasonUnmanaged               = "Unmanaged"
+	reasonNoKlusterletAddonConfig = "NoKlusterletAddonConfig"
+	reasonPlacements              = "InstalledByPlacements"
+	reasonDryRun                  = "DryRun"
+	reasonPaused                  = "Paused"
+	reasonDisabled                = "Disabled"
+)
+
+// clusterConditions is the conditions of the KlusterletAddonConfig which hold all the addons of the cluster
+var clusterConditions = []string{
+	agentv1.AddonsDeferred,
+	agentv1.AddonsTornDown,
+}
+
+// addonConditions is the conditions of the KlusterletAddonConfig which list the addons skipped or held by the
+// controller in their messages
+var addonConditions = []string{
+	agentv1.AddonsIncompatible,
+	agentv1.AddonArchitectureUnsupported,
+	agentv1.AddonResourcesInvalid,
+	agentv1.AddonDependencyConflict,
+	agentv1.AddonsWaitingForPrerequisites,
+	agentv1.AddonModeMigrating,
+	agentv1.AddonChangesPending,
+	agentv1.AddonsRollingOut,
+	agentv1.ManagedClusterAddOnApplyConflict,
+}
+
+// skipReason is why an addon is skipped or held by the controller
+type skipReason struct {
+	reason  string
+	message string
+}
+
+// List writes the effective state of the addons of the managed clusters which match the selector
+func (c *Command) List(ctx context.Context, selector labels.Selector) error {
+	clusterNames, err := c.selectClusters(ctx, selector)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.Out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER\tADDON\tENABLED\tSTATE\tREASONS")
+	for _, clusterName := range clusterNames {
+		config, err := c.getKlusterletAddonConfig(ctx, clusterName)
+		if err != nil {
+			return err
+		}
+		if config == nil {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t%s\n", clusterName, reasonNoKlusterletAddonConfig)
+			continue
+		}
+
+		for _, addonName := range managedAddons() {
+			state, err := c.getAddonState(ctx, clusterName, addonName)
+			if err != nil {
+				return err
+			}
+			reasons, err := c.explainAddon(ctx, config, addonName)
+			if err != nil {
+				return err
+			}
+			names := []string{}
+			for _, reason := range reasons {
+				names = append(names, reason.reason)
+			}
+			if len(names) == 0 {
+				names = append(names, "-")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%s\n", clusterName, addonName,
+				agentv1.GetAddonAgentConfig(addonName, config).Enabled, state, strings.Join(names, ","))
+		}
+	}
+	return w.Flush()
+}
+
+// Explain writes why the addon of the managed cluster is skipped or held by the controller
+func (c *Command) Explain(ctx context.Context, clusterName, addonName string) error {
+	var reasons []skipReason
+	if message := unmanagedAddonReason(addonName); len(message) != 0 {
+		reasons = append(reasons, skipReason{reason: reasonUnmanaged, message: message})
+	} else {
+		if err := validateAddon(addonName); err != nil {
+			return err
+		}
+		config, err := c.getKlusterletAddonConfig(ctx, clusterName)
+		if err != nil {
+			return err
+		}
+		if config == nil {
+			reasons = append(reasons, skipReason{reason: reasonNoKlusterletAddonConfig,
+				message: "the cluster has no KlusterletAddonConfig"})
+		} else if reasons, err = c.explainAddon(ctx, config, addonName); err != nil {
+			return err
+		}
+	}
+
+	state, err := c.getAddonState(ctx, clusterName, addonName)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Out, "Addon %s of cluster %s is %s.\n", addonName, clusterName, state)
+	if len(reasons) == 0 {
+		fmt.Fprintln(c.Out, "It is not skipped or held by the klusterlet addon controller.")
+		return nil
+	}
+	fmt.Fprintln(c.Out, "It is skipped or held by the klusterlet addon controller because:")
+	for _, reason := range reasons {
+		fmt.Fprintf(c.Out, "- %s: %s\n", reason.reason, reason.message)
+	}
+	return nil
+}
+
+// explainAddon returns why the addon is skipped or held by the controller, from the KlusterletAddonConfig, its
+// conditions, and the install strategy of the ClusterManagementAddOn
+func (c *Command) explainAddon(ctx context.Context, config *agentv1.KlusterletAddonConfig,
+	addonName string) ([]skipReason, error) {
+	reasons := []skipReason{}
+
+	cma := &addonv1alpha1.ClusterManagementAddOn{}
+	err := c.Client.Get(ctx, types.NamespacedName{Name: addonName}, cma)
+	switch {
+	case err == nil && cma.Spec.InstallStrategy.Type == addonv1alpha1.AddonInstallStrategyPlacements:
+		reasons = append(reasons, skipReason{reason: reasonPlacements, message: fmt.Sprintf(
+			"the addon is installed by the placements of the ClusterManagementAddOn %s", addonName)})
+	case err != nil && !errors.IsNotFound(err):
+		return nil, fmt.Errorf("failed to get the ClusterManagementAddOn %s: %v", addonName, err)
+	}
+
+	if config.Spec.DryRun {
+		reasons = append(reasons, skipReason{reason: reasonDryRun,
+			message: "the changes are only planned in the status of the KlusterletAddonConfig"})
+	}
+
+	agentConfig := agentv1.GetAddonAgentConfig(addonName, config)
+	paused := meta.FindStatusCondition(config.Status.Conditions, agentv1.AddonsPaused)
+	switch {
+	case config.Spec.Paused || (paused != nil && paused.Status == metav1.ConditionTrue &&
+		paused.Reason == agentv1.ReasonKlusterletAddonConfigPaused):
+		reasons = append(reasons, skipReason{reason: reasonPaused, message: "the KlusterletAddonConfig is paused"})
+	case agentConfig.Paused:
+		reasons = append(reasons, skipReason{reason: reasonPaused,
+			message: "the addon is paused in the KlusterletAddonConfig"})
+	}
+	if !agentConfig.Enabled {
+		reasons = append(reasons, skipReason{reason: reasonDisabled,
+			message: "the addon is disabled in the KlusterletAddonConfig"})
+	}
+
+	for _, conditionType := range clusterConditions {
+		condition := meta.FindStatusCondition(config.Status.Conditions, conditionType)
+		if condition != nil && condition.Status == metav1.ConditionTrue {
+			reasons = append(reasons, skipReason{reason: conditionType, message: condition.Message})
+		}
+	}
+	for _, conditionType := range addonConditions {
+		condition := meta.FindStatusCondition(config.Status.Conditions, conditionType)
+		if condition == nil || condition.Status != metav1.ConditionTrue {
+			continue
+		}
+		if message, ok := getAddonMessage(condition.Message, addonName); ok {
+			reasons = append(reasons, skipReason{reason: conditionType, message: message})
+		}
+	}
+	return reasons, nil
+}
+
+// getAddonMessage returns the message of the addon in the message of a condition, the addons are listed in the
+// condition message like "message: addon1: [message1], addon2: [message2]"
+func getAddonMessage(conditionMessage, addonName string) (string, bool) {
+	prefix := " " + addonName + ": ["
+	start := strings.Index(conditionMessage, prefix)
+	if start < 0 {
+		return "", false
+	}
+
+	message := conditionMessage[start+len(prefix):]
+	if end := strings.Index(message, "], "); end >= 0 {
+		return message[:end], true
+	}
+	return strings.TrimSuffix(message, "]"), true
+}
+
+// getAddonState returns the state of the ManagedClusterAddOn of the addon
+func (c *Command) getAddonState(ctx context.Context, clusterName, addonName string) (string, error) {
+	addon := &addonv1alpha1.ManagedClusterAddOn{}
+	err := c.Client.Get(ctx, types.NamespacedName{Namespace: clusterName, Name: addonName}, addon)
+	if errors.IsNotFound(err) {
+		return addonStateNotInstalled, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get the ManagedClusterAddOn %s/%s: %v", clusterName, addonName, err)
+	}
+
+	if !addon.DeletionTimestamp.IsZero() {
+		return addonStateDeleting, nil
+	}
+	available := meta.FindStatusCondition(addon.Status.Conditions, addonv1alpha1.ManagedClusterAddOnConditionAvailable)
+	switch {
+	case available == nil || available.Status == metav1.ConditionUnknown:
+		return addonStateUnknown, nil
+	case available.Status == metav1.ConditionTrue:
+		return addonStateAvailable, nil
+	}
+	return addonStateUnavailable, nil
+}
@@ -0,0 +1,246 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+	clusterv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
+	workv1 "open-cluster-management.io/api/work/v1"
+
+	"github.com/stolostron/klusterlet-addon-controller/pkg/apis"
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+)
+
+func newTestScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = apis.AddToScheme(scheme)
+	_ = mcv1.AddToScheme(scheme)
+	_ = addonv1alpha1.AddToScheme(scheme)
+	_ = workv1.AddToScheme(scheme)
+	_ = clusterv1beta1.AddToScheme(scheme)
+	_ = clusterv1beta2.AddToScheme(scheme)
+	return scheme
+}
+
+func newTestCommand(objs ...runtime.Object) (*Command, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme()).WithRuntimeObjects(objs...).
+		WithStatusSubresource(&agentv1.KlusterletAddonConfig{}).Build()
+	return &Command{Client: c, Out: out}, out
+}
+
+func newManagedCluster(name string, labels map[string]string) *mcv1.ManagedCluster {
+	return &mcv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func newKlusterletAddonConfig(clusterName string, conditions ...metav1.Condition) *agentv1.KlusterletAddonConfig {
+	return &agentv1.KlusterletAddonConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: clusterName, Namespace: clusterName},
+		Spec: agentv1.KlusterletAddonConfigSpec{
+			SearchCollectorConfig:      agentv1.KlusterletAddonAgentConfigSpec{Enabled: true},
+			PolicyController:           agentv1.KlusterletAddonAgentConfigSpec{Enabled: true},
+			ApplicationManagerConfig:   agentv1.KlusterletAddonAgentConfigSpec{Enabled: true},
+			CertPolicyControllerConfig: agentv1.KlusterletAddonAgentConfigSpec{Enabled: false},
+		},
+		Status: agentv1.KlusterletAddonConfigStatus{Conditions: conditions},
+	}
+}
+
+func newManagedClusterAddon(clusterName, addonName, values string, available metav1.ConditionStatus) client.Object {
+	addon := &addonv1alpha1.ManagedClusterAddOn{
+		ObjectMeta: metav1.ObjectMeta{Name: addonName, Namespace: clusterName},
+	}
+	if len(values) != 0 {
+		addon.Annotations = map[string]string{annotationValues: values}
+	}
+	if len(available) != 0 {
+		addon.Status.Conditions = []metav1.Condition{{
+			Type:   addonv1alpha1.ManagedClusterAddOnConditionAvailable,
+			Status: available,
+		}}
+	}
+	return addon
+}
+
+func Test_List(t *testing.T) {
+	cmd, out := newTestCommand(
+		newManagedCluster("cluster1", map[string]string{"env": "prod"}),
+		newManagedCluster("cluster2", map[string]string{"env": "prod"}),
+		newManagedCluster("cluster3", map[string]string{"env": "dev"}),
+		newKlusterletAddonConfig("cluster1"),
+		newKlusterletAddonConfig("cluster3"),
+		newManagedClusterAddon("cluster1", agentv1.SearchAddonName, "", metav1.ConditionTrue),
+		newManagedClusterAddon("cluster1", agentv1.ApplicationAddonName, "", metav1.ConditionFalse),
+	)
+
+	if err := cmd.List(context.TODO(), labels.SelectorFromSet(labels.Set{"env": "prod"})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	// the header, the addons of cluster1 and the line of cluster2 without KlusterletAddonConfig
+	if len(lines) != len(managedAddons())+2 {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+	expected := map[string][]string{
+		agentv1.SearchAddonName:      {"cluster1", agentv1.SearchAddonName, "true", addonStateAvailable, "-"},
+		agentv1.ApplicationAddonName: {"cluster1", agentv1.ApplicationAddonName, "true", addonStateUnavailable, "-"},
+		agentv1.CertPolicyAddonName: {"cluster1", agentv1.CertPolicyAddonName, "false", addonStateNotInstalled,
+			reasonDisabled},
+		"cluster2": {"cluster2", "-", "-", "-", reasonNoKlusterletAddonConfig},
+	}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		key := fields[1]
+		if fields[0] == "cluster2" {
+			key = fields[0]
+		}
+		if fields[0] == "cluster3" {
+			t.Errorf("expected cluster3 is not selected, but got %s", line)
+		}
+		if want, ok := expected[key]; ok && strings.Join(fields, " ") != strings.Join(want, " ") {
+			t.Errorf("expected %v, but got %v", want, fields)
+		}
+	}
+}
+
+func Test_Explain(t *testing.T) {
+	placements := &addonv1alpha1.ClusterManagementAddOn{
+		ObjectMeta: metav1.ObjectMeta{Name: agentv1.ApplicationAddonName},
+		Spec: addonv1alpha1.ClusterManagementAddOnSpec{
+			InstallStrategy: addonv1alpha1.InstallStrategy{Type: addonv1alpha1.AddonInstallStrategyPlacements},
+		},
+	}
+	paused := newKlusterletAddonConfig("cluster1")
+	paused.Spec.SearchCollectorConfig.Paused = true
+
+	cases := []struct {
+		name            string
+		objs            []runtime.Object
+		addonName       string
+		expectedReasons []string
+		expectErr       bool
+	}{
+		{
+			name:      "not skipped",
+			objs:      []runtime.Object{newKlusterletAddonConfig("cluster1")},
+			addonName: agentv1.SearchAddonName,
+		},
+		{
+			name:            "deprecated addon",
+			addonName:       agentv1.IamPolicyAddonName,
+			expectedReasons: []string{reasonUnmanaged},
+		},
+		{
+			name:      "unknown addon",
+			addonName: "unknown",
+			expectErr: true,
+		},
+		{
+			name:            "no klusterletaddonconfig",
+			addonName:       agentv1.SearchAddonName,
+			expectedReasons: []string{reasonNoKlusterletAddonConfig},
+		},
+		{
+			name:            "installed by placements",
+			objs:            []runtime.Object{newKlusterletAddonConfig("cluster1"), placements},
+			addonName:       agentv1.ApplicationAddonName,
+			expectedReasons: []string{reasonPlacements},
+		},
+		{
+			name:            "paused addon",
+			objs:            []runtime.Object{paused},
+			addonName:       agentv1.SearchAddonName,
+			expectedReasons: []string{reasonPaused},
+		},
+		{
+			name:            "disabled addon",
+			objs:            []runtime.Object{newKlusterletAddonConfig("cluster1")},
+			addonName:       agentv1.CertPolicyAddonName,
+			expectedReasons: []string{reasonDisabled},
+		},
+		{
+			name: "reported by the conditions",
+			objs: []runtime.Object{newKlusterletAddonConfig("cluster1",
+				metav1.Condition{
+					Type:    agentv1.AddonsIncompatible,
+					Status:  metav1.ConditionTrue,
+					Message: "The addons are not supported: config-policy-controller: [MicroShift], search-collector: [IBMZ]",
+				},
+				metav1.Condition{
+					Type:    agentv1.AddonsRollingOut,
+					Status:  metav1.ConditionFalse,
+					Message: "search-collector: [held]",
+				})},
+			addonName:       agentv1.SearchAddonName,
+			expectedReasons: []string{agentv1.AddonsIncompatible + ": IBMZ"},
+		},
+		{
+			name: "held for the cluster",
+			objs: []runtime.Object{newKlusterletAddonConfig("cluster1", metav1.Condition{
+				Type:    agentv1.AddonsDeferred,
+				Status:  metav1.ConditionTrue,
+				Message: "The cluster is not available",
+			})},
+			addonName:       agentv1.SearchAddonName,
+			expectedReasons: []string{agentv1.AddonsDeferred + ": The cluster is not available"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cmd, out := newTestCommand(c.objs...)
+			err := cmd.Explain(context.TODO(), "cluster1", c.addonName)
+			if c.expectErr != (err != nil) {
+				t.Fatalf("expected error %v, but got %v", c.expectErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			output := out.String()
+			if len(c.expectedReasons) == 0 && !strings.Contains(output, "It is not skipped") {
+				t.Errorf("expected the addon is not skipped, but got:\n%s", output)
+			}
+			for _, reason := range c.expectedReasons {
+				if !strings.Contains(output, "- "+reason) {
+					t.Errorf("expected reason %q, but got:\n%s", reason, output)
+				}
+			}
+		})
+	}
+}
+
+func Test_getAddonMessage(t *testing.T) {
+	message := "The addons are held: config-policy-controller: [enable], policy-controller: [update values]"
+	cases := []struct {
+		addonName string
+		expected  string
+		found     bool
+	}{
+		{addonName: agentv1.ConfigPolicyAddonName, expected: "enable", found: true},
+		{addonName: agentv1.PolicyAddonName, expected: "update values", found: true},
+		{addonName: agentv1.SearchAddonName},
+	}
+	for _, c := range cases {
+		actual, found := getAddonMessage(message, c.addonName)
+		if actual != c.expected || found != c.found {
+			t.Errorf("expected %q %v for %s, but got %q %v", c.expected, c.found, c.addonName, actual, found)
+		}
+	}
+}
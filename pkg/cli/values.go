@@ -0,0 +1,82 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/controller/addon"
+)
+
+// annotationValues is the annotation of the ManagedClusterAddOns of the values set by the controller
+const annotationValues = "addon.open-cluster-management.io/values"
+
+// Values writes the values of the ManagedClusterAddOns of the managed cluster decoded as yaml, the values of all
+// the addons are written if the addon name is empty
+func (c *Command) Values(ctx context.Context, clusterName, addonName string) error {
+	addonNames := managedAddons()
+	if len(addonName) != 0 {
+		if err := validateAddon(addonName); err != nil {
+			return err
+		}
+		addonNames = []string{addonName}
+	}
+
+	for _, name := range addonNames {
+		mca := &addonv1alpha1.ManagedClusterAddOn{}
+		err := c.Client.Get(ctx, types.NamespacedName{Namespace: clusterName, Name: name}, mca)
+		if errors.IsNotFound(err) {
+			if len(addonName) != 0 {
+				return fmt.Errorf("addon %s is not installed on cluster %s", name, clusterName)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get the ManagedClusterAddOn %s/%s: %v", clusterName, name, err)
+		}
+
+		fmt.Fprintf(c.Out, "# %s\n", name)
+		values := mca.Annotations[annotationValues]
+		if len(values) == 0 {
+			fmt.Fprintln(c.Out, "{}")
+			continue
+		}
+		decoded, err := yaml.JSONToYAML([]byte(values))
+		if err != nil {
+			return fmt.Errorf("failed to decode the values of the ManagedClusterAddOn %s/%s: %v",
+				clusterName, name, err)
+		}
+		if _, err := c.Out.Write(decoded); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Plan writes the changes of the ManagedClusterAddOns which the controller with the options would apply for the
+// KlusterletAddonConfig of the managed cluster, nothing is changed on the hub
+func (c *Command) Plan(ctx context.Context, clusterName string, opts *common.Options) error {
+	plan, err := addon.NewPlanner(c.Client, opts).Plan(ctx, clusterName, clusterName)
+	if err != nil {
+		return err
+	}
+	if len(plan.Addons) == 0 {
+		fmt.Fprintf(c.Out, "No changes of the addons of cluster %s.\n", clusterName)
+		return nil
+	}
+
+	data, err := yaml.Marshal(plan.Addons)
+	if err != nil {
+		return err
+	}
+	_, err = c.Out.Write(data)
+	return err
+}
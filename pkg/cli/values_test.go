@@ -0,0 +1,90 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package cli
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+)
+
+func Test_Values(t *testing.T) {
+	objs := []runtime.Object{
+		newManagedClusterAddon("cluster1", agentv1.SearchAddonName,
+			`{"global":{"nodeSelector":{"zone":"a"},"imagePullPolicy":"Always"}}`, metav1.ConditionTrue),
+		newManagedClusterAddon("cluster1", agentv1.ApplicationAddonName, "", metav1.ConditionTrue),
+	}
+
+	cases := []struct {
+		name           string
+		addonName      string
+		expectErr      bool
+		expectedOutput string
+	}{
+		{
+			name:      "values of an addon",
+			addonName: agentv1.SearchAddonName,
+			expectedOutput: `# search-collector
+global:
+  imagePullPolicy: Always
+  nodeSelector:
+    zone: a
+`,
+		},
+		{
+			name: "values of all the addons",
+			expectedOutput: `# application-manager
+{}
+# search-collector
+global:
+  imagePullPolicy: Always
+  nodeSelector:
+    zone: a
+`,
+		},
+		{
+			name:      "addon not installed",
+			addonName: agentv1.CertPolicyAddonName,
+			expectErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cmd, out := newTestCommand(objs...)
+			err := cmd.Values(context.TODO(), "cluster1", c.addonName)
+			if c.expectErr != (err != nil) {
+				t.Fatalf("expected error %v, but got %v", c.expectErr, err)
+			}
+			if out.String() != c.expectedOutput {
+				t.Errorf("expected output:\n%s\nbut got:\n%s", c.expectedOutput, out.String())
+			}
+		})
+	}
+}
+
+func Test_Plan(t *testing.T) {
+	config := newKlusterletAddonConfig("cluster1")
+	config.Spec.PolicyController.Enabled = false
+	config.Spec.ApplicationManagerConfig.Enabled = false
+	cmd, out := newTestCommand(newManagedCluster("cluster1", nil), config,
+		newManagedClusterAddon("cluster1", agentv1.ApplicationAddonName, "", metav1.ConditionTrue))
+
+	if err := cmd.Plan(context.TODO(), "cluster1", common.NewOptions()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, expected := range []string{
+		"- action: Delete\n  addonName: application-manager",
+		"- action: Create\n  addonName: search-collector",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected %q in the output, but got:\n%s", expected, out.String())
+		}
+	}
+}
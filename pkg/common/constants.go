@@ -14,6 +14,67 @@ const (
 	// AnnotationAddOnHostingClusterName is the annotation key of hosting cluster name for add-ons
 	AnnotationAddOnHostingClusterName = "addon.open-cluster-management.io/hosting-cluster-name"
 
+	// AnnotationHostedModeAddOn is the annotation key of the ClusterManagementAddOn which indicates if the add-on
+	// can be deployed in hosted mode, it overrides the hub-wide hosted add-ons
+	AnnotationHostedModeAddOn = "agent.open-cluster-management.io/hosted-mode-addon"
+
+	// AnnotationHostedAddOnInstallNamespace is the annotation key of the ClusterManagementAddOn of the template of
+	// the install namespace of the add-on in hosted mode, it overrides the hub-wide template
+	AnnotationHostedAddOnInstallNamespace = "agent.open-cluster-management.io/hosted-addon-install-namespace"
+
+	// AnnotationModeMigration is the annotation key of the ClusterManagementAddOn of how the add-on is moved between
+	// default mode and hosted mode, the value is InPlace or Recreate, and Recreate is the default
+	AnnotationModeMigration = "agent.open-cluster-management.io/mode-migration"
+
+	// AnnotationRolloutStrategy is the annotation key of the ClusterManagementAddOn of the strategy rolling out the
+	// values updates of the add-on across the managed clusters, the value is a json of the OCM RolloutStrategy with
+	// an optional groupLabel, and the updates are applied to all the clusters at once if it is not set
+	AnnotationRolloutStrategy = "agent.open-cluster-management.io/rollout-strategy"
+
+	// AnnotationRolloutPending is the annotation key of the ManagedClusterAddOn whose values update is held by the
+	// rollout strategy, the value is the time the update was held
+	AnnotationRolloutPending = "agent.open-cluster-management.io/rollout-pending"
+
+	// AnnotationRolloutUpdatedAt is the annotation key of the ManagedClusterAddOn whose values are updated by the
+	// rollout strategy, the value is the time of the update and it is removed once the update succeeds
+	AnnotationRolloutUpdatedAt = "agent.open-cluster-management.io/rollout-updated-at"
+
+	// AnnotationLastAppliedTemplate is the annotation key of the KlusterletAddonConfig of the fields last rendered
+	// from the KlusterletAddonConfigTemplates, the fields changed since they were rendered are not overwritten
+	AnnotationLastAppliedTemplate = "agent.open-cluster-management.io/last-applied-template"
+
+	// AnnotationNodePlacement is the annotation key of the node placement of the add-ons on a managed cluster,
+	// the value is a json of the NodePlacement with nodeSelector and tolerations
+	AnnotationNodePlacement = "agent.open-cluster-management.io/node-placement"
+
+	// LabelMaintenanceWindow is the label key of the ManagedCluster of the name of its maintenance window, the
+	// windows are defined in the maintenance windows ConfigMap of the hub
+	LabelMaintenanceWindow = "agent.open-cluster-management.io/maintenance-window"
+
+	// AnnotationMaintenanceWindowOverride is the annotation key of the KlusterletAddonConfig which applies the
+	// changes of the add-ons outside the maintenance window if it is "true", it is used for emergency changes
+	AnnotationMaintenanceWindowOverride = "agent.open-cluster-management.io/maintenance-window-override"
+
+	// ClusterClaimArchitecture is the name of the cluster claim of the architectures of a managed cluster, the value
+	// is a comma separated list of the architectures, for example amd64,arm64
+	ClusterClaimArchitecture = "architecture.open-cluster-management.io"
+
+	// ClusterClaimProduct is the name of the cluster claim of the product of a managed cluster, like OpenShift and EKS
+	ClusterClaimProduct = "product.open-cluster-management.io"
+
+	// ClusterClaimPlatform is the name of the cluster claim of the platform a managed cluster is running on,
+	// like AWS and GCP
+	ClusterClaimPlatform = "platform.open-cluster-management.io"
+
+	// LabelImagePullSecretCluster is the label key of the ManifestWorks which copy the image pull secrets of the
+	// add-ons to a managed cluster, the value is the managed cluster name
+	LabelImagePullSecretCluster = "agent.open-cluster-management.io/image-pull-secret-cluster"
+
 	// AnnotationCreateWithDefaultKlusterletAddonConfig is the annotation key for creating default klusterlet addon config for a normal managed cluster.
 	AnnotationCreateWithDefaultKlusterletAddonConfig = "agent.open-cluster-management.io/create-with-default-klusterletaddonconfig"
+
+	// AnnotationKlusterletAddonConfigRemoved is the annotation key of the ManagedCluster which records the intentional
+	// removal of its KlusterletAddonConfig if it is "true", the KlusterletAddonConfig is deleted and not recreated
+	// by the controller until the annotation is removed
+	AnnotationKlusterletAddonConfigRemoved = "agent.open-cluster-management.io/klusterletaddonconfig-removed"
 )
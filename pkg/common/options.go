@@ -0,0 +1,329 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package common //nolint:revive // package name is used across the codebase
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+)
+
+// DefaultHostedAddOns is the addons which can be deployed in hosted mode by default
+var DefaultHostedAddOns = []string{
+	agentv1.PolicyFrameworkAddonName,
+	agentv1.ConfigPolicyAddonName,
+	agentv1.CertPolicyAddonName,
+}
+
+const (
+	// DefaultHostedAddOnInstallNamespace is the default template of the install namespace of the hosted addons
+	DefaultHostedAddOnInstallNamespace = "klusterlet-{{clusterName}}"
+
+	hostedAddOnClusterNamePlaceholder        = "{{clusterName}}"
+	hostedAddOnHostingClusterNamePlaceholder = "{{hostingClusterName}}"
+)
+
+// Options contains the configurations of all the controllers
+type Options struct {
+	Addon          ControllerOptions
+	ManagedCluster ControllerOptions
+	GlobalProxy    ControllerOptions
+	ConfigTemplate ControllerOptions
+	History        ControllerOptions
+
+	// Shard selects the managed clusters handled by the controllers
+	Shard *Shard
+
+	// DefaultImagePullSecret is the namespace/name of the hub secret used as the image pull secret of the addons
+	// if it is not set in the KlusterletAddonConfig
+	DefaultImagePullSecret string
+
+	// DefaultImagePullPolicy is the image pull policy of the addons if it is not set in the KlusterletAddonConfig
+	DefaultImagePullPolicy string
+
+	// CompatibilityConfigMap is the namespace/name of the hub ConfigMap which overrides the compatibility matrix
+	// of the addons and the platforms of the managed clusters
+	CompatibilityConfigMap string
+
+	// MaintenanceWindowsConfigMap is the namespace/name of the hub ConfigMap which defines the maintenance windows
+	// named by the labels of the managed clusters
+	MaintenanceWindowsConfigMap string
+
+	// GateOnClusterAvailability defers the deployment of the addons until the managed cluster is accepted, joined
+	// and available
+	GateOnClusterAvailability bool
+
+	// UnavailableClusterGracePeriod is the duration a managed cluster can be unavailable before its addons are torn
+	// down, the addons are never torn down if it is zero
+	UnavailableClusterGracePeriod time.Duration
+
+	// FleetReport serves the addon health report of the fleet on a TLS endpoint
+	FleetReport bool
+
+	// FleetReportBindAddress is the address the TLS endpoint of the fleet report binds to
+	FleetReportBindAddress string
+
+	// FleetReportCertDir is the directory of the serving certificate tls.crt and key tls.key of the fleet report
+	FleetReportCertDir string
+
+	// ChangeHistoryWebhook serves the mutating webhook which records the changes of the KlusterletAddonConfigs
+	// made by the users in their history
+	ChangeHistoryWebhook bool
+
+	// ControllerServiceAccount is the namespace/name of the service account of the controllers, its changes are
+	// recorded by the controllers instead of the change history webhook
+	ControllerServiceAccount string
+
+	// HostedAddOns is the comma separated names of the addons which can be deployed in hosted mode
+	HostedAddOns string
+
+	// HostedAddOnInstallNamespace is the template of the install namespace of the hosted addons on the hosting
+	// cluster, {{clusterName}} and {{hostingClusterName}} are replaced by the cluster names
+	HostedAddOnInstallNamespace string
+}
+
+// NewOptions returns the Options with the default configurations
+func NewOptions() *Options {
+	return &Options{
+		Addon:          NewControllerOptions(),
+		ManagedCluster: NewControllerOptions(),
+		GlobalProxy:    NewControllerOptions(),
+		ConfigTemplate: NewControllerOptions(),
+		History:        NewControllerOptions(),
+		Shard:          &Shard{},
+
+		FleetReportBindAddress:      ":8443",
+		HostedAddOns:                strings.Join(DefaultHostedAddOns, ","),
+		HostedAddOnInstallNamespace: DefaultHostedAddOnInstallNamespace,
+	}
+}
+
+// AddFlags registers the flags of all the controllers
+func (o *Options) AddFlags(fs *flag.FlagSet) {
+	o.Addon.AddFlags(fs, "addon")
+	o.ManagedCluster.AddFlags(fs, "managedcluster")
+	o.GlobalProxy.AddFlags(fs, "globalproxy")
+	o.ConfigTemplate.AddFlags(fs, "configtemplate")
+	o.History.AddFlags(fs, "history")
+	o.Shard.AddFlags(fs)
+	fs.StringVar(&o.DefaultImagePullSecret, "default-image-pull-secret", o.DefaultImagePullSecret,
+		"The namespace/name of the hub secret used as the image pull secret of the addons by default.")
+	fs.StringVar(&o.DefaultImagePullPolicy, "default-image-pull-policy", o.DefaultImagePullPolicy,
+		"The image pull policy of the addons by default, one of Always, Never and IfNotPresent.")
+	fs.StringVar(&o.CompatibilityConfigMap, "compatibility-configmap", o.CompatibilityConfigMap,
+		"The namespace/name of the hub ConfigMap which overrides the platform compatibility matrix of the addons.")
+	fs.StringVar(&o.MaintenanceWindowsConfigMap, "maintenance-windows-configmap", o.MaintenanceWindowsConfigMap,
+		"The namespace/name of the hub ConfigMap which defines the maintenance windows named by the cluster labels.")
+	fs.BoolVar(&o.GateOnClusterAvailability, "gate-on-cluster-availability", o.GateOnClusterAvailability,
+		"Defer the deployment of the addons until the managed cluster is accepted, joined and available.")
+	fs.DurationVar(&o.UnavailableClusterGracePeriod, "unavailable-cluster-grace-period",
+		o.UnavailableClusterGracePeriod,
+		"The duration a managed cluster can be unavailable before its addons are torn down, 0 never tears down.")
+	fs.BoolVar(&o.FleetReport, "fleet-report", o.FleetReport,
+		"Serve the addon health report of the fleet at /fleet-report over TLS, the requests are authenticated by "+
+			"bearer tokens and authorized to get the path. Requires --fleet-report-cert-dir.")
+	fs.StringVar(&o.FleetReportBindAddress, "fleet-report-bind-address", o.FleetReportBindAddress,
+		"The address the TLS endpoint of the fleet report binds to.")
+	fs.StringVar(&o.FleetReportCertDir, "fleet-report-cert-dir", o.FleetReportCertDir,
+		"The directory of the serving certificate tls.crt and key tls.key of the fleet report.")
+	fs.BoolVar(&o.ChangeHistoryWebhook, "change-history-webhook", o.ChangeHistoryWebhook,
+		"Serve the mutating webhook which records the changes of the KlusterletAddonConfigs made by the users.")
+	fs.StringVar(&o.ControllerServiceAccount, "controller-service-account", o.ControllerServiceAccount,
+		"The namespace/name of the service account of the controllers, the changes it makes are not recorded by "+
+			"the webhook. Defaults to klusterlet-addon-controller in the namespace of the pod.")
+	fs.StringVar(&o.HostedAddOns, "hosted-addons", o.HostedAddOns,
+		"The comma separated names of the addons which can be deployed in hosted mode.")
+	fs.StringVar(&o.HostedAddOnInstallNamespace, "hosted-addon-install-namespace", o.HostedAddOnInstallNamespace,
+		"The template of the install namespace of the hosted addons, {{clusterName}} and {{hostingClusterName}} "+
+			"are replaced by the names of the managed cluster and the hosting cluster.")
+}
+
+// Validate returns an error if the configurations of any controller are invalid, and completes the Shard
+func (o *Options) Validate() error {
+	if err := o.Addon.Validate(); err != nil {
+		return fmt.Errorf("addon controller: %v", err)
+	}
+	if err := o.ManagedCluster.Validate(); err != nil {
+		return fmt.Errorf("managedcluster controller: %v", err)
+	}
+	if err := o.GlobalProxy.Validate(); err != nil {
+		return fmt.Errorf("globalproxy controller: %v", err)
+	}
+	if err := o.ConfigTemplate.Validate(); err != nil {
+		return fmt.Errorf("configtemplate controller: %v", err)
+	}
+	if err := o.History.Validate(); err != nil {
+		return fmt.Errorf("history controller: %v", err)
+	}
+	if _, err := parseNamespacedName(o.DefaultImagePullSecret); err != nil {
+		return fmt.Errorf("default image pull secret: %v", err)
+	}
+	if _, err := parseNamespacedName(o.CompatibilityConfigMap); err != nil {
+		return fmt.Errorf("compatibility configmap: %v", err)
+	}
+	if _, err := parseNamespacedName(o.MaintenanceWindowsConfigMap); err != nil {
+		return fmt.Errorf("maintenance windows configmap: %v", err)
+	}
+	if _, err := parseNamespacedName(o.ControllerServiceAccount); err != nil {
+		return fmt.Errorf("controller service account: %v", err)
+	}
+	if o.UnavailableClusterGracePeriod < 0 {
+		return fmt.Errorf("unavailable cluster grace period must not be negative, but got %v",
+			o.UnavailableClusterGracePeriod)
+	}
+	for _, addonName := range o.HostedAddOnNames() {
+		if !agentv1.KlusterletAddons[addonName] {
+			return fmt.Errorf("hosted addons: unknown addon %q", addonName)
+		}
+	}
+	namespace := RenderHostedAddOnInstallNamespace(o.HostedAddOnInstallNamespace, "cluster", "hosting")
+	if errs := validation.IsDNS1123Label(namespace); len(errs) != 0 {
+		return fmt.Errorf("invalid hosted addon install namespace %q: %s", o.HostedAddOnInstallNamespace,
+			strings.Join(errs, ", "))
+	}
+	switch corev1.PullPolicy(o.DefaultImagePullPolicy) {
+	case "", corev1.PullAlways, corev1.PullNever, corev1.PullIfNotPresent:
+	default:
+		return fmt.Errorf("invalid default image pull policy %q", o.DefaultImagePullPolicy)
+	}
+	return o.Shard.Complete()
+}
+
+// DefaultImagePullSecretName returns the namespace and name of the default image pull secret, an empty
+// NamespacedName is returned if it is not set
+func (o *Options) DefaultImagePullSecretName() types.NamespacedName {
+	name, _ := parseNamespacedName(o.DefaultImagePullSecret)
+	return name
+}
+
+// CompatibilityConfigMapName returns the namespace and name of the compatibility ConfigMap, an empty
+// NamespacedName is returned if it is not set
+func (o *Options) CompatibilityConfigMapName() types.NamespacedName {
+	name, _ := parseNamespacedName(o.CompatibilityConfigMap)
+	return name
+}
+
+// MaintenanceWindowsConfigMapName returns the namespace and name of the maintenance windows ConfigMap, an empty
+// NamespacedName is returned if it is not set
+func (o *Options) MaintenanceWindowsConfigMapName() types.NamespacedName {
+	name, _ := parseNamespacedName(o.MaintenanceWindowsConfigMap)
+	return name
+}
+
+// ControllerServiceAccountName returns the namespace and name of the service account of the controllers, an empty
+// NamespacedName is returned if it is not set
+func (o *Options) ControllerServiceAccountName() types.NamespacedName {
+	name, _ := parseNamespacedName(o.ControllerServiceAccount)
+	return name
+}
+
+// HostedAddOnNames returns the names of the addons which can be deployed in hosted mode
+func (o *Options) HostedAddOnNames() []string {
+	names := []string{}
+	for _, name := range strings.Split(o.HostedAddOns, ",") {
+		if name = strings.TrimSpace(name); len(name) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// RenderHostedAddOnInstallNamespace returns the install namespace of the hosted addons of the managed cluster, the
+// default template is used if the template is empty
+func RenderHostedAddOnInstallNamespace(template, clusterName, hostingClusterName string) string {
+	if len(template) == 0 {
+		template = DefaultHostedAddOnInstallNamespace
+	}
+	return strings.NewReplacer(
+		hostedAddOnClusterNamePlaceholder, clusterName,
+		hostedAddOnHostingClusterNamePlaceholder, hostingClusterName,
+	).Replace(template)
+}
+
+// parseNamespacedName parses the string in the format namespace/name, an empty NamespacedName is returned if the
+// string is empty
+func parseNamespacedName(s string) (types.NamespacedName, error) {
+	if len(s) == 0 {
+		return types.NamespacedName{}, nil
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return types.NamespacedName{}, fmt.Errorf("%q is not in the format namespace/name", s)
+	}
+	return types.NamespacedName{Namespace: parts[0], Name: parts[1]}, nil
+}
+
+// ControllerOptions is the worker and rate limiter configurations of a controller
+type ControllerOptions struct {
+	// MaxConcurrentReconciles is the maximum number of concurrent reconciles of the controller
+	MaxConcurrentReconciles int
+
+	// BaseDelay and MaxDelay configure the per-item exponential failure rate limiter
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// QPS and Burst configure the overall token bucket rate limiter
+	QPS   float64
+	Burst int
+}
+
+// NewControllerOptions returns the ControllerOptions with the same values as the controller-runtime defaults
+func NewControllerOptions() ControllerOptions {
+	return ControllerOptions{
+		MaxConcurrentReconciles: 1,
+		BaseDelay:               5 * time.Millisecond,
+		MaxDelay:                1000 * time.Second,
+		QPS:                     10,
+		Burst:                   100,
+	}
+}
+
+// AddFlags registers the flags of the controller, the flag names are prefixed by the given name
+func (o *ControllerOptions) AddFlags(fs *flag.FlagSet, name string) {
+	fs.IntVar(&o.MaxConcurrentReconciles, name+"-max-concurrent-reconciles", o.MaxConcurrentReconciles,
+		fmt.Sprintf("The maximum number of concurrent reconciles of the %s controller.", name))
+	fs.DurationVar(&o.BaseDelay, name+"-rate-limiter-base-delay", o.BaseDelay,
+		fmt.Sprintf("The base delay of the exponential failure rate limiter of the %s controller.", name))
+	fs.DurationVar(&o.MaxDelay, name+"-rate-limiter-max-delay", o.MaxDelay,
+		fmt.Sprintf("The max delay of the exponential failure rate limiter of the %s controller.", name))
+	fs.Float64Var(&o.QPS, name+"-rate-limiter-qps", o.QPS,
+		fmt.Sprintf("The qps of the bucket rate limiter of the %s controller.", name))
+	fs.IntVar(&o.Burst, name+"-rate-limiter-burst", o.Burst,
+		fmt.Sprintf("The burst of the bucket rate limiter of the %s controller.", name))
+}
+
+// Validate returns an error if the ControllerOptions are invalid
+func (o ControllerOptions) Validate() error {
+	switch {
+	case o.MaxConcurrentReconciles < 1:
+		return fmt.Errorf("max concurrent reconciles must be at least 1, but got %d", o.MaxConcurrentReconciles)
+	case o.BaseDelay <= 0 || o.MaxDelay < o.BaseDelay:
+		return fmt.Errorf("invalid rate limiter delays, base delay %v, max delay %v", o.BaseDelay, o.MaxDelay)
+	case o.QPS <= 0 || o.Burst < 1:
+		return fmt.Errorf("invalid rate limiter bucket, qps %v, burst %d", o.QPS, o.Burst)
+	}
+	return nil
+}
+
+// ToControllerOptions returns the controller.Options of the given reconciler
+func (o ControllerOptions) ToControllerOptions(r reconcile.Reconciler) controller.Options {
+	return controller.Options{
+		Reconciler:              r,
+		MaxConcurrentReconciles: o.MaxConcurrentReconciles,
+		RateLimiter: workqueue.NewMaxOfRateLimiter(
+			workqueue.NewItemExponentialFailureRateLimiter(o.BaseDelay, o.MaxDelay),
+			&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(o.QPS), o.Burst)},
+		),
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package common //nolint:revive // package name is used across the codebase
+
+import (
+	"context"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+// AnnotationDisableAddonAutomaticInstallation is the annotation key of the ManagedCluster for disabling the
+// automatic installation of the addons
+const AnnotationDisableAddonAutomaticInstallation = "addon.open-cluster-management.io/disable-automatic-installation"
+
+// KlusterletAddonConfigRemoved returns true if the KlusterletAddonConfig of the managed cluster is removed on purpose
+func KlusterletAddonConfigRemoved(cluster metav1.Object) bool {
+	return strings.EqualFold(cluster.GetAnnotations()[AnnotationKlusterletAddonConfigRemoved], "true")
+}
+
+// KlusterletAddonConfigOptedOut returns true if the KlusterletAddonConfig of the managed cluster is removed on
+// purpose or the automatic installation of the addons is disabled, the KlusterletAddonConfig of the managed cluster
+// must not be created by any controller then
+func KlusterletAddonConfigOptedOut(cluster metav1.Object) bool {
+	return KlusterletAddonConfigRemoved(cluster) ||
+		strings.EqualFold(cluster.GetAnnotations()[AnnotationDisableAddonAutomaticInstallation], "true")
+}
+
+// ClusterOptedOut returns true if the managed cluster of the given cluster namespace opts out of the
+// KlusterletAddonConfig, the managed cluster is read from the given reader.
+func ClusterOptedOut(ctx context.Context, reader client.Reader, namespace string) bool {
+	cluster := &mcv1.ManagedCluster{}
+	if err := reader.Get(ctx, types.NamespacedName{Name: namespace}, cluster); err != nil {
+		return false
+	}
+	return KlusterletAddonConfigOptedOut(cluster)
+}
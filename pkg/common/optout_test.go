@@ -0,0 +1,69 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package common //nolint:revive // package name is used across the codebase
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+func TestClusterOptedOut(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		namespace   string
+		expected    bool
+	}{
+		{
+			name:      "no annotations",
+			namespace: "cluster1",
+			expected:  false,
+		},
+		{
+			name:        "klusterletAddonConfig removed",
+			annotations: map[string]string{AnnotationKlusterletAddonConfigRemoved: "True"},
+			namespace:   "cluster1",
+			expected:    true,
+		},
+		{
+			name:        "automatic installation disabled",
+			annotations: map[string]string{AnnotationDisableAddonAutomaticInstallation: "true"},
+			namespace:   "cluster1",
+			expected:    true,
+		},
+		{
+			name: "annotations are not true",
+			annotations: map[string]string{
+				AnnotationKlusterletAddonConfigRemoved:      "false",
+				AnnotationDisableAddonAutomaticInstallation: "false",
+			},
+			namespace: "cluster1",
+			expected:  false,
+		},
+		{
+			name:        "cluster not found",
+			annotations: map[string]string{AnnotationKlusterletAddonConfigRemoved: "true"},
+			namespace:   "cluster2",
+			expected:    false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cluster := newManagedCluster("cluster1", nil)
+			cluster.Annotations = c.annotations
+			scheme := runtime.NewScheme()
+			_ = mcv1.AddToScheme(scheme)
+			reader := fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(cluster).Build()
+
+			if actual := ClusterOptedOut(context.TODO(), reader, c.namespace); actual != c.expected {
+				t.Errorf("expected %v, but got %v", c.expected, actual)
+			}
+		})
+	}
+}
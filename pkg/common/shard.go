@@ -0,0 +1,126 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package common //nolint:revive // package name is used across the codebase
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"hash/fnv"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+// Shard selects the managed clusters handled by a controller replica. The clusters can be selected by a label
+// selector, by a hash range of the cluster name, or both. A nil or empty Shard selects all clusters.
+type Shard struct {
+	// Selector is the label selector of the managed clusters in the shard
+	Selector string
+
+	// Count is the total number of the hash shards, the hash sharding is disabled if it is less than 2
+	Count int
+
+	// Index is the index of this shard in [0, Count)
+	Index int
+
+	selector labels.Selector
+}
+
+// AddFlags registers the sharding flags
+func (s *Shard) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&s.Selector, "shard-selector", s.Selector,
+		"The label selector of the ManagedClusters handled by this replica. All clusters are handled if it is empty.")
+	fs.IntVar(&s.Count, "shard-count", s.Count,
+		"The total number of the hash shards of the ManagedClusters. The hash sharding is disabled if it is less than 2.")
+	fs.IntVar(&s.Index, "shard-index", s.Index,
+		"The index of the hash shard handled by this replica, must be in [0, shard-count).")
+}
+
+// Complete parses the label selector, it must be called before the Shard is used
+func (s *Shard) Complete() error {
+	if s.Count > 1 && (s.Index < 0 || s.Index >= s.Count) {
+		return fmt.Errorf("shard index %d is out of range [0, %d)", s.Index, s.Count)
+	}
+
+	s.selector = labels.Everything()
+	if len(s.Selector) == 0 {
+		return nil
+	}
+
+	selector, err := labels.Parse(s.Selector)
+	if err != nil {
+		return fmt.Errorf("invalid shard selector %q: %v", s.Selector, err)
+	}
+	s.selector = selector
+	return nil
+}
+
+// IsSharded returns true if the Shard does not select all the clusters
+func (s *Shard) IsSharded() bool {
+	if s == nil {
+		return false
+	}
+	return len(s.Selector) != 0 || s.Count > 1
+}
+
+// LabelSelector returns the label selector of the managed clusters in the shard, nil means all clusters
+func (s *Shard) LabelSelector() labels.Selector {
+	if s == nil || s.selector == nil || s.selector.Empty() {
+		return nil
+	}
+	return s.selector
+}
+
+// ContainsClusterName returns true if the cluster name is in the hash range of the shard
+func (s *Shard) ContainsClusterName(clusterName string) bool {
+	if s == nil || s.Count < 2 {
+		return true
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(clusterName))
+	return int(h.Sum32()%uint32(s.Count)) == s.Index
+}
+
+// Contains returns true if the managed cluster is handled by the shard
+func (s *Shard) Contains(cluster *mcv1.ManagedCluster) bool {
+	if !s.ContainsClusterName(cluster.GetName()) {
+		return false
+	}
+
+	selector := s.LabelSelector()
+	return selector == nil || selector.Matches(labels.Set(cluster.GetLabels()))
+}
+
+// ContainsNamespace returns true if the managed cluster of the given cluster namespace is handled by the shard.
+// The managed cluster is read from the given reader only when a label selector is set.
+func (s *Shard) ContainsNamespace(ctx context.Context, reader client.Reader, namespace string) bool {
+	if !s.ContainsClusterName(namespace) {
+		return false
+	}
+	if s.LabelSelector() == nil {
+		return true
+	}
+
+	cluster := &mcv1.ManagedCluster{}
+	if err := reader.Get(ctx, types.NamespacedName{Name: namespace}, cluster); err != nil {
+		return false
+	}
+	return s.Contains(cluster)
+}
+
+// LeaderElectionID returns the leader election ID of the shard, so the replicas of different shards can run
+// at the same time
+func (s *Shard) LeaderElectionID(base string) string {
+	if !s.IsSharded() {
+		return base
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(fmt.Sprintf("%s/%d/%d", s.Selector, s.Count, s.Index)))
+	return fmt.Sprintf("%s-shard-%x", base, h.Sum32())
+}
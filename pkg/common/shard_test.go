@@ -0,0 +1,135 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package common //nolint:revive // package name is used across the codebase
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+func newManagedCluster(name string, labels map[string]string) *mcv1.ManagedCluster {
+	return &mcv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+	}
+}
+
+func TestShardContains(t *testing.T) {
+	cases := []struct {
+		name     string
+		shard    *Shard
+		cluster  *mcv1.ManagedCluster
+		expected bool
+	}{
+		{
+			name:     "nil shard",
+			cluster:  newManagedCluster("cluster1", nil),
+			expected: true,
+		},
+		{
+			name:     "empty shard",
+			shard:    &Shard{},
+			cluster:  newManagedCluster("cluster1", nil),
+			expected: true,
+		},
+		{
+			name:     "selector matched",
+			shard:    &Shard{Selector: "env=prod"},
+			cluster:  newManagedCluster("cluster1", map[string]string{"env": "prod"}),
+			expected: true,
+		},
+		{
+			name:     "selector not matched",
+			shard:    &Shard{Selector: "env=prod"},
+			cluster:  newManagedCluster("cluster1", map[string]string{"env": "dev"}),
+			expected: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if c.shard != nil {
+				if err := c.shard.Complete(); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if actual := c.shard.Contains(c.cluster); actual != c.expected {
+				t.Errorf("expected %v, but got %v", c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestShardHashRange(t *testing.T) {
+	const count = 4
+	shards := []*Shard{}
+	for i := 0; i < count; i++ {
+		shard := &Shard{Count: count, Index: i}
+		if err := shard.Complete(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		shards = append(shards, shard)
+	}
+
+	ids := map[string]bool{}
+	for _, shard := range shards {
+		ids[shard.LeaderElectionID("lock")] = true
+	}
+	if len(ids) != count {
+		t.Errorf("expected %d different leader election IDs, but got %v", count, ids)
+	}
+
+	for i := 0; i < 1000; i++ {
+		clusterName := fmt.Sprintf("cluster%d", i)
+		owners := 0
+		for _, shard := range shards {
+			if shard.ContainsClusterName(clusterName) {
+				owners++
+			}
+		}
+		if owners != 1 {
+			t.Errorf("expected cluster %s is handled by 1 shard, but got %d", clusterName, owners)
+		}
+	}
+}
+
+func TestShardContainsNamespace(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = mcv1.AddToScheme(scheme)
+	reader := fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(
+		newManagedCluster("prod", map[string]string{"env": "prod"}),
+		newManagedCluster("dev", map[string]string{"env": "dev"}),
+	).Build()
+
+	shard := &Shard{Selector: "env=prod"}
+	if err := shard.Complete(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !shard.ContainsNamespace(context.TODO(), reader, "prod") {
+		t.Errorf("expected namespace prod is in the shard")
+	}
+	if shard.ContainsNamespace(context.TODO(), reader, "dev") {
+		t.Errorf("expected namespace dev is not in the shard")
+	}
+	if shard.ContainsNamespace(context.TODO(), reader, "unknown") {
+		t.Errorf("expected namespace unknown is not in the shard")
+	}
+}
+
+func TestShardComplete(t *testing.T) {
+	if err := (&Shard{Count: 2, Index: 2}).Complete(); err == nil {
+		t.Errorf("expected error for out of range index")
+	}
+	if err := (&Shard{Selector: "env in (prod"}).Complete(); err == nil {
+		t.Errorf("expected error for invalid selector")
+	}
+}
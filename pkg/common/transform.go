@@ -0,0 +1,125 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package common //nolint:revive // package name is used across the codebase
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+
+	clusterinfov1beta1 "github.com/stolostron/cluster-lifecycle-api/clusterinfo/v1beta1"
+)
+
+// The transform functions below remove the fields which are never read by the controllers from the cached objects
+// to reduce the memory of the hub. The spec, labels and annotations are always kept because the controllers update
+// the objects read from the cache.
+
+// TransformStripManagedFields removes the managed fields of the object
+func TransformStripManagedFields() toolscache.TransformFunc {
+	return func(in interface{}) (interface{}, error) {
+		stripManagedFields(in)
+		return in, nil
+	}
+}
+
+// cachedClusterClaims are the cluster claims read by the controllers, the other cluster claims are not cached
+var cachedClusterClaims = map[string]bool{
+	ClusterClaimArchitecture: true,
+	ClusterClaimProduct:      true,
+	ClusterClaimPlatform:     true,
+}
+
+// TransformManagedCluster removes the managed fields and the status except the conditions and the cluster claims
+// read by the controllers of the ManagedCluster
+func TransformManagedCluster() toolscache.TransformFunc {
+	return func(in interface{}) (interface{}, error) {
+		stripManagedFields(in)
+		cluster, ok := in.(*mcv1.ManagedCluster)
+		if !ok {
+			return in, nil
+		}
+
+		var claims []mcv1.ManagedClusterClaim
+		for _, claim := range cluster.Status.ClusterClaims {
+			if cachedClusterClaims[claim.Name] {
+				claims = append(claims, claim)
+			}
+		}
+		cluster.Status = mcv1.ManagedClusterStatus{
+			Conditions:    cluster.Status.Conditions,
+			ClusterClaims: claims,
+		}
+		return cluster, nil
+	}
+}
+
+// TransformManagedClusterAddOn removes the status except the conditions of the ManagedClusterAddOns in the given
+// names, and the fields of their managed fields, the field managers are kept to find the ManagedClusterAddOns not
+// yet applied by the controller. The other ManagedClusterAddOns are not handled by the controllers, only their name
+// and namespace are kept.
+func TransformManagedClusterAddOn(addonNames map[string]bool) toolscache.TransformFunc {
+	return func(in interface{}) (interface{}, error) {
+		addon, ok := in.(*addonv1alpha1.ManagedClusterAddOn)
+		if !ok {
+			stripManagedFields(in)
+			return in, nil
+		}
+
+		if _, ok := addonNames[addon.Name]; !ok {
+			return &addonv1alpha1.ManagedClusterAddOn{
+				TypeMeta: addon.TypeMeta,
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              addon.Name,
+					Namespace:         addon.Namespace,
+					UID:               addon.UID,
+					ResourceVersion:   addon.ResourceVersion,
+					DeletionTimestamp: addon.DeletionTimestamp,
+				},
+			}, nil
+		}
+
+		for i := range addon.ManagedFields {
+			addon.ManagedFields[i].FieldsV1 = nil
+		}
+		addon.Status = addonv1alpha1.ManagedClusterAddOnStatus{
+			Conditions: addon.Status.Conditions,
+		}
+		return addon, nil
+	}
+}
+
+// TransformManagedClusterInfo removes the spec and the status except the architecture labels of the nodes of the
+// ManagedClusterInfos, the controllers only read the architectures of the managed clusters from them
+func TransformManagedClusterInfo() toolscache.TransformFunc {
+	return func(in interface{}) (interface{}, error) {
+		stripManagedFields(in)
+		clusterInfo, ok := in.(*clusterinfov1beta1.ManagedClusterInfo)
+		if !ok {
+			return in, nil
+		}
+
+		nodes := []clusterinfov1beta1.NodeStatus{}
+		for _, node := range clusterInfo.Status.NodeList {
+			if arch, ok := node.Labels[corev1.LabelArchStable]; ok {
+				nodes = append(nodes, clusterinfov1beta1.NodeStatus{
+					Name:   node.Name,
+					Labels: map[string]string{corev1.LabelArchStable: arch},
+				})
+			}
+		}
+		clusterInfo.Spec = clusterinfov1beta1.ClusterInfoSpec{}
+		clusterInfo.Status = clusterinfov1beta1.ClusterInfoStatus{NodeList: nodes}
+		return clusterInfo, nil
+	}
+}
+
+func stripManagedFields(in interface{}) {
+	// Nilcheck managed fields to avoid hitting https://github.com/kubernetes/kubernetes/issues/124337
+	if obj, err := meta.Accessor(in); err == nil && obj.GetManagedFields() != nil {
+		obj.SetManagedFields(nil)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package common //nolint:revive // package name is used across the codebase
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+
+	clusterinfov1beta1 "github.com/stolostron/cluster-lifecycle-api/clusterinfo/v1beta1"
+)
+
+func newFullManagedCluster(name string) *mcv1.ManagedCluster {
+	cluster := &mcv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{"vendor": "OpenShift", "cloud": "Amazon"},
+			Annotations: map[string]string{"open-cluster-management/created-via": "hive"},
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{Manager: "registration-controller", Operation: metav1.ManagedFieldsOperationUpdate},
+				{Manager: "import-controller", Operation: metav1.ManagedFieldsOperationApply},
+			},
+		},
+		Status: mcv1.ManagedClusterStatus{
+			Conditions: []metav1.Condition{
+				{Type: mcv1.ManagedClusterConditionAvailable, Status: metav1.ConditionTrue},
+			},
+			Capacity: mcv1.ResourceList{
+				mcv1.ResourceCPU:    resource.MustParse("96"),
+				mcv1.ResourceMemory: resource.MustParse("384Gi"),
+			},
+			Allocatable: mcv1.ResourceList{
+				mcv1.ResourceCPU:    resource.MustParse("90"),
+				mcv1.ResourceMemory: resource.MustParse("360Gi"),
+			},
+			Version: mcv1.ManagedClusterVersion{Kubernetes: "v1.30.2"},
+		},
+	}
+	cluster.Status.ClusterClaims = append(cluster.Status.ClusterClaims, mcv1.ManagedClusterClaim{
+		Name:  ClusterClaimArchitecture,
+		Value: "amd64",
+	})
+	for i := 0; i < 30; i++ {
+		cluster.Status.ClusterClaims = append(cluster.Status.ClusterClaims, mcv1.ManagedClusterClaim{
+			Name:  fmt.Sprintf("claim%d.open-cluster-management.io", i),
+			Value: fmt.Sprintf("a-long-value-of-the-cluster-claim-%d-of-cluster-%s", i, name),
+		})
+	}
+	return cluster
+}
+
+func TestTransformManagedCluster(t *testing.T) {
+	out, err := TransformManagedCluster()(newFullManagedCluster("cluster1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cluster := out.(*mcv1.ManagedCluster)
+	if len(cluster.ManagedFields) != 0 {
+		t.Errorf("expected managed fields are removed")
+	}
+	if len(cluster.Status.ClusterClaims) != 1 || cluster.Status.ClusterClaims[0].Name != ClusterClaimArchitecture {
+		t.Errorf("expected the cluster claims except the architecture are removed, but got %v",
+			cluster.Status.ClusterClaims)
+	}
+	if len(cluster.Status.Capacity) != 0 {
+		t.Errorf("expected capacity is removed")
+	}
+	if len(cluster.Status.Conditions) != 1 {
+		t.Errorf("expected conditions are kept")
+	}
+	if len(cluster.Labels) != 2 || len(cluster.Annotations) != 1 {
+		t.Errorf("expected labels and annotations are kept")
+	}
+}
+
+func TestTransformManagedClusterAddOn(t *testing.T) {
+	transform := TransformManagedClusterAddOn(map[string]bool{"search-collector": true})
+	newAddon := func(name string) *addonv1alpha1.ManagedClusterAddOn {
+		return &addonv1alpha1.ManagedClusterAddOn{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "cluster1",
+				Annotations: map[string]string{"addon.open-cluster-management.io/values": "{}"},
+				ManagedFields: []metav1.ManagedFieldsEntry{
+					{Manager: "addon-manager", FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{}}`)}},
+				},
+			},
+			Spec: addonv1alpha1.ManagedClusterAddOnSpec{InstallNamespace: "open-cluster-management-agent-addon"},
+			Status: addonv1alpha1.ManagedClusterAddOnStatus{
+				Conditions:     []metav1.Condition{{Type: "Available", Status: metav1.ConditionTrue}},
+				RelatedObjects: []addonv1alpha1.ObjectReference{{Name: "search-collector"}},
+			},
+		}
+	}
+
+	out, err := transform(newAddon("search-collector"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	addon := out.(*addonv1alpha1.ManagedClusterAddOn)
+	if len(addon.ManagedFields) != 1 || addon.ManagedFields[0].Manager != "addon-manager" ||
+		addon.ManagedFields[0].FieldsV1 != nil {
+		t.Errorf("expected the field managers are kept without their fields, but got %v", addon.ManagedFields)
+	}
+	if len(addon.Status.RelatedObjects) != 0 {
+		t.Errorf("expected related objects are removed")
+	}
+	if len(addon.Status.Conditions) != 1 || len(addon.Annotations) != 1 || len(addon.Spec.InstallNamespace) == 0 {
+		t.Errorf("expected conditions, annotations and spec are kept")
+	}
+
+	out, err = transform(newAddon("other-addon"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	addon = out.(*addonv1alpha1.ManagedClusterAddOn)
+	if addon.Name != "other-addon" || addon.Namespace != "cluster1" {
+		t.Errorf("expected name and namespace are kept")
+	}
+	if len(addon.Annotations) != 0 || len(addon.Spec.InstallNamespace) != 0 || len(addon.Status.Conditions) != 0 {
+		t.Errorf("expected the other addon is trimmed, but got %v", addon)
+	}
+}
+
+func TestTransformManagedClusterInfo(t *testing.T) {
+	out, err := TransformManagedClusterInfo()(&clusterinfov1beta1.ManagedClusterInfo{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster1", Namespace: "cluster1"},
+		Spec:       clusterinfov1beta1.ClusterInfoSpec{MasterEndpoint: "https://api.cluster1:6443"},
+		Status: clusterinfov1beta1.ClusterInfoStatus{
+			ConsoleURL: "https://console.cluster1",
+			NodeList: []clusterinfov1beta1.NodeStatus{
+				{
+					Name:   "node1",
+					Labels: map[string]string{corev1.LabelArchStable: "arm64", corev1.LabelHostname: "node1"},
+				},
+				{Name: "node2", Labels: map[string]string{corev1.LabelHostname: "node2"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clusterInfo := out.(*clusterinfov1beta1.ManagedClusterInfo)
+	if len(clusterInfo.Spec.MasterEndpoint) != 0 || len(clusterInfo.Status.ConsoleURL) != 0 {
+		t.Errorf("expected spec and status are removed, but got %v", clusterInfo)
+	}
+	expected := []clusterinfov1beta1.NodeStatus{
+		{Name: "node1", Labels: map[string]string{corev1.LabelArchStable: "arm64"}},
+	}
+	if !reflect.DeepEqual(clusterInfo.Status.NodeList, expected) {
+		t.Errorf("expected nodes %v, but got %v", expected, clusterInfo.Status.NodeList)
+	}
+}
+
+// BenchmarkTransformManagedCluster compares the heap used by the cached ManagedClusters with and without the
+// transform, run with: go test -run none -bench TransformManagedCluster ./pkg/common/
+func BenchmarkTransformManagedCluster(b *testing.B) {
+	const clusterCount = 3500
+
+	heapOf := func(transform bool) uint64 {
+		runtime.GC()
+		var before runtime.MemStats
+		runtime.ReadMemStats(&before)
+
+		store := make([]interface{}, 0, clusterCount)
+		for i := 0; i < clusterCount; i++ {
+			var obj interface{} = newFullManagedCluster(fmt.Sprintf("cluster%d", i))
+			if transform {
+				obj, _ = TransformManagedCluster()(obj)
+			}
+			store = append(store, obj)
+		}
+
+		runtime.GC()
+		var after runtime.MemStats
+		runtime.ReadMemStats(&after)
+		runtime.KeepAlive(store)
+		if after.HeapAlloc < before.HeapAlloc {
+			return 0
+		}
+		return after.HeapAlloc - before.HeapAlloc
+	}
+
+	var full, trimmed uint64
+	for i := 0; i < b.N; i++ {
+		full += heapOf(false)
+		trimmed += heapOf(true)
+	}
+	b.ReportMetric(float64(full)/float64(b.N)/1024, "full-KiB")
+	b.ReportMetric(float64(trimmed)/float64(b.N)/1024, "trimmed-KiB")
+}
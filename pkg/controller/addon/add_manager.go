@@ -7,8 +7,10 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
@@ -16,23 +18,139 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/controller/history"
 
 	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
 	managedclusterv1 "open-cluster-management.io/api/cluster/v1"
+	workv1 "open-cluster-management.io/api/work/v1"
 )
 
-func Add(mgr manager.Manager, kubeClient kubernetes.Interface) error {
-	return add(mgr, newReconciler(mgr))
+func Add(mgr manager.Manager, kubeClient kubernetes.Interface, opts *common.Options) error {
+	configMaps := []types.NamespacedName{}
+	for _, configMap := range []types.NamespacedName{opts.CompatibilityConfigMapName(),
+		opts.MaintenanceWindowsConfigMapName()} {
+		if len(configMap.Name) != 0 {
+			configMaps = append(configMaps, configMap)
+		}
+	}
+	r := newReconciler(mgr, opts)
+	// the rollout state of the addons is shared by the reconciles of all the managed clusters
+	if err := r.rolloutTracker.register(context.TODO(), mgr.GetCache()); err != nil {
+		return err
+	}
+	return add(mgr, r, opts.Addon, opts.Shard, configMaps, opts.DefaultImagePullSecretName())
 }
 
-func add(mgr manager.Manager, r reconcile.Reconciler) error {
-	c, err := controller.New("klusterletAddon-controller", mgr, controller.Options{Reconciler: r})
+// add creates the controller and adds it to the Manager, the configMaps are the hub ConfigMaps which configure the
+// addons of all the managed clusters, and the defaultImagePullSecret is the image pull secret of all the managed
+// clusters which do not set their own
+func add(mgr manager.Manager, r reconcile.Reconciler, opts common.ControllerOptions, shard *common.Shard,
+	configMaps []types.NamespacedName, defaultImagePullSecret types.NamespacedName) error {
+	c, err := controller.New("klusterletAddon-controller", mgr, opts.ToControllerOptions(r))
+	if err != nil {
+		return err
+	}
+
+	// the rollout of the values updates lists the addons of the same name across the managed clusters until the
+	// rollout tracker is synced
+	err = mgr.GetFieldIndexer().IndexField(context.TODO(), &addonv1alpha1.ManagedClusterAddOn{}, addonNameIndex,
+		indexAddonName)
+	if err != nil {
+		return err
+	}
+
+	if len(configMaps) != 0 {
+		// all the klusterletAddonConfigs are reconciled when the compatibility or maintenance windows configMap
+		// is changed
+		err = c.Watch(source.Kind(mgr.GetCache(), &corev1.ConfigMap{},
+			handler.TypedEnqueueRequestsFromMapFunc[*corev1.ConfigMap](
+				enqueueAllKlusterletAddonConfigs[*corev1.ConfigMap](mgr)),
+			predicate.NewTypedPredicateFuncs[*corev1.ConfigMap](func(cm *corev1.ConfigMap) bool {
+				for _, configMap := range configMaps {
+					if cm.Namespace == configMap.Namespace && cm.Name == configMap.Name {
+						return true
+					}
+				}
+				return false
+			}),
+		))
+		if err != nil {
+			return err
+		}
+	}
+
+	// the image pull secrets are copied to the managed clusters again when they are rotated, the secrets of a
+	// klusterletAddonConfig are in its namespace, and the default secret is used by all the klusterletAddonConfigs.
+	// The other secrets are not referenced by any klusterletAddonConfig, they are ignored.
+	enqueueAll := enqueueAllKlusterletAddonConfigs[*corev1.Secret](mgr)
+	err = c.Watch(source.Kind(mgr.GetCache(), &corev1.Secret{},
+		handler.TypedEnqueueRequestsFromMapFunc[*corev1.Secret](
+			func(ctx context.Context, secret *corev1.Secret) []reconcile.Request {
+				if secret.Namespace == defaultImagePullSecret.Namespace && secret.Name == defaultImagePullSecret.Name {
+					return enqueueAll(ctx, secret)
+				}
+				return []reconcile.Request{
+					{
+						NamespacedName: types.NamespacedName{
+							Name:      secret.GetNamespace(),
+							Namespace: secret.GetNamespace(),
+						},
+					},
+				}
+			}),
+		predicate.NewTypedPredicateFuncs[*corev1.Secret](func(secret *corev1.Secret) bool {
+			if secret.Namespace == defaultImagePullSecret.Namespace && secret.Name == defaultImagePullSecret.Name {
+				return true
+			}
+			config := &agentv1.KlusterletAddonConfig{}
+			err := mgr.GetClient().Get(context.TODO(),
+				types.NamespacedName{Namespace: secret.Namespace, Name: secret.Namespace}, config)
+			if err != nil || !referencesImagePullSecret(config, secret.Name) {
+				return false
+			}
+			return shard.ContainsNamespace(context.TODO(), mgr.GetClient(), secret.GetNamespace())
+		}),
+	))
+	if err != nil {
+		return err
+	}
+
+	// all the klusterletAddonConfigs are reconciled when the annotations of the clusterManagementAddOns configuring
+	// the addons are changed
+	err = c.Watch(source.Kind(mgr.GetCache(), &addonv1alpha1.ClusterManagementAddOn{},
+		handler.TypedEnqueueRequestsFromMapFunc[*addonv1alpha1.ClusterManagementAddOn](
+			enqueueAllKlusterletAddonConfigs[*addonv1alpha1.ClusterManagementAddOn](mgr)),
+		predicate.TypedFuncs[*addonv1alpha1.ClusterManagementAddOn]{
+			GenericFunc: func(e event.TypedGenericEvent[*addonv1alpha1.ClusterManagementAddOn]) bool { return false },
+			CreateFunc: func(e event.TypedCreateEvent[*addonv1alpha1.ClusterManagementAddOn]) bool {
+				return hasAddonAnnotations(e.Object)
+			},
+			DeleteFunc: func(e event.TypedDeleteEvent[*addonv1alpha1.ClusterManagementAddOn]) bool {
+				return hasAddonAnnotations(e.Object)
+			},
+			UpdateFunc: func(e event.TypedUpdateEvent[*addonv1alpha1.ClusterManagementAddOn]) bool {
+				for _, key := range clusterManagementAddOnAnnotations {
+					if e.ObjectOld.GetAnnotations()[key] != e.ObjectNew.GetAnnotations()[key] {
+						return true
+					}
+				}
+				return false
+			},
+		},
+	))
 	if err != nil {
 		return err
 	}
 
 	err = c.Watch(source.Kind(mgr.GetCache(), &agentv1.KlusterletAddonConfig{},
-		&handler.TypedEnqueueRequestForObject[*agentv1.KlusterletAddonConfig]{}))
+		&handler.TypedEnqueueRequestForObject[*agentv1.KlusterletAddonConfig]{},
+		predicate.NewTypedPredicateFuncs[*agentv1.KlusterletAddonConfig](
+			func(config *agentv1.KlusterletAddonConfig) bool {
+				return shard.ContainsNamespace(context.TODO(), mgr.GetClient(), config.GetNamespace())
+			}),
+		history.IgnorePendingChangesRemoval,
+	))
 	if err != nil {
 		return err
 	}
@@ -49,6 +167,39 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 					},
 				}
 			}),
+		predicate.NewTypedPredicateFuncs[*managedclusterv1.ManagedCluster](
+			func(cluster *managedclusterv1.ManagedCluster) bool {
+				return shard.Contains(cluster)
+			}),
+	))
+	if err != nil {
+		return err
+	}
+
+	err = c.Watch(source.Kind(mgr.GetCache(), &workv1.ManifestWork{},
+		handler.TypedEnqueueRequestsFromMapFunc[*workv1.ManifestWork](
+			func(ctx context.Context, work *workv1.ManifestWork) []reconcile.Request {
+				clusterName, ok := work.GetLabels()[common.LabelImagePullSecretCluster]
+				if !ok {
+					return nil
+				}
+				return []reconcile.Request{
+					{
+						NamespacedName: types.NamespacedName{
+							Name:      clusterName,
+							Namespace: clusterName,
+						},
+					},
+				}
+			}),
+		predicate.TypedFuncs[*workv1.ManifestWork]{
+			GenericFunc: func(e event.TypedGenericEvent[*workv1.ManifestWork]) bool { return false },
+			CreateFunc:  func(e event.TypedCreateEvent[*workv1.ManifestWork]) bool { return false },
+			// the status of the ManifestWork is updated by the work agent frequently, only spec changes are handled
+			UpdateFunc: func(e event.TypedUpdateEvent[*workv1.ManifestWork]) bool {
+				return e.ObjectOld.GetGeneration() != e.ObjectNew.GetGeneration()
+			},
+		},
 	))
 	if err != nil {
 		return err
@@ -66,6 +217,10 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 					},
 				}
 			}),
+		predicate.NewTypedPredicateFuncs[*addonv1alpha1.ManagedClusterAddOn](
+			func(addon *addonv1alpha1.ManagedClusterAddOn) bool {
+				return shard.ContainsNamespace(context.TODO(), mgr.GetClient(), addon.GetNamespace())
+			}),
 		predicate.TypedFuncs[*addonv1alpha1.ManagedClusterAddOn]{
 			GenericFunc: func(e event.TypedGenericEvent[*addonv1alpha1.ManagedClusterAddOn]) bool { return false },
 			CreateFunc: func(e event.TypedCreateEvent[*addonv1alpha1.ManagedClusterAddOn]) bool {
@@ -96,3 +251,43 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 
 	return err
 }
+
+// enqueueAllKlusterletAddonConfigs returns the map function which enqueues all the klusterletAddonConfigs
+func enqueueAllKlusterletAddonConfigs[T client.Object](mgr manager.Manager) handler.TypedMapFunc[T] {
+	return func(ctx context.Context, _ T) []reconcile.Request {
+		configs := &agentv1.KlusterletAddonConfigList{}
+		if err := mgr.GetClient().List(ctx, configs); err != nil {
+			klog.Errorf("failed to list klusterletAddonConfigs: %v", err)
+			return nil
+		}
+		requests := []reconcile.Request{}
+		for _, config := range configs.Items {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: config.Name, Namespace: config.Namespace},
+			})
+		}
+		return requests
+	}
+}
+
+// clusterManagementAddOnAnnotations is the annotations of the clusterManagementAddOns which configure the addons
+// on all the managed clusters
+var clusterManagementAddOnAnnotations = []string{
+	common.AnnotationHostedModeAddOn,
+	common.AnnotationHostedAddOnInstallNamespace,
+	common.AnnotationRolloutStrategy,
+}
+
+// hasAddonAnnotations returns true if the clusterManagementAddOn of a klusterlet addon has any annotations
+// configuring the addons
+func hasAddonAnnotations(cma *addonv1alpha1.ClusterManagementAddOn) bool {
+	if _, ok := agentv1.KlusterletAddons[cma.GetName()]; !ok {
+		return false
+	}
+	for _, key := range clusterManagementAddOnAnnotations {
+		if _, ok := cma.GetAnnotations()[key]; ok {
+			return true
+		}
+	}
+	return false
+}
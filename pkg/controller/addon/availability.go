@@ -0,0 +1,177 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addon
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+)
+
+// clusterAvailability is the availability of the managed cluster read from its spec and conditions
+type clusterAvailability struct {
+	// available is true if the cluster is accepted by the hub, joined and available
+	available bool
+	// reason describes why the cluster is not available
+	reason string
+	// unavailableSince is the last transition time of the available condition, or the creation time of the cluster
+	// if it has never been available
+	unavailableSince time.Time
+}
+
+// getClusterAvailability returns the availability of the managed cluster
+func getClusterAvailability(cluster *mcv1.ManagedCluster) clusterAvailability {
+	availability := clusterAvailability{unavailableSince: cluster.CreationTimestamp.Time}
+	available := meta.FindStatusCondition(cluster.Status.Conditions, mcv1.ManagedClusterConditionAvailable)
+	if available != nil {
+		availability.unavailableSince = available.LastTransitionTime.Time
+	}
+
+	switch {
+	case !cluster.Spec.HubAcceptsClient:
+		availability.reason = "the cluster is not accepted by the hub"
+	case !meta.IsStatusConditionTrue(cluster.Status.Conditions, mcv1.ManagedClusterConditionJoined):
+		availability.reason = "the cluster has not joined the hub"
+	case available == nil || available.Status != metav1.ConditionTrue:
+		availability.reason = "the cluster is not available"
+	default:
+		availability.available = true
+	}
+	return availability
+}
+
+// gracePeriodRemaining returns the remaining grace period of the unavailable cluster before its addons are torn
+// down and whether the grace period has expired, zero is returned if the grace period is disabled or the cluster
+// is available.
+func (r *ReconcileKlusterletAddOn) gracePeriodRemaining(availability clusterAvailability) (time.Duration, bool) {
+	if r.unavailableClusterGracePeriod == 0 || availability.available {
+		return 0, false
+	}
+	remaining := time.Until(availability.unavailableSince.Add(r.unavailableClusterGracePeriod))
+	if remaining <= 0 {
+		return 0, true
+	}
+	return remaining, false
+}
+
+// tearDownManagedClusterAddons deletes the managedClusterAddons created by this controller for the cluster which is
+// unavailable longer than the grace period, the paused addons and the addons installed by the placements are kept.
+func (r *ReconcileKlusterletAddOn) tearDownManagedClusterAddons(ctx context.Context,
+	config *agentv1.KlusterletAddonConfig, clusterName string) error {
+	var errs []error
+	for addonName, needUpdate := range agentv1.KlusterletAddons {
+		if !needUpdate || addonIsPaused(addonName, config) {
+			continue
+		}
+
+		cma := &addonv1alpha1.ClusterManagementAddOn{}
+		if err := r.client.Get(ctx, types.NamespacedName{Name: addonName}, cma); err == nil &&
+			cma.Spec.InstallStrategy.Type == addonv1alpha1.AddonInstallStrategyPlacements {
+			continue
+		}
+
+		if err := r.deleteManagedClusterAddon(ctx, addonName, clusterName); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := r.syncImagePullSecretWorks(ctx, clusterName, nil); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("failed to tear down addons %v", errs)
+	}
+	return nil
+}
+
+// reconcileUnavailableCluster defers the addons of the cluster which is not available, and tears them down if the
+// cluster is unavailable longer than the grace period.
+func (r *ReconcileKlusterletAddOn) reconcileUnavailableCluster(ctx context.Context,
+	config *agentv1.KlusterletAddonConfig, cluster *mcv1.ManagedCluster,
+	availability clusterAvailability) (reconcile.Result, error) {
+	remaining, expired := r.gracePeriodRemaining(availability)
+	if expired {
+		klog.Infof("tear down the addons of cluster %v: %s since %v", cluster.GetName(), availability.reason,
+			availability.unavailableSince.Format(time.RFC3339))
+		if err := r.tearDownManagedClusterAddons(ctx, config, cluster.GetName()); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	return reconcile.Result{RequeueAfter: remaining}, r.updateStatus(ctx, config,
+		setConditions(
+			newDeferredCondition(config, r.gateOnClusterAvailability, availability),
+			r.newTornDownCondition(config, availability, expired)))
+}
+
+// newDeferredCondition returns the condition which reports the addons are deferred until the cluster is available,
+// nil is returned if the addons are not deferred and the condition is not set before.
+func newDeferredCondition(config *agentv1.KlusterletAddonConfig, gateOnClusterAvailability bool,
+	availability clusterAvailability) *metav1.Condition {
+	deferred := gateOnClusterAvailability && !availability.available
+	if !deferred && meta.FindStatusCondition(config.Status.Conditions, agentv1.AddonsDeferred) == nil {
+		return nil
+	}
+
+	if !deferred {
+		return &metav1.Condition{
+			Type:    agentv1.AddonsDeferred,
+			Status:  metav1.ConditionFalse,
+			Reason:  agentv1.ReasonClusterAvailable,
+			Message: "The addons are deployed.",
+		}
+	}
+	return &metav1.Condition{
+		Type:    agentv1.AddonsDeferred,
+		Status:  metav1.ConditionTrue,
+		Reason:  agentv1.ReasonClusterNotAvailable,
+		Message: fmt.Sprintf("The addons are deferred because %s.", availability.reason),
+	}
+}
+
+// newTornDownCondition returns the condition which reports the addons are torn down or will be torn down after the
+// grace period, nil is returned if the cluster is available and the condition is not set before.
+func (r *ReconcileKlusterletAddOn) newTornDownCondition(config *agentv1.KlusterletAddonConfig,
+	availability clusterAvailability, expired bool) *metav1.Condition {
+	inGracePeriod := r.unavailableClusterGracePeriod != 0 && !availability.available
+	if !inGracePeriod && meta.FindStatusCondition(config.Status.Conditions, agentv1.AddonsTornDown) == nil {
+		return nil
+	}
+
+	switch {
+	case !inGracePeriod:
+		return &metav1.Condition{
+			Type:    agentv1.AddonsTornDown,
+			Status:  metav1.ConditionFalse,
+			Reason:  agentv1.ReasonClusterAvailable,
+			Message: "The addons are not torn down.",
+		}
+	case expired:
+		return &metav1.Condition{
+			Type:   agentv1.AddonsTornDown,
+			Status: metav1.ConditionTrue,
+			Reason: agentv1.ReasonUnavailableGracePeriodExpired,
+			Message: fmt.Sprintf("The addons are torn down because %s since %s.", availability.reason,
+				availability.unavailableSince.UTC().Format(time.RFC3339)),
+		}
+	}
+	return &metav1.Condition{
+		Type:   agentv1.AddonsTornDown,
+		Status: metav1.ConditionFalse,
+		Reason: agentv1.ReasonWithinUnavailableGracePeriod,
+		Message: fmt.Sprintf("The addons will be torn down at %s because %s.",
+			availability.unavailableSince.Add(r.unavailableClusterGracePeriod).UTC().Format(time.RFC3339),
+			availability.reason),
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"open-cluster-management.io/api/addon/v1alpha1"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	workv1 "open-cluster-management.io/api/work/v1"
+
+	"github.com/stolostron/klusterlet-addon-controller/pkg/apis"
+	v1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+)
+
+func newClusterWithAvailability(name string, joined bool, available metav1.ConditionStatus,
+	lastTransitionTime time.Time) *mcv1.ManagedCluster {
+	cluster := newManagedCluster(name, nil, nil)
+	cluster.Spec.HubAcceptsClient = true
+	if joined {
+		cluster.Status.Conditions = append(cluster.Status.Conditions, metav1.Condition{
+			Type:               mcv1.ManagedClusterConditionJoined,
+			Status:             metav1.ConditionTrue,
+			Reason:             "ManagedClusterJoined",
+			LastTransitionTime: metav1.NewTime(lastTransitionTime),
+		})
+	}
+	if len(available) != 0 {
+		cluster.Status.Conditions = append(cluster.Status.Conditions, metav1.Condition{
+			Type:               mcv1.ManagedClusterConditionAvailable,
+			Status:             available,
+			Reason:             "ManagedClusterAvailable",
+			LastTransitionTime: metav1.NewTime(lastTransitionTime),
+		})
+	}
+	return cluster
+}
+
+func Test_getClusterAvailability(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name              string
+		cluster           *mcv1.ManagedCluster
+		expectedAvailable bool
+		expectedReason    string
+	}{
+		{
+			name: "not accepted",
+			cluster: func() *mcv1.ManagedCluster {
+				cluster := newClusterWithAvailability("cluster1", true, metav1.ConditionTrue, now)
+				cluster.Spec.HubAcceptsClient = false
+				return cluster
+			}(),
+			expectedReason: "the cluster is not accepted by the hub",
+		},
+		{
+			name:           "not joined",
+			cluster:        newClusterWithAvailability("cluster1", false, "", now),
+			expectedReason: "the cluster has not joined the hub",
+		},
+		{
+			name:           "not available",
+			cluster:        newClusterWithAvailability("cluster1", true, metav1.ConditionUnknown, now),
+			expectedReason: "the cluster is not available",
+		},
+		{
+			name:              "available",
+			cluster:           newClusterWithAvailability("cluster1", true, metav1.ConditionTrue, now),
+			expectedAvailable: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			availability := getClusterAvailability(c.cluster)
+			if availability.available != c.expectedAvailable || availability.reason != c.expectedReason {
+				t.Errorf("expected available %v and reason %q, but got %v and %q", c.expectedAvailable,
+					c.expectedReason, availability.available, availability.reason)
+			}
+		})
+	}
+}
+
+func Test_ReconcileAvailability(t *testing.T) {
+	testscheme := scheme.Scheme
+	_ = mcv1.AddToScheme(testscheme)
+	_ = v1alpha1.AddToScheme(testscheme)
+	_ = apis.AddToScheme(testscheme)
+	_ = workv1.AddToScheme(testscheme)
+
+	cases := []struct {
+		name                      string
+		cluster                   *mcv1.ManagedCluster
+		addons                    []runtime.Object
+		gateOnClusterAvailability bool
+		gracePeriod               time.Duration
+		expectedAddons            int
+		expectedRequeue           bool
+		expectedConditions        map[string]string
+	}{
+		{
+			name:                      "deferred until the cluster is available",
+			cluster:                   newClusterWithAvailability("cluster1", true, metav1.ConditionUnknown, time.Now()),
+			gateOnClusterAvailability: true,
+			expectedConditions:        map[string]string{v1.AddonsDeferred: v1.ReasonClusterNotAvailable},
+		},
+		{
+			name:                      "not deferred if the cluster is available",
+			cluster:                   newClusterWithAvailability("cluster1", true, metav1.ConditionTrue, time.Now()),
+			gateOnClusterAvailability: true,
+			expectedAddons:            5,
+		},
+		{
+			name:           "not gated, the addons are deployed within the grace period",
+			cluster:        newClusterWithAvailability("cluster1", true, metav1.ConditionFalse, time.Now()),
+			gracePeriod:    time.Hour,
+			expectedAddons: 5,
+			expectedConditions: map[string]string{
+				v1.AddonsTornDown: v1.ReasonWithinUnavailableGracePeriod,
+			},
+			expectedRequeue: true,
+		},
+		{
+			name: "torn down after the grace period",
+			cluster: newClusterWithAvailability("cluster1", true, metav1.ConditionFalse,
+				time.Now().Add(-2*time.Hour)),
+			addons: []runtime.Object{
+				newManagedClusterAddon(v1.SearchAddonName, "cluster1", addonHosting{}),
+				newManagedClusterAddon(v1.WorkManagerAddonName, "cluster1", addonHosting{}),
+			},
+			gateOnClusterAvailability: true,
+			gracePeriod:               time.Hour,
+			// the work-manager addon is not managed by this controller
+			expectedAddons: 1,
+			expectedConditions: map[string]string{
+				v1.AddonsDeferred: v1.ReasonClusterNotAvailable,
+				v1.AddonsTornDown: v1.ReasonUnavailableGracePeriodExpired,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			objs := append([]runtime.Object{c.cluster, newKlusterletAddonConfig("cluster1")}, c.addons...)
+			reconciler := &ReconcileKlusterletAddOn{
+				client:                        newFakeClient(testscheme, objs...),
+				gateOnClusterAvailability:     c.gateOnClusterAvailability,
+				unavailableClusterGracePeriod: c.gracePeriod,
+			}
+
+			result, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: "cluster1", Name: "cluster1"},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if requeue := result.RequeueAfter > 0; requeue != c.expectedRequeue {
+				t.Errorf("expected requeue %v, but got %v", c.expectedRequeue, result.RequeueAfter)
+			}
+
+			addons := &v1alpha1.ManagedClusterAddOnList{}
+			if err := reconciler.client.List(context.TODO(), addons, client.InNamespace("cluster1")); err != nil {
+				t.Fatalf("failed to list addons: %v", err)
+			}
+			if len(addons.Items) != c.expectedAddons {
+				t.Errorf("expected %d addons, but got %d", c.expectedAddons, len(addons.Items))
+			}
+
+			config := &v1.KlusterletAddonConfig{}
+			if err := reconciler.client.Get(context.TODO(),
+				types.NamespacedName{Namespace: "cluster1", Name: "cluster1"}, config); err != nil {
+				t.Fatalf("failed to get klusterletaddonconfig: %v", err)
+			}
+			for _, conditionType := range []string{v1.AddonsDeferred, v1.AddonsTornDown} {
+				condition := meta.FindStatusCondition(config.Status.Conditions, conditionType)
+				expectedReason, ok := c.expectedConditions[conditionType]
+				switch {
+				case !ok && condition != nil:
+					t.Errorf("expected no condition %s, but got %v", conditionType, condition)
+				case ok && (condition == nil || condition.Reason != expectedReason):
+					t.Errorf("expected condition %s with reason %s, but got %v", conditionType, expectedReason,
+						condition)
+				}
+			}
+		})
+	}
+}
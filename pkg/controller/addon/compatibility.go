@@ -0,0 +1,130 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addon
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+)
+
+const (
+	// compatibilityConfigMapKey is the data key of the compatibility matrix in the compatibility ConfigMap
+	compatibilityConfigMapKey = "compatibility.yaml"
+
+	// the legacy labels of the product and the platform of the managed cluster
+	labelVendor = "vendor"
+	labelCloud  = "cloud"
+)
+
+// compatibilityMatrix is the map of the addon names to the products and platforms the addons do not support
+type compatibilityMatrix map[string]addonCompatibility
+
+// addonCompatibility is the products and platforms an addon does not support, the values are compared with the
+// product and platform cluster claims case-insensitively
+type addonCompatibility struct {
+	UnsupportedProducts  []string `json:"unsupportedProducts,omitempty"`
+	UnsupportedPlatforms []string `json:"unsupportedPlatforms,omitempty"`
+}
+
+// defaultCompatibilityMatrix is the compatibility matrix used if it is not overridden by the compatibility ConfigMap,
+// the search collector is not supported on the edge devices of MicroShift
+var defaultCompatibilityMatrix = compatibilityMatrix{
+	agentv1.SearchAddonName: {UnsupportedProducts: []string{"MicroShift"}},
+}
+
+// getCompatibilityMatrix returns the default compatibility matrix overridden by the compatibility ConfigMap,
+// the ConfigMap overrides the compatibility of the addons it contains.
+func (r *ReconcileKlusterletAddOn) getCompatibilityMatrix(ctx context.Context) (compatibilityMatrix, error) {
+	if len(r.compatibilityConfigMap.Name) == 0 {
+		return defaultCompatibilityMatrix, nil
+	}
+
+	cm := &corev1.ConfigMap{}
+	err := r.client.Get(ctx, r.compatibilityConfigMap, cm)
+	if errors.IsNotFound(err) {
+		return defaultCompatibilityMatrix, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := compatibilityMatrix{}
+	if err := yaml.Unmarshal([]byte(cm.Data[compatibilityConfigMapKey]), &overrides); err != nil {
+		return nil, fmt.Errorf("failed to parse the compatibility configmap %s: %v", r.compatibilityConfigMap, err)
+	}
+
+	matrix := compatibilityMatrix{}
+	for addonName, compatibility := range defaultCompatibilityMatrix {
+		matrix[addonName] = compatibility
+	}
+	for addonName, compatibility := range overrides {
+		matrix[addonName] = compatibility
+	}
+	return matrix, nil
+}
+
+// checkCompatibility returns the reason if the addon does not support the product or platform of the cluster,
+// an empty string is returned if the addon is compatible.
+func (m compatibilityMatrix) checkCompatibility(addonName string, cluster *mcv1.ManagedCluster) string {
+	compatibility, ok := m[addonName]
+	if !ok {
+		return ""
+	}
+
+	if product := getClusterClaimOrLabel(cluster, common.ClusterClaimProduct, labelVendor); containsFold(
+		compatibility.UnsupportedProducts, product) {
+		return fmt.Sprintf("product %s is not supported", product)
+	}
+	if platform := getClusterClaimOrLabel(cluster, common.ClusterClaimPlatform, labelCloud); containsFold(
+		compatibility.UnsupportedPlatforms, platform) {
+		return fmt.Sprintf("platform %s is not supported", platform)
+	}
+	return ""
+}
+
+// getClusterClaimOrLabel returns the value of the cluster claim, or the label with the claim name or the legacy
+// label name if the claim is not set
+func getClusterClaimOrLabel(cluster *mcv1.ManagedCluster, claimName, legacyLabel string) string {
+	for _, claim := range cluster.Status.ClusterClaims {
+		if claim.Name == claimName {
+			return claim.Value
+		}
+	}
+	if value, ok := cluster.Labels[claimName]; ok {
+		return value
+	}
+	return cluster.Labels[legacyLabel]
+}
+
+func containsFold(values []string, value string) bool {
+	if len(value) == 0 {
+		return false
+	}
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// newCompatibilityCondition returns the condition which reports the addons incompatible with the cluster, nil is
+// returned if all the addons are compatible and the condition is not set before.
+func newCompatibilityCondition(config *agentv1.KlusterletAddonConfig,
+	incompatibleAddons map[string]string) *metav1.Condition {
+	return newAddonsCondition(config, agentv1.AddonsIncompatible, incompatibleAddons,
+		agentv1.ReasonIncompatiblePlatform,
+		"The addons are skipped because they are incompatible with the cluster",
+		agentv1.ReasonCompatiblePlatform, "The addons are compatible with the cluster.")
+}
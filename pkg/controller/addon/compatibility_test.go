@@ -0,0 +1,171 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addon
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"open-cluster-management.io/api/addon/v1alpha1"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	workv1 "open-cluster-management.io/api/work/v1"
+
+	"github.com/stolostron/klusterlet-addon-controller/pkg/apis"
+	v1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+)
+
+func Test_checkCompatibility(t *testing.T) {
+	matrix := compatibilityMatrix{
+		v1.SearchAddonName: {
+			UnsupportedProducts:  []string{"MicroShift"},
+			UnsupportedPlatforms: []string{"IBMZ"},
+		},
+	}
+
+	cases := []struct {
+		name      string
+		addonName string
+		cluster   *mcv1.ManagedCluster
+		expected  string
+	}{
+		{
+			name:      "addon not in the matrix",
+			addonName: v1.ApplicationAddonName,
+			cluster:   newManagedCluster("cluster1", map[string]string{"vendor": "MicroShift"}, nil),
+		},
+		{
+			name:      "unsupported product from the cluster claim",
+			addonName: v1.SearchAddonName,
+			cluster: func() *mcv1.ManagedCluster {
+				cluster := newManagedCluster("cluster1", map[string]string{"vendor": "OpenShift"}, nil)
+				cluster.Status.ClusterClaims = []mcv1.ManagedClusterClaim{
+					{Name: common.ClusterClaimProduct, Value: "microshift"},
+				}
+				return cluster
+			}(),
+			expected: "product microshift is not supported",
+		},
+		{
+			name:      "unsupported platform from the claim label",
+			addonName: v1.SearchAddonName,
+			cluster:   newManagedCluster("cluster1", map[string]string{common.ClusterClaimPlatform: "IBMZ"}, nil),
+			expected:  "platform IBMZ is not supported",
+		},
+		{
+			name:      "unsupported product from the legacy label",
+			addonName: v1.SearchAddonName,
+			cluster:   newManagedCluster("cluster1", map[string]string{"vendor": "MicroShift"}, nil),
+			expected:  "product MicroShift is not supported",
+		},
+		{
+			name:      "supported product and platform",
+			addonName: v1.SearchAddonName,
+			cluster:   newManagedCluster("cluster1", map[string]string{"vendor": "OpenShift", "cloud": "Amazon"}, nil),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if actual := matrix.checkCompatibility(c.addonName, c.cluster); actual != c.expected {
+				t.Errorf("expected %q, but got %q", c.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_ReconcileCompatibility(t *testing.T) {
+	testscheme := scheme.Scheme
+	_ = mcv1.AddToScheme(testscheme)
+	_ = v1alpha1.AddToScheme(testscheme)
+	_ = apis.AddToScheme(testscheme)
+	_ = workv1.AddToScheme(testscheme)
+
+	compatibilityConfigMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "compatibility", Namespace: "open-cluster-management"},
+		Data: map[string]string{
+			compatibilityConfigMapKey: "search-collector:\n  unsupportedProducts:\n  - MicroShift\n",
+		},
+	}
+	kubeClient := newFakeClient(testscheme,
+		newManagedCluster("cluster1", map[string]string{"vendor": "MicroShift"}, nil),
+		newKlusterletAddonConfig("cluster1"),
+		compatibilityConfigMap)
+	reconciler := &ReconcileKlusterletAddOn{
+		client:                 kubeClient,
+		compatibilityConfigMap: types.NamespacedName{Namespace: "open-cluster-management", Name: "compatibility"},
+	}
+
+	_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+		NamespacedName: types.NamespacedName{Namespace: "cluster1", Name: "cluster1"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	addons := &v1alpha1.ManagedClusterAddOnList{}
+	if err := kubeClient.List(context.TODO(), addons); err != nil {
+		t.Fatalf("failed to list addons: %v", err)
+	}
+	for _, addon := range addons.Items {
+		if addon.Name == v1.SearchAddonName {
+			t.Errorf("expected the incompatible search-collector is not created")
+		}
+	}
+	if len(addons.Items) != 4 {
+		t.Errorf("expected 4 addons, but got %d", len(addons.Items))
+	}
+
+	config := &v1.KlusterletAddonConfig{}
+	if err := kubeClient.Get(context.TODO(), types.NamespacedName{Namespace: "cluster1", Name: "cluster1"}, config); err != nil {
+		t.Fatalf("failed to get klusterletaddonconfig: %v", err)
+	}
+	condition := meta.FindStatusCondition(config.Status.Conditions, v1.AddonsIncompatible)
+	if condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != v1.ReasonIncompatiblePlatform {
+		t.Fatalf("expected incompatible condition is true, but got %v", condition)
+	}
+}
+
+func Test_ReconcileDefaultCompatibility(t *testing.T) {
+	testscheme := scheme.Scheme
+	_ = mcv1.AddToScheme(testscheme)
+	_ = v1alpha1.AddToScheme(testscheme)
+	_ = apis.AddToScheme(testscheme)
+	_ = workv1.AddToScheme(testscheme)
+
+	// the search-collector deployed before the cluster is known as MicroShift is removed by the default matrix
+	kubeClient := newFakeClient(testscheme,
+		newManagedCluster("cluster1", map[string]string{"vendor": "MicroShift"}, nil),
+		newKlusterletAddonConfig("cluster1"),
+		newManagedClusterAddon(v1.SearchAddonName, "cluster1", addonHosting{}))
+	reconciler := &ReconcileKlusterletAddOn{client: kubeClient}
+
+	_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+		NamespacedName: types.NamespacedName{Namespace: "cluster1", Name: "cluster1"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = kubeClient.Get(context.TODO(), types.NamespacedName{Namespace: "cluster1", Name: v1.SearchAddonName},
+		&v1alpha1.ManagedClusterAddOn{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("expected the incompatible search-collector is deleted, but got %v", err)
+	}
+
+	config := &v1.KlusterletAddonConfig{}
+	if err := kubeClient.Get(context.TODO(), types.NamespacedName{Namespace: "cluster1", Name: "cluster1"}, config); err != nil {
+		t.Fatalf("failed to get klusterletaddonconfig: %v", err)
+	}
+	if !meta.IsStatusConditionTrue(config.Status.Conditions, v1.AddonsIncompatible) {
+		t.Errorf("expected incompatible condition is true, but got %v", config.Status.Conditions)
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addon
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+)
+
+// orderedKlusterletAddons is the names of the klusterlet addons sorted by their dependencies, the prerequisites are
+// ahead of their dependents
+var orderedKlusterletAddons = mustSortAddons(agentv1.KlusterletAddons, agentv1.KlusterletAddonDependencies)
+
+// sortAddons returns the addon names in topological order of the dependencies, the addons without dependencies
+// between them are sorted by name. An error is returned if there is an unknown prerequisite or a dependency cycle.
+func sortAddons(addons map[string]bool, dependencies map[string][]string) ([]string, error) {
+	remaining := map[string]int{}
+	for addonName := range addons {
+		remaining[addonName] = len(dependencies[addonName])
+		for _, prerequisite := range dependencies[addonName] {
+			if _, ok := addons[prerequisite]; !ok {
+				return nil, fmt.Errorf("the prerequisite %s of addon %s is unknown", prerequisite, addonName)
+			}
+		}
+	}
+
+	sorted := []string{}
+	for len(remaining) != 0 {
+		ready := []string{}
+		for addonName, count := range remaining {
+			if count == 0 {
+				ready = append(ready, addonName)
+			}
+		}
+		if len(ready) == 0 {
+			cycle := []string{}
+			for addonName := range remaining {
+				cycle = append(cycle, addonName)
+			}
+			sort.Strings(cycle)
+			return nil, fmt.Errorf("dependency cycle between addons %s", strings.Join(cycle, ", "))
+		}
+
+		sort.Strings(ready)
+		for _, addonName := range ready {
+			delete(remaining, addonName)
+			sorted = append(sorted, addonName)
+		}
+		for addonName := range remaining {
+			for _, prerequisite := range dependencies[addonName] {
+				for _, r := range ready {
+					if prerequisite == r {
+						remaining[addonName]--
+					}
+				}
+			}
+		}
+	}
+	return sorted, nil
+}
+
+func mustSortAddons(addons map[string]bool, dependencies map[string][]string) []string {
+	sorted, err := sortAddons(addons, dependencies)
+	if err != nil {
+		panic(err)
+	}
+	return sorted
+}
+
+// resolveAddonDependencies returns the addons enabled after applying the dependency policy of the
+// klusterletAddonConfig, the enabled addons rejected because their prerequisites are disabled, and the conflicts
+// between the disabled prerequisites and their enabled dependents. The rejected addons are neither applied nor
+// removed.
+func resolveAddonDependencies(config *agentv1.KlusterletAddonConfig) (map[string]bool, sets.Set[string],
+	map[string]string) {
+	enabled := map[string]bool{}
+	for addonName := range agentv1.KlusterletAddons {
+		enabled[addonName] = addonIsEnabled(addonName, config)
+	}
+
+	rejected := sets.New[string]()
+	conflicts := map[string][]string{}
+	switch config.Spec.DependencyPolicy {
+	case agentv1.AddonDependencyPolicyCascade:
+		// the prerequisites are resolved ahead of their dependents, so the cascade goes through the chains
+		for _, addonName := range orderedKlusterletAddons {
+			for _, prerequisite := range agentv1.KlusterletAddonDependencies[addonName] {
+				if enabled[addonName] && !enabled[prerequisite] {
+					enabled[addonName] = false
+					conflicts[addonName] = append(conflicts[addonName],
+						fmt.Sprintf("disabled because the prerequisite %s is disabled", prerequisite))
+				}
+			}
+		}
+	case agentv1.AddonDependencyPolicyEnablePrerequisites:
+		// the dependents are resolved ahead of their prerequisites, so the kept prerequisites keep their own ones
+		kept := sets.New[string]()
+		for i := len(orderedKlusterletAddons) - 1; i >= 0; i-- {
+			addonName := orderedKlusterletAddons[i]
+			if !enabled[addonName] {
+				continue
+			}
+			for _, prerequisite := range agentv1.KlusterletAddonDependencies[addonName] {
+				if !enabled[prerequisite] || kept.Has(prerequisite) {
+					enabled[prerequisite] = true
+					kept.Insert(prerequisite)
+					conflicts[prerequisite] = append(conflicts[prerequisite],
+						fmt.Sprintf("kept because it is required by %s", addonName))
+				}
+			}
+		}
+	default:
+		// the prerequisites are resolved ahead of their dependents, so the rejection goes through the chains
+		for _, addonName := range orderedKlusterletAddons {
+			for _, prerequisite := range agentv1.KlusterletAddonDependencies[addonName] {
+				if !enabled[addonName] {
+					continue
+				}
+				switch {
+				case !enabled[prerequisite]:
+					conflicts[addonName] = append(conflicts[addonName],
+						fmt.Sprintf("rejected because the prerequisite %s is disabled", prerequisite))
+				case rejected.Has(prerequisite):
+					conflicts[addonName] = append(conflicts[addonName],
+						fmt.Sprintf("rejected because the prerequisite %s is rejected", prerequisite))
+				default:
+					continue
+				}
+				rejected.Insert(addonName)
+			}
+		}
+	}
+
+	messages := map[string]string{}
+	for addonName, conflict := range conflicts {
+		messages[addonName] = strings.Join(conflict, "; ")
+	}
+	return enabled, rejected, messages
+}
+
+// waitForPrerequisites returns the reason if the addon is not created yet and any of its prerequisite addons are
+// not available, an empty string is returned if the addon can be applied.
+func (r *ReconcileKlusterletAddOn) waitForPrerequisites(ctx context.Context, addonName, clusterName string) (
+	string, error) {
+	prerequisites := agentv1.KlusterletAddonDependencies[addonName]
+	if len(prerequisites) == 0 {
+		return "", nil
+	}
+
+	// the created addon is kept updated even if its prerequisites become unavailable
+	err := r.client.Get(ctx, types.NamespacedName{Namespace: clusterName, Name: addonName},
+		&addonv1alpha1.ManagedClusterAddOn{})
+	if err == nil {
+		return "", nil
+	}
+	if !errors.IsNotFound(err) {
+		return "", err
+	}
+
+	notAvailable := []string{}
+	for _, prerequisite := range prerequisites {
+		addon := &addonv1alpha1.ManagedClusterAddOn{}
+		err := r.client.Get(ctx, types.NamespacedName{Namespace: clusterName, Name: prerequisite}, addon)
+		if err != nil && !errors.IsNotFound(err) {
+			return "", err
+		}
+		if err != nil || !meta.IsStatusConditionTrue(addon.Status.Conditions,
+			addonv1alpha1.ManagedClusterAddOnConditionAvailable) {
+			notAvailable = append(notAvailable, prerequisite)
+		}
+	}
+	if len(notAvailable) == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("waiting for the prerequisites %s to be available", strings.Join(notAvailable, ", ")), nil
+}
+
+// newPrerequisitesCondition returns the condition which reports the addons waiting for their prerequisites, nil is
+// returned if no addons are waiting and the condition is not set before.
+func newPrerequisitesCondition(config *agentv1.KlusterletAddonConfig,
+	waitingAddons map[string]string) *metav1.Condition {
+	return newAddonsCondition(config, agentv1.AddonsWaitingForPrerequisites, waitingAddons,
+		agentv1.ReasonPrerequisitesNotAvailable,
+		"The addons are not created because their prerequisites are not available",
+		agentv1.ReasonPrerequisitesAvailable, "The prerequisites of the addons are available.")
+}
+
+// newDependencyConflictCondition returns the condition which reports the conflicts between the disabled
+// prerequisites and their enabled dependents, nil is returned if there are no conflicts and the condition is not
+// set before.
+func newDependencyConflictCondition(config *agentv1.KlusterletAddonConfig,
+	conflicts map[string]string) *metav1.Condition {
+	reason := agentv1.ReasonDependentsRejected
+	message := "The dependents are neither applied nor removed because their prerequisites are disabled"
+	switch config.Spec.DependencyPolicy {
+	case agentv1.AddonDependencyPolicyCascade:
+		reason = agentv1.ReasonDependentsDisabled
+		message = "The dependents are disabled because their prerequisites are disabled"
+	case agentv1.AddonDependencyPolicyEnablePrerequisites:
+		reason = agentv1.ReasonPrerequisiteDisableRejected
+		message = "The disabled prerequisites are kept because their dependents are enabled"
+	}
+	return newAddonsCondition(config, agentv1.AddonDependencyConflict, conflicts, reason, message,
+		agentv1.ReasonNoDependencyConflict, "There are no conflicts between the addon dependencies.")
+}
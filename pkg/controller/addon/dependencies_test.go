@@ -0,0 +1,312 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addon
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"open-cluster-management.io/api/addon/v1alpha1"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	workv1 "open-cluster-management.io/api/work/v1"
+
+	"github.com/stolostron/klusterlet-addon-controller/pkg/apis"
+	v1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+)
+
+func Test_sortAddons(t *testing.T) {
+	cases := []struct {
+		name         string
+		addons       map[string]bool
+		dependencies map[string][]string
+		expected     []string
+		expectErr    bool
+	}{
+		{
+			name:     "no dependencies",
+			addons:   map[string]bool{"c": true, "a": true, "b": true},
+			expected: []string{"a", "b", "c"},
+		},
+		{
+			name:         "dependency chain",
+			addons:       map[string]bool{"a": true, "b": true, "c": true, "d": true},
+			dependencies: map[string][]string{"a": {"b"}, "b": {"c"}},
+			expected:     []string{"c", "d", "b", "a"},
+		},
+		{
+			name:         "unknown prerequisite",
+			addons:       map[string]bool{"a": true},
+			dependencies: map[string][]string{"a": {"b"}},
+			expectErr:    true,
+		},
+		{
+			name:         "dependency cycle",
+			addons:       map[string]bool{"a": true, "b": true, "c": true},
+			dependencies: map[string][]string{"a": {"b"}, "b": {"a"}},
+			expectErr:    true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, err := sortAddons(c.addons, c.dependencies)
+			if c.expectErr {
+				if err == nil {
+					t.Errorf("expected error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(actual, c.expected) {
+				t.Errorf("expected %v, but got %v", c.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_resolveAddonDependencies(t *testing.T) {
+	cases := []struct {
+		name              string
+		policy            v1.AddonDependencyPolicy
+		policyEnabled     bool
+		certPolicyEnabled bool
+		expectedEnabled   map[string]bool
+		expectedRejected  []string
+		expectedConflicts []string
+	}{
+		{
+			name:              "no conflicts",
+			policyEnabled:     true,
+			certPolicyEnabled: true,
+			expectedEnabled: map[string]bool{
+				v1.PolicyFrameworkAddonName: true,
+				v1.ConfigPolicyAddonName:    true,
+				v1.CertPolicyAddonName:      true,
+			},
+		},
+		{
+			name:              "dependent of the disabled prerequisite is rejected",
+			certPolicyEnabled: true,
+			expectedEnabled: map[string]bool{
+				v1.PolicyFrameworkAddonName: false,
+				v1.ConfigPolicyAddonName:    false,
+				v1.CertPolicyAddonName:      true,
+			},
+			expectedRejected:  []string{v1.CertPolicyAddonName},
+			expectedConflicts: []string{v1.CertPolicyAddonName},
+		},
+		{
+			name:              "disabled prerequisite is enabled",
+			policy:            v1.AddonDependencyPolicyEnablePrerequisites,
+			certPolicyEnabled: true,
+			expectedEnabled: map[string]bool{
+				v1.PolicyFrameworkAddonName: true,
+				v1.ConfigPolicyAddonName:    false,
+				v1.CertPolicyAddonName:      true,
+			},
+			expectedConflicts: []string{v1.PolicyFrameworkAddonName},
+		},
+		{
+			name:              "disabled prerequisite cascades",
+			policy:            v1.AddonDependencyPolicyCascade,
+			certPolicyEnabled: true,
+			expectedEnabled: map[string]bool{
+				v1.PolicyFrameworkAddonName: false,
+				v1.ConfigPolicyAddonName:    false,
+				v1.CertPolicyAddonName:      false,
+			},
+			expectedConflicts: []string{v1.CertPolicyAddonName},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := newKlusterletAddonConfig("cluster1")
+			config.Spec.DependencyPolicy = c.policy
+			config.Spec.PolicyController.Enabled = c.policyEnabled
+			config.Spec.CertPolicyControllerConfig.Enabled = c.certPolicyEnabled
+
+			enabled, rejected, conflicts := resolveAddonDependencies(config)
+			for addonName, expected := range c.expectedEnabled {
+				if enabled[addonName] != expected {
+					t.Errorf("expected addon %s enabled %v, but got %v", addonName, expected, enabled[addonName])
+				}
+			}
+			if !rejected.Equal(sets.New(c.expectedRejected...)) {
+				t.Errorf("expected rejected addons %v, but got %v", c.expectedRejected, sets.List(rejected))
+			}
+			if len(conflicts) != len(c.expectedConflicts) {
+				t.Fatalf("expected conflicts of %v, but got %v", c.expectedConflicts, conflicts)
+			}
+			for _, addonName := range c.expectedConflicts {
+				if _, ok := conflicts[addonName]; !ok {
+					t.Errorf("expected conflict of addon %s, but got %v", addonName, conflicts)
+				}
+			}
+		})
+	}
+}
+
+func Test_ReconcileDependencies(t *testing.T) {
+	testscheme := scheme.Scheme
+	_ = mcv1.AddToScheme(testscheme)
+	_ = v1alpha1.AddToScheme(testscheme)
+	_ = apis.AddToScheme(testscheme)
+	_ = workv1.AddToScheme(testscheme)
+
+	availableFramework := newManagedClusterAddon(v1.PolicyFrameworkAddonName, "cluster1", addonHosting{})
+	availableFramework.Status.Conditions = []metav1.Condition{{
+		Type:   v1alpha1.ManagedClusterAddOnConditionAvailable,
+		Status: metav1.ConditionTrue,
+		Reason: "ManagedClusterAddOnLeaseUpdated",
+	}}
+
+	cases := []struct {
+		name                  string
+		klusterletAddonConfig *v1.KlusterletAddonConfig
+		addons                []runtime.Object
+		expectedCertPolicy    bool
+		expectedFramework     bool
+		expectedConditions    map[string]string
+	}{
+		{
+			name:                  "wait for the prerequisite to be available",
+			klusterletAddonConfig: newKlusterletAddonConfig("cluster1"),
+			addons: []runtime.Object{
+				newManagedClusterAddon(v1.PolicyFrameworkAddonName, "cluster1", addonHosting{}),
+			},
+			expectedFramework: true,
+			expectedConditions: map[string]string{
+				v1.AddonsWaitingForPrerequisites: v1.ReasonPrerequisitesNotAvailable,
+			},
+		},
+		{
+			name:                  "prerequisite is available",
+			klusterletAddonConfig: newKlusterletAddonConfig("cluster1"),
+			addons:                []runtime.Object{availableFramework},
+			expectedFramework:     true,
+			expectedCertPolicy:    true,
+		},
+		{
+			name: "dependent of the disabled prerequisite is kept as it is",
+			klusterletAddonConfig: func() *v1.KlusterletAddonConfig {
+				config := newKlusterletAddonConfig("cluster1")
+				config.Spec.PolicyController.Enabled = false
+				return config
+			}(),
+			addons: []runtime.Object{
+				availableFramework,
+				newManagedClusterAddon(v1.CertPolicyAddonName, "cluster1", addonHosting{}),
+			},
+			expectedCertPolicy: true,
+			expectedConditions: map[string]string{
+				v1.AddonDependencyConflict: v1.ReasonDependentsRejected,
+			},
+		},
+		{
+			name: "dependent of the disabled prerequisite is not created",
+			klusterletAddonConfig: func() *v1.KlusterletAddonConfig {
+				config := newKlusterletAddonConfig("cluster1")
+				config.Spec.PolicyController.Enabled = false
+				return config
+			}(),
+			expectedConditions: map[string]string{
+				v1.AddonDependencyConflict: v1.ReasonDependentsRejected,
+			},
+		},
+		{
+			name: "disabled prerequisite is kept",
+			klusterletAddonConfig: func() *v1.KlusterletAddonConfig {
+				config := newKlusterletAddonConfig("cluster1")
+				config.Spec.PolicyController.Enabled = false
+				config.Spec.DependencyPolicy = v1.AddonDependencyPolicyEnablePrerequisites
+				return config
+			}(),
+			addons: []runtime.Object{
+				availableFramework,
+				newManagedClusterAddon(v1.CertPolicyAddonName, "cluster1", addonHosting{}),
+			},
+			expectedFramework:  true,
+			expectedCertPolicy: true,
+			expectedConditions: map[string]string{
+				v1.AddonDependencyConflict: v1.ReasonPrerequisiteDisableRejected,
+			},
+		},
+		{
+			name: "dependents of the disabled prerequisite are disabled",
+			klusterletAddonConfig: func() *v1.KlusterletAddonConfig {
+				config := newKlusterletAddonConfig("cluster1")
+				config.Spec.PolicyController.Enabled = false
+				config.Spec.DependencyPolicy = v1.AddonDependencyPolicyCascade
+				return config
+			}(),
+			addons: []runtime.Object{
+				availableFramework,
+				newManagedClusterAddon(v1.CertPolicyAddonName, "cluster1", addonHosting{}),
+			},
+			expectedConditions: map[string]string{
+				v1.AddonDependencyConflict: v1.ReasonDependentsDisabled,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			objs := append([]runtime.Object{newManagedCluster("cluster1", nil, nil), c.klusterletAddonConfig},
+				c.addons...)
+			reconciler := &ReconcileKlusterletAddOn{
+				client: newFakeClient(testscheme, objs...),
+			}
+
+			_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: "cluster1", Name: "cluster1"},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for addonName, expected := range map[string]bool{
+				v1.CertPolicyAddonName:      c.expectedCertPolicy,
+				v1.PolicyFrameworkAddonName: c.expectedFramework,
+			} {
+				err := reconciler.client.Get(context.TODO(),
+					types.NamespacedName{Namespace: "cluster1", Name: addonName}, &v1alpha1.ManagedClusterAddOn{})
+				if err != nil && !errors.IsNotFound(err) {
+					t.Fatalf("failed to get addon %s: %v", addonName, err)
+				}
+				if exists := err == nil; exists != expected {
+					t.Errorf("expected addon %s exists %v, but got %v", addonName, expected, exists)
+				}
+			}
+
+			config := &v1.KlusterletAddonConfig{}
+			if err := reconciler.client.Get(context.TODO(),
+				types.NamespacedName{Namespace: "cluster1", Name: "cluster1"}, config); err != nil {
+				t.Fatalf("failed to get klusterletaddonconfig: %v", err)
+			}
+			for _, conditionType := range []string{v1.AddonsWaitingForPrerequisites, v1.AddonDependencyConflict} {
+				condition := meta.FindStatusCondition(config.Status.Conditions, conditionType)
+				expectedReason, ok := c.expectedConditions[conditionType]
+				switch {
+				case !ok && condition != nil:
+					t.Errorf("expected no condition %s, but got %v", conditionType, condition)
+				case ok && (condition == nil || condition.Reason != expectedReason):
+					t.Errorf("expected condition %s with reason %s, but got %v", conditionType, expectedReason,
+						condition)
+				}
+			}
+		})
+	}
+}
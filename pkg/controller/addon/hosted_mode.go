@@ -0,0 +1,182 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addon
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+)
+
+const (
+	// modeMigrationInPlace updates the hosting cluster and the install namespace of the existing addon
+	modeMigrationInPlace = "InPlace"
+	// modeMigrationRecreate deletes the existing addon and creates it in the new mode after it is deleted
+	modeMigrationRecreate = "Recreate"
+)
+
+// defaultHostedAddOns is the addons deployed in hosted mode if the hosted addons are not configured
+var defaultHostedAddOns = sets.New[string](common.DefaultHostedAddOns...)
+
+// addonHosting is the hosting cluster and the install namespace of an addon in hosted mode, the addon is deployed
+// in default mode if the hosting cluster name is empty.
+type addonHosting struct {
+	hostingClusterName string
+	installNamespace   string
+}
+
+// hostedModeConfig is the hub-wide configuration of the addons in hosted mode, it is overridden by the annotations
+// of the ClusterManagementAddOns.
+type hostedModeConfig struct {
+	// addons is the addons which can be deployed in hosted mode, the default hosted addons are used if it is nil
+	addons sets.Set[string]
+	// installNamespaceTemplate is the template of the install namespace of the hosted addons, the default template
+	// is used if it is empty
+	installNamespaceTemplate string
+}
+
+func newHostedModeConfig(opts *common.Options) hostedModeConfig {
+	return hostedModeConfig{
+		addons:                   sets.New[string](opts.HostedAddOnNames()...),
+		installNamespaceTemplate: opts.HostedAddOnInstallNamespace,
+	}
+}
+
+// isHostedAddon returns true if the addon can be deployed in hosted mode, the annotation of the
+// ClusterManagementAddOn overrides the hub-wide configuration.
+func (c hostedModeConfig) isHostedAddon(addonName string, cma *addonv1alpha1.ClusterManagementAddOn) bool {
+	if cma != nil {
+		if value, ok := cma.Annotations[common.AnnotationHostedModeAddOn]; ok {
+			return strings.EqualFold(value, "true")
+		}
+	}
+	if c.addons == nil {
+		return defaultHostedAddOns.Has(addonName)
+	}
+	return c.addons.Has(addonName)
+}
+
+// getAddonHosting returns the hosting of the addon on the given managed cluster, an error is returned if the
+// install namespace rendered from the template is invalid.
+func (c hostedModeConfig) getAddonHosting(addonName string, cma *addonv1alpha1.ClusterManagementAddOn,
+	cluster *mcv1.ManagedCluster) (addonHosting, error) {
+	hostingClusterName := getAddOnHostingClusterName(cluster)
+	if len(hostingClusterName) == 0 || !c.isHostedAddon(addonName, cma) {
+		return addonHosting{}, nil
+	}
+
+	template := c.installNamespaceTemplate
+	if cma != nil && len(cma.Annotations[common.AnnotationHostedAddOnInstallNamespace]) != 0 {
+		template = cma.Annotations[common.AnnotationHostedAddOnInstallNamespace]
+	}
+	installNamespace := common.RenderHostedAddOnInstallNamespace(template, cluster.GetName(), hostingClusterName)
+	if errs := validation.IsDNS1123Label(installNamespace); len(errs) != 0 {
+		return addonHosting{}, fmt.Errorf("invalid hosted install namespace %q of addon %s: %s",
+			installNamespace, addonName, strings.Join(errs, ", "))
+	}
+	return addonHosting{hostingClusterName: hostingClusterName, installNamespace: installNamespace}, nil
+}
+
+// String returns the description of the mode of the addon
+func (h addonHosting) String() string {
+	if len(h.hostingClusterName) == 0 {
+		return "default mode"
+	}
+	return fmt.Sprintf("hosted mode on %s", h.hostingClusterName)
+}
+
+// getExistingAddonHosting returns the hosting of the existing addon
+func getExistingAddonHosting(addon *addonv1alpha1.ManagedClusterAddOn) addonHosting {
+	hostingClusterName := addon.Annotations[common.AnnotationAddOnHostingClusterName]
+	if len(hostingClusterName) == 0 {
+		return addonHosting{}
+	}
+	return addonHosting{hostingClusterName: hostingClusterName, installNamespace: addon.Spec.InstallNamespace}
+}
+
+// getModeMigration returns how the addon is moved between the modes, it is set by the annotation of the
+// ClusterManagementAddOn and Recreate is the default.
+func getModeMigration(cma *addonv1alpha1.ClusterManagementAddOn) string {
+	if cma != nil && strings.EqualFold(cma.Annotations[common.AnnotationModeMigration], modeMigrationInPlace) {
+		return modeMigrationInPlace
+	}
+	return modeMigrationRecreate
+}
+
+// modeMigrationResult is the result of moving an addon to the desired mode
+type modeMigrationResult struct {
+	// progress is the progress of the addon being migrated
+	progress string
+	// pendingChange is the migration held until the maintenance window opens
+	pendingChange string
+	// existing is the mode of the existing addon
+	existing addonHosting
+}
+
+// migrateAddonMode moves the existing addon to the desired mode if it is deployed in another mode. The addon is
+// deleted and it is created in the desired mode after it is deleted, so its agents are never deployed in both
+// modes, unless the ClusterManagementAddOn declares the addon can be updated in place. The addon is kept in its
+// mode until the maintenance window opens. The result is empty if the addon can be applied.
+func (r *ReconcileKlusterletAddOn) migrateAddonMode(ctx context.Context, addonName, clusterName string,
+	hosting addonHosting, cma *addonv1alpha1.ClusterManagementAddOn, windowOpen bool) (modeMigrationResult, error) {
+	result := modeMigrationResult{}
+	addon := &addonv1alpha1.ManagedClusterAddOn{}
+	err := r.client.Get(ctx, types.NamespacedName{Namespace: clusterName, Name: addonName}, addon)
+	if errors.IsNotFound(err) {
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+
+	existing := getExistingAddonHosting(addon)
+	if existing.hostingClusterName == hosting.hostingClusterName {
+		return result, nil
+	}
+	result.existing = existing
+
+	if !addon.DeletionTimestamp.IsZero() {
+		result.progress = fmt.Sprintf("waiting for the addon in %s to be deleted before it is created in %s",
+			existing, hosting)
+		return result, nil
+	}
+
+	if !windowOpen {
+		result.pendingChange = pendingChangeMigrateMode
+		return result, nil
+	}
+
+	if getModeMigration(cma) == modeMigrationInPlace {
+		klog.Infof("move addon %s/%s from %s to %s in place", clusterName, addonName, existing, hosting)
+		return modeMigrationResult{}, nil
+	}
+
+	klog.Infof("delete addon %s/%s in %s to recreate it in %s", clusterName, addonName, existing, hosting)
+	if err := r.deleteManagedClusterAddon(ctx, addonName, clusterName); err != nil {
+		return result, err
+	}
+	result.progress = fmt.Sprintf("deleting the addon in %s to recreate it in %s", existing, hosting)
+	return result, nil
+}
+
+// newModeMigrationCondition returns the condition which reports the progress of the addons being moved between
+// the modes, nil is returned if no addons are being moved and the condition is not set before.
+func newModeMigrationCondition(config *agentv1.KlusterletAddonConfig,
+	migratingAddons map[string]string) *metav1.Condition {
+	return newAddonsCondition(config, agentv1.AddonModeMigrating, migratingAddons,
+		agentv1.ReasonModeMigrationInProgress, "The addons are being moved to the new mode",
+		agentv1.ReasonModeMigrationCompleted, "The addons are deployed in the desired mode.")
+}
@@ -0,0 +1,316 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addon
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"open-cluster-management.io/api/addon/v1alpha1"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	workv1 "open-cluster-management.io/api/work/v1"
+
+	"github.com/stolostron/klusterlet-addon-controller/pkg/apis"
+	v1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+)
+
+func newHostedManagedCluster(name, hostingClusterName string) *mcv1.ManagedCluster {
+	return newManagedCluster(name, nil, map[string]string{
+		common.AnnotationKlusterletDeployMode:         "Hosted",
+		common.AnnotationKlusterletHostingClusterName: hostingClusterName,
+		common.AnnotationEnableHostedModeAddons:       "true",
+	})
+}
+
+func Test_getAddonHosting(t *testing.T) {
+	cases := []struct {
+		name      string
+		config    hostedModeConfig
+		addonName string
+		cma       *v1alpha1.ClusterManagementAddOn
+		cluster   *mcv1.ManagedCluster
+		expected  addonHosting
+		expectErr bool
+	}{
+		{
+			name:      "default mode cluster",
+			addonName: v1.PolicyFrameworkAddonName,
+			cluster:   newManagedCluster("cluster1", nil, nil),
+		},
+		{
+			name:      "default hosted addon",
+			addonName: v1.PolicyFrameworkAddonName,
+			cluster:   newHostedManagedCluster("cluster1", "hosting"),
+			expected:  addonHosting{hostingClusterName: "hosting", installNamespace: "klusterlet-cluster1"},
+		},
+		{
+			name:      "addon is not hosted by default",
+			addonName: v1.SearchAddonName,
+			cluster:   newHostedManagedCluster("cluster1", "hosting"),
+		},
+		{
+			name: "hub-wide hosted addons and namespace template",
+			config: hostedModeConfig{
+				addons:                   sets.New[string](v1.SearchAddonName),
+				installNamespaceTemplate: "{{hostingClusterName}}-{{clusterName}}",
+			},
+			addonName: v1.SearchAddonName,
+			cluster:   newHostedManagedCluster("cluster1", "hosting"),
+			expected:  addonHosting{hostingClusterName: "hosting", installNamespace: "hosting-cluster1"},
+		},
+		{
+			name:      "hosted mode and namespace template of the clusterManagementAddOn",
+			config:    hostedModeConfig{addons: sets.New[string](), installNamespaceTemplate: "ignored"},
+			addonName: v1.ApplicationAddonName,
+			cma: &v1alpha1.ClusterManagementAddOn{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: v1.ApplicationAddonName,
+					Annotations: map[string]string{
+						common.AnnotationHostedModeAddOn:             "true",
+						common.AnnotationHostedAddOnInstallNamespace: "app-{{clusterName}}",
+					},
+				},
+			},
+			cluster:  newHostedManagedCluster("cluster1", "hosting"),
+			expected: addonHosting{hostingClusterName: "hosting", installNamespace: "app-cluster1"},
+		},
+		{
+			name:      "hosted mode disabled by the clusterManagementAddOn",
+			addonName: v1.PolicyFrameworkAddonName,
+			cma: &v1alpha1.ClusterManagementAddOn{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        v1.PolicyFrameworkAddonName,
+					Annotations: map[string]string{common.AnnotationHostedModeAddOn: "false"},
+				},
+			},
+			cluster: newHostedManagedCluster("cluster1", "hosting"),
+		},
+		{
+			name:      "invalid install namespace",
+			config:    hostedModeConfig{installNamespaceTemplate: "Klusterlet_{{clusterName}}"},
+			addonName: v1.PolicyFrameworkAddonName,
+			cluster:   newHostedManagedCluster("cluster1", "hosting"),
+			expectErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, err := c.config.getAddonHosting(c.addonName, c.cma, c.cluster)
+			if c.expectErr {
+				if err == nil {
+					t.Errorf("expected error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual != c.expected {
+				t.Errorf("expected %+v, but got %+v", c.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_ReconcileHostedMode(t *testing.T) {
+	testscheme := scheme.Scheme
+	_ = mcv1.AddToScheme(testscheme)
+	_ = v1alpha1.AddToScheme(testscheme)
+	_ = apis.AddToScheme(testscheme)
+	_ = workv1.AddToScheme(testscheme)
+
+	hostedSearch := newManagedClusterAddon(v1.SearchAddonName, "cluster1",
+		addonHosting{hostingClusterName: "hosting", installNamespace: "hosting-cluster1"})
+	// the addon updated by the previous versions of the controller, its fields are owned by the legacy field
+	// manager, so the omitted hosting cluster annotation is removed by the apply only after the fields are migrated
+	legacyHostedSearch := changedBy(nil, hostedSearch, legacyFieldManager, false)
+
+	cases := []struct {
+		name              string
+		cluster           *mcv1.ManagedCluster
+		addons            []runtime.Object
+		modeMigration     string
+		closedWindow      bool
+		expectedRecreate  bool
+		expectedPending   bool
+		expectedHosting   string
+		expectedNamespace string
+	}{
+		{
+			name:              "create the addon on the hosting cluster",
+			cluster:           newHostedManagedCluster("cluster1", "hosting"),
+			expectedHosting:   "hosting",
+			expectedNamespace: "hosting-cluster1",
+		},
+		{
+			name:              "recreate the addon on the hosting cluster",
+			cluster:           newHostedManagedCluster("cluster1", "hosting"),
+			addons:            []runtime.Object{newManagedClusterAddon(v1.SearchAddonName, "cluster1", addonHosting{})},
+			expectedRecreate:  true,
+			expectedHosting:   "hosting",
+			expectedNamespace: "hosting-cluster1",
+		},
+		{
+			name:              "recreate the addon on another hosting cluster",
+			cluster:           newHostedManagedCluster("cluster1", "hosting2"),
+			addons:            []runtime.Object{hostedSearch},
+			expectedRecreate:  true,
+			expectedHosting:   "hosting2",
+			expectedNamespace: "hosting2-cluster1",
+		},
+		{
+			name:              "recreate the addon in default mode",
+			cluster:           newManagedCluster("cluster1", nil, nil),
+			addons:            []runtime.Object{hostedSearch},
+			expectedRecreate:  true,
+			expectedNamespace: v1.KlusterletAddonNamespace,
+		},
+		{
+			name:              "move the addon to default mode in place",
+			cluster:           newManagedCluster("cluster1", nil, nil),
+			addons:            []runtime.Object{hostedSearch},
+			modeMigration:     modeMigrationInPlace,
+			expectedNamespace: v1.KlusterletAddonNamespace,
+		},
+		{
+			name:              "hold the recreate outside the maintenance window",
+			cluster:           newHostedManagedCluster("cluster1", "hosting"),
+			addons:            []runtime.Object{newManagedClusterAddon(v1.SearchAddonName, "cluster1", addonHosting{})},
+			closedWindow:      true,
+			expectedPending:   true,
+			expectedNamespace: v1.KlusterletAddonNamespace,
+		},
+		{
+			name:              "hold the move in place outside the maintenance window",
+			cluster:           newManagedCluster("cluster1", nil, nil),
+			addons:            []runtime.Object{hostedSearch},
+			modeMigration:     modeMigrationInPlace,
+			closedWindow:      true,
+			expectedPending:   true,
+			expectedHosting:   "hosting",
+			expectedNamespace: "hosting-cluster1",
+		},
+		{
+			name:              "move the addon of the legacy field manager to default mode in place",
+			cluster:           newManagedCluster("cluster1", nil, nil),
+			addons:            []runtime.Object{legacyHostedSearch},
+			modeMigration:     modeMigrationInPlace,
+			expectedNamespace: v1.KlusterletAddonNamespace,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := newKlusterletAddonConfig("cluster1")
+			if c.closedWindow {
+				// the closed window starts in 12 hours every day
+				config.Spec.MaintenanceWindow = &v1.MaintenanceWindow{
+					Schedule: fmt.Sprintf("0 %d * * *", (time.Now().UTC().Hour()+12)%24),
+					Duration: metav1.Duration{Duration: time.Hour},
+				}
+			}
+			objs := append([]runtime.Object{c.cluster, config}, c.addons...)
+			if len(c.modeMigration) != 0 {
+				cma := newClusterManagementAddOn(v1.SearchAddonName, v1alpha1.AddonInstallStrategyManual)
+				cma.Annotations = map[string]string{common.AnnotationModeMigration: c.modeMigration}
+				objs = append(objs, cma)
+			}
+			kubeClient := newFakeClient(testscheme, objs...)
+			reconciler := &ReconcileKlusterletAddOn{
+				client:    kubeClient,
+				apiReader: kubeClient,
+				hostedMode: hostedModeConfig{
+					addons:                   sets.New[string](v1.SearchAddonName),
+					installNamespaceTemplate: "{{hostingClusterName}}-{{clusterName}}",
+				},
+			}
+			request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "cluster1", Name: "cluster1"}}
+
+			if _, err := reconciler.Reconcile(context.TODO(), request); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if c.expectedRecreate {
+				// the addon in the previous mode is deleted first, and it is created in the new mode by the next
+				// reconcile after it is deleted
+				err := reconciler.client.Get(context.TODO(),
+					types.NamespacedName{Namespace: "cluster1", Name: v1.SearchAddonName}, &v1alpha1.ManagedClusterAddOn{})
+				if !errors.IsNotFound(err) {
+					t.Fatalf("expected the addon is deleted, but got %v", err)
+				}
+				assertCondition(t, reconciler.client, v1.AddonModeMigrating, v1.ReasonModeMigrationInProgress)
+
+				if _, err := reconciler.Reconcile(context.TODO(), request); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				assertCondition(t, reconciler.client, v1.AddonModeMigrating, v1.ReasonModeMigrationCompleted)
+			}
+			if c.expectedPending {
+				assertCondition(t, reconciler.client, v1.AddonChangesPending, v1.ReasonOutsideMaintenanceWindow)
+			}
+
+			addon := &v1alpha1.ManagedClusterAddOn{}
+			if err := reconciler.client.Get(context.TODO(),
+				types.NamespacedName{Namespace: "cluster1", Name: v1.SearchAddonName}, addon); err != nil {
+				t.Fatalf("failed to get addon: %v", err)
+			}
+			if hosting := addon.Annotations[common.AnnotationAddOnHostingClusterName]; hosting != c.expectedHosting {
+				t.Errorf("expected hosting cluster %q, but got %q", c.expectedHosting, hosting)
+			}
+			if addon.Spec.InstallNamespace != c.expectedNamespace {
+				t.Errorf("expected install namespace %q, but got %q", c.expectedNamespace, addon.Spec.InstallNamespace)
+			}
+			if legacyManagedClusterAddon(addon) {
+				t.Errorf("expected the fields are taken over from the legacy field manager, but got %v",
+					addon.ManagedFields)
+			}
+		})
+	}
+}
+
+func assertCondition(t *testing.T, kubeClient client.Client, conditionType, expectedReason string) {
+	config := &v1.KlusterletAddonConfig{}
+	if err := kubeClient.Get(context.TODO(),
+		types.NamespacedName{Namespace: "cluster1", Name: "cluster1"}, config); err != nil {
+		t.Fatalf("failed to get klusterletaddonconfig: %v", err)
+	}
+	condition := meta.FindStatusCondition(config.Status.Conditions, conditionType)
+	if condition == nil || condition.Reason != expectedReason {
+		t.Errorf("expected condition %s with reason %s, but got %v", conditionType, expectedReason, condition)
+	}
+}
+
+func Test_migrateAddonModeWaitsForDeletion(t *testing.T) {
+	testscheme := scheme.Scheme
+	_ = v1alpha1.AddToScheme(testscheme)
+	_ = apis.AddToScheme(testscheme)
+
+	addon := newManagedClusterAddon(v1.SearchAddonName, "cluster1", addonHosting{})
+	now := metav1.Now()
+	addon.DeletionTimestamp = &now
+	addon.Finalizers = []string{"addon.open-cluster-management.io/addon-pre-delete"}
+	reconciler := &ReconcileKlusterletAddOn{client: newFakeClient(testscheme, addon)}
+
+	migration, err := reconciler.migrateAddonMode(context.TODO(), v1.SearchAddonName, "cluster1",
+		addonHosting{hostingClusterName: "hosting", installNamespace: "klusterlet-cluster1"}, nil, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "waiting for the addon in default mode to be deleted before it is created in hosted mode on hosting"
+	if migration.progress != expected {
+		t.Errorf("expected progress %q, but got %q", expected, migration.progress)
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addon
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	workv1 "open-cluster-management.io/api/work/v1"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+)
+
+const (
+	// imagePullSecretWorkSuffix is the name suffix of the ManifestWork which copies the image pull secrets
+	imagePullSecretWorkSuffix = "klusterlet-addon-image-pull-secret"
+
+	// annotationImagePullSecretHash is the annotation key of the hash of the secrets in the ManifestWork
+	annotationImagePullSecretHash = "agent.open-cluster-management.io/image-pull-secret-hash"
+)
+
+// imagePullSecretLocation is where the image pull secrets are copied to, the ManifestWork is created in the
+// WorkNamespace and the secrets are created in the InstallNamespace of the cluster.
+type imagePullSecretLocation struct {
+	WorkNamespace    string
+	InstallNamespace string
+}
+
+// workName returns the namespace and name of the ManifestWork which copies the image pull secrets of the cluster to
+// the location, the install namespace is in the name so the ManifestWorks of the install namespaces in the same work
+// namespace are not overwritten by each other
+func (l imagePullSecretLocation) workName(clusterName string) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: l.WorkNamespace,
+		Name:      fmt.Sprintf("%s-%s-%s", clusterName, imagePullSecretWorkSuffix, l.InstallNamespace),
+	}
+}
+
+// getImagePullSecret returns the hub image pull secret of the addon, the secret of the addon overrides the secret of
+// the klusterletAddonConfig, which overrides the default secret. The secrets of the klusterletAddonConfig are in
+// its namespace.
+func getImagePullSecret(addonName string, config *agentv1.KlusterletAddonConfig,
+	defaultSecret types.NamespacedName) types.NamespacedName {
+	if agentConfig := agentv1.GetAddonAgentConfig(addonName, config); agentConfig != nil &&
+		len(agentConfig.ImagePullSecret) != 0 {
+		return types.NamespacedName{Namespace: config.Namespace, Name: agentConfig.ImagePullSecret}
+	}
+	if len(config.Spec.ImagePullSecret) != 0 {
+		return types.NamespacedName{Namespace: config.Namespace, Name: config.Spec.ImagePullSecret}
+	}
+	return defaultSecret
+}
+
+// referencesImagePullSecret returns true if the klusterletAddonConfig or any of its addons uses the secret in its
+// namespace as the image pull secret
+func referencesImagePullSecret(config *agentv1.KlusterletAddonConfig, secretName string) bool {
+	if config.Spec.ImagePullSecret == secretName {
+		return true
+	}
+	for addonName := range agentv1.KlusterletAddons {
+		if agentConfig := agentv1.GetAddonAgentConfig(addonName, config); agentConfig != nil &&
+			agentConfig.ImagePullSecret == secretName {
+			return true
+		}
+	}
+	return false
+}
+
+// getImagePullPolicy returns the image pull policy of the addon, the policy of the addon overrides the policy of
+// the klusterletAddonConfig, which overrides the default policy.
+func getImagePullPolicy(addonName string, config *agentv1.KlusterletAddonConfig,
+	defaultPolicy corev1.PullPolicy) corev1.PullPolicy {
+	if agentConfig := agentv1.GetAddonAgentConfig(addonName, config); agentConfig != nil &&
+		len(agentConfig.ImagePullPolicy) != 0 {
+		return agentConfig.ImagePullPolicy
+	}
+	if len(config.Spec.ImagePullPolicy) != 0 {
+		return config.Spec.ImagePullPolicy
+	}
+	return defaultPolicy
+}
+
+// syncImagePullSecretWorks creates or updates the ManifestWorks which copy the image pull secrets to the install
+// namespaces of the addons, and deletes the ManifestWorks of the cluster which are not required anymore. All the
+// ManifestWorks of the cluster are deleted if there are no pull secrets, they are not owned by the cluster or the
+// klusterletAddonConfig, and the ManifestWorks in the namespaces of the hosting clusters are not deleted with the
+// cluster namespace.
+func (r *ReconcileKlusterletAddOn) syncImagePullSecretWorks(ctx context.Context, clusterName string,
+	pullSecrets map[imagePullSecretLocation]sets.Set[types.NamespacedName]) error {
+	works := &workv1.ManifestWorkList{}
+	if err := r.client.List(ctx, works, client.MatchingLabels{common.LabelImagePullSecretCluster: clusterName}); err != nil {
+		return err
+	}
+
+	existingWorks := map[types.NamespacedName]*workv1.ManifestWork{}
+	for i := range works.Items {
+		existingWorks[types.NamespacedName{Namespace: works.Items[i].Namespace, Name: works.Items[i].Name}] =
+			&works.Items[i]
+	}
+
+	for location, secrets := range pullSecrets {
+		work, err := r.newImagePullSecretWork(ctx, clusterName, location, secrets)
+		if err != nil {
+			return err
+		}
+
+		workName := location.workName(clusterName)
+		existing, ok := existingWorks[workName]
+		delete(existingWorks, workName)
+		switch {
+		case !ok:
+			klog.Infof("create image pull secret work %s/%s", work.Namespace, work.Name)
+			if err := r.client.Create(ctx, work); err != nil {
+				return err
+			}
+		case existing.Annotations[annotationImagePullSecretHash] != work.Annotations[annotationImagePullSecretHash]:
+			klog.Infof("update image pull secret work %s/%s", work.Namespace, work.Name)
+			existing = existing.DeepCopy()
+			existing.Annotations = work.Annotations
+			existing.Spec = work.Spec
+			if err := r.client.Update(ctx, existing); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, work := range existingWorks {
+		klog.Infof("delete image pull secret work %s/%s", work.Namespace, work.Name)
+		if err := r.client.Delete(ctx, work); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteImagePullSecretWorks deletes all the ManifestWorks which copy the image pull secrets of the cluster, it is
+// called once the cluster or its klusterletAddonConfig is deleted
+func (r *ReconcileKlusterletAddOn) deleteImagePullSecretWorks(ctx context.Context, clusterName string) error {
+	return r.syncImagePullSecretWorks(ctx, clusterName, nil)
+}
+
+// newImagePullSecretWork returns the ManifestWork which copies the given hub secrets to the install namespace,
+// the secrets are copied with the same names.
+func (r *ReconcileKlusterletAddOn) newImagePullSecretWork(ctx context.Context, clusterName string,
+	location imagePullSecretLocation, secrets sets.Set[types.NamespacedName]) (*workv1.ManifestWork, error) {
+	manifests := []workv1.Manifest{}
+	names := map[string]types.NamespacedName{}
+	hash := sha256.New()
+	for _, secretName := range sortedNamespacedNames(secrets) {
+		if existing, ok := names[secretName.Name]; ok {
+			return nil, fmt.Errorf("the image pull secrets %s and %s have the same name", existing, secretName)
+		}
+		names[secretName.Name] = secretName
+
+		secret, err := r.getImagePullSecret(ctx, secretName)
+		if err != nil {
+			return nil, err
+		}
+
+		raw, err := json.Marshal(&corev1.Secret{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      secret.Name,
+				Namespace: location.InstallNamespace,
+			},
+			Type: secret.Type,
+			Data: secret.Data,
+		})
+		if err != nil {
+			return nil, err
+		}
+		_, _ = hash.Write(raw)
+		manifests = append(manifests, workv1.Manifest{RawExtension: runtime.RawExtension{Raw: raw}})
+	}
+
+	workName := location.workName(clusterName)
+	return &workv1.ManifestWork{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      workName.Name,
+			Namespace: workName.Namespace,
+			Labels: map[string]string{
+				common.LabelImagePullSecretCluster: clusterName,
+			},
+			Annotations: map[string]string{
+				annotationImagePullSecretHash: fmt.Sprintf("%x", hash.Sum(nil)),
+			},
+		},
+		Spec: workv1.ManifestWorkSpec{
+			Workload: workv1.ManifestsTemplate{Manifests: manifests},
+		},
+	}, nil
+}
+
+// getImagePullSecret returns the image pull secret from the cache, which only keeps the secrets of type
+// kubernetes.io/dockerconfigjson, the secret is read from the API server if it is not in the cache to report the
+// secret of another type.
+func (r *ReconcileKlusterletAddOn) getImagePullSecret(ctx context.Context,
+	secretName types.NamespacedName) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := r.client.Get(ctx, secretName, secret)
+	if err == nil {
+		return secret, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get the image pull secret %s: %v", secretName, err)
+	}
+
+	err = r.apiReader.Get(ctx, secretName, secret)
+	switch {
+	case errors.IsNotFound(err):
+		return nil, fmt.Errorf("the image pull secret %s is not found", secretName)
+	case err != nil:
+		return nil, fmt.Errorf("failed to get the image pull secret %s: %v", secretName, err)
+	case secret.Type != corev1.SecretTypeDockerConfigJson:
+		return nil, fmt.Errorf("the image pull secret %s is of type %s, only the secrets of type %s are supported",
+			secretName, secret.Type, corev1.SecretTypeDockerConfigJson)
+	}
+	// the secret is not synced to the cache yet
+	return secret, nil
+}
+
+func sortedNamespacedNames(names sets.Set[types.NamespacedName]) []types.NamespacedName {
+	sorted := names.UnsortedList()
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].String() < sorted[j].String()
+	})
+	return sorted
+}
@@ -0,0 +1,370 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addon
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"open-cluster-management.io/api/addon/v1alpha1"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	workv1 "open-cluster-management.io/api/work/v1"
+
+	"github.com/stolostron/klusterlet-addon-controller/pkg/apis"
+	v1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+)
+
+func newPullSecret(namespace, name string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{"registry.example.com":{"auth":"dXNlcjpwYXNz"}}}`),
+		},
+	}
+}
+
+func newImagePullSecretWork(clusterName string) *workv1.ManifestWork {
+	return &workv1.ManifestWork{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      clusterName + "-" + imagePullSecretWorkSuffix + "-" + v1.KlusterletAddonNamespace,
+			Namespace: clusterName,
+			Labels:    map[string]string{common.LabelImagePullSecretCluster: clusterName},
+		},
+	}
+}
+
+func Test_ReconcileImagePullSecret(t *testing.T) {
+	testscheme := scheme.Scheme
+	_ = mcv1.AddToScheme(testscheme)
+	_ = v1alpha1.AddToScheme(testscheme)
+	_ = apis.AddToScheme(testscheme)
+	_ = workv1.AddToScheme(testscheme)
+
+	cases := []struct {
+		name                   string
+		klusterletAddonConfig  *v1.KlusterletAddonConfig
+		works                  []runtime.Object
+		secrets                []runtime.Object
+		uncachedSecrets        []runtime.Object
+		defaultImagePullSecret types.NamespacedName
+		defaultImagePullPolicy corev1.PullPolicy
+		expectedErr            string
+		expectedSecrets        []string
+		expectedValues         map[string]global
+	}{
+		{
+			name: "pull secret and policy of the klusterletaddonconfig and addon",
+			klusterletAddonConfig: func() *v1.KlusterletAddonConfig {
+				config := newKlusterletAddonConfig("cluster1")
+				config.Spec.ImagePullSecret = "cluster-pull-secret"
+				config.Spec.ImagePullPolicy = corev1.PullIfNotPresent
+				config.Spec.SearchCollectorConfig.ImagePullSecret = "search-pull-secret"
+				config.Spec.SearchCollectorConfig.ImagePullPolicy = corev1.PullAlways
+				return config
+			}(),
+			secrets: []runtime.Object{
+				newPullSecret("cluster1", "cluster-pull-secret"),
+				newPullSecret("cluster1", "search-pull-secret"),
+			},
+			defaultImagePullSecret: types.NamespacedName{Namespace: "open-cluster-management", Name: "default"},
+			defaultImagePullPolicy: corev1.PullNever,
+			expectedSecrets:        []string{"cluster-pull-secret", "search-pull-secret"},
+			expectedValues: map[string]global{
+				v1.SearchAddonName: {
+					ImagePullSecret: "search-pull-secret",
+					ImagePullPolicy: corev1.PullAlways,
+				},
+				v1.ApplicationAddonName: {
+					ImagePullSecret: "cluster-pull-secret",
+					ImagePullPolicy: corev1.PullIfNotPresent,
+				},
+			},
+		},
+		{
+			name:                   "default pull secret and policy",
+			klusterletAddonConfig:  newKlusterletAddonConfig("cluster1"),
+			secrets:                []runtime.Object{newPullSecret("open-cluster-management", "default")},
+			defaultImagePullSecret: types.NamespacedName{Namespace: "open-cluster-management", Name: "default"},
+			defaultImagePullPolicy: corev1.PullIfNotPresent,
+			expectedSecrets:        []string{"default"},
+			expectedValues: map[string]global{
+				v1.SearchAddonName: {
+					ImagePullSecret: "default",
+					ImagePullPolicy: corev1.PullIfNotPresent,
+				},
+			},
+		},
+		{
+			name:                  "no pull secret, delete the work",
+			klusterletAddonConfig: newKlusterletAddonConfig("cluster1"),
+			works:                 []runtime.Object{newImagePullSecretWork("cluster1")},
+			expectedValues: map[string]global{
+				v1.SearchAddonName: {},
+			},
+		},
+		{
+			name: "pull secret is not found",
+			klusterletAddonConfig: func() *v1.KlusterletAddonConfig {
+				config := newKlusterletAddonConfig("cluster1")
+				config.Spec.ImagePullSecret = "cluster-pull-secret"
+				return config
+			}(),
+			expectedErr: "the image pull secret cluster1/cluster-pull-secret is not found",
+		},
+		{
+			name: "pull secret of the unsupported type",
+			klusterletAddonConfig: func() *v1.KlusterletAddonConfig {
+				config := newKlusterletAddonConfig("cluster1")
+				config.Spec.ImagePullSecret = "cluster-pull-secret"
+				return config
+			}(),
+			// the secrets of the other types are not in the cache
+			uncachedSecrets: []runtime.Object{func() runtime.Object {
+				secret := newPullSecret("cluster1", "cluster-pull-secret")
+				secret.Type = corev1.SecretTypeDockercfg
+				return secret
+			}()},
+			expectedErr: "the image pull secret cluster1/cluster-pull-secret is of type kubernetes.io/dockercfg, " +
+				"only the secrets of type kubernetes.io/dockerconfigjson are supported",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			objs := append([]runtime.Object{newManagedCluster("cluster1", nil, nil), c.klusterletAddonConfig},
+				c.works...)
+			objs = append(objs, c.secrets...)
+			reconciler := &ReconcileKlusterletAddOn{
+				client: newFakeClient(testscheme, objs...),
+				apiReader: fake.NewClientBuilder().WithScheme(testscheme).
+					WithRuntimeObjects(append(objs, c.uncachedSecrets...)...).Build(),
+				defaultImagePullSecret: c.defaultImagePullSecret,
+				defaultImagePullPolicy: c.defaultImagePullPolicy,
+			}
+
+			_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: "cluster1", Name: "cluster1"},
+			})
+			if len(c.expectedErr) != 0 {
+				if err == nil || !strings.Contains(err.Error(), c.expectedErr) {
+					t.Errorf("expected error %q, but got %v", c.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			works := &workv1.ManifestWorkList{}
+			if err := reconciler.client.List(context.TODO(), works, client.InNamespace("cluster1")); err != nil {
+				t.Fatalf("failed to list works: %v", err)
+			}
+			if len(c.expectedSecrets) == 0 {
+				if len(works.Items) != 0 {
+					t.Errorf("expected no works, but got %d", len(works.Items))
+				}
+			} else {
+				if len(works.Items) != 1 {
+					t.Fatalf("expected 1 work, but got %d", len(works.Items))
+				}
+				manifests := works.Items[0].Spec.Workload.Manifests
+				if len(manifests) != len(c.expectedSecrets) {
+					t.Fatalf("expected %d secrets, but got %d", len(c.expectedSecrets), len(manifests))
+				}
+				for i, manifest := range manifests {
+					secret := &corev1.Secret{}
+					if err := json.Unmarshal(manifest.Raw, secret); err != nil {
+						t.Fatalf("failed to unmarshal secret: %v", err)
+					}
+					if secret.Name != c.expectedSecrets[i] || secret.Namespace != v1.KlusterletAddonNamespace ||
+						secret.Type != corev1.SecretTypeDockerConfigJson || len(secret.Data) == 0 {
+						t.Errorf("unexpected secret %s/%s", secret.Namespace, secret.Name)
+					}
+				}
+			}
+
+			for addonName, expected := range c.expectedValues {
+				addon := &v1alpha1.ManagedClusterAddOn{}
+				if err := reconciler.client.Get(context.TODO(),
+					types.NamespacedName{Namespace: "cluster1", Name: addonName}, addon); err != nil {
+					t.Fatalf("failed to get addon %s: %v", addonName, err)
+				}
+				gv := globalValues{}
+				if values, ok := addon.Annotations[annotationValues]; ok {
+					if err := json.Unmarshal([]byte(values), &gv); err != nil {
+						t.Fatalf("failed to unmarshal values of addon %s: %v", addonName, err)
+					}
+				}
+				if gv.Global.ImagePullSecret != expected.ImagePullSecret ||
+					gv.Global.ImagePullPolicy != expected.ImagePullPolicy {
+					t.Errorf("expected pull secret %q and policy %q of addon %s, but got %q and %q",
+						expected.ImagePullSecret, expected.ImagePullPolicy, addonName,
+						gv.Global.ImagePullSecret, gv.Global.ImagePullPolicy)
+				}
+			}
+		})
+	}
+}
+
+func Test_referencesImagePullSecret(t *testing.T) {
+	cases := []struct {
+		name     string
+		config   func(config *v1.KlusterletAddonConfig)
+		expected bool
+	}{
+		{
+			name:     "no pull secret",
+			config:   func(config *v1.KlusterletAddonConfig) {},
+			expected: false,
+		},
+		{
+			name:     "pull secret of the klusterletaddonconfig",
+			config:   func(config *v1.KlusterletAddonConfig) { config.Spec.ImagePullSecret = "pull-secret" },
+			expected: true,
+		},
+		{
+			name: "pull secret of an addon",
+			config: func(config *v1.KlusterletAddonConfig) {
+				config.Spec.ImagePullSecret = "other"
+				config.Spec.SearchCollectorConfig.ImagePullSecret = "pull-secret"
+			},
+			expected: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := newKlusterletAddonConfig("cluster1")
+			c.config(config)
+			if actual := referencesImagePullSecret(config, "pull-secret"); actual != c.expected {
+				t.Errorf("expected %v, but got %v", c.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_ReconcileDeleteImagePullSecretWorks(t *testing.T) {
+	testscheme := scheme.Scheme
+	_ = mcv1.AddToScheme(testscheme)
+	_ = v1alpha1.AddToScheme(testscheme)
+	_ = apis.AddToScheme(testscheme)
+	_ = workv1.AddToScheme(testscheme)
+
+	deleting := func(obj client.Object) client.Object {
+		obj.SetDeletionTimestamp(&metav1.Time{Time: time.Now()})
+		obj.SetFinalizers([]string{"test"})
+		return obj
+	}
+
+	cases := []struct {
+		name                  string
+		cluster               client.Object
+		klusterletAddonConfig client.Object
+	}{
+		{
+			name: "cluster is not found",
+		},
+		{
+			name:                  "cluster is deleting",
+			cluster:               deleting(newManagedCluster("cluster1", nil, nil)),
+			klusterletAddonConfig: newKlusterletAddonConfig("cluster1"),
+		},
+		{
+			name:    "klusterletaddonconfig is not found",
+			cluster: newManagedCluster("cluster1", nil, nil),
+		},
+		{
+			name:                  "klusterletaddonconfig is deleting",
+			cluster:               newManagedCluster("cluster1", nil, nil),
+			klusterletAddonConfig: deleting(newKlusterletAddonConfig("cluster1")),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// the work of the hosted addons is in the namespace of the hosting cluster, it is not deleted with
+			// the cluster namespace
+			hostedWork := newImagePullSecretWork("cluster1")
+			hostedWork.Namespace = "hosting"
+			otherWork := newImagePullSecretWork("cluster2")
+			otherWork.Namespace = "hosting"
+			objs := []runtime.Object{newImagePullSecretWork("cluster1"), hostedWork, otherWork}
+			for _, obj := range []client.Object{c.cluster, c.klusterletAddonConfig} {
+				if obj != nil {
+					objs = append(objs, obj)
+				}
+			}
+			reconciler := &ReconcileKlusterletAddOn{client: newFakeClient(testscheme, objs...)}
+
+			if _, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: "cluster1", Name: "cluster1"},
+			}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			works := &workv1.ManifestWorkList{}
+			if err := reconciler.client.List(context.TODO(), works); err != nil {
+				t.Fatalf("failed to list works: %v", err)
+			}
+			if len(works.Items) != 1 || works.Items[0].Name != otherWork.Name {
+				t.Errorf("expected only the work of cluster2 is kept, but got %v", works.Items)
+			}
+		})
+	}
+}
+
+func Test_syncImagePullSecretWorks(t *testing.T) {
+	testscheme := scheme.Scheme
+	_ = workv1.AddToScheme(testscheme)
+
+	secret := types.NamespacedName{Namespace: "cluster1", Name: "cluster-pull-secret"}
+	// the addons of two clusters hosted on the same hosting cluster are installed in different namespaces, their
+	// ManifestWorks are in the same namespace
+	pullSecrets := map[imagePullSecretLocation]sets.Set[types.NamespacedName]{
+		{WorkNamespace: "hosting", InstallNamespace: "klusterlet-cluster1"}: sets.New(secret),
+		{WorkNamespace: "hosting", InstallNamespace: "klusterlet-cluster2"}: sets.New(secret),
+	}
+	reconciler := &ReconcileKlusterletAddOn{
+		client: newFakeClient(testscheme, newPullSecret(secret.Namespace, secret.Name)),
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := reconciler.syncImagePullSecretWorks(context.TODO(), "cluster1", pullSecrets); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	works := &workv1.ManifestWorkList{}
+	if err := reconciler.client.List(context.TODO(), works, client.InNamespace("hosting")); err != nil {
+		t.Fatalf("failed to list works: %v", err)
+	}
+	namespaces := sets.New[string]()
+	for _, work := range works.Items {
+		secret := &corev1.Secret{}
+		if err := json.Unmarshal(work.Spec.Workload.Manifests[0].Raw, secret); err != nil {
+			t.Fatalf("failed to unmarshal secret: %v", err)
+		}
+		namespaces.Insert(secret.Namespace)
+	}
+	if !namespaces.Equal(sets.New("klusterlet-cluster1", "klusterlet-cluster2")) {
+		t.Errorf("expected the secrets are copied to both install namespaces, but got %v", sets.List(namespaces))
+	}
+}
@@ -3,19 +3,30 @@ package addon
 import (
 	"context"
 	"encoding/json"
+	goerrors "errors"
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/csaupgrade"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
+	clusterinfov1beta1 "github.com/stolostron/cluster-lifecycle-api/clusterinfo/v1beta1"
 	"github.com/stolostron/cluster-lifecycle-api/helpers/localcluster"
 	imageregistryv1alpha1 "github.com/stolostron/cluster-lifecycle-api/imageregistry/v1alpha1"
 
@@ -34,10 +45,27 @@ const (
 
 	// annotationValues is the key name of values annotation on managedClusterAddon
 	annotationValues = "addon.open-cluster-management.io/values"
+
+	// fieldManager is the field manager of the server-side apply of managedClusterAddons
+	fieldManager = "klusterlet-addon-controller"
+
+	// legacyFieldManager is the field manager of the managedClusterAddons updated by the previous versions of
+	// this controller, which does not set the field manager explicitly. It is also the default field manager of
+	// the other controller-runtime binaries, so its fields are migrated once from the managedClusterAddons never
+	// applied by this controller, and they are never taken over by a forced apply.
+	legacyFieldManager = "manager"
+
+	// changeSource is the source of the changes recorded in the history of the klusterletAddonConfigs
+	changeSource = "addon-controller"
 )
 
-var hostedAddOns = sets.NewString(agentv1.PolicyFrameworkAddonName, agentv1.ConfigPolicyAddonName,
-	agentv1.CertPolicyAddonName)
+// managedClusterAddonAnnotations is the annotations of the managedClusterAddons owned by this controller
+var managedClusterAddonAnnotations = []string{
+	annotationValues,
+	common.AnnotationAddOnHostingClusterName,
+	common.AnnotationRolloutPending,
+	common.AnnotationRolloutUpdatedAt,
+}
 
 // globalValues is the values can be overridden by klusterletAddon-controller
 type globalValues struct {
@@ -45,18 +73,76 @@ type globalValues struct {
 }
 
 type global struct {
-	ImageOverrides map[string]string `json:"imageOverrides,omitempty"`
-	NodeSelector   map[string]string `json:"nodeSelector,omitempty"`
-	ProxyConfig    map[string]string `json:"proxyConfig,omitempty"`
+	ImagePullPolicy corev1.PullPolicy   `json:"imagePullPolicy,omitempty"`
+	ImagePullSecret string              `json:"imagePullSecret,omitempty"`
+	ImageOverrides  map[string]string   `json:"imageOverrides,omitempty"`
+	NodeSelector    map[string]string   `json:"nodeSelector,omitempty"`
+	Tolerations     []corev1.Toleration `json:"tolerations,omitempty"`
+	ProxyConfig     map[string]string   `json:"proxyConfig,omitempty"`
+
+	Resources []agentv1.ContainerResourceRequirements `json:"resources,omitempty"`
 }
 
 // newReconciler returns a new reconcile.Reconciler
-func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &ReconcileKlusterletAddOn{client: mgr.GetClient()}
+func newReconciler(mgr manager.Manager, opts *common.Options) *ReconcileKlusterletAddOn {
+	r := newKlusterletAddonReconciler(mgr.GetClient(), opts)
+	r.apiReader = mgr.GetAPIReader()
+	r.rolloutTracker = newRolloutTracker(opts.Shard)
+	return r
+}
+
+func newKlusterletAddonReconciler(c client.Client, opts *common.Options) *ReconcileKlusterletAddOn {
+	return &ReconcileKlusterletAddOn{
+		client:                 c,
+		apiReader:              c,
+		shard:                  opts.Shard,
+		defaultImagePullSecret: opts.DefaultImagePullSecretName(),
+		defaultImagePullPolicy: corev1.PullPolicy(opts.DefaultImagePullPolicy),
+		compatibilityConfigMap: opts.CompatibilityConfigMapName(),
+
+		maintenanceWindowsConfigMap: opts.MaintenanceWindowsConfigMapName(),
+
+		gateOnClusterAvailability:     opts.GateOnClusterAvailability,
+		unavailableClusterGracePeriod: opts.UnavailableClusterGracePeriod,
+		hostedMode:                    newHostedModeConfig(opts),
+	}
 }
 
 type ReconcileKlusterletAddOn struct {
 	client client.Client
+	// apiReader reads the objects from the API server, like the managed fields of the managedClusterAddons which
+	// are stripped from the cache
+	apiReader client.Reader
+	// shard selects the managed clusters handled by this reconciler, nil means all clusters
+	shard *common.Shard
+
+	// defaultImagePullSecret and defaultImagePullPolicy are used by the addons if they are not set
+	// in the klusterletAddonConfig
+	defaultImagePullSecret types.NamespacedName
+	defaultImagePullPolicy corev1.PullPolicy
+
+	// compatibilityConfigMap overrides the compatibility matrix of the addons if it is set
+	compatibilityConfigMap types.NamespacedName
+
+	// maintenanceWindowsConfigMap defines the maintenance windows named by the labels of the managed clusters
+	maintenanceWindowsConfigMap types.NamespacedName
+
+	// gateOnClusterAvailability defers the addons until the cluster is accepted, joined and available
+	gateOnClusterAvailability bool
+	// unavailableClusterGracePeriod is the duration before the addons of an unavailable cluster are torn down,
+	// zero means the addons are never torn down
+	unavailableClusterGracePeriod time.Duration
+
+	// hostedMode configures the addons deployed in hosted mode
+	hostedMode hostedModeConfig
+
+	// rolloutTracker selects the clusters of the held values updates across the reconciles, the addons are listed
+	// to select the clusters if it is nil
+	rolloutTracker *rolloutTracker
+
+	// dryRun is true if the reconciler only plans the changes of the addons, its client records the changes
+	// instead of applying them
+	dryRun bool
 }
 
 func (r *ReconcileKlusterletAddOn) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
@@ -65,35 +151,137 @@ func (r *ReconcileKlusterletAddOn) Reconcile(ctx context.Context, request reconc
 	if err := r.client.Get(ctx, types.NamespacedName{Name: request.Namespace}, managedCluster); err != nil {
 		if errors.IsNotFound(err) {
 			klog.Warningf("the managed cluster %v is not found.", request.Namespace)
-			return reconcile.Result{}, nil
+			return reconcile.Result{}, r.deleteImagePullSecretWorks(ctx, request.Namespace)
 		}
 		return reconcile.Result{}, err
 	}
 
 	if !managedCluster.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, r.deleteImagePullSecretWorks(ctx, managedCluster.Name)
+	}
+
+	if !r.shard.Contains(managedCluster) {
 		return reconcile.Result{}, nil
 	}
+
 	// Fetch the klusterletAddonConfig instance
 	klusterletAddonConfig := &agentv1.KlusterletAddonConfig{}
 	if err := r.client.Get(ctx, request.NamespacedName, klusterletAddonConfig); err != nil {
 		if errors.IsNotFound(err) {
-			return reconcile.Result{}, nil
+			return reconcile.Result{}, r.deleteImagePullSecretWorks(ctx, managedCluster.Name)
 		}
 		return reconcile.Result{}, err
 	}
+	if !klusterletAddonConfig.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, r.deleteImagePullSecretWorks(ctx, managedCluster.Name)
+	}
+
+	if !r.dryRun {
+		if klusterletAddonConfig.Spec.DryRun {
+			return r.reconcileDryRun(ctx, klusterletAddonConfig)
+		}
+		// the plan is removed once the dry-run mode is turned off
+		if klusterletAddonConfig.Status.Plan != nil {
+			if err := r.updateStatus(ctx, klusterletAddonConfig, setPlan(nil)); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+	}
 
 	if isPaused(klusterletAddonConfig) {
-		return reconcile.Result{}, nil
+		pausedAddons := []string{}
+		for addonName := range agentv1.KlusterletAddons {
+			if agentv1.GetAddonAgentConfig(addonName, klusterletAddonConfig) != nil {
+				pausedAddons = append(pausedAddons, addonName)
+			}
+		}
+		return reconcile.Result{}, r.updateStatus(ctx, klusterletAddonConfig,
+			setConditions(newPausedCondition(klusterletAddonConfig, pausedAddons)))
+	}
+
+	availability := getClusterAvailability(managedCluster)
+	gracePeriodRemaining, gracePeriodExpired := r.gracePeriodRemaining(availability)
+	if gracePeriodExpired || (r.gateOnClusterAvailability && !availability.available) {
+		return r.reconcileUnavailableCluster(ctx, klusterletAddonConfig, managedCluster, availability)
+	}
+
+	clusterNodePlacement, err := getClusterNodePlacement(managedCluster)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// the architectures are only required to select the architecture images
+	var architectures []string
+	if hasArchitectureImages() {
+		if architectures, err = r.getClusterArchitectures(ctx, managedCluster); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	compatibility, err := r.getCompatibilityMatrix(ctx)
+	if err != nil {
+		return reconcile.Result{}, err
 	}
 
-	nodeSelector, err := getNodeSelector(managedCluster)
+	window, err := r.getMaintenanceWindow(ctx, klusterletAddonConfig, managedCluster)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
+	if window != nil && len(window.unavailable) != 0 {
+		klog.Warningf("hold the changes of the addons of cluster %s: %s", managedCluster.GetName(), window.unavailable)
+	}
+	windowOpen, nextWindow := window.isOpen(time.Now())
+	if !windowOpen && isMaintenanceWindowOverridden(klusterletAddonConfig) {
+		klog.Infof("apply the changes of the addons of cluster %s outside the maintenance window",
+			managedCluster.GetName())
+		windowOpen = true
+	}
 
-	addOnHostingClusterName := getAddOnHostingClusterName(managedCluster)
 	var aggregatedErrs []error
-	for addonName, needUpdate := range agentv1.KlusterletAddons {
+	applyConflicts := map[string][]string{}
+	pausedAddons := []string{}
+	invalidResources := map[string]string{}
+	unsupportedArchitectures := map[string]string{}
+	incompatibleAddons := map[string]string{}
+	pullSecrets := map[imagePullSecretLocation]sets.Set[types.NamespacedName]{}
+	addonImages := map[string]map[string]string{}
+	waitingAddons := map[string]string{}
+	migratingAddons := map[string]string{}
+	rollingOutAddons := map[string]string{}
+	pendingChanges := map[string]string{}
+	changedValues := []string{}
+	var rolloutRequeueAfter time.Duration
+	enabledAddons, rejectedAddons, dependencyConflicts := resolveAddonDependencies(klusterletAddonConfig)
+	// keepAddonImages keeps the images in the status of the addon which is not updated
+	keepAddonImages := func(addonName string) {
+		for _, images := range klusterletAddonConfig.Status.AddonImages {
+			if images.AddonName == addonName {
+				addonImages[addonName] = images.Images
+			}
+		}
+	}
+	addImagePullSecret := func(addonName string, hosting addonHosting) types.NamespacedName {
+		pullSecret := getImagePullSecret(addonName, klusterletAddonConfig, r.defaultImagePullSecret)
+		if len(pullSecret.Name) == 0 {
+			return pullSecret
+		}
+		addon := newManagedClusterAddon(addonName, managedCluster.GetName(), hosting)
+		location := imagePullSecretLocation{
+			WorkNamespace:    addon.Namespace,
+			InstallNamespace: addon.Spec.InstallNamespace,
+		}
+		if hostingClusterName, ok := addon.Annotations[common.AnnotationAddOnHostingClusterName]; ok {
+			location.WorkNamespace = hostingClusterName
+		}
+		if _, ok := pullSecrets[location]; !ok {
+			pullSecrets[location] = sets.New[types.NamespacedName]()
+		}
+		pullSecrets[location].Insert(pullSecret)
+		return pullSecret
+	}
+	// the addons are applied in the order of their dependencies
+	for _, addonName := range orderedKlusterletAddons {
+		needUpdate := agentv1.KlusterletAddons[addonName]
 
 		// Skip the addon if the ClusterManagementAddOn install strategy type is Placements
 		cma := &addonv1alpha1.ClusterManagementAddOn{}
@@ -102,9 +290,63 @@ func (r *ReconcileKlusterletAddOn) Reconcile(ctx context.Context, request reconc
 				klog.V(4).Infof("skip addon %v because ClusterManagementAddOn install strategy type is Placements", addonName)
 				continue
 			}
+		} else {
+			cma = nil
+		}
+
+		hosting, err := r.hostedMode.getAddonHosting(addonName, cma, managedCluster)
+		if err != nil {
+			aggregatedErrs = append(aggregatedErrs, err)
+			continue
+		}
+
+		if addonIsPaused(addonName, klusterletAddonConfig) {
+			klog.V(4).Infof("skip addon %v because it is paused", addonName)
+			pausedAddons = append(pausedAddons, addonName)
+			keepAddonImages(addonName)
+			// keep the image pull secret of the paused addon on the cluster
+			if needUpdate && enabledAddons[addonName] {
+				addImagePullSecret(addonName, hosting)
+			}
+			continue
+		}
+
+		// the addon rejected because of its disabled prerequisites is left as it is
+		if rejectedAddons.Has(addonName) {
+			klog.V(4).Infof("skip addon %v because it is rejected: %s", addonName, dependencyConflicts[addonName])
+			keepAddonImages(addonName)
+			if needUpdate {
+				exists, err := r.managedClusterAddonExists(ctx, addonName, managedCluster.GetName())
+				if err != nil {
+					aggregatedErrs = append(aggregatedErrs, err)
+				} else if exists {
+					addImagePullSecret(addonName, hosting)
+				}
+			}
+			continue
 		}
 
-		if !addonIsEnabled(addonName, klusterletAddonConfig) {
+		// the addons incompatible with the cluster are removed as the disabled addons
+		incompatibility := ""
+		if enabledAddons[addonName] && needUpdate {
+			incompatibility = compatibility.checkCompatibility(addonName, managedCluster)
+		}
+		if len(incompatibility) != 0 {
+			klog.V(4).Infof("skip addon %v of cluster %v: %s", addonName, managedCluster.GetName(), incompatibility)
+			incompatibleAddons[addonName] = incompatibility
+		}
+
+		if !enabledAddons[addonName] || len(incompatibility) != 0 {
+			if !windowOpen {
+				exists, err := r.managedClusterAddonExists(ctx, addonName, managedCluster.GetName())
+				if err != nil {
+					aggregatedErrs = append(aggregatedErrs, err)
+				}
+				if exists {
+					pendingChanges[addonName] = pendingChangeDisable
+				}
+				continue
+			}
 			if err := r.deleteManagedClusterAddon(ctx, addonName, managedCluster.GetName()); err != nil {
 				aggregatedErrs = append(aggregatedErrs, err)
 			}
@@ -116,21 +358,136 @@ func (r *ReconcileKlusterletAddOn) Reconcile(ctx context.Context, request reconc
 			continue
 		}
 
-		imageOverrides, err := getImageOverrides(managedCluster, addonName)
+		reason, err := r.waitForPrerequisites(ctx, addonName, managedCluster.GetName())
+		if err != nil {
+			aggregatedErrs = append(aggregatedErrs, err)
+			continue
+		}
+		if len(reason) != 0 {
+			klog.V(4).Infof("skip addon %v of cluster %v: %s", addonName, managedCluster.GetName(), reason)
+			waitingAddons[addonName] = reason
+			continue
+		}
+
+		migration, err := r.migrateAddonMode(ctx, addonName, managedCluster.GetName(), hosting, cma, windowOpen)
+		if err != nil {
+			aggregatedErrs = append(aggregatedErrs, err)
+			continue
+		}
+		if len(migration.pendingChange) != 0 {
+			// the addon is kept in its mode with its image pull secret until the maintenance window opens
+			pendingChanges[addonName] = migration.pendingChange
+			keepAddonImages(addonName)
+			addImagePullSecret(addonName, migration.existing)
+			continue
+		}
+		if len(migration.progress) != 0 {
+			migratingAddons[addonName] = migration.progress
+			keepAddonImages(addonName)
+			continue
+		}
+
+		pullSecret := addImagePullSecret(addonName, hosting)
+		resources := getResources(addonName, klusterletAddonConfig)
+		if err := validateResources(resources); err != nil {
+			klog.Warningf("skip addon %v of cluster %v because the resources are invalid: %v",
+				addonName, managedCluster.GetName(), err)
+			invalidResources[addonName] = err.Error()
+			keepAddonImages(addonName)
+			continue
+		}
+
+		imageOverrides, err := getImageOverrides(managedCluster, addonName, architectures)
+		if goerrors.Is(err, agentv1.ErrNoImageForArchitecture) {
+			klog.Warningf("skip addon %v of cluster %v: %v", addonName, managedCluster.GetName(), err)
+			unsupportedArchitectures[addonName] = err.Error()
+			keepAddonImages(addonName)
+			continue
+		}
 		if err != nil {
 			return reconcile.Result{}, err
 		}
-		gv := getGlobalValues(nodeSelector, imageOverrides, addonName, klusterletAddonConfig)
+		imageOverrides = mergeImageOverrides(addonName, klusterletAddonConfig, imageOverrides)
+		if len(imageOverrides) != 0 {
+			addonImages[addonName] = imageOverrides
+		}
+		nodePlacement := getNodePlacement(addonName, klusterletAddonConfig, clusterNodePlacement)
+		gv := getGlobalValues(nodePlacement, imageOverrides, addonName, klusterletAddonConfig)
+		gv.Global.Resources = resources
+		gv.Global.ImagePullSecret = pullSecret.Name
+		gv.Global.ImagePullPolicy = getImagePullPolicy(addonName, klusterletAddonConfig, r.defaultImagePullPolicy)
+
+		rollout, err := getRolloutConfig(cma)
+		if err != nil {
+			aggregatedErrs = append(aggregatedErrs, err)
+			keepAddonImages(addonName)
+			continue
+		}
 
-		if err := r.updateManagedClusterAddon(ctx, gv, addonName, managedCluster.GetName(), addOnHostingClusterName); err != nil {
+		result, err := r.applyManagedClusterAddon(ctx, gv, addonName, managedCluster.GetName(), hosting,
+			rollout, windowOpen)
+		if err != nil {
 			aggregatedErrs = append(aggregatedErrs, err)
 		}
+		if len(result.conflicts) != 0 {
+			applyConflicts[addonName] = result.conflicts
+		}
+		if len(result.pendingChange) != 0 {
+			pendingChanges[addonName] = result.pendingChange
+		}
+		if result.valuesChanged {
+			changedValues = append(changedValues, addonName)
+		}
+		if len(result.rollout.message) != 0 {
+			rollingOutAddons[addonName] = result.rollout.message
+		}
+		if result.rollout.held || result.pendingChange == pendingChangeUpdateValues {
+			delete(addonImages, addonName)
+			keepAddonImages(addonName)
+		}
+		rolloutRequeueAfter = earlierRequeue(rolloutRequeueAfter, result.rollout.requeueAfter)
+	}
+
+	if err := r.syncImagePullSecretWorks(ctx, managedCluster.GetName(), pullSecrets); err != nil {
+		aggregatedErrs = append(aggregatedErrs, err)
+	}
+	if err := r.updateStatus(ctx, klusterletAddonConfig,
+		setConditions(
+			newApplyConflictCondition(klusterletAddonConfig, applyConflicts),
+			newPausedCondition(klusterletAddonConfig, pausedAddons),
+			newResourcesCondition(klusterletAddonConfig, invalidResources),
+			newArchitectureCondition(klusterletAddonConfig, unsupportedArchitectures),
+			newCompatibilityCondition(klusterletAddonConfig, incompatibleAddons),
+			newPrerequisitesCondition(klusterletAddonConfig, waitingAddons),
+			newDependencyConflictCondition(klusterletAddonConfig, dependencyConflicts),
+			newModeMigrationCondition(klusterletAddonConfig, migratingAddons),
+			newRolloutCondition(klusterletAddonConfig, rollingOutAddons),
+			newMaintenanceWindowCondition(klusterletAddonConfig, pendingChanges, window, nextWindow),
+			newDeferredCondition(klusterletAddonConfig, r.gateOnClusterAvailability, availability),
+			r.newTornDownCondition(klusterletAddonConfig, availability, false)),
+		setAddonImages(addonImages),
+		recordValuesChanges(changedValues, metav1.Now())); err != nil {
+		aggregatedErrs = append(aggregatedErrs, err)
 	}
 	if len(aggregatedErrs) != 0 {
 		return reconcile.Result{}, fmt.Errorf("failed create/update addon %v", aggregatedErrs)
 	}
 
-	return reconcile.Result{}, nil
+	// requeue to tear down the addons when the grace period of the unavailable cluster expires, to check the
+	// values updates held or being rolled out, and to apply the pending changes when the maintenance window opens
+	requeueAfter := earlierRequeue(gracePeriodRemaining, rolloutRequeueAfter)
+	if len(pendingChanges) != 0 && !nextWindow.IsZero() {
+		requeueAfter = earlierRequeue(requeueAfter, time.Until(nextWindow))
+	}
+	return reconcile.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// earlierRequeue returns the earlier one of the requeue durations, zero means no requeue
+func earlierRequeue(requeueAfter, other time.Duration) time.Duration {
+	if other > 0 && (requeueAfter == 0 || other < requeueAfter) {
+		return other
+	}
+	return requeueAfter
 }
 
 func (r *ReconcileKlusterletAddOn) deleteManagedClusterAddon(ctx context.Context, addonName, clusterName string) error {
@@ -149,65 +506,369 @@ func (r *ReconcileKlusterletAddOn) deleteManagedClusterAddon(ctx context.Context
 	return nil
 }
 
-func (r *ReconcileKlusterletAddOn) updateManagedClusterAddon(ctx context.Context, gv globalValues, addonName, clusterName string, hostingClusterName string) error {
+// applyResult is the result of applying a managedClusterAddon
+type applyResult struct {
+	// conflicts is the conflicts with the other field managers
+	conflicts []string
+	// rollout is the progress of the values update rolled out by the rollout strategy
+	rollout rolloutProgress
+	// pendingChange is the change held until the maintenance window opens
+	pendingChange string
+	// valuesChanged is true if the values of the existing addon are updated
+	valuesChanged bool
+}
+
+// applyManagedClusterAddon creates or updates the managedClusterAddon with server-side apply. Only the values
+// annotation, the hosting cluster annotation, the rollout annotations and the install namespace are owned by this
+// controller, the conflicts with the other field managers are returned instead of being overwritten. The values
+// update of the existing addon is rolled out by the rollout config if it is set, and the addon is neither created
+// nor its values are updated if the maintenance window is not open.
+func (r *ReconcileKlusterletAddOn) applyManagedClusterAddon(ctx context.Context, gv globalValues,
+	addonName, clusterName string, hosting addonHosting, rollout *rolloutConfig, windowOpen bool) (
+	applyResult, error) {
+	result := applyResult{}
 	valuesString, err := marshalGlobalValues(gv)
 	if err != nil {
-		return err
+		return result, err
+	}
+
+	desired := newManagedClusterAddon(addonName, clusterName, hosting)
+	if len(valuesString) != 0 {
+		if desired.Annotations == nil {
+			desired.Annotations = map[string]string{}
+		}
+		desired.Annotations[annotationValues] = valuesString
 	}
+
+	valuesChanged, legacy := false, false
 	addon := &addonv1alpha1.ManagedClusterAddOn{}
 	err = r.client.Get(ctx, types.NamespacedName{Name: addonName, Namespace: clusterName}, addon)
-	if errors.IsNotFound(err) {
+	switch {
+	case errors.IsNotFound(err):
 		if !agentv1.KlusterletAddons[addonName] {
-			return nil
+			return result, nil
 		}
+		if !windowOpen {
+			result.pendingChange = pendingChangeEnable
+			return result, nil
+		}
+	case err != nil:
+		return result, err
+	default:
+		if !windowOpen && addon.Annotations[annotationValues] != desired.Annotations[annotationValues] {
+			// the values are kept, and the update is rolled out after the maintenance window opens
+			keepValues(addon, desired)
+			result.pendingChange = pendingChangeUpdateValues
+		}
+		progress := &result.rollout
+		progress.message, progress.requeueAfter, err = r.rolloutValues(ctx, rollout, addon, desired)
+		if err != nil {
+			return result, err
+		}
+		_, progress.held = desired.Annotations[common.AnnotationRolloutPending]
+		if managedClusterAddonApplied(addon, desired) {
+			return result, nil
+		}
+		valuesChanged = addon.Annotations[annotationValues] != desired.Annotations[annotationValues]
+		legacy = legacyManagedClusterAddon(addon)
+	}
 
-		newAddon := newManagedClusterAddon(addonName, clusterName, hostingClusterName)
-		if len(valuesString) != 0 {
-			newAddon.SetAnnotations(map[string]string{annotationValues: valuesString})
+	if legacy && !r.dryRun {
+		// the fields updated by the previous versions of this controller are migrated to the field manager of the
+		// apply, so they are removed if they are omitted from the apply
+		if err := r.migrateLegacyManagedFields(ctx, addonName, clusterName); err != nil {
+			return result, err
 		}
+	}
 
-		return r.client.Create(ctx, newAddon)
+	applyConfig := newManagedClusterAddonApplyConfig(desired)
+	err = r.client.Patch(ctx, applyConfig, client.Apply, client.FieldOwner(fieldManager))
+	if err == nil || !errors.IsConflict(err) {
+		result.valuesChanged = err == nil && valuesChanged
+		return result, err
 	}
-	if err != nil {
+
+	conflicts := getApplyConflicts(err)
+	klog.Warningf("failed to apply addon %s/%s, conflicts: %v", clusterName, addonName, conflicts)
+	result.conflicts = conflicts
+	return result, nil
+}
+
+// legacyManagedClusterAddon returns true if the managedClusterAddon is updated by the previous versions of this
+// controller and not yet applied by this controller
+func legacyManagedClusterAddon(addon *addonv1alpha1.ManagedClusterAddOn) bool {
+	legacy := false
+	for _, entry := range addon.ManagedFields {
+		switch {
+		case entry.Manager == fieldManager && entry.Operation == metav1.ManagedFieldsOperationApply:
+			return false
+		case entry.Manager == legacyFieldManager:
+			legacy = true
+		}
+	}
+	return legacy
+}
+
+// migrateLegacyManagedFields migrates the fields updated by the legacy field manager to the field manager of the
+// server-side apply, the managedClusterAddon is read from the API server since the fields of its managed fields are
+// stripped from the cache. The migration is done once, the managedClusterAddon is not legacy after it is applied.
+func (r *ReconcileKlusterletAddOn) migrateLegacyManagedFields(ctx context.Context, addonName,
+	clusterName string) error {
+	addon := &addonv1alpha1.ManagedClusterAddOn{}
+	if err := r.apiReader.Get(ctx, types.NamespacedName{Name: addonName, Namespace: clusterName}, addon); err != nil {
+		return err
+	}
+	patch, err := csaupgrade.UpgradeManagedFieldsPatch(addon, sets.New(legacyFieldManager), fieldManager)
+	if err != nil || patch == nil {
 		return err
 	}
+	klog.Infof("migrate the managed fields of addon %s/%s from the field manager %q", clusterName, addonName,
+		legacyFieldManager)
+	return r.client.Patch(ctx, addon, client.RawPatch(types.JSONPatchType, patch))
+}
+
+// managedClusterAddonExists returns true if the managedClusterAddon exists and is not being deleted
+func (r *ReconcileKlusterletAddOn) managedClusterAddonExists(ctx context.Context, addonName,
+	clusterName string) (bool, error) {
+	addon := &addonv1alpha1.ManagedClusterAddOn{}
+	err := r.client.Get(ctx, types.NamespacedName{Name: addonName, Namespace: clusterName}, addon)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return addon.DeletionTimestamp.IsZero(), nil
+}
+
+// managedClusterAddonApplied returns true if the fields owned by this controller are already in the desired state
+func managedClusterAddonApplied(addon, desired *addonv1alpha1.ManagedClusterAddOn) bool {
+	if addon.Spec.InstallNamespace != desired.Spec.InstallNamespace {
+		return false
+	}
+
+	// the annotations are removed if they are not desired, for example the hosting cluster annotation is removed
+	// if the addon is moved to default mode
+	for _, key := range managedClusterAddonAnnotations {
+		value, existed := addon.Annotations[key]
+		desiredValue, desiredExisted := desired.Annotations[key]
+		if existed != desiredExisted || value != desiredValue {
+			return false
+		}
+	}
+	return true
+}
 
-	update := false
-	addon = addon.DeepCopy()
+// newManagedClusterAddonApplyConfig returns the apply configuration which only contains the fields owned by
+// this controller
+func newManagedClusterAddonApplyConfig(desired *addonv1alpha1.ManagedClusterAddOn) *unstructured.Unstructured {
+	applyConfig := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	applyConfig.SetGroupVersionKind(addonv1alpha1.GroupVersion.WithKind("ManagedClusterAddOn"))
+	applyConfig.SetName(desired.Name)
+	applyConfig.SetNamespace(desired.Namespace)
+	if len(desired.Annotations) != 0 {
+		applyConfig.SetAnnotations(desired.Annotations)
+	}
+	applyConfig.Object["spec"] = map[string]interface{}{
+		"installNamespace": desired.Spec.InstallNamespace,
+	}
+	return applyConfig
+}
 
-	if len(addon.Annotations) == 0 && len(valuesString) != 0 {
-		addon.SetAnnotations(map[string]string{annotationValues: valuesString})
-		update = true
+// getApplyConflicts returns the conflict messages of a server-side apply error
+func getApplyConflicts(err error) []string {
+	status, ok := err.(errors.APIStatus)
+	if !ok || status.Status().Details == nil || len(status.Status().Details.Causes) == 0 {
+		return []string{err.Error()}
 	}
-	if len(addon.Annotations) != 0 {
-		values, existed := addon.Annotations[annotationValues]
-		if !existed && len(valuesString) != 0 {
-			addon.Annotations[annotationValues] = valuesString
-			update = true
+
+	conflicts := []string{}
+	for _, cause := range status.Status().Details.Causes {
+		conflicts = append(conflicts, fmt.Sprintf("%s: %s", cause.Field, cause.Message))
+	}
+	return conflicts
+}
+
+// newApplyConflictCondition returns the condition which reports the conflicts of applying the managedClusterAddons,
+// nil is returned if there are no conflicts and the condition is not set before.
+func newApplyConflictCondition(config *agentv1.KlusterletAddonConfig, conflicts map[string][]string) *metav1.Condition {
+	if len(conflicts) == 0 && meta.FindStatusCondition(config.Status.Conditions,
+		agentv1.ManagedClusterAddOnApplyConflict) == nil {
+		return nil
+	}
+
+	condition := &metav1.Condition{
+		Type:    agentv1.ManagedClusterAddOnApplyConflict,
+		Status:  metav1.ConditionFalse,
+		Reason:  agentv1.ReasonManagedClusterAddOnApplied,
+		Message: "The managedClusterAddOns are applied without conflicts.",
+	}
+	if len(conflicts) != 0 {
+		messages := []string{}
+		for _, addonName := range sets.List(sets.KeySet(conflicts)) {
+			messages = append(messages, fmt.Sprintf("%s: [%s]", addonName, strings.Join(conflicts[addonName], "; ")))
 		}
-		if existed && !reflect.DeepEqual(values, valuesString) {
-			if len(valuesString) != 0 {
-				addon.Annotations[annotationValues] = valuesString
-			} else {
-				delete(addon.Annotations, annotationValues)
-			}
-			update = true
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = agentv1.ReasonManagedClusterAddOnApplyConflict
+		condition.Message = fmt.Sprintf("The fields of the managedClusterAddOns are managed by others: %s",
+			strings.Join(messages, ", "))
+	}
+	return condition
+}
+
+// newPausedCondition returns the condition which lists the paused addons, nil is returned if there are no paused
+// addons and the condition is not set before.
+func newPausedCondition(config *agentv1.KlusterletAddonConfig, pausedAddons []string) *metav1.Condition {
+	if len(pausedAddons) == 0 && meta.FindStatusCondition(config.Status.Conditions, agentv1.AddonsPaused) == nil {
+		return nil
+	}
+
+	if len(pausedAddons) == 0 {
+		return &metav1.Condition{
+			Type:    agentv1.AddonsPaused,
+			Status:  metav1.ConditionFalse,
+			Reason:  agentv1.ReasonAddonsNotPaused,
+			Message: "No addons are paused.",
 		}
 	}
 
-	if !update {
+	reason := agentv1.ReasonAddonsPaused
+	if isPaused(config) {
+		reason = agentv1.ReasonKlusterletAddonConfigPaused
+	}
+	return &metav1.Condition{
+		Type:    agentv1.AddonsPaused,
+		Status:  metav1.ConditionTrue,
+		Reason:  reason,
+		Message: fmt.Sprintf("The addons are paused: %s", strings.Join(sets.List(sets.New(pausedAddons...)), ", ")),
+	}
+}
+
+// newResourcesCondition returns the condition which reports the addons with invalid resources, nil is returned if
+// all the resources are valid and the condition is not set before.
+func newResourcesCondition(config *agentv1.KlusterletAddonConfig, invalidResources map[string]string) *metav1.Condition {
+	return newAddonsCondition(config, agentv1.AddonResourcesInvalid, invalidResources,
+		agentv1.ReasonAddonResourcesInvalid, "The addons are not updated because the resources are invalid",
+		agentv1.ReasonAddonResourcesValid, "The resources of the addons are valid.")
+}
+
+// newArchitectureCondition returns the condition which reports the addons without images for the architecture of
+// the cluster, nil is returned if all the addons have images and the condition is not set before.
+func newArchitectureCondition(config *agentv1.KlusterletAddonConfig,
+	unsupportedAddons map[string]string) *metav1.Condition {
+	return newAddonsCondition(config, agentv1.AddonArchitectureUnsupported, unsupportedAddons,
+		agentv1.ReasonNoImageForArchitecture,
+		"The addons are not deployed because there are no images for the architecture of the cluster",
+		agentv1.ReasonImagesAvailableForArchitecture, "The images of the addons are available for the architecture.")
+}
+
+// newAddonsCondition returns the condition which is true if there are any addons with the given messages, the
+// addons and their messages are listed in the message of the condition. nil is returned if there are no addons and
+// the condition is not set before.
+func newAddonsCondition(config *agentv1.KlusterletAddonConfig, conditionType string, addons map[string]string,
+	trueReason, trueMessage, falseReason, falseMessage string) *metav1.Condition {
+	if len(addons) == 0 && meta.FindStatusCondition(config.Status.Conditions, conditionType) == nil {
 		return nil
 	}
 
-	err = r.client.Update(ctx, addon)
-	if err != nil {
-		return err
+	if len(addons) == 0 {
+		return &metav1.Condition{
+			Type:    conditionType,
+			Status:  metav1.ConditionFalse,
+			Reason:  falseReason,
+			Message: falseMessage,
+		}
 	}
-	return nil
+
+	messages := []string{}
+	for _, addonName := range sets.List(sets.KeySet(addons)) {
+		messages = append(messages, fmt.Sprintf("%s: [%s]", addonName, addons[addonName]))
+	}
+	return &metav1.Condition{
+		Type:    conditionType,
+		Status:  metav1.ConditionTrue,
+		Reason:  trueReason,
+		Message: fmt.Sprintf("%s: %s", trueMessage, strings.Join(messages, ", ")),
+	}
+}
+
+// statusMutateFunc changes the status of the klusterletAddonConfig
+type statusMutateFunc func(status *agentv1.KlusterletAddonConfigStatus)
+
+// setConditions returns the statusMutateFunc which sets the conditions, the nil conditions are ignored.
+func setConditions(conditions ...*metav1.Condition) statusMutateFunc {
+	return func(status *agentv1.KlusterletAddonConfigStatus) {
+		for _, condition := range conditions {
+			if condition != nil {
+				meta.SetStatusCondition(&status.Conditions, *condition)
+			}
+		}
+	}
+}
+
+// setAddonImages returns the statusMutateFunc which sets the overridden images of the addons.
+func setAddonImages(addonImages map[string]map[string]string) statusMutateFunc {
+	return func(status *agentv1.KlusterletAddonConfigStatus) {
+		status.AddonImages = nil
+		for _, addonName := range sets.List(sets.KeySet(addonImages)) {
+			status.AddonImages = append(status.AddonImages, agentv1.AddonImages{
+				AddonName: addonName,
+				Images:    addonImages[addonName],
+			})
+		}
+	}
+}
+
+// recordValuesChanges returns the statusMutateFunc which records the addons whose values are changed in the history.
+func recordValuesChanges(addonNames []string, now metav1.Time) statusMutateFunc {
+	return func(status *agentv1.KlusterletAddonConfigStatus) {
+		records := []agentv1.ChangeRecord{}
+		for _, addonName := range addonNames {
+			records = append(records, agentv1.ChangeRecord{
+				Action:    agentv1.ChangeActionValuesChanged,
+				AddonName: addonName,
+				Source:    changeSource,
+				Time:      now,
+				Message:   "the values of the ManagedClusterAddOn are updated",
+			})
+		}
+		if len(records) != 0 {
+			status.History = agentv1.AppendChanges(status.History, records...)
+		}
+	}
+}
+
+// updateStatus updates the status of the klusterletAddonConfig by the mutate funcs if it is changed.
+func (r *ReconcileKlusterletAddOn) updateStatus(ctx context.Context,
+	config *agentv1.KlusterletAddonConfig, mutateFuncs ...statusMutateFunc) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		klusterletAddonConfig := &agentv1.KlusterletAddonConfig{}
+		err := r.client.Get(ctx, types.NamespacedName{Name: config.Name, Namespace: config.Namespace},
+			klusterletAddonConfig)
+		if err != nil {
+			return err
+		}
+
+		newStatus := klusterletAddonConfig.Status.DeepCopy()
+		for _, mutate := range mutateFuncs {
+			mutate(newStatus)
+		}
+		if equality.Semantic.DeepEqual(klusterletAddonConfig.Status, *newStatus) {
+			return nil
+		}
+
+		klusterletAddonConfig.Status = *newStatus
+		return r.client.Status().Update(ctx, klusterletAddonConfig)
+	})
 }
 
-// isPaused returns true if the KlusterletAddonConfig instance is labeled as paused, and false otherwise
+// isPaused returns true if the KlusterletAddonConfig instance is paused by the spec or the annotation,
+// and false otherwise
 func isPaused(instance *agentv1.KlusterletAddonConfig) bool {
+	if instance.Spec.Paused {
+		return true
+	}
+
 	a := instance.GetAnnotations()
 	if len(a) == 0 {
 		return false
@@ -221,6 +882,12 @@ func isPaused(instance *agentv1.KlusterletAddonConfig) bool {
 	return false
 }
 
+// addonIsPaused returns true if the reconcile of the addon is paused in the KlusterletAddonConfig
+func addonIsPaused(addonName string, config *agentv1.KlusterletAddonConfig) bool {
+	agentConfig := agentv1.GetAddonAgentConfig(addonName, config)
+	return agentConfig != nil && agentConfig.Paused
+}
+
 func getNodeSelector(managedCluster *mcv1.ManagedCluster) (map[string]string, error) {
 	var nodeSelector map[string]string
 	if localcluster.IsClusterSelfManaged(managedCluster) {
@@ -236,18 +903,141 @@ func getNodeSelector(managedCluster *mcv1.ManagedCluster) (map[string]string, er
 	return nodeSelector, nil
 }
 
-func getImageOverrides(managedCluster *mcv1.ManagedCluster, addonName string) (map[string]string, error) {
-	imageOverrides := map[string]string{}
-	if len(managedCluster.Annotations) == 0 {
-		return imageOverrides, nil
+// getClusterNodePlacement returns the node placement of the addons set by the annotation of the managed cluster.
+// The nodeSelector annotation synced from MCH is used on the self managed cluster if the nodeSelector is not set.
+func getClusterNodePlacement(managedCluster *mcv1.ManagedCluster) (agentv1.NodePlacement, error) {
+	nodePlacement := agentv1.NodePlacement{}
+	if nodePlacementString, ok := managedCluster.GetAnnotations()[common.AnnotationNodePlacement]; ok {
+		if err := json.Unmarshal([]byte(nodePlacementString), &nodePlacement); err != nil {
+			klog.Errorf("failed to unmarshal nodePlacement annotation of cluster %v: %v", managedCluster.GetName(), err)
+			return nodePlacement, err
+		}
+	}
+
+	if len(nodePlacement.NodeSelector) == 0 {
+		nodeSelector, err := getNodeSelector(managedCluster)
+		if err != nil {
+			return nodePlacement, err
+		}
+		nodePlacement.NodeSelector = nodeSelector
+	}
+	return nodePlacement, nil
+}
+
+// getNodePlacement returns the node placement of the addon. The nodeSelector and tolerations are overridden
+// separately, by the KlusterletAddonConfig over the managed cluster, and by the addon over the KlusterletAddonConfig.
+func getNodePlacement(addonName string, config *agentv1.KlusterletAddonConfig,
+	clusterNodePlacement agentv1.NodePlacement) agentv1.NodePlacement {
+	nodePlacement := clusterNodePlacement
+	overrides := []*agentv1.NodePlacement{config.Spec.NodePlacement}
+	if agentConfig := agentv1.GetAddonAgentConfig(addonName, config); agentConfig != nil {
+		overrides = append(overrides, agentConfig.NodePlacement)
+	}
+
+	for _, override := range overrides {
+		if override == nil {
+			continue
+		}
+		if len(override.NodeSelector) != 0 {
+			nodePlacement.NodeSelector = override.NodeSelector
+		}
+		if len(override.Tolerations) != 0 {
+			nodePlacement.Tolerations = override.Tolerations
+		}
+	}
+	return nodePlacement
+}
+
+// getResources returns the resources of the containers of the addon agent set in the klusterletAddonConfig
+func getResources(addonName string, config *agentv1.KlusterletAddonConfig) []agentv1.ContainerResourceRequirements {
+	if agentConfig := agentv1.GetAddonAgentConfig(addonName, config); agentConfig != nil {
+		return agentConfig.Resources
+	}
+	return nil
+}
+
+// validateResources returns an error if the container names are not unique or not valid, the resource names are not
+// supported, or the quantities are negative or the requests exceed the limits.
+func validateResources(resources []agentv1.ContainerResourceRequirements) error {
+	var errs []error
+	names := sets.New[string]()
+	for _, resource := range resources {
+		if resource.Name != "*" {
+			for _, msg := range validation.IsDNS1123Label(resource.Name) {
+				errs = append(errs, fmt.Errorf("invalid container name %q: %s", resource.Name, msg))
+			}
+		}
+		if names.Has(resource.Name) {
+			errs = append(errs, fmt.Errorf("duplicated container name %q", resource.Name))
+		}
+		names.Insert(resource.Name)
+
+		for _, list := range []corev1.ResourceList{resource.Limits, resource.Requests} {
+			for resourceName, quantity := range list {
+				if !isSupportedResourceName(resourceName) {
+					errs = append(errs, fmt.Errorf("unsupported resource %q of container %q", resourceName, resource.Name))
+				}
+				if quantity.Sign() < 0 {
+					errs = append(errs, fmt.Errorf("negative quantity %s of resource %q of container %q",
+						quantity.String(), resourceName, resource.Name))
+				}
+			}
+		}
+
+		for resourceName, request := range resource.Requests {
+			if limit, ok := resource.Limits[resourceName]; ok && request.Cmp(limit) > 0 {
+				errs = append(errs, fmt.Errorf("the request %s of resource %q of container %q exceeds the limit %s",
+					request.String(), resourceName, resource.Name, limit.String()))
+			}
+		}
 	}
+	return utilerrors.NewAggregate(errs)
+}
 
-	if _, ok := managedCluster.Annotations[imageregistryv1alpha1.ClusterImageRegistriesAnnotation]; !ok {
-		return imageOverrides, nil
+func isSupportedResourceName(name corev1.ResourceName) bool {
+	switch name {
+	case corev1.ResourceCPU, corev1.ResourceMemory, corev1.ResourceEphemeralStorage:
+		return true
 	}
+	return strings.HasPrefix(string(name), corev1.ResourceHugePagesPrefix)
+}
+
+// mergeImageOverrides merges the image overrides of the addon in the klusterletAddonConfig over the given image
+// overrides, the image keys which are not used by the addon are ignored.
+func mergeImageOverrides(addonName string, config *agentv1.KlusterletAddonConfig,
+	imageOverrides map[string]string) map[string]string {
+	agentConfig := agentv1.GetAddonAgentConfig(addonName, config)
+	if agentConfig == nil || len(agentConfig.ImageOverrides) == 0 {
+		return imageOverrides
+	}
+
+	merged := map[string]string{}
+	for imageKey, image := range imageOverrides {
+		merged[imageKey] = image
+	}
+	imageKeys := sets.New(agentv1.KlusterletAddonImageNames[addonName]...)
+	for imageKey, image := range agentConfig.ImageOverrides {
+		if imageKeys.Has(imageKey) {
+			merged[imageKey] = image
+		}
+	}
+	return merged
+}
+
+// getImageOverrides returns the images of the addon if the ClusterImageRegistries annotation is set on the cluster,
+// or the image manifest has the architecture images of the addon. The images are selected by the architectures
+// of the cluster.
+func getImageOverrides(managedCluster *mcv1.ManagedCluster, addonName string,
+	architectures []string) (map[string]string, error) {
+	imageOverrides := map[string]string{}
+	_, hasRegistries := managedCluster.GetAnnotations()[imageregistryv1alpha1.ClusterImageRegistriesAnnotation]
 
 	for _, imageKey := range agentv1.KlusterletAddonImageNames[addonName] {
-		image, err := agentv1.GetImage(managedCluster, imageKey)
+		if !hasRegistries && !agentv1.HasArchitectureImages(imageKey) {
+			continue
+		}
+
+		image, err := agentv1.GetImageForArchitectures(managedCluster, imageKey, architectures)
 		if err != nil {
 			return imageOverrides, err
 		}
@@ -257,6 +1047,54 @@ func getImageOverrides(managedCluster *mcv1.ManagedCluster, addonName string) (m
 	return imageOverrides, nil
 }
 
+// hasArchitectureImages returns true if the image manifest has any architecture images of the addons
+func hasArchitectureImages() bool {
+	for _, imageKeys := range agentv1.KlusterletAddonImageNames {
+		for _, imageKey := range imageKeys {
+			if agentv1.HasArchitectureImages(imageKey) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getClusterArchitectures returns the architectures of the managed cluster. They are read from the architecture
+// cluster claim, or from the node labels in the cached ManagedClusterInfo if the claim is not set.
+func (r *ReconcileKlusterletAddOn) getClusterArchitectures(ctx context.Context,
+	managedCluster *mcv1.ManagedCluster) ([]string, error) {
+	for _, claim := range managedCluster.Status.ClusterClaims {
+		if claim.Name != common.ClusterClaimArchitecture {
+			continue
+		}
+		architectures := sets.New[string]()
+		for _, arch := range strings.Split(claim.Value, ",") {
+			if arch = strings.TrimSpace(arch); len(arch) != 0 {
+				architectures.Insert(arch)
+			}
+		}
+		return sets.List(architectures), nil
+	}
+
+	clusterInfo := &clusterinfov1beta1.ManagedClusterInfo{}
+	err := r.client.Get(ctx, types.NamespacedName{Namespace: managedCluster.Name, Name: managedCluster.Name},
+		clusterInfo)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	architectures := sets.New[string]()
+	for _, node := range clusterInfo.Status.NodeList {
+		if arch, ok := node.Labels[corev1.LabelArchStable]; ok {
+			architectures.Insert(arch)
+		}
+	}
+	return sets.List(architectures), nil
+}
+
 func getProxyConfig(addonName string, config *agentv1.KlusterletAddonConfig) map[string]string {
 	var proxyPolicy agentv1.ProxyPolicy
 	switch addonName {
@@ -300,7 +1138,7 @@ func getProxyConfig(addonName string, config *agentv1.KlusterletAddonConfig) map
 	return proxyConfig
 }
 
-func getGlobalValues(nodeSelector map[string]string,
+func getGlobalValues(nodePlacement agentv1.NodePlacement,
 	imageOverrides map[string]string,
 	addonName string,
 	config *agentv1.KlusterletAddonConfig,
@@ -308,12 +1146,66 @@ func getGlobalValues(nodeSelector map[string]string,
 	return globalValues{
 		Global: global{
 			ImageOverrides: imageOverrides,
-			NodeSelector:   nodeSelector,
+			NodeSelector:   nodePlacement.NodeSelector,
+			Tolerations:    nodePlacement.Tolerations,
 			ProxyConfig:    getProxyConfig(addonName, config),
 		},
 	}
 }
 
+// AddonDeployment is the deployment of an addon of a managed cluster configured by the KlusterletAddonConfig
+type AddonDeployment struct {
+	NodePlacement agentv1.NodePlacement
+	ProxyConfig   map[string]string
+	Resources     []agentv1.ContainerResourceRequirements
+
+	// ImagePullSecret is the hub image pull secret copied to the install namespace of the addon, ImagePullPolicy is
+	// the image pull policy of the addon, and ImageOverrides are the images of the addon set in the
+	// KlusterletAddonConfig by image key
+	ImagePullSecret types.NamespacedName
+	ImagePullPolicy corev1.PullPolicy
+	ImageOverrides  map[string]string
+
+	// Paused is true if the KlusterletAddonConfig or the addon is paused
+	Paused bool
+	// HostingClusterName is the name of the hosting cluster if the addon is deployed in hosted mode
+	HostingClusterName string
+}
+
+// GetAddonDeployment returns the deployment of the addon which the controller sets in the values of the
+// ManagedClusterAddOn, the ManagedCluster and the ClusterManagementAddOn can be nil. The hosted mode and the
+// default image pull secret and policy are decided by the options of the controller, the default options are used
+// if they are nil.
+func GetAddonDeployment(addonName string, config *agentv1.KlusterletAddonConfig, managedCluster *mcv1.ManagedCluster,
+	cma *addonv1alpha1.ClusterManagementAddOn, opts *common.Options) (AddonDeployment, error) {
+	if opts == nil {
+		opts = common.NewOptions()
+	}
+	deployment := AddonDeployment{
+		ProxyConfig:     getProxyConfig(addonName, config),
+		Resources:       getResources(addonName, config),
+		ImagePullSecret: getImagePullSecret(addonName, config, opts.DefaultImagePullSecretName()),
+		ImagePullPolicy: getImagePullPolicy(addonName, config, corev1.PullPolicy(opts.DefaultImagePullPolicy)),
+		ImageOverrides:  mergeImageOverrides(addonName, config, nil),
+		Paused:          isPaused(config) || addonIsPaused(addonName, config),
+	}
+
+	hosting, err := newHostedModeConfig(opts).getAddonHosting(addonName, cma, managedCluster)
+	if err != nil {
+		return deployment, err
+	}
+	deployment.HostingClusterName = hosting.hostingClusterName
+
+	clusterNodePlacement := agentv1.NodePlacement{}
+	if managedCluster != nil {
+		if clusterNodePlacement, err = getClusterNodePlacement(managedCluster); err != nil {
+			return deployment, err
+		}
+	}
+	deployment.NodePlacement = getNodePlacement(addonName, config, clusterNodePlacement)
+	return deployment, nil
+}
+
 // getAddOnHostingClusterName returns the hosting cluster name for add-ons of the given managed cluster.
 // An empty string is returned if the add-ons should be deployed in the default mode.
 func getAddOnHostingClusterName(cluster *mcv1.ManagedCluster) string {
@@ -329,7 +1221,7 @@ func getAddOnHostingClusterName(cluster *mcv1.ManagedCluster) string {
 	}
 }
 
-func newManagedClusterAddon(addonName, namespace string, hostingClusterName string) *addonv1alpha1.ManagedClusterAddOn {
+func newManagedClusterAddon(addonName, namespace string, hosting addonHosting) *addonv1alpha1.ManagedClusterAddOn {
 	addOn := &addonv1alpha1.ManagedClusterAddOn{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      addonName,
@@ -340,20 +1232,24 @@ func newManagedClusterAddon(addonName, namespace string, hostingClusterName stri
 		},
 	}
 
-	if hostedAddOns.Has(addonName) && len(hostingClusterName) > 0 {
+	if len(hosting.hostingClusterName) > 0 {
 		addOn.Annotations = map[string]string{
-			common.AnnotationAddOnHostingClusterName: hostingClusterName,
+			common.AnnotationAddOnHostingClusterName: hosting.hostingClusterName,
 		}
-		addOn.Spec.InstallNamespace = fmt.Sprintf("klusterlet-%s", namespace)
+		addOn.Spec.InstallNamespace = hosting.installNamespace
 	}
 
 	return addOn
 }
 
 func marshalGlobalValues(values globalValues) (string, error) {
-	if len(values.Global.NodeSelector) == 0 &&
+	if len(values.Global.ImagePullPolicy) == 0 &&
+		len(values.Global.ImagePullSecret) == 0 &&
+		len(values.Global.NodeSelector) == 0 &&
+		len(values.Global.Tolerations) == 0 &&
 		len(values.Global.ProxyConfig) == 0 &&
-		len(values.Global.ImageOverrides) == 0 {
+		len(values.Global.ImageOverrides) == 0 &&
+		len(values.Global.Resources) == 0 {
 		return "", nil
 	}
 
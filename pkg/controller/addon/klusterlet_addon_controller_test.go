@@ -5,24 +5,39 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/managedfields"
+	"k8s.io/apimachinery/pkg/util/managedfields/managedfieldstest"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/kube-openapi/pkg/validation/spec"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
+	clusterinfov1beta1 "github.com/stolostron/cluster-lifecycle-api/clusterinfo/v1beta1"
 	apiconstants "github.com/stolostron/cluster-lifecycle-api/constants"
 
 	"open-cluster-management.io/api/addon/v1alpha1"
 	mcv1 "open-cluster-management.io/api/cluster/v1"
+	workv1 "open-cluster-management.io/api/work/v1"
 
 	"github.com/stolostron/klusterlet-addon-controller/pkg/apis"
 	v1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
 	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+	"github.com/stolostron/klusterlet-addon-controller/version"
 )
 
 func validateValues(values, expectedValues string) error {
@@ -127,6 +142,179 @@ func Test_updateAnnotationValues(t *testing.T) {
 	}
 }
 
+// addonFieldManager tracks the managed fields of the managedClusterAddOns in the fake clients with the field manager
+// of the API server, so the server-side applies conflict as they do on the API server. The schema only defines the
+// fields written by this controller, the other fields are atomic.
+var addonFieldManager = func() *managedfields.FieldManager {
+	gvk := v1alpha1.GroupVersion.WithKind("ManagedClusterAddOn")
+	object := func(properties map[string]spec.Schema) spec.Schema {
+		return spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{"object"}, Properties: properties}}
+	}
+	addonSchema := object(map[string]spec.Schema{
+		"metadata": object(map[string]spec.Schema{
+			"name":        *spec.StringProperty(),
+			"namespace":   *spec.StringProperty(),
+			"labels":      *spec.MapProperty(spec.StringProperty()),
+			"annotations": *spec.MapProperty(spec.StringProperty()),
+		}),
+		"spec": object(map[string]spec.Schema{
+			"installNamespace": *spec.StringProperty(),
+		}),
+	})
+	addonSchema.AddExtension("x-kubernetes-group-version-kind", []interface{}{
+		map[string]interface{}{"group": gvk.Group, "version": gvk.Version, "kind": gvk.Kind},
+	})
+
+	typeConverter, err := managedfields.NewTypeConverter(map[string]*spec.Schema{
+		"io.open-cluster-management.addon.v1alpha1.ManagedClusterAddOn": &addonSchema,
+	}, true)
+	if err != nil {
+		panic(err)
+	}
+	return managedfieldstest.NewFakeFieldManager(typeConverter, gvk)
+}()
+
+// newFakeClient returns a fake client which serves the server-side apply of managedClusterAddOns with the field
+// manager of the API server, since the apply patches are not supported by the fake client. The applies conflict
+// with the fields of the existing managedClusterAddOns owned by the other field managers, see changedBy. The existing
+// managedClusterAddOns without managed fields are regarded as applied by this controller.
+func newFakeClient(scheme *runtime.Scheme, objs ...runtime.Object) client.Client {
+	return fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objs...).
+		WithStatusSubresource(&v1.KlusterletAddonConfig{}).
+		WithIndex(&v1alpha1.ManagedClusterAddOn{}, addonNameIndex, indexAddonName).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch,
+				opts ...client.PatchOption) error {
+				if patch.Type() != types.ApplyPatchType {
+					return c.Patch(ctx, obj, patch, opts...)
+				}
+
+				patchOpts := &client.PatchOptions{}
+				patchOpts.ApplyOptions(opts)
+				data, err := patch.Data(obj)
+				if err != nil {
+					return err
+				}
+				applied := &unstructured.Unstructured{}
+				if err := json.Unmarshal(data, &applied.Object); err != nil {
+					return err
+				}
+
+				live := &unstructured.Unstructured{}
+				live.SetGroupVersionKind(applied.GroupVersionKind())
+				existing := &v1alpha1.ManagedClusterAddOn{}
+				err = c.Get(ctx, types.NamespacedName{Namespace: applied.GetNamespace(), Name: applied.GetName()},
+					existing)
+				found := err == nil
+				switch {
+				case errors.IsNotFound(err):
+				case err != nil:
+					return err
+				default:
+					if len(existing.ManagedFields) == 0 {
+						existing = changedBy(nil, existing, fieldManager, true)
+					}
+					if live.Object, err = runtime.DefaultUnstructuredConverter.ToUnstructured(existing); err != nil {
+						return err
+					}
+				}
+
+				force := patchOpts.Force != nil && *patchOpts.Force
+				newObj, err := addonFieldManager.Apply(live, applied, patchOpts.FieldManager, force)
+				if err != nil {
+					return err
+				}
+				addon := &v1alpha1.ManagedClusterAddOn{}
+				if err := runtime.DefaultUnstructuredConverter.FromUnstructured(
+					newObj.(*unstructured.Unstructured).Object, addon); err != nil {
+					return err
+				}
+				if found {
+					return c.Update(ctx, addon)
+				}
+
+				// the addon agents are emulated to be available once the addons are created, so the
+				// dependent addons are not waiting for their prerequisites
+				meta.SetStatusCondition(&addon.Status.Conditions, metav1.Condition{
+					Type:   v1alpha1.ManagedClusterAddOnConditionAvailable,
+					Status: metav1.ConditionTrue,
+					Reason: "ManagedClusterAddOnLeaseUpdated",
+				})
+				return c.Create(ctx, addon)
+			},
+		}).Build()
+}
+
+// changedBy returns the managedClusterAddOn changed from the live one by the given field manager with an update or
+// an apply, like the managedClusterAddOns updated by the previous versions of this controller or by the users. A nil
+// live managedClusterAddOn means it is created.
+func changedBy(live, addon *v1alpha1.ManagedClusterAddOn, manager string, apply bool) *v1alpha1.ManagedClusterAddOn {
+	toUnstructured := func(addon *v1alpha1.ManagedClusterAddOn) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		if addon != nil {
+			data, err := runtime.DefaultUnstructuredConverter.ToUnstructured(addon)
+			if err != nil {
+				panic(err)
+			}
+			obj.Object = data
+		}
+		obj.SetGroupVersionKind(v1alpha1.GroupVersion.WithKind("ManagedClusterAddOn"))
+		return obj
+	}
+
+	var newObj runtime.Object
+	var err error
+	if apply {
+		newObj, err = addonFieldManager.Apply(toUnstructured(live), toUnstructured(addon), manager, true)
+	} else {
+		newObj, err = addonFieldManager.Update(toUnstructured(live), toUnstructured(addon), manager)
+	}
+	if err != nil {
+		panic(err)
+	}
+	changed := &v1alpha1.ManagedClusterAddOn{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(
+		newObj.(*unstructured.Unstructured).Object, changed); err != nil {
+		panic(err)
+	}
+	return changed
+}
+
+// newEditedSearchAddon returns the search addon with the install namespace edited by others
+func newEditedSearchAddon() *v1alpha1.ManagedClusterAddOn {
+	addon := newManagedClusterAddon(v1.SearchAddonName, "cluster1", addonHosting{})
+	addon.Spec.InstallNamespace = "edited"
+	return addon
+}
+
+// assertApplyConflict asserts the apply conflict condition of the klusterletAddonConfig cluster1, the search addon
+// keeps the install namespace edited by others if the conflict is reported
+func assertApplyConflict(t *testing.T, kubeClient client.Client, expected bool) {
+	config := &v1.KlusterletAddonConfig{}
+	if err := kubeClient.Get(context.TODO(), types.NamespacedName{Namespace: "cluster1", Name: "cluster1"},
+		config); err != nil {
+		t.Fatalf("failed to get klusterletaddonconfig. %v", err)
+	}
+	if conflict := meta.IsStatusConditionTrue(config.Status.Conditions,
+		v1.ManagedClusterAddOnApplyConflict); conflict != expected {
+		t.Errorf("expected conflict condition %v, but got %v", expected, config.Status.Conditions)
+	}
+
+	addonList := &v1alpha1.ManagedClusterAddOnList{}
+	if err := kubeClient.List(context.TODO(), addonList, &client.ListOptions{Namespace: "cluster1"}); err != nil {
+		t.Fatalf("faild to list addons. %v", err)
+	}
+	if len(addonList.Items) != 5 {
+		t.Errorf("expected 5 addons, but got %v", len(addonList.Items))
+	}
+	for _, addon := range addonList.Items {
+		if addon.Name == v1.SearchAddonName && (addon.Spec.InstallNamespace == "edited") != expected {
+			t.Errorf("expected the edited install namespace is kept %v, but got %s", expected,
+				addon.Spec.InstallNamespace)
+		}
+	}
+}
+
 func newKlusterletAddonConfig(clusterName string) *v1.KlusterletAddonConfig {
 	return &v1.KlusterletAddonConfig{
 		TypeMeta: metav1.TypeMeta{},
@@ -196,6 +384,7 @@ func Test_Reconcile(t *testing.T) {
 	_ = mcv1.AddToScheme(testscheme)
 	_ = v1alpha1.AddToScheme(testscheme)
 	_ = apis.AddToScheme(testscheme)
+	_ = workv1.AddToScheme(testscheme)
 
 	tests := []struct {
 		name                    string
@@ -243,7 +432,7 @@ func Test_Reconcile(t *testing.T) {
 				}
 
 				for _, addon := range addonList.Items {
-					if hostedAddOns.Has(addon.Name) {
+					if defaultHostedAddOns.Has(addon.Name) {
 						if value := addon.Annotations[common.AnnotationAddOnHostingClusterName]; value != "local-cluster" {
 							t.Errorf("expected hosting cluster of addon %q is %q, but got %s", addon.Name, "local-cluster", value)
 						}
@@ -318,7 +507,7 @@ func Test_Reconcile(t *testing.T) {
 			managedCluster:        newManagedCluster("cluster1", nil, nil),
 			klusterletAddonConfig: newKlusterletAddonConfigWithProxy("cluster1"),
 			managedClusterAddons: []runtime.Object{
-				newManagedClusterAddon(v1.ApplicationAddonName, "cluster1", ""),
+				newManagedClusterAddon(v1.ApplicationAddonName, "cluster1", addonHosting{}),
 			},
 			validateFunc: func(t *testing.T, kubeClient client.Client) {
 				addonList := &v1alpha1.ManagedClusterAddOnList{}
@@ -354,7 +543,7 @@ func Test_Reconcile(t *testing.T) {
 			managedCluster:        newManagedCluster("cluster1", nil, nil),
 			klusterletAddonConfig: newKlusterletAddonConfig("cluster1"),
 			managedClusterAddons: []runtime.Object{
-				newManagedClusterAddon(v1.IamPolicyAddonName, "cluster1", ""),
+				newManagedClusterAddon(v1.IamPolicyAddonName, "cluster1", addonHosting{}),
 			},
 			validateFunc: func(t *testing.T, kubeClient client.Client) {
 				addonList := &v1alpha1.ManagedClusterAddOnList{}
@@ -421,6 +610,329 @@ func Test_Reconcile(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:                  "values annotation is removed when there are no values",
+			clusterName:           "cluster1",
+			managedCluster:        newManagedCluster("cluster1", nil, nil),
+			klusterletAddonConfig: newKlusterletAddonConfig("cluster1"),
+			managedClusterAddons: []runtime.Object{
+				func() runtime.Object {
+					addon := newManagedClusterAddon(v1.SearchAddonName, "cluster1", addonHosting{})
+					addon.SetAnnotations(map[string]string{
+						annotationValues: `{"global":{"nodeSelector":{"infraNode":"true"}}}`,
+					})
+					applied := changedBy(nil, addon, fieldManager, true)
+					annotated := applied.DeepCopy()
+					annotated.Annotations["other"] = "value"
+					return changedBy(applied, annotated, "kubectl-annotate", false)
+				}(),
+			},
+			validateFunc: func(t *testing.T, kubeClient client.Client) {
+				addon := &v1alpha1.ManagedClusterAddOn{}
+				if err := kubeClient.Get(context.TODO(), types.NamespacedName{Namespace: "cluster1", Name: v1.SearchAddonName}, addon); err != nil {
+					t.Fatalf("failed to get addon. %v", err)
+				}
+				if _, ok := addon.Annotations[annotationValues]; ok {
+					t.Errorf("expected values annotation is removed")
+				}
+				if addon.Annotations["other"] != "value" {
+					t.Errorf("expected the annotation of others is kept")
+				}
+
+				// only the values of the existing addon are recorded as changed
+				config := &v1.KlusterletAddonConfig{}
+				if err := kubeClient.Get(context.TODO(), types.NamespacedName{Namespace: "cluster1", Name: "cluster1"}, config); err != nil {
+					t.Fatalf("failed to get klusterletaddonconfig. %v", err)
+				}
+				if len(config.Status.History) != 1 {
+					t.Fatalf("expected 1 record in the history, but got %v", config.Status.History)
+				}
+				record := config.Status.History[0]
+				if record.Action != v1.ChangeActionValuesChanged || record.AddonName != v1.SearchAddonName ||
+					record.Source != changeSource {
+					t.Errorf("unexpected record %v", record)
+				}
+			},
+		},
+		{
+			name:                  "conflicts are reported in status",
+			clusterName:           "cluster1",
+			managedCluster:        newManagedCluster("cluster1", nil, nil),
+			klusterletAddonConfig: newKlusterletAddonConfig("cluster1"),
+			managedClusterAddons: []runtime.Object{
+				changedBy(nil, newEditedSearchAddon(), "kubectl-edit", false),
+			},
+			validateFunc: func(t *testing.T, kubeClient client.Client) {
+				assertApplyConflict(t, kubeClient, true)
+			},
+		},
+		{
+			name:                  "conflicts with the legacy field manager are not forced after the migration",
+			clusterName:           "cluster1",
+			managedCluster:        newManagedCluster("cluster1", nil, nil),
+			klusterletAddonConfig: newKlusterletAddonConfig("cluster1"),
+			managedClusterAddons: []runtime.Object{
+				// the addon is applied by this controller, and then changed by another controller-runtime binary
+				// with the default field manager
+				changedBy(
+					changedBy(nil, newManagedClusterAddon(v1.SearchAddonName, "cluster1", addonHosting{}),
+						fieldManager, true),
+					newEditedSearchAddon(), legacyFieldManager, false),
+			},
+			validateFunc: func(t *testing.T, kubeClient client.Client) {
+				assertApplyConflict(t, kubeClient, true)
+			},
+		},
+		{
+			name:                  "the fields of the legacy field manager are migrated once",
+			clusterName:           "cluster1",
+			managedCluster:        newManagedCluster("cluster1", nil, nil),
+			klusterletAddonConfig: newKlusterletAddonConfig("cluster1"),
+			managedClusterAddons: []runtime.Object{
+				changedBy(nil, func() *v1alpha1.ManagedClusterAddOn {
+					addon := newManagedClusterAddon(v1.SearchAddonName, "cluster1", addonHosting{})
+					addon.Annotations = map[string]string{annotationValues: `{"global":{"imagePullPolicy":"Never"}}`}
+					return addon
+				}(), legacyFieldManager, false),
+			},
+			validateFunc: func(t *testing.T, kubeClient client.Client) {
+				assertApplyConflict(t, kubeClient, false)
+
+				addon := &v1alpha1.ManagedClusterAddOn{}
+				if err := kubeClient.Get(context.TODO(),
+					types.NamespacedName{Namespace: "cluster1", Name: v1.SearchAddonName}, addon); err != nil {
+					t.Fatalf("failed to get addon: %v", err)
+				}
+				if strings.Contains(addon.Annotations[annotationValues], "Never") {
+					t.Errorf("expected the legacy values are removed, but got %s", addon.Annotations[annotationValues])
+				}
+				for _, entry := range addon.ManagedFields {
+					if entry.Manager != fieldManager || entry.Operation != metav1.ManagedFieldsOperationApply {
+						t.Errorf("expected the fields are migrated to %s, but got %v", fieldManager, addon.ManagedFields)
+					}
+				}
+			},
+		},
+		{
+			name:           "addon is paused",
+			clusterName:    "cluster1",
+			managedCluster: newManagedCluster("cluster1", nil, nil),
+			klusterletAddonConfig: func() *v1.KlusterletAddonConfig {
+				config := newKlusterletAddonConfig("cluster1")
+				config.Spec.SearchCollectorConfig = v1.KlusterletAddonAgentConfigSpec{Enabled: false, Paused: true}
+				config.Spec.ApplicationManagerConfig.Paused = true
+				return config
+			}(),
+			managedClusterAddons: []runtime.Object{
+				newManagedClusterAddon(v1.SearchAddonName, "cluster1", addonHosting{}),
+			},
+			validateFunc: func(t *testing.T, kubeClient client.Client) {
+				addonList := &v1alpha1.ManagedClusterAddOnList{}
+				if err := kubeClient.List(context.TODO(), addonList, &client.ListOptions{Namespace: "cluster1"}); err != nil {
+					t.Errorf("faild to list addons. %v", err)
+				}
+				// the paused search-collector is not deleted, and the paused application-manager is not created
+				addons := sets.New[string]()
+				for _, addon := range addonList.Items {
+					addons.Insert(addon.Name)
+				}
+				if !addons.Has(v1.SearchAddonName) || addons.Has(v1.ApplicationAddonName) || addons.Len() != 4 {
+					t.Errorf("unexpected addons %v", sets.List(addons))
+				}
+
+				config := &v1.KlusterletAddonConfig{}
+				if err := kubeClient.Get(context.TODO(), types.NamespacedName{Namespace: "cluster1", Name: "cluster1"}, config); err != nil {
+					t.Fatalf("failed to get klusterletaddonconfig. %v", err)
+				}
+				condition := meta.FindStatusCondition(config.Status.Conditions, v1.AddonsPaused)
+				if condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != v1.ReasonAddonsPaused {
+					t.Fatalf("expected paused condition is true, but got %v", condition)
+				}
+				if condition.Message != "The addons are paused: application-manager, search-collector" {
+					t.Errorf("unexpected message %q", condition.Message)
+				}
+			},
+		},
+		{
+			name:           "klusterletaddonconfig is paused by spec",
+			clusterName:    "cluster1",
+			managedCluster: newManagedCluster("cluster1", nil, nil),
+			klusterletAddonConfig: func() *v1.KlusterletAddonConfig {
+				config := newKlusterletAddonConfig("cluster1")
+				config.Spec.Paused = true
+				return config
+			}(),
+			validateFunc: func(t *testing.T, kubeClient client.Client) {
+				addonList := &v1alpha1.ManagedClusterAddOnList{}
+				if err := kubeClient.List(context.TODO(), addonList, &client.ListOptions{Namespace: "cluster1"}); err != nil {
+					t.Errorf("faild to list addons. %v", err)
+				}
+				if len(addonList.Items) != 0 {
+					t.Errorf("expected 0 addons, but got %v", len(addonList.Items))
+				}
+
+				config := &v1.KlusterletAddonConfig{}
+				if err := kubeClient.Get(context.TODO(), types.NamespacedName{Namespace: "cluster1", Name: "cluster1"}, config); err != nil {
+					t.Fatalf("failed to get klusterletaddonconfig. %v", err)
+				}
+				condition := meta.FindStatusCondition(config.Status.Conditions, v1.AddonsPaused)
+				if condition == nil || condition.Reason != v1.ReasonKlusterletAddonConfigPaused {
+					t.Errorf("expected paused condition with reason %s, but got %v", v1.ReasonKlusterletAddonConfigPaused, condition)
+				}
+			},
+		},
+		{
+			name:           "klusterletaddonconfig is paused by annotation",
+			clusterName:    "cluster1",
+			managedCluster: newManagedCluster("cluster1", nil, nil),
+			klusterletAddonConfig: func() *v1.KlusterletAddonConfig {
+				config := newKlusterletAddonConfig("cluster1")
+				config.SetAnnotations(map[string]string{klusterletAddonConfigAnnotationPause: "true"})
+				return config
+			}(),
+			validateFunc: func(t *testing.T, kubeClient client.Client) {
+				addonList := &v1alpha1.ManagedClusterAddOnList{}
+				if err := kubeClient.List(context.TODO(), addonList, &client.ListOptions{Namespace: "cluster1"}); err != nil {
+					t.Errorf("faild to list addons. %v", err)
+				}
+				if len(addonList.Items) != 0 {
+					t.Errorf("expected 0 addons, but got %v", len(addonList.Items))
+				}
+			},
+		},
+		{
+			name:        "cluster with node placement annotation",
+			clusterName: "cluster1",
+			managedCluster: newManagedCluster("cluster1", nil, map[string]string{
+				common.AnnotationNodePlacement: `{"nodeSelector":{"node":"infra"},"tolerations":[{"key":"infra","operator":"Exists","effect":"NoSchedule"}]}`,
+			}),
+			klusterletAddonConfig: func() *v1.KlusterletAddonConfig {
+				config := newKlusterletAddonConfig("cluster1")
+				config.Spec.SearchCollectorConfig.NodePlacement = &v1.NodePlacement{
+					NodeSelector: map[string]string{"node": "search"},
+				}
+				return config
+			}(),
+			validateFunc: func(t *testing.T, kubeClient client.Client) {
+				addonList := &v1alpha1.ManagedClusterAddOnList{}
+				if err := kubeClient.List(context.TODO(), addonList, &client.ListOptions{Namespace: "cluster1"}); err != nil {
+					t.Errorf("faild to list addons. %v", err)
+				}
+				if len(addonList.Items) != 5 {
+					t.Errorf("expected 5 addons, but got %v", len(addonList.Items))
+				}
+				for _, addon := range addonList.Items {
+					gv := globalValues{}
+					if err := json.Unmarshal([]byte(addon.Annotations[annotationValues]), &gv); err != nil {
+						t.Errorf("failed to Unmarshal gv annotation of addon %s", addon.Name)
+					}
+					expectedNode := "infra"
+					if addon.Name == v1.SearchAddonName {
+						expectedNode = "search"
+					}
+					if gv.Global.NodeSelector["node"] != expectedNode {
+						t.Errorf("expected nodeSelector %s of addon %s, but got %v", expectedNode, addon.Name, gv.Global.NodeSelector)
+					}
+					if len(gv.Global.Tolerations) != 1 || gv.Global.Tolerations[0].Key != "infra" {
+						t.Errorf("expected tolerations of addon %s, but got %v", addon.Name, gv.Global.Tolerations)
+					}
+				}
+			},
+		},
+		{
+			name:           "addon image overrides",
+			clusterName:    "cluster1",
+			managedCluster: newManagedCluster("cluster1", nil, nil),
+			klusterletAddonConfig: func() *v1.KlusterletAddonConfig {
+				config := newKlusterletAddonConfig("cluster1")
+				config.Spec.SearchCollectorConfig.ImageOverrides = map[string]string{
+					"search_collector":       "quay.io/stolostron/search-collector@sha256:abc",
+					"unknown_image_key":      "quay.io/stolostron/unknown:latest",
+					"cert_policy_controller": "quay.io/stolostron/cert-policy-controller:latest",
+				}
+				return config
+			}(),
+			validateFunc: func(t *testing.T, kubeClient client.Client) {
+				addon := &v1alpha1.ManagedClusterAddOn{}
+				if err := kubeClient.Get(context.TODO(), types.NamespacedName{Namespace: "cluster1", Name: v1.SearchAddonName}, addon); err != nil {
+					t.Fatalf("failed to get addon. %v", err)
+				}
+				gv := globalValues{}
+				if err := json.Unmarshal([]byte(addon.Annotations[annotationValues]), &gv); err != nil {
+					t.Fatalf("failed to Unmarshal gv annotation of addon %s", addon.Name)
+				}
+				expectedImages := map[string]string{"search_collector": "quay.io/stolostron/search-collector@sha256:abc"}
+				if !reflect.DeepEqual(gv.Global.ImageOverrides, expectedImages) {
+					t.Errorf("expected image overrides %v, but got %v", expectedImages, gv.Global.ImageOverrides)
+				}
+
+				config := &v1.KlusterletAddonConfig{}
+				if err := kubeClient.Get(context.TODO(), types.NamespacedName{Namespace: "cluster1", Name: "cluster1"}, config); err != nil {
+					t.Fatalf("failed to get klusterletaddonconfig. %v", err)
+				}
+				expectedStatus := []v1.AddonImages{{AddonName: v1.SearchAddonName, Images: expectedImages}}
+				if !reflect.DeepEqual(config.Status.AddonImages, expectedStatus) {
+					t.Errorf("expected addon images %v, but got %v", expectedStatus, config.Status.AddonImages)
+				}
+			},
+		},
+		{
+			name:           "addon resources",
+			clusterName:    "cluster1",
+			managedCluster: newManagedCluster("cluster1", nil, nil),
+			klusterletAddonConfig: func() *v1.KlusterletAddonConfig {
+				config := newKlusterletAddonConfig("cluster1")
+				config.Spec.SearchCollectorConfig.Resources = []v1.ContainerResourceRequirements{
+					{
+						Name:     "collector",
+						Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("512Mi")},
+						Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")},
+					},
+				}
+				config.Spec.ApplicationManagerConfig.Resources = []v1.ContainerResourceRequirements{
+					{
+						Name:     "*",
+						Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")},
+						Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+					},
+				}
+				return config
+			}(),
+			validateFunc: func(t *testing.T, kubeClient client.Client) {
+				addonList := &v1alpha1.ManagedClusterAddOnList{}
+				if err := kubeClient.List(context.TODO(), addonList, &client.ListOptions{Namespace: "cluster1"}); err != nil {
+					t.Errorf("faild to list addons. %v", err)
+				}
+				// the application-manager with invalid resources is not created
+				addons := sets.New[string]()
+				for _, addon := range addonList.Items {
+					addons.Insert(addon.Name)
+					if addon.Name != v1.SearchAddonName {
+						continue
+					}
+					gv := globalValues{}
+					if err := json.Unmarshal([]byte(addon.Annotations[annotationValues]), &gv); err != nil {
+						t.Fatalf("failed to Unmarshal gv annotation of addon %s", addon.Name)
+					}
+					if len(gv.Global.Resources) != 1 || gv.Global.Resources[0].Name != "collector" ||
+						gv.Global.Resources[0].Limits.Memory().String() != "512Mi" {
+						t.Errorf("unexpected resources %v", gv.Global.Resources)
+					}
+				}
+				if addons.Has(v1.ApplicationAddonName) || addons.Len() != 4 {
+					t.Errorf("unexpected addons %v", sets.List(addons))
+				}
+
+				config := &v1.KlusterletAddonConfig{}
+				if err := kubeClient.Get(context.TODO(), types.NamespacedName{Namespace: "cluster1", Name: "cluster1"}, config); err != nil {
+					t.Fatalf("failed to get klusterletaddonconfig. %v", err)
+				}
+				condition := meta.FindStatusCondition(config.Status.Conditions, v1.AddonResourcesInvalid)
+				if condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != v1.ReasonAddonResourcesInvalid {
+					t.Fatalf("expected invalid resources condition is true, but got %v", condition)
+				}
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -439,8 +951,10 @@ func Test_Reconcile(t *testing.T) {
 				objs = append(objs, tt.clusterManagementAddons...)
 			}
 
+			kubeClient := newFakeClient(testscheme, objs...)
 			reconciler := &ReconcileKlusterletAddOn{
-				client: fake.NewClientBuilder().WithScheme(testscheme).WithRuntimeObjects(objs...).Build(),
+				client:    kubeClient,
+				apiReader: kubeClient,
 			}
 			request := reconcile.Request{
 				NamespacedName: types.NamespacedName{
@@ -461,3 +975,346 @@ func Test_Reconcile(t *testing.T) {
 		})
 	}
 }
+
+func Test_ReconcileConcurrently(t *testing.T) {
+	testscheme := scheme.Scheme
+	_ = mcv1.AddToScheme(testscheme)
+	_ = v1alpha1.AddToScheme(testscheme)
+	_ = apis.AddToScheme(testscheme)
+	_ = workv1.AddToScheme(testscheme)
+
+	const clusterCount = 3000
+	const workers = 16
+
+	objs := []runtime.Object{}
+	for i := 0; i < clusterCount; i++ {
+		clusterName := fmt.Sprintf("cluster%d", i)
+		objs = append(objs, newManagedCluster(clusterName, nil, nil), newKlusterletAddonConfigWithProxy(clusterName))
+	}
+	reconciler := &ReconcileKlusterletAddOn{
+		client: newFakeClient(testscheme, objs...),
+	}
+
+	requests := make(chan reconcile.Request, clusterCount)
+	for i := 0; i < clusterCount; i++ {
+		clusterName := fmt.Sprintf("cluster%d", i)
+		requests <- reconcile.Request{NamespacedName: types.NamespacedName{Name: clusterName, Namespace: clusterName}}
+	}
+	close(requests)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, clusterCount)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for request := range requests {
+				if _, err := reconciler.Reconcile(context.TODO(), request); err != nil {
+					errs <- fmt.Errorf("failed to reconcile %s: %v", request.Name, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	addonList := &v1alpha1.ManagedClusterAddOnList{}
+	if err := reconciler.client.List(context.TODO(), addonList); err != nil {
+		t.Fatalf("faild to list addons. %v", err)
+	}
+	if len(addonList.Items) != 5*clusterCount {
+		t.Errorf("expected %v addons, but got %v", 5*clusterCount, len(addonList.Items))
+	}
+}
+
+func Test_getNodePlacement(t *testing.T) {
+	clusterTolerations := []corev1.Toleration{{Key: "cluster", Operator: corev1.TolerationOpExists}}
+	configTolerations := []corev1.Toleration{{Key: "config", Operator: corev1.TolerationOpExists}}
+
+	cases := []struct {
+		name                 string
+		addonName            string
+		configNodePlacement  *v1.NodePlacement
+		addonNodePlacement   *v1.NodePlacement
+		clusterNodePlacement v1.NodePlacement
+		expected             v1.NodePlacement
+	}{
+		{
+			name:                 "cluster node placement",
+			addonName:            v1.SearchAddonName,
+			clusterNodePlacement: v1.NodePlacement{NodeSelector: map[string]string{"node": "cluster"}, Tolerations: clusterTolerations},
+			expected:             v1.NodePlacement{NodeSelector: map[string]string{"node": "cluster"}, Tolerations: clusterTolerations},
+		},
+		{
+			name:                 "config overrides tolerations only",
+			addonName:            v1.SearchAddonName,
+			configNodePlacement:  &v1.NodePlacement{Tolerations: configTolerations},
+			clusterNodePlacement: v1.NodePlacement{NodeSelector: map[string]string{"node": "cluster"}, Tolerations: clusterTolerations},
+			expected:             v1.NodePlacement{NodeSelector: map[string]string{"node": "cluster"}, Tolerations: configTolerations},
+		},
+		{
+			name:                "addon overrides config",
+			addonName:           v1.SearchAddonName,
+			configNodePlacement: &v1.NodePlacement{NodeSelector: map[string]string{"node": "config"}, Tolerations: configTolerations},
+			addonNodePlacement:  &v1.NodePlacement{NodeSelector: map[string]string{"node": "addon"}},
+			expected:            v1.NodePlacement{NodeSelector: map[string]string{"node": "addon"}, Tolerations: configTolerations},
+		},
+		{
+			name:                "addon node placement does not apply to other addons",
+			addonName:           v1.ApplicationAddonName,
+			configNodePlacement: &v1.NodePlacement{NodeSelector: map[string]string{"node": "config"}},
+			addonNodePlacement:  &v1.NodePlacement{NodeSelector: map[string]string{"node": "addon"}},
+			expected:            v1.NodePlacement{NodeSelector: map[string]string{"node": "config"}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := newKlusterletAddonConfig("cluster1")
+			config.Spec.NodePlacement = c.configNodePlacement
+			config.Spec.SearchCollectorConfig.NodePlacement = c.addonNodePlacement
+			actual := getNodePlacement(c.addonName, config, c.clusterNodePlacement)
+			if !reflect.DeepEqual(actual, c.expected) {
+				t.Errorf("expected %v, but got %v", c.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_validateResources(t *testing.T) {
+	cases := []struct {
+		name      string
+		resources []v1.ContainerResourceRequirements
+		expectErr bool
+	}{
+		{
+			name: "no resources",
+		},
+		{
+			name: "valid resources",
+			resources: []v1.ContainerResourceRequirements{
+				{
+					Name:     "*",
+					Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m")},
+				},
+				{
+					Name:     "collector",
+					Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi"), "hugepages-2Mi": resource.MustParse("2Mi")},
+					Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("512Mi")},
+				},
+			},
+		},
+		{
+			name:      "invalid container name",
+			resources: []v1.ContainerResourceRequirements{{Name: "Collector"}},
+			expectErr: true,
+		},
+		{
+			name:      "duplicated container name",
+			resources: []v1.ContainerResourceRequirements{{Name: "collector"}, {Name: "collector"}},
+			expectErr: true,
+		},
+		{
+			name: "unsupported resource",
+			resources: []v1.ContainerResourceRequirements{
+				{Name: "collector", Limits: corev1.ResourceList{"gpu": resource.MustParse("1")}},
+			},
+			expectErr: true,
+		},
+		{
+			name: "negative quantity",
+			resources: []v1.ContainerResourceRequirements{
+				{Name: "collector", Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("-1")}},
+			},
+			expectErr: true,
+		},
+		{
+			name: "request exceeds limit",
+			resources: []v1.ContainerResourceRequirements{
+				{
+					Name:     "collector",
+					Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")},
+					Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+				},
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := validateResources(c.resources)
+			if c.expectErr && err == nil {
+				t.Errorf("expected error, but got nil")
+			}
+			if !c.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func Test_mergeImageOverrides(t *testing.T) {
+	cases := []struct {
+		name           string
+		addonName      string
+		imageOverrides map[string]string
+		addonOverrides map[string]string
+		expected       map[string]string
+	}{
+		{
+			name:           "no addon overrides",
+			addonName:      v1.PolicyFrameworkAddonName,
+			imageOverrides: map[string]string{"kube_rbac_proxy": "registry.example.com/kube-rbac-proxy:v1"},
+			expected:       map[string]string{"kube_rbac_proxy": "registry.example.com/kube-rbac-proxy:v1"},
+		},
+		{
+			name:      "addon overrides are merged over the registry overrides",
+			addonName: v1.PolicyFrameworkAddonName,
+			imageOverrides: map[string]string{
+				"kube_rbac_proxy":                   "registry.example.com/kube-rbac-proxy:v1",
+				"governance_policy_framework_addon": "registry.example.com/framework:v1",
+			},
+			addonOverrides: map[string]string{"governance_policy_framework_addon": "registry.example.com/framework@sha256:abc"},
+			expected: map[string]string{
+				"kube_rbac_proxy":                   "registry.example.com/kube-rbac-proxy:v1",
+				"governance_policy_framework_addon": "registry.example.com/framework@sha256:abc",
+			},
+		},
+		{
+			name:           "the image keys of other addons are ignored",
+			addonName:      v1.PolicyFrameworkAddonName,
+			imageOverrides: map[string]string{},
+			addonOverrides: map[string]string{"config_policy_controller": "registry.example.com/config-policy:v1"},
+			expected:       map[string]string{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := newKlusterletAddonConfig("cluster1")
+			config.Spec.PolicyController.ImageOverrides = c.addonOverrides
+			actual := mergeImageOverrides(c.addonName, config, c.imageOverrides)
+			if !reflect.DeepEqual(actual, c.expected) {
+				t.Errorf("expected %v, but got %v", c.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_ReconcileArchitecture(t *testing.T) {
+	testscheme := scheme.Scheme
+	_ = mcv1.AddToScheme(testscheme)
+	_ = v1alpha1.AddToScheme(testscheme)
+	_ = apis.AddToScheme(testscheme)
+	_ = workv1.AddToScheme(testscheme)
+	_ = clusterinfov1beta1.AddToScheme(testscheme)
+
+	version.Version = "x.y.z"
+	imageManifest := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "image-manifest-x.y.z",
+			Namespace: "open-cluster-management",
+			Labels: map[string]string{
+				"ocm-configmap-type":  "image-manifest",
+				"ocm-release-version": "x.y.z",
+			},
+		},
+		Data: map[string]string{
+			"search_collector":             "registry.example.com/search-collector@sha256:multi-arch",
+			"search_collector.arm64":       "registry.example.com/search-collector@sha256:arm64",
+			"cert_policy_controller.amd64": "registry.example.com/cert-policy-controller@sha256:amd64",
+		},
+	}
+	if err := v1.LoadImages(fake.NewClientBuilder().WithObjects(imageManifest).Build()); err != nil {
+		t.Fatalf("failed to load images: %v", err)
+	}
+	defer func() {
+		_ = v1.LoadImages(fake.NewClientBuilder().Build())
+	}()
+
+	cases := []struct {
+		name                 string
+		managedCluster       *mcv1.ManagedCluster
+		clusterInfo          *clusterinfov1beta1.ManagedClusterInfo
+		expectedSearchImage  string
+		expectedUnsupported  bool
+		expectedCertDeployed bool
+	}{
+		{
+			name: "architecture from cluster claim",
+			managedCluster: func() *mcv1.ManagedCluster {
+				cluster := newManagedCluster("cluster1", nil, nil)
+				cluster.Status.ClusterClaims = []mcv1.ManagedClusterClaim{
+					{Name: common.ClusterClaimArchitecture, Value: "arm64"},
+				}
+				return cluster
+			}(),
+			expectedSearchImage: "registry.example.com/search-collector@sha256:arm64",
+			expectedUnsupported: true,
+		},
+		{
+			name:           "architecture from node info",
+			managedCluster: newManagedCluster("cluster1", nil, nil),
+			clusterInfo: &clusterinfov1beta1.ManagedClusterInfo{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster1", Namespace: "cluster1"},
+				Status: clusterinfov1beta1.ClusterInfoStatus{
+					NodeList: []clusterinfov1beta1.NodeStatus{
+						{Name: "node1", Labels: map[string]string{corev1.LabelArchStable: "amd64"}},
+					},
+				},
+			},
+			expectedSearchImage:  "registry.example.com/search-collector@sha256:multi-arch",
+			expectedCertDeployed: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			objs := []runtime.Object{c.managedCluster, newKlusterletAddonConfig("cluster1")}
+			if c.clusterInfo != nil {
+				objs = append(objs, c.clusterInfo)
+			}
+			kubeClient := newFakeClient(testscheme, objs...)
+			reconciler := &ReconcileKlusterletAddOn{client: kubeClient}
+
+			_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: "cluster1", Name: "cluster1"},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			addon := &v1alpha1.ManagedClusterAddOn{}
+			if err := kubeClient.Get(context.TODO(),
+				types.NamespacedName{Namespace: "cluster1", Name: v1.SearchAddonName}, addon); err != nil {
+				t.Fatalf("failed to get addon: %v", err)
+			}
+			gv := globalValues{}
+			if err := json.Unmarshal([]byte(addon.Annotations[annotationValues]), &gv); err != nil {
+				t.Fatalf("failed to unmarshal values: %v", err)
+			}
+			if image := gv.Global.ImageOverrides["search_collector"]; image != c.expectedSearchImage {
+				t.Errorf("expected search image %s, but got %s", c.expectedSearchImage, image)
+			}
+
+			err = kubeClient.Get(context.TODO(),
+				types.NamespacedName{Namespace: "cluster1", Name: v1.CertPolicyAddonName}, addon)
+			if c.expectedCertDeployed != (err == nil) {
+				t.Errorf("expected cert policy addon deployed %v, but got %v", c.expectedCertDeployed, err)
+			}
+
+			config := &v1.KlusterletAddonConfig{}
+			if err := kubeClient.Get(context.TODO(), types.NamespacedName{Namespace: "cluster1", Name: "cluster1"}, config); err != nil {
+				t.Fatalf("failed to get klusterletaddonconfig. %v", err)
+			}
+			condition := meta.FindStatusCondition(config.Status.Conditions, v1.AddonArchitectureUnsupported)
+			if c.expectedUnsupported != (condition != nil && condition.Status == metav1.ConditionTrue) {
+				t.Errorf("unexpected architecture condition %v", condition)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addon
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+)
+
+const (
+	// the changes of the addons held outside the maintenance window
+	pendingChangeEnable       = "enable"
+	pendingChangeDisable      = "disable"
+	pendingChangeUpdateValues = "update values"
+	pendingChangeMigrateMode  = "migrate mode"
+
+	// maxMaintenanceWindowDuration is the maximum duration of a maintenance window
+	maxMaintenanceWindowDuration = 7 * 24 * time.Hour
+)
+
+// cronField is the values of a field of a cron expression which are matched
+type cronField map[int]bool
+
+// cronSchedule is a parsed cron expression with the five fields minute, hour, day of month, month and day of week
+type cronSchedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek cronField
+	// anyDayOfMonth and anyDayOfWeek are true if the day fields start with "*", like "*" or "*/2". A day matches
+	// both day fields if either of them starts with "*", otherwise it matches either of them, as in vixie cron.
+	anyDayOfMonth, anyDayOfWeek bool
+}
+
+// parseCronSchedule parses the cron expression, each field is "*" or a comma separated list of the values, the
+// ranges like 1-5, and the steps like */15 or 1-5/2. Sunday is 0 or 7 in the day of week field.
+func parseCronSchedule(expression string) (*cronSchedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields, but got %d", expression, len(fields))
+	}
+
+	bounds := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	parsed := make([]cronField, 5)
+	for i, field := range fields {
+		values, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %v", expression, err)
+		}
+		parsed[i] = values
+	}
+	if parsed[4][7] {
+		parsed[4][0] = true
+	}
+
+	return &cronSchedule{
+		minute:        parsed[0],
+		hour:          parsed[1],
+		dayOfMonth:    parsed[2],
+		month:         parsed[3],
+		dayOfWeek:     parsed[4],
+		anyDayOfMonth: strings.HasPrefix(fields[2], "*"),
+		anyDayOfWeek:  strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+func parseCronField(field string, min, max int) (cronField, error) {
+	values := cronField{}
+	for _, item := range strings.Split(field, ",") {
+		rangePart, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", item)
+			}
+			rangePart = item[:i]
+		}
+
+		start, end := min, max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err1, err2 error
+			start, err1 = strconv.Atoi(bounds[0])
+			end, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("invalid range %q", item)
+			}
+		default:
+			value, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", item)
+			}
+			start, end = value, value
+			if step != 1 {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return nil, fmt.Errorf("%q is out of range %d-%d", item, min, max)
+		}
+		for value := start; value <= end; value += step {
+			values[value] = true
+		}
+	}
+	return values, nil
+}
+
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	dayOfMonth, dayOfWeek := s.dayOfMonth[t.Day()], s.dayOfWeek[int(t.Weekday())]
+	if s.anyDayOfMonth || s.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// next returns the first time after the given time which matches the schedule, the zero time is returned if no
+// time matches the schedule in five years
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	yearLimit := t.Year() + 5
+	for t.Year() <= yearLimit {
+		year, month, day := t.Date()
+		switch {
+		case !s.month[int(month)]:
+			t = time.Date(year, month+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(year, month, day+1, 0, 0, 0, 0, t.Location())
+		case !s.hour[t.Hour()]:
+			t = time.Date(year, month, day, t.Hour()+1, 0, 0, 0, t.Location())
+		case !s.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// maintenanceWindow is the parsed maintenance window of a managed cluster
+type maintenanceWindow struct {
+	schedule *cronSchedule
+	duration time.Duration
+	location *time.Location
+	// unavailable is the reason why the window of the managed cluster is not found or invalid, the window never
+	// opens until it is fixed, so the changes are never applied outside the window
+	unavailable string
+}
+
+func newMaintenanceWindow(window *agentv1.MaintenanceWindow) (*maintenanceWindow, error) {
+	schedule, err := parseCronSchedule(window.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	if window.Duration.Duration <= 0 || window.Duration.Duration > maxMaintenanceWindowDuration {
+		return nil, fmt.Errorf("invalid duration %v: it must be positive and at most %v", window.Duration.Duration,
+			maxMaintenanceWindowDuration)
+	}
+	location := time.UTC
+	if len(window.TimeZone) != 0 {
+		if location, err = time.LoadLocation(window.TimeZone); err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %v", window.TimeZone, err)
+		}
+	}
+	return &maintenanceWindow{schedule: schedule, duration: window.Duration.Duration, location: location}, nil
+}
+
+// isOpen returns true if the given time is inside a window, otherwise the start of the next window is returned.
+// The changes are never held if there is no maintenance window.
+func (w *maintenanceWindow) isOpen(now time.Time) (bool, time.Time) {
+	if w == nil {
+		return true, time.Time{}
+	}
+	if len(w.unavailable) != 0 {
+		return false, time.Time{}
+	}
+
+	now = now.In(w.location)
+	start := w.schedule.next(now.Add(-w.duration).Add(-time.Minute))
+	for !start.IsZero() && !start.After(now) {
+		if start.Add(w.duration).After(now) {
+			return true, time.Time{}
+		}
+		start = w.schedule.next(start)
+	}
+	return false, start
+}
+
+// getMaintenanceWindow returns the maintenance window of the klusterletAddonConfig, or the window named by the
+// label of the managed cluster in the maintenance windows ConfigMap. nil is returned if there is no window. The
+// window is unavailable if it is not found or invalid, and the error is only returned if the ConfigMap cannot be read.
+func (r *ReconcileKlusterletAddOn) getMaintenanceWindow(ctx context.Context, config *agentv1.KlusterletAddonConfig,
+	cluster *mcv1.ManagedCluster) (*maintenanceWindow, error) {
+	if config.Spec.MaintenanceWindow != nil {
+		window, err := newMaintenanceWindow(config.Spec.MaintenanceWindow)
+		if err != nil {
+			return newUnavailableWindow("the maintenance window of klusterletAddonConfig %s is invalid: %v",
+				config.Name, err), nil
+		}
+		return window, nil
+	}
+
+	name := cluster.Labels[common.LabelMaintenanceWindow]
+	if len(name) == 0 {
+		return nil, nil
+	}
+	if len(r.maintenanceWindowsConfigMap.Name) == 0 {
+		return newUnavailableWindow("the maintenance window %q of cluster %s is not found: "+
+			"no maintenance windows ConfigMap", name, cluster.Name), nil
+	}
+	cm := &corev1.ConfigMap{}
+	err := r.client.Get(ctx, r.maintenanceWindowsConfigMap, cm)
+	if errors.IsNotFound(err) {
+		return newUnavailableWindow("the maintenance window %q of cluster %s is not found: ConfigMap %s is not found",
+			name, cluster.Name, r.maintenanceWindowsConfigMap), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the maintenance windows ConfigMap: %v", err)
+	}
+	data, ok := cm.Data[name]
+	if !ok {
+		return newUnavailableWindow("the maintenance window %q of cluster %s is not found in ConfigMap %s", name,
+			cluster.Name, r.maintenanceWindowsConfigMap), nil
+	}
+
+	windowConfig := &agentv1.MaintenanceWindow{}
+	if err := yaml.Unmarshal([]byte(data), windowConfig); err != nil {
+		return newUnavailableWindow("the maintenance window %q is invalid: %v", name, err), nil
+	}
+	window, err := newMaintenanceWindow(windowConfig)
+	if err != nil {
+		return newUnavailableWindow("the maintenance window %q is invalid: %v", name, err), nil
+	}
+	return window, nil
+}
+
+// newUnavailableWindow returns the window which never opens, the reason is reported by the condition of the held
+// changes
+func newUnavailableWindow(format string, args ...interface{}) *maintenanceWindow {
+	return &maintenanceWindow{unavailable: fmt.Sprintf(format, args...)}
+}
+
+// isMaintenanceWindowOverridden returns true if the changes are applied outside the maintenance window
+func isMaintenanceWindowOverridden(config *agentv1.KlusterletAddonConfig) bool {
+	return strings.EqualFold(config.Annotations[common.AnnotationMaintenanceWindowOverride], "true")
+}
+
+// newMaintenanceWindowCondition returns the condition which reports the changes of the addons held until the
+// maintenance window opens, nil is returned if no changes are held and the condition is not set before.
+func newMaintenanceWindowCondition(config *agentv1.KlusterletAddonConfig, pendingChanges map[string]string,
+	window *maintenanceWindow, nextWindow time.Time) *metav1.Condition {
+	trueMessage := "The changes of the addons are held until the maintenance window opens"
+	switch {
+	case window != nil && len(window.unavailable) != 0:
+		trueMessage = fmt.Sprintf("The changes of the addons are held because %s", window.unavailable)
+	case !nextWindow.IsZero():
+		trueMessage = fmt.Sprintf("%s at %s", trueMessage, nextWindow.Format(time.RFC3339))
+	}
+	return newAddonsCondition(config, agentv1.AddonChangesPending, pendingChanges,
+		agentv1.ReasonOutsideMaintenanceWindow, trueMessage,
+		agentv1.ReasonNoPendingChanges, "No changes of the addons are held.")
+}
@@ -0,0 +1,401 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addon
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"open-cluster-management.io/api/addon/v1alpha1"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	workv1 "open-cluster-management.io/api/work/v1"
+
+	"github.com/stolostron/klusterlet-addon-controller/pkg/apis"
+	v1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+)
+
+func Test_parseCronSchedule(t *testing.T) {
+	cases := []struct {
+		name      string
+		schedule  string
+		from      time.Time
+		expected  time.Time
+		expectErr bool
+	}{
+		{
+			name:     "every minute",
+			schedule: "* * * * *",
+			from:     time.Date(2024, 1, 1, 10, 30, 15, 0, time.UTC),
+			expected: time.Date(2024, 1, 1, 10, 31, 0, 0, time.UTC),
+		},
+		{
+			name:     "saturday at 02:00",
+			schedule: "0 2 * * 6",
+			from:     time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC),
+			expected: time.Date(2024, 1, 6, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "sunday as 7",
+			schedule: "30 1 * * 7",
+			from:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 1, 7, 1, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "steps and lists",
+			schedule: "*/20 8,20 * * *",
+			from:     time.Date(2024, 1, 1, 8, 40, 0, 0, time.UTC),
+			expected: time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "either day of month or day of week",
+			schedule: "0 0 15 * 1",
+			from:     time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "next year",
+			schedule: "0 0 1 1-3/2 *",
+			from:     time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "only day of month",
+			schedule: "0 0 13 * *",
+			from:     time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 1, 13, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "only day of week",
+			schedule: "0 0 * * 5",
+			from:     time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "both day fields with a step of day of week",
+			schedule: "0 0 12 * */2",
+			from:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			// the 12th of January 2024 is a Friday, of February a Monday, and of March a Tuesday
+			expected: time.Date(2024, 3, 12, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "either day field with a range of day of week",
+			schedule: "0 0 13 * 1-2",
+			from:     time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 1, 13, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "day of week range ending with sunday as 7",
+			schedule: "0 0 * * 6-7",
+			from:     time.Date(2024, 1, 7, 12, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 1, 13, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "range with a step",
+			schedule: "10-50/20 * * * *",
+			from:     time.Date(2024, 1, 1, 10, 31, 0, 0, time.UTC),
+			expected: time.Date(2024, 1, 1, 10, 50, 0, 0, time.UTC),
+		},
+		{
+			name:     "range with a step beyond the range",
+			schedule: "50-59/20 * * * *",
+			from:     time.Date(2024, 1, 1, 10, 51, 0, 0, time.UTC),
+			expected: time.Date(2024, 1, 1, 11, 50, 0, 0, time.UTC),
+		},
+		{
+			name:     "value with a step",
+			schedule: "0 20/2 * * *",
+			from:     time.Date(2024, 1, 1, 22, 30, 0, 0, time.UTC),
+			expected: time.Date(2024, 1, 2, 20, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "day of month missing in some months",
+			schedule: "0 0 31 * *",
+			from:     time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "leap day",
+			schedule: "0 0 29 2 *",
+			from:     time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "day that never comes",
+			schedule: "0 0 30 2 *",
+			from:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "too few fields",
+			schedule:  "0 2 * *",
+			expectErr: true,
+		},
+		{
+			name:      "out of range",
+			schedule:  "0 24 * * *",
+			expectErr: true,
+		},
+		{
+			name:      "invalid step",
+			schedule:  "*/0 * * * *",
+			expectErr: true,
+		},
+		{
+			name:      "reversed range",
+			schedule:  "0 0 * * 5-1",
+			expectErr: true,
+		},
+		{
+			name:      "open range",
+			schedule:  "0 0 * * 1-",
+			expectErr: true,
+		},
+		{
+			name:      "empty list item",
+			schedule:  "0 1,,2 * * *",
+			expectErr: true,
+		},
+		{
+			name:      "names are not supported",
+			schedule:  "0 0 * * MON",
+			expectErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			schedule, err := parseCronSchedule(c.schedule)
+			if c.expectErr != (err != nil) {
+				t.Fatalf("expected error %v, but got %v", c.expectErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if actual := schedule.next(c.from); !actual.Equal(c.expected) {
+				t.Errorf("expected next %v, but got %v", c.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_maintenanceWindowIsOpen(t *testing.T) {
+	window, err := newMaintenanceWindow(&v1.MaintenanceWindow{
+		Schedule: "0 22 * * 5",
+		Duration: metav1.Duration{Duration: 4 * time.Hour},
+		TimeZone: "Europe/Berlin",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+
+	cases := []struct {
+		name         string
+		now          time.Time
+		expectedOpen bool
+		expectedNext time.Time
+	}{
+		{
+			name:         "before the window",
+			now:          time.Date(2024, 1, 5, 21, 0, 0, 0, berlin),
+			expectedNext: time.Date(2024, 1, 5, 22, 0, 0, 0, berlin),
+		},
+		{
+			name:         "inside the window",
+			now:          time.Date(2024, 1, 5, 22, 0, 0, 0, berlin),
+			expectedOpen: true,
+		},
+		{
+			name:         "inside the window on the next day",
+			now:          time.Date(2024, 1, 6, 1, 59, 0, 0, berlin),
+			expectedOpen: true,
+		},
+		{
+			name:         "after the window",
+			now:          time.Date(2024, 1, 6, 2, 0, 0, 0, berlin),
+			expectedNext: time.Date(2024, 1, 12, 22, 0, 0, 0, berlin),
+		},
+		{
+			name:         "in another time zone",
+			now:          time.Date(2024, 1, 5, 21, 30, 0, 0, time.UTC),
+			expectedOpen: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			open, next := window.isOpen(c.now)
+			if open != c.expectedOpen || !next.Equal(c.expectedNext) {
+				t.Errorf("expected open %v and next %v, but got %v and %v", c.expectedOpen, c.expectedNext,
+					open, next)
+			}
+		})
+	}
+}
+
+func Test_ReconcileMaintenanceWindow(t *testing.T) {
+	testscheme := scheme.Scheme
+	_ = mcv1.AddToScheme(testscheme)
+	_ = v1alpha1.AddToScheme(testscheme)
+	_ = apis.AddToScheme(testscheme)
+	_ = workv1.AddToScheme(testscheme)
+
+	// the closed window starts in 12 hours every day
+	closed := fmt.Sprintf("0 %d * * *", (time.Now().UTC().Hour()+12)%24)
+	windowsConfigMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "maintenance-windows", Namespace: "open-cluster-management"},
+		Data: map[string]string{
+			"nightly": fmt.Sprintf("schedule: %q\nduration: 1h\n", closed),
+		},
+	}
+	withWindow := func(schedule string, annotations map[string]string) *v1.KlusterletAddonConfig {
+		config := newKlusterletAddonConfig("cluster1")
+		config.Annotations = annotations
+		config.Spec.MaintenanceWindow = &v1.MaintenanceWindow{
+			Schedule: schedule,
+			Duration: metav1.Duration{Duration: time.Hour},
+		}
+		return config
+	}
+	searchDisabled := withWindow(closed, nil)
+	searchDisabled.Spec.SearchCollectorConfig.Enabled = false
+
+	invalidWindow := withWindow("0 22 * *", nil)
+
+	cases := []struct {
+		name            string
+		cluster         *mcv1.ManagedCluster
+		config          *v1.KlusterletAddonConfig
+		addons          []runtime.Object
+		noConfigMap     bool
+		expectedAddons  int
+		expectedPending bool
+		// expectedMessage is the message of the pending condition if the window is unavailable
+		expectedMessage string
+	}{
+		{
+			name:           "inside the window",
+			cluster:        newManagedCluster("cluster1", nil, nil),
+			config:         withWindow("* * * * *", nil),
+			expectedAddons: 5,
+		},
+		{
+			name:            "enable outside the window",
+			cluster:         newManagedCluster("cluster1", nil, nil),
+			config:          withWindow(closed, nil),
+			expectedPending: true,
+		},
+		{
+			name: "enable outside the window of the cluster label",
+			cluster: newManagedCluster("cluster1",
+				map[string]string{common.LabelMaintenanceWindow: "nightly"}, nil),
+			config:          newKlusterletAddonConfig("cluster1"),
+			expectedPending: true,
+		},
+		{
+			name:    "override the window",
+			cluster: newManagedCluster("cluster1", nil, nil),
+			config: withWindow(closed, map[string]string{
+				common.AnnotationMaintenanceWindowOverride: "true",
+			}),
+			expectedAddons: 5,
+		},
+		{
+			name:            "disable outside the window",
+			cluster:         newManagedCluster("cluster1", nil, nil),
+			config:          searchDisabled,
+			addons:          []runtime.Object{newManagedClusterAddon(v1.SearchAddonName, "cluster1", addonHosting{})},
+			expectedAddons:  1,
+			expectedPending: true,
+		},
+		{
+			name: "hold the changes if the window is not found in the ConfigMap",
+			cluster: newManagedCluster("cluster1",
+				map[string]string{common.LabelMaintenanceWindow: "weekend"}, nil),
+			config:          newKlusterletAddonConfig("cluster1"),
+			expectedPending: true,
+			expectedMessage: "The changes of the addons are held because the maintenance window \"weekend\" of " +
+				"cluster cluster1 is not found in ConfigMap open-cluster-management/maintenance-windows",
+		},
+		{
+			name: "hold the changes if the ConfigMap is not found",
+			cluster: newManagedCluster("cluster1",
+				map[string]string{common.LabelMaintenanceWindow: "nightly"}, nil),
+			config:          newKlusterletAddonConfig("cluster1"),
+			noConfigMap:     true,
+			expectedPending: true,
+			expectedMessage: "The changes of the addons are held because the maintenance window \"nightly\" of " +
+				"cluster cluster1 is not found: ConfigMap open-cluster-management/maintenance-windows is not found",
+		},
+		{
+			name:            "hold the changes if the window is invalid",
+			cluster:         newManagedCluster("cluster1", nil, nil),
+			config:          invalidWindow,
+			expectedPending: true,
+			expectedMessage: "The changes of the addons are held because the maintenance window of " +
+				"klusterletAddonConfig cluster1 is invalid: invalid schedule \"0 22 * *\": expected 5 fields, but got 4",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			objs := append([]runtime.Object{c.cluster, c.config}, c.addons...)
+			if !c.noConfigMap {
+				objs = append(objs, windowsConfigMap)
+			}
+			kubeClient := newFakeClient(testscheme, objs...)
+			reconciler := &ReconcileKlusterletAddOn{
+				client: kubeClient,
+				maintenanceWindowsConfigMap: types.NamespacedName{
+					Namespace: "open-cluster-management", Name: "maintenance-windows",
+				},
+			}
+
+			result, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: "cluster1", Name: "cluster1"},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			addons := &v1alpha1.ManagedClusterAddOnList{}
+			if err := kubeClient.List(context.TODO(), addons); err != nil {
+				t.Fatalf("failed to list addons: %v", err)
+			}
+			if len(addons.Items) != c.expectedAddons {
+				t.Errorf("expected %d addons, but got %d", c.expectedAddons, len(addons.Items))
+			}
+
+			config := &v1.KlusterletAddonConfig{}
+			if err := kubeClient.Get(context.TODO(),
+				types.NamespacedName{Namespace: "cluster1", Name: "cluster1"}, config); err != nil {
+				t.Fatalf("failed to get klusterletaddonconfig: %v", err)
+			}
+			pending := meta.IsStatusConditionTrue(config.Status.Conditions, v1.AddonChangesPending)
+			if pending != c.expectedPending {
+				t.Errorf("expected pending %v, but got %v", c.expectedPending, pending)
+			}
+			if len(c.expectedMessage) != 0 {
+				condition := meta.FindStatusCondition(config.Status.Conditions, v1.AddonChangesPending)
+				if condition == nil || !strings.HasPrefix(condition.Message, c.expectedMessage) {
+					t.Errorf("expected message %q, but got %v", c.expectedMessage, condition)
+				}
+				return
+			}
+			if c.expectedPending && (result.RequeueAfter <= 0 || result.RequeueAfter > 12*time.Hour) {
+				t.Errorf("expected requeue at the next window, but got %v", result.RequeueAfter)
+			}
+		})
+	}
+}
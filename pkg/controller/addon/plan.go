@@ -0,0 +1,240 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addon
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+)
+
+// Planner computes the changes of the ManagedClusterAddOns of the KlusterletAddonConfigs with the same logic as the
+// controller without applying them. It is used by the dry-run mode of the KlusterletAddonConfigs and the CLI.
+type Planner struct {
+	reconciler *ReconcileKlusterletAddOn
+}
+
+// NewPlanner returns a planner which reads the hub with the client, the client is never used to write.
+func NewPlanner(c client.Client, opts *common.Options) *Planner {
+	return &Planner{reconciler: newKlusterletAddonReconciler(c, opts)}
+}
+
+// Plan returns the changes of the ManagedClusterAddOns which the controller would apply for the
+// KlusterletAddonConfig, whether its dry-run mode is on or not.
+func (p *Planner) Plan(ctx context.Context, namespace, name string) (*agentv1.AddonsPlan, error) {
+	config := &agentv1.KlusterletAddonConfig{}
+	if err := p.reconciler.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name},
+		config); err != nil {
+		return nil, err
+	}
+	return p.reconciler.plan(ctx, config)
+}
+
+// plan reconciles the klusterletAddonConfig with a client which records the changes of the managedClusterAddons
+// instead of applying them, and returns the recorded changes.
+func (r *ReconcileKlusterletAddOn) plan(ctx context.Context,
+	config *agentv1.KlusterletAddonConfig) (*agentv1.AddonsPlan, error) {
+	recorder := newPlanClient(r.client)
+	planner := *r
+	planner.client = recorder
+	planner.dryRun = true
+
+	if _, err := planner.Reconcile(ctx, reconcile.Request{
+		NamespacedName: types.NamespacedName{Namespace: config.Namespace, Name: config.Name},
+	}); err != nil {
+		return nil, err
+	}
+	return &agentv1.AddonsPlan{
+		ObservedGeneration: config.Generation,
+		Addons:             recorder.addonPlans(),
+	}, nil
+}
+
+// reconcileDryRun writes the plan of the klusterletAddonConfig to its status without changing the addons
+func (r *ReconcileKlusterletAddOn) reconcileDryRun(ctx context.Context,
+	config *agentv1.KlusterletAddonConfig) (reconcile.Result, error) {
+	plan, err := r.plan(ctx, config)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{}, r.updateStatus(ctx, config, setPlan(plan))
+}
+
+// setPlan returns the statusMutateFunc which sets the plan of the dry-run mode, the plan is removed if it is nil.
+func setPlan(plan *agentv1.AddonsPlan) statusMutateFunc {
+	return func(status *agentv1.KlusterletAddonConfigStatus) {
+		status.Plan = plan
+	}
+}
+
+// planClient records the changes of the managedClusterAddons instead of applying them, and the changes of the
+// other objects, like the image pull secret ManifestWorks and the status of the klusterletAddonConfig, are dropped.
+// The objects are read with the wrapped client.
+type planClient struct {
+	client.Client
+
+	lock   sync.Mutex
+	addons map[string]agentv1.AddonPlan
+}
+
+func newPlanClient(c client.Client) *planClient {
+	return &planClient{Client: c, addons: map[string]agentv1.AddonPlan{}}
+}
+
+func (c *planClient) Create(context.Context, client.Object, ...client.CreateOption) error {
+	return nil
+}
+
+func (c *planClient) Update(context.Context, client.Object, ...client.UpdateOption) error {
+	return nil
+}
+
+func (c *planClient) DeleteAllOf(context.Context, client.Object, ...client.DeleteAllOfOption) error {
+	return nil
+}
+
+func (c *planClient) Status() client.SubResourceWriter {
+	return planStatusWriter{}
+}
+
+// Patch records the managedClusterAddon applied by the controller, it is created if it does not exist
+func (c *planClient) Patch(ctx context.Context, obj client.Object, _ client.Patch, _ ...client.PatchOption) error {
+	if !isManagedClusterAddon(obj) {
+		return nil
+	}
+
+	existing := &addonv1alpha1.ManagedClusterAddOn{}
+	err := c.Client.Get(ctx, client.ObjectKeyFromObject(obj), existing)
+	switch {
+	case errors.IsNotFound(err):
+		c.record(obj.GetName(), agentv1.AddonPlanActionCreate, "", obj.GetAnnotations()[annotationValues])
+	case err != nil:
+		return err
+	default:
+		c.record(obj.GetName(), agentv1.AddonPlanActionUpdate, existing.Annotations[annotationValues],
+			obj.GetAnnotations()[annotationValues])
+	}
+	return nil
+}
+
+// Delete records the managedClusterAddon deleted by the controller, the NotFound error is returned as the real
+// client does if it does not exist
+func (c *planClient) Delete(ctx context.Context, obj client.Object, _ ...client.DeleteOption) error {
+	if !isManagedClusterAddon(obj) {
+		return nil
+	}
+
+	existing := &addonv1alpha1.ManagedClusterAddOn{}
+	if err := c.Client.Get(ctx, client.ObjectKeyFromObject(obj), existing); err != nil {
+		return err
+	}
+	if existing.DeletionTimestamp.IsZero() {
+		c.record(obj.GetName(), agentv1.AddonPlanActionDelete, existing.Annotations[annotationValues], "")
+	}
+	return nil
+}
+
+func (c *planClient) record(addonName string, action agentv1.AddonPlanAction, fromValues, toValues string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.addons[addonName] = agentv1.AddonPlan{
+		AddonName:  addonName,
+		Action:     action,
+		ValuesDiff: diffValues(fromValues, toValues),
+	}
+}
+
+// addonPlans returns the recorded changes of the managedClusterAddons sorted by the addon names
+func (c *planClient) addonPlans() []agentv1.AddonPlan {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var plans []agentv1.AddonPlan
+	for _, addonName := range sets.List(sets.KeySet(c.addons)) {
+		plans = append(plans, c.addons[addonName])
+	}
+	return plans
+}
+
+// planStatusWriter drops the changes of the status
+type planStatusWriter struct{}
+
+func (planStatusWriter) Create(context.Context, client.Object, client.Object,
+	...client.SubResourceCreateOption) error {
+	return nil
+}
+
+func (planStatusWriter) Update(context.Context, client.Object, ...client.SubResourceUpdateOption) error {
+	return nil
+}
+
+func (planStatusWriter) Patch(context.Context, client.Object, client.Patch, ...client.SubResourcePatchOption) error {
+	return nil
+}
+
+// isManagedClusterAddon returns true if the object is a managedClusterAddon, or the apply configuration of it
+func isManagedClusterAddon(obj client.Object) bool {
+	if _, ok := obj.(*addonv1alpha1.ManagedClusterAddOn); ok {
+		return true
+	}
+	return obj.GetObjectKind().GroupVersionKind() == addonv1alpha1.GroupVersion.WithKind("ManagedClusterAddOn")
+}
+
+// diffValues returns the changes between the values annotations. The objects in the values are compared by their
+// fields, and the other values, like the lists, are compared by their json.
+func diffValues(fromValues, toValues string) []agentv1.ValueChange {
+	from, to := flattenValues(fromValues), flattenValues(toValues)
+	var changes []agentv1.ValueChange
+	for _, path := range sets.List(sets.KeySet(from).Union(sets.KeySet(to))) {
+		if from[path] != to[path] {
+			changes = append(changes, agentv1.ValueChange{Path: path, From: from[path], To: to[path]})
+		}
+	}
+	return changes
+}
+
+// flattenValues returns the json of the values keyed by their paths, the values which are not json are returned
+// as a whole with the empty path
+func flattenValues(values string) map[string]string {
+	flattened := map[string]string{}
+	if len(values) == 0 {
+		return flattened
+	}
+
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(values), &parsed); err != nil {
+		flattened[""] = values
+		return flattened
+	}
+	flattenValue("", parsed, flattened)
+	return flattened
+}
+
+func flattenValue(path string, value interface{}, flattened map[string]string) {
+	if object, ok := value.(map[string]interface{}); ok && len(object) != 0 {
+		for key, field := range object {
+			fieldPath := key
+			if len(path) != 0 {
+				fieldPath = path + "." + key
+			}
+			flattenValue(fieldPath, field, flattened)
+		}
+		return
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	flattened[path] = string(raw)
+}
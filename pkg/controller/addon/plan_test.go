@@ -0,0 +1,243 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addon
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"open-cluster-management.io/api/addon/v1alpha1"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	workv1 "open-cluster-management.io/api/work/v1"
+
+	"github.com/stolostron/klusterlet-addon-controller/pkg/apis"
+	v1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+)
+
+func Test_diffValues(t *testing.T) {
+	cases := []struct {
+		name     string
+		from     string
+		to       string
+		expected []v1.ValueChange
+	}{
+		{
+			name: "no changes",
+			from: `{"global":{"nodeSelector":{"zone":"a"}}}`,
+			to:   `{"global":{"nodeSelector":{"zone":"a"}}}`,
+		},
+		{
+			name: "added values",
+			to:   `{"global":{"imagePullPolicy":"Always"}}`,
+			expected: []v1.ValueChange{
+				{Path: "global.imagePullPolicy", To: `"Always"`},
+			},
+		},
+		{
+			name: "removed values",
+			from: `{"global":{"imagePullPolicy":"Always"}}`,
+			expected: []v1.ValueChange{
+				{Path: "global.imagePullPolicy", From: `"Always"`},
+			},
+		},
+		{
+			name: "changed fields",
+			from: `{"global":{"nodeSelector":{"zone":"a","tier":"infra"},"imagePullSecret":"s1"}}`,
+			to:   `{"global":{"nodeSelector":{"zone":"b","tier":"infra"},"imagePullSecret":"s1"}}`,
+			expected: []v1.ValueChange{
+				{Path: "global.nodeSelector.zone", From: `"a"`, To: `"b"`},
+			},
+		},
+		{
+			name: "changed lists",
+			from: `{"global":{"tolerations":[{"key":"a"}]}}`,
+			to:   `{"global":{"tolerations":[{"key":"b"}]}}`,
+			expected: []v1.ValueChange{
+				{Path: "global.tolerations", From: `[{"key":"a"}]`, To: `[{"key":"b"}]`},
+			},
+		},
+		{
+			name: "invalid values",
+			from: "invalid",
+			to:   `{"global":{"imagePullPolicy":"Always"}}`,
+			expected: []v1.ValueChange{
+				{Path: "", From: "invalid"},
+				{Path: "global.imagePullPolicy", To: `"Always"`},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if actual := diffValues(c.from, c.to); !reflect.DeepEqual(actual, c.expected) {
+				t.Errorf("expected %v, but got %v", c.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_ReconcileDryRun(t *testing.T) {
+	testscheme := scheme.Scheme
+	_ = mcv1.AddToScheme(testscheme)
+	_ = v1alpha1.AddToScheme(testscheme)
+	_ = apis.AddToScheme(testscheme)
+	_ = workv1.AddToScheme(testscheme)
+
+	config := newKlusterletAddonConfig("cluster1")
+	config.Spec.DryRun = true
+	config.Spec.CertPolicyControllerConfig.Enabled = false
+	config.Spec.SearchCollectorConfig.ImagePullPolicy = "Always"
+
+	search := newManagedClusterAddon(v1.SearchAddonName, "cluster1", addonHosting{})
+	search.Annotations = map[string]string{annotationValues: `{"global":{"imagePullPolicy":"IfNotPresent"}}`}
+	certPolicy := newManagedClusterAddon(v1.CertPolicyAddonName, "cluster1", addonHosting{})
+
+	kubeClient := newFakeClient(testscheme, newManagedCluster("cluster1", nil, nil), config, search, certPolicy)
+	reconciler := &ReconcileKlusterletAddOn{client: kubeClient}
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "cluster1", Name: "cluster1"}}
+
+	if _, err := reconciler.Reconcile(context.TODO(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	addons := &v1alpha1.ManagedClusterAddOnList{}
+	if err := kubeClient.List(context.TODO(), addons); err != nil {
+		t.Fatalf("failed to list addons: %v", err)
+	}
+	if len(addons.Items) != 2 {
+		t.Errorf("expected the addons are not changed in dry-run mode, but got %d addons", len(addons.Items))
+	}
+	for _, addon := range addons.Items {
+		if addon.Name == v1.SearchAddonName && addon.Annotations[annotationValues] != search.Annotations[annotationValues] {
+			t.Errorf("expected the values of the search addon are not changed, but got %s",
+				addon.Annotations[annotationValues])
+		}
+	}
+
+	actual := &v1.KlusterletAddonConfig{}
+	if err := kubeClient.Get(context.TODO(), request.NamespacedName, actual); err != nil {
+		t.Fatalf("failed to get klusterletaddonconfig: %v", err)
+	}
+	if actual.Status.Plan == nil {
+		t.Fatalf("expected the plan in the status")
+	}
+	if len(actual.Status.Conditions) != 0 {
+		t.Errorf("expected no conditions in dry-run mode, but got %v", actual.Status.Conditions)
+	}
+	actions := map[string]v1.AddonPlanAction{}
+	for _, plan := range actual.Status.Plan.Addons {
+		actions[plan.AddonName] = plan.Action
+	}
+	expectedActions := map[string]v1.AddonPlanAction{
+		v1.ApplicationAddonName:     v1.AddonPlanActionCreate,
+		v1.ConfigPolicyAddonName:    v1.AddonPlanActionCreate,
+		v1.PolicyFrameworkAddonName: v1.AddonPlanActionCreate,
+		v1.SearchAddonName:          v1.AddonPlanActionUpdate,
+		v1.CertPolicyAddonName:      v1.AddonPlanActionDelete,
+	}
+	if !reflect.DeepEqual(actions, expectedActions) {
+		t.Errorf("expected actions %v, but got %v", expectedActions, actions)
+	}
+	for _, plan := range actual.Status.Plan.Addons {
+		if plan.AddonName != v1.SearchAddonName {
+			continue
+		}
+		expectedDiff := []v1.ValueChange{{Path: "global.imagePullPolicy", From: `"IfNotPresent"`, To: `"Always"`}}
+		if !reflect.DeepEqual(plan.ValuesDiff, expectedDiff) {
+			t.Errorf("expected values diff %v, but got %v", expectedDiff, plan.ValuesDiff)
+		}
+	}
+
+	// the changes are applied and the plan is removed once the dry-run mode is turned off
+	actual.Spec.DryRun = false
+	if err := kubeClient.Update(context.TODO(), actual); err != nil {
+		t.Fatalf("failed to update klusterletaddonconfig: %v", err)
+	}
+	if _, err := reconciler.Reconcile(context.TODO(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := kubeClient.List(context.TODO(), addons); err != nil {
+		t.Fatalf("failed to list addons: %v", err)
+	}
+	if len(addons.Items) != 4 {
+		t.Errorf("expected 4 addons, but got %d", len(addons.Items))
+	}
+	if err := kubeClient.Get(context.TODO(), request.NamespacedName, actual); err != nil {
+		t.Fatalf("failed to get klusterletaddonconfig: %v", err)
+	}
+	if actual.Status.Plan != nil {
+		t.Errorf("expected the plan is removed, but got %v", actual.Status.Plan)
+	}
+}
+
+func Test_PlannerPlan(t *testing.T) {
+	testscheme := scheme.Scheme
+	_ = mcv1.AddToScheme(testscheme)
+	_ = v1alpha1.AddToScheme(testscheme)
+	_ = apis.AddToScheme(testscheme)
+	_ = workv1.AddToScheme(testscheme)
+
+	config := newKlusterletAddonConfig("cluster1")
+	config.Generation = 3
+	config.Spec.ApplicationManagerConfig.Enabled = false
+	config.Spec.PolicyController.Enabled = false
+	config.Spec.CertPolicyControllerConfig.Enabled = false
+
+	cases := []struct {
+		name     string
+		objs     []runtime.Object
+		expected *v1.AddonsPlan
+	}{
+		{
+			name: "create addon",
+			objs: []runtime.Object{newManagedCluster("cluster1", nil, nil), config},
+			expected: &v1.AddonsPlan{
+				ObservedGeneration: 3,
+				Addons: []v1.AddonPlan{
+					{AddonName: v1.SearchAddonName, Action: v1.AddonPlanActionCreate},
+				},
+			},
+		},
+		{
+			name: "no changes",
+			objs: []runtime.Object{newManagedCluster("cluster1", nil, nil), config,
+				newManagedClusterAddon(v1.SearchAddonName, "cluster1", addonHosting{})},
+			expected: &v1.AddonsPlan{ObservedGeneration: 3},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			kubeClient := newFakeClient(testscheme, c.objs...)
+			planner := NewPlanner(kubeClient, common.NewOptions())
+
+			plan, err := planner.Plan(context.TODO(), "cluster1", "cluster1")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(plan, c.expected) {
+				t.Errorf("expected plan %v, but got %v", c.expected, plan)
+			}
+
+			addons := &v1alpha1.ManagedClusterAddOnList{}
+			if err := kubeClient.List(context.TODO(), addons); err != nil {
+				t.Fatalf("failed to list addons: %v", err)
+			}
+			if len(addons.Items) != len(c.objs)-2 {
+				t.Errorf("expected the addons are not changed, but got %d addons", len(addons.Items))
+			}
+		})
+	}
+
+	if _, err := NewPlanner(newFakeClient(testscheme), common.NewOptions()).Plan(context.TODO(),
+		"cluster1", "cluster1"); err == nil {
+		t.Errorf("expected error if the klusterletaddonconfig is not found")
+	}
+}
@@ -0,0 +1,551 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1alpha1 "open-cluster-management.io/api/cluster/v1alpha1"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+)
+
+const (
+	// addonNameIndex indexes the managedClusterAddOns by their names, so the addons of the same name on all the
+	// managed clusters are listed from the cache. It is the field supported by the field selectors of the API
+	// server, so the addons are listed in the same way by the planner without the cache.
+	addonNameIndex = "metadata.name"
+
+	// rolloutRequeuePeriod is the period the held and the progressing values updates are checked again, the
+	// reconcile of a managed cluster is not triggered by the addons of the other managed clusters
+	rolloutRequeuePeriod = 30 * time.Second
+
+	// rolloutSettlePeriod is how long a values update is held before it can be applied, so the updates of all the
+	// managed clusters triggered by the same change are held before the clusters to be updated are selected
+	rolloutSettlePeriod = 30 * time.Second
+
+	// progressDeadlineNone means the values updates wait for the addons to be available indefinitely
+	progressDeadlineNone = "None"
+)
+
+func indexAddonName(obj client.Object) []string {
+	return []string{obj.GetName()}
+}
+
+// addonRolloutStrategy is the json of the rollout strategy annotation of the ClusterManagementAddOn
+type addonRolloutStrategy struct {
+	clusterv1alpha1.RolloutStrategy `json:",inline"`
+
+	// GroupLabel is the label key of the managed clusters whose values group the managed clusters, the groups are
+	// updated one after another by ProgressivePerGroup and the mandatory groups are matched by the label values
+	GroupLabel string `json:"groupLabel,omitempty"`
+}
+
+// rolloutConfig is how the values updates of an addon are rolled out across the managed clusters
+type rolloutConfig struct {
+	rolloutType clusterv1alpha1.RolloutType
+	groupLabel  string
+
+	minSuccessTime time.Duration
+	// progressDeadline is zero if the updates wait for the addons to be available indefinitely
+	progressDeadline time.Duration
+	maxFailures      intstr.IntOrString
+	// maxConcurrency is nil if the number of the clusters updated at the same time is not limited
+	maxConcurrency  *intstr.IntOrString
+	mandatoryGroups []clusterv1alpha1.MandatoryDecisionGroup
+}
+
+// getRolloutConfig returns the rollout config set by the annotation of the ClusterManagementAddOn, nil is
+// returned if the values updates are applied to all the managed clusters at once.
+func getRolloutConfig(cma *addonv1alpha1.ClusterManagementAddOn) (*rolloutConfig, error) {
+	if cma == nil || len(strings.TrimSpace(cma.Annotations[common.AnnotationRolloutStrategy])) == 0 {
+		return nil, nil
+	}
+
+	strategy := &addonRolloutStrategy{}
+	if err := json.Unmarshal([]byte(cma.Annotations[common.AnnotationRolloutStrategy]), strategy); err != nil {
+		return nil, fmt.Errorf("invalid rollout strategy of addon %s: %v", cma.Name, err)
+	}
+
+	config := &rolloutConfig{rolloutType: strategy.Type, groupLabel: strategy.GroupLabel}
+	var rolloutConfig clusterv1alpha1.RolloutConfig
+	switch strategy.Type {
+	case "", clusterv1alpha1.All:
+		return nil, nil
+	case clusterv1alpha1.Progressive:
+		if strategy.Progressive != nil {
+			rolloutConfig = strategy.Progressive.RolloutConfig
+			config.mandatoryGroups = strategy.Progressive.MandatoryDecisionGroups.MandatoryDecisionGroups
+			if strategy.Progressive.MaxConcurrency.String() != "0" {
+				config.maxConcurrency = &strategy.Progressive.MaxConcurrency
+			}
+		}
+	case clusterv1alpha1.ProgressivePerGroup:
+		if strategy.ProgressivePerGroup != nil {
+			rolloutConfig = strategy.ProgressivePerGroup.RolloutConfig
+			config.mandatoryGroups = strategy.ProgressivePerGroup.MandatoryDecisionGroups.MandatoryDecisionGroups
+		}
+	default:
+		return nil, fmt.Errorf("invalid rollout strategy of addon %s: unknown type %q", cma.Name, strategy.Type)
+	}
+
+	config.minSuccessTime = rolloutConfig.MinSuccessTime.Duration
+	config.maxFailures = rolloutConfig.MaxFailures
+	if len(rolloutConfig.ProgressDeadline) != 0 && rolloutConfig.ProgressDeadline != progressDeadlineNone {
+		deadline, err := time.ParseDuration(rolloutConfig.ProgressDeadline)
+		if err != nil {
+			return nil, fmt.Errorf("invalid progress deadline of addon %s: %v", cma.Name, err)
+		}
+		config.progressDeadline = deadline
+	}
+	for _, value := range []*intstr.IntOrString{&config.maxFailures, config.maxConcurrency} {
+		if value == nil {
+			continue
+		}
+		if _, err := intstr.GetScaledValueFromIntOrPercent(value, 100, false); err != nil {
+			return nil, fmt.Errorf("invalid rollout strategy of addon %s: %v", cma.Name, err)
+		}
+	}
+	return config, nil
+}
+
+// rolloutStatus is the status of the values update of an addon on a managed cluster
+type rolloutStatus int
+
+const (
+	// rolloutNone means the values of the addon are not being updated
+	rolloutNone rolloutStatus = iota
+	// rolloutToApply means the values update is held until the cluster is selected by the rollout strategy
+	rolloutToApply
+	// rolloutProgressing means the values are updated and the addon is not available for minSuccessTime yet
+	rolloutProgressing
+	// rolloutSucceeded means the addon is available for minSuccessTime after the values are updated
+	rolloutSucceeded
+	// rolloutFailed means the addon becomes unavailable after the values are updated
+	rolloutFailed
+	// rolloutTimeout means the addon is not available within the progress deadline after the values are updated
+	rolloutTimeout
+)
+
+func (s rolloutStatus) failed() bool {
+	return s == rolloutFailed || s == rolloutTimeout
+}
+
+// getRolloutStatus returns the status of the values update of the addon and the duration after which the
+// status may change
+func (c *rolloutConfig) getRolloutStatus(addon *addonv1alpha1.ManagedClusterAddOn, now time.Time) (rolloutStatus,
+	time.Duration) {
+	value, ok := addon.Annotations[common.AnnotationRolloutUpdatedAt]
+	if !ok {
+		if _, ok := addon.Annotations[common.AnnotationRolloutPending]; ok {
+			return rolloutToApply, 0
+		}
+		return rolloutNone, 0
+	}
+	updatedAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		// the annotation is removed if it is invalid
+		return rolloutSucceeded, 0
+	}
+
+	// the addons do not report the values they are running with, so the update succeeds once the addon keeps
+	// available for minSuccessTime after the values are updated
+	elapsed := now.Sub(updatedAt)
+	available := meta.FindStatusCondition(addon.Status.Conditions, addonv1alpha1.ManagedClusterAddOnConditionAvailable)
+	if available != nil && available.Status == metav1.ConditionTrue {
+		if elapsed >= c.minSuccessTime {
+			return rolloutSucceeded, 0
+		}
+		return rolloutProgressing, c.minSuccessTime - elapsed
+	}
+	if available != nil && !available.LastTransitionTime.Time.Before(updatedAt) {
+		return rolloutFailed, rolloutRequeuePeriod
+	}
+	if c.progressDeadline > 0 && elapsed >= c.progressDeadline {
+		return rolloutTimeout, rolloutRequeuePeriod
+	}
+	return rolloutProgressing, rolloutRequeuePeriod
+}
+
+// rolloutCluster is the values update of an addon on a managed cluster
+type rolloutCluster struct {
+	name   string
+	group  string
+	status rolloutStatus
+}
+
+// rolloutGroups returns the groups of the clusters in the order they are updated, the mandatory groups are
+// updated first, then the other groups in the order of their names, and the clusters without the group label
+// are updated last. The mandatory groups are matched by their names, or by their indexes in the groups ordered
+// by names if the names are not set.
+func (c *rolloutConfig) rolloutGroups(clusters []rolloutCluster) ([]string, sets.Set[string]) {
+	names := sets.New[string]()
+	unlabeled := false
+	for _, cluster := range clusters {
+		if len(cluster.group) == 0 {
+			unlabeled = true
+			continue
+		}
+		names.Insert(cluster.group)
+	}
+	sortedNames := sets.List(names)
+
+	groups := []string{}
+	mandatory := sets.New[string]()
+	for _, group := range c.mandatoryGroups {
+		name := group.GroupName
+		if len(name) == 0 {
+			if int(group.GroupIndex) >= len(sortedNames) {
+				continue
+			}
+			name = sortedNames[group.GroupIndex]
+		}
+		if !mandatory.Has(name) {
+			mandatory.Insert(name)
+			groups = append(groups, name)
+		}
+	}
+	for _, name := range sortedNames {
+		if !mandatory.Has(name) {
+			groups = append(groups, name)
+		}
+	}
+	if unlabeled {
+		groups = append(groups, "")
+	}
+	return groups, mandatory
+}
+
+// canProceed returns true if the held values update of the addon on the given cluster can be applied, otherwise
+// the reason why it is still held is returned. The clusters are all the managed clusters of the addon.
+func (c *rolloutConfig) canProceed(clusters []rolloutCluster, clusterName string) (bool, string) {
+	return c.selectClusters(clusters).canProceed(clusterName)
+}
+
+// rolloutSelection is the result of the rollout strategy across all the managed clusters of an addon
+type rolloutSelection struct {
+	// reasons are the reasons why the held updates are still held, keyed by the cluster names, an empty reason
+	// means the held update of the cluster can be applied
+	reasons map[string]string
+	// defaultReason is the reason of the clusters which are not in reasons
+	defaultReason string
+}
+
+func (s *rolloutSelection) canProceed(clusterName string) (bool, string) {
+	reason, ok := s.reasons[clusterName]
+	if !ok {
+		return false, s.defaultReason
+	}
+	return len(reason) == 0, reason
+}
+
+// selectClusters selects the clusters whose held values updates can be applied by the rollout strategy
+func (c *rolloutConfig) selectClusters(clusters []rolloutCluster) *rolloutSelection {
+	if c.rolloutType == clusterv1alpha1.ProgressivePerGroup {
+		return c.selectClustersPerGroup(clusters)
+	}
+	return c.selectClustersProgressive(clusters)
+}
+
+// selectClustersProgressive selects the clusters to be updated by Progressive, at most maxConcurrency clusters
+// are updated at the same time and the clusters of the mandatory groups are updated before the other clusters.
+// The rollout is paused if any clusters of the mandatory groups fail, or more than maxFailures clusters fail.
+func (c *rolloutConfig) selectClustersProgressive(clusters []rolloutCluster) *rolloutSelection {
+	groups, mandatory := c.rolloutGroups(clusters)
+	groupOrder := map[string]int{}
+	for i, group := range groups {
+		groupOrder[group] = i
+	}
+
+	var progressing, failed, mandatoryFailed int
+	mandatoryCompleted := true
+	toApply := []rolloutCluster{}
+	for _, cluster := range clusters {
+		isMandatory := mandatory.Has(cluster.group)
+		switch {
+		case cluster.status == rolloutToApply:
+			toApply = append(toApply, cluster)
+		case cluster.status == rolloutProgressing:
+			progressing++
+		case cluster.status.failed() && isMandatory:
+			mandatoryFailed++
+		case cluster.status.failed():
+			failed++
+		default:
+			continue
+		}
+		if isMandatory {
+			mandatoryCompleted = false
+		}
+	}
+
+	if mandatoryFailed != 0 {
+		return &rolloutSelection{defaultReason: fmt.Sprintf(
+			"the rollout is paused because %d clusters of the mandatory groups failed", mandatoryFailed)}
+	}
+	maxFailures, _ := intstr.GetScaledValueFromIntOrPercent(&c.maxFailures, len(clusters), false)
+	if failed > maxFailures {
+		return &rolloutSelection{defaultReason: fmt.Sprintf(
+			"the rollout is paused because %d clusters failed, more than maxFailures %d", failed, maxFailures)}
+	}
+
+	if !mandatoryCompleted {
+		mandatoryToApply := []rolloutCluster{}
+		for _, cluster := range toApply {
+			if mandatory.Has(cluster.group) {
+				mandatoryToApply = append(mandatoryToApply, cluster)
+			}
+		}
+		toApply = mandatoryToApply
+	}
+	sort.Slice(toApply, func(i, j int) bool {
+		if groupOrder[toApply[i].group] != groupOrder[toApply[j].group] {
+			return groupOrder[toApply[i].group] < groupOrder[toApply[j].group]
+		}
+		return toApply[i].name < toApply[j].name
+	})
+
+	maxConcurrency := len(clusters)
+	if c.maxConcurrency != nil {
+		if value, _ := intstr.GetScaledValueFromIntOrPercent(c.maxConcurrency, len(clusters), true); value > 0 {
+			maxConcurrency = value
+		}
+	}
+	selection := &rolloutSelection{
+		reasons:       map[string]string{},
+		defaultReason: "waiting for the clusters of the mandatory groups to be updated",
+	}
+	for i, cluster := range toApply {
+		if i < maxConcurrency-progressing {
+			selection.reasons[cluster.name] = ""
+			continue
+		}
+		selection.reasons[cluster.name] = fmt.Sprintf(
+			"waiting behind %d clusters, %d clusters are being updated and maxConcurrency is %d",
+			i, progressing, maxConcurrency)
+	}
+	return selection
+}
+
+// selectClustersPerGroup selects the clusters to be updated by ProgressivePerGroup, the clusters of a group are
+// updated at the same time after the previous groups are updated. The rollout is paused if any clusters of the
+// mandatory groups fail, or more than maxFailures clusters of a group fail.
+func (c *rolloutConfig) selectClustersPerGroup(clusters []rolloutCluster) *rolloutSelection {
+	groups, mandatory := c.rolloutGroups(clusters)
+	for _, group := range groups {
+		var size, progressing, failed int
+		toApply := []string{}
+		for _, cluster := range clusters {
+			if cluster.group != group {
+				continue
+			}
+			size++
+			switch {
+			case cluster.status == rolloutToApply:
+				toApply = append(toApply, cluster.name)
+			case cluster.status == rolloutProgressing:
+				progressing++
+			case cluster.status.failed():
+				failed++
+			}
+		}
+
+		maxFailures := 0
+		if !mandatory.Has(group) {
+			maxFailures, _ = intstr.GetScaledValueFromIntOrPercent(&c.maxFailures, size, false)
+		}
+		if failed > maxFailures {
+			return &rolloutSelection{defaultReason: fmt.Sprintf(
+				"the rollout is paused because %d clusters of group %q failed, more than maxFailures %d",
+				failed, group, maxFailures)}
+		}
+		if len(toApply) == 0 && progressing == 0 {
+			continue
+		}
+		selection := &rolloutSelection{
+			reasons:       map[string]string{},
+			defaultReason: fmt.Sprintf("waiting for the clusters of group %q to be updated", group),
+		}
+		for _, clusterName := range toApply {
+			selection.reasons[clusterName] = ""
+		}
+		return selection
+	}
+	return &rolloutSelection{defaultReason: "the cluster is not in any group"}
+}
+
+// rolloutProgress is the progress of the values update of an addon on a managed cluster
+type rolloutProgress struct {
+	// message is empty if the values are not being updated
+	message string
+	// held is true if the update is held by the rollout strategy
+	held         bool
+	requeueAfter time.Duration
+}
+
+// rolloutValues holds the values update of the existing addon until the managed cluster is selected by the
+// rollout strategy and tracks the progress of the update. The annotations of the desired addon are changed to
+// keep the previous values and to record the progress, and the progress is returned until the update succeeds.
+func (r *ReconcileKlusterletAddOn) rolloutValues(ctx context.Context, config *rolloutConfig,
+	addon, desired *addonv1alpha1.ManagedClusterAddOn) (string, time.Duration, error) {
+	if config == nil {
+		return "", 0, nil
+	}
+
+	now := time.Now()
+	values, existed := addon.Annotations[annotationValues]
+	desiredValues, desiredExisted := desired.Annotations[annotationValues]
+	valuesChanged := existed != desiredExisted || values != desiredValues
+
+	status, requeueAfter := config.getRolloutStatus(addon, now)
+	switch {
+	case status == rolloutProgressing || status.failed():
+		updatedAt := addon.Annotations[common.AnnotationRolloutUpdatedAt]
+		if valuesChanged {
+			// the cluster being updated keeps its place in the rollout when the values are changed again
+			updatedAt = now.Format(time.RFC3339)
+			status, requeueAfter = rolloutProgressing, rolloutRequeuePeriod
+		}
+		setAnnotation(desired, common.AnnotationRolloutUpdatedAt, updatedAt)
+		return rolloutProgressMessage(status, updatedAt), requeueAfter, nil
+	case !valuesChanged:
+		return "", 0, nil
+	case status != rolloutToApply:
+		holdValues(addon, desired, now.Format(time.RFC3339))
+		return "waiting for the rollout strategy to select the cluster", rolloutSettlePeriod, nil
+	}
+
+	pendingSince := addon.Annotations[common.AnnotationRolloutPending]
+	if heldAt, err := time.Parse(time.RFC3339, pendingSince); err == nil && now.Sub(heldAt) < rolloutSettlePeriod {
+		holdValues(addon, desired, pendingSince)
+		return "waiting for the rollout strategy to select the cluster", rolloutSettlePeriod - now.Sub(heldAt), nil
+	}
+
+	ok, reason, err := r.canProceed(ctx, config, addon, now)
+	if err != nil {
+		return "", 0, err
+	}
+	if !ok {
+		holdValues(addon, desired, pendingSince)
+		return reason, rolloutRequeuePeriod, nil
+	}
+
+	updatedAt := now.Format(time.RFC3339)
+	setAnnotation(desired, common.AnnotationRolloutUpdatedAt, updatedAt)
+	if r.rolloutTracker != nil && !r.dryRun {
+		r.rolloutTracker.markUpdated(addon.Name, addon.Namespace, updatedAt)
+	}
+	if config.minSuccessTime > 0 {
+		requeueAfter = config.minSuccessTime
+	} else {
+		requeueAfter = rolloutRequeuePeriod
+	}
+	return rolloutProgressMessage(rolloutProgressing, updatedAt), requeueAfter, nil
+}
+
+// canProceed returns true if the held values update of the addon can be applied by the rollout strategy. The
+// clusters are selected by the rollout tracker which is shared by all the reconciles, and they are selected from
+// the addons listed from the client if the tracker is not set or not synced yet, like in the planner.
+func (r *ReconcileKlusterletAddOn) canProceed(ctx context.Context, config *rolloutConfig,
+	addon *addonv1alpha1.ManagedClusterAddOn, now time.Time) (bool, string, error) {
+	if r.rolloutTracker != nil && r.rolloutTracker.hasSynced() {
+		ok, reason := r.rolloutTracker.canProceed(config, addon.Name, addon.Namespace, now)
+		return ok, reason, nil
+	}
+
+	clusters, err := r.listRolloutClusters(ctx, config, addon.Name, now)
+	if err != nil {
+		return false, "", err
+	}
+	ok, reason := config.canProceed(clusters, addon.Namespace)
+	return ok, reason, nil
+}
+
+// listRolloutClusters returns the values updates of the addon on all the managed clusters of this shard
+func (r *ReconcileKlusterletAddOn) listRolloutClusters(ctx context.Context, config *rolloutConfig, addonName string,
+	now time.Time) ([]rolloutCluster, error) {
+	addons := &addonv1alpha1.ManagedClusterAddOnList{}
+	if err := r.client.List(ctx, addons, client.MatchingFields{addonNameIndex: addonName}); err != nil {
+		return nil, err
+	}
+	clusterList := &mcv1.ManagedClusterList{}
+	if err := r.client.List(ctx, clusterList); err != nil {
+		return nil, err
+	}
+	clusters := map[string]*mcv1.ManagedCluster{}
+	for i := range clusterList.Items {
+		clusters[clusterList.Items[i].Name] = &clusterList.Items[i]
+	}
+
+	rolloutClusters := []rolloutCluster{}
+	for i := range addons.Items {
+		addon := &addons.Items[i]
+		cluster, ok := clusters[addon.Namespace]
+		if !ok || !addon.DeletionTimestamp.IsZero() || !r.shard.Contains(cluster) {
+			continue
+		}
+		status, _ := config.getRolloutStatus(addon, now)
+		rolloutClusters = append(rolloutClusters, rolloutCluster{
+			name:   cluster.Name,
+			group:  cluster.Labels[config.groupLabel],
+			status: status,
+		})
+	}
+	return rolloutClusters, nil
+}
+
+// holdValues keeps the values of the existing addon and marks the update of the values as held
+func holdValues(addon, desired *addonv1alpha1.ManagedClusterAddOn, pendingSince string) {
+	keepValues(addon, desired)
+	setAnnotation(desired, common.AnnotationRolloutPending, pendingSince)
+}
+
+// keepValues sets the values of the desired addon to the values of the existing addon
+func keepValues(addon, desired *addonv1alpha1.ManagedClusterAddOn) {
+	if values, ok := addon.Annotations[annotationValues]; ok {
+		setAnnotation(desired, annotationValues, values)
+	} else {
+		delete(desired.Annotations, annotationValues)
+	}
+}
+
+func setAnnotation(addon *addonv1alpha1.ManagedClusterAddOn, key, value string) {
+	if addon.Annotations == nil {
+		addon.Annotations = map[string]string{}
+	}
+	addon.Annotations[key] = value
+}
+
+func rolloutProgressMessage(status rolloutStatus, updatedAt string) string {
+	switch status {
+	case rolloutFailed:
+		return fmt.Sprintf("the addon is not available after the values were updated at %s", updatedAt)
+	case rolloutTimeout:
+		return fmt.Sprintf("the addon is not available within the progress deadline after the values were "+
+			"updated at %s", updatedAt)
+	default:
+		return fmt.Sprintf("the values were updated at %s, waiting for the addon to be available", updatedAt)
+	}
+}
+
+// newRolloutCondition returns the condition which reports the values updates of the addons held or being rolled
+// out, nil is returned if no updates are rolled out and the condition is not set before.
+func newRolloutCondition(config *agentv1.KlusterletAddonConfig, rollingOutAddons map[string]string) *metav1.Condition {
+	return newAddonsCondition(config, agentv1.AddonsRollingOut, rollingOutAddons,
+		agentv1.ReasonRolloutInProgress, "The values updates of the addons are being rolled out",
+		agentv1.ReasonRolloutCompleted, "The values of the addons are up to date.")
+}
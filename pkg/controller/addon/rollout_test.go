@@ -0,0 +1,472 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"open-cluster-management.io/api/addon/v1alpha1"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1alpha1 "open-cluster-management.io/api/cluster/v1alpha1"
+
+	"github.com/stolostron/klusterlet-addon-controller/pkg/apis"
+	v1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+)
+
+func newRolloutAddon(clusterName, values string, annotations map[string]string,
+	available *metav1.Condition) *v1alpha1.ManagedClusterAddOn {
+	addon := newManagedClusterAddon(v1.SearchAddonName, clusterName, addonHosting{})
+	addon.Annotations = map[string]string{}
+	if len(values) != 0 {
+		addon.Annotations[annotationValues] = values
+	}
+	for k, v := range annotations {
+		addon.Annotations[k] = v
+	}
+	if available != nil {
+		addon.Status.Conditions = []metav1.Condition{*available}
+	}
+	return addon
+}
+
+func newAvailableCondition(status metav1.ConditionStatus, lastTransitionTime time.Time) *metav1.Condition {
+	return &metav1.Condition{
+		Type:               v1alpha1.ManagedClusterAddOnConditionAvailable,
+		Status:             status,
+		Reason:             "ManagedClusterAddOnLeaseUpdated",
+		LastTransitionTime: metav1.NewTime(lastTransitionTime),
+	}
+}
+
+func Test_getRolloutConfig(t *testing.T) {
+	cases := []struct {
+		name      string
+		strategy  string
+		expected  *rolloutConfig
+		expectErr bool
+	}{
+		{
+			name: "no strategy",
+		},
+		{
+			name:     "all",
+			strategy: `{"type":"All"}`,
+		},
+		{
+			name: "progressive",
+			strategy: `{"type":"Progressive","groupLabel":"env","progressive":{"maxConcurrency":"25%",` +
+				`"maxFailures":1,"minSuccessTime":"5m","progressDeadline":"1h",` +
+				`"mandatoryDecisionGroups":[{"groupName":"canary"}]}}`,
+			expected: &rolloutConfig{
+				rolloutType:      clusterv1alpha1.Progressive,
+				groupLabel:       "env",
+				minSuccessTime:   5 * time.Minute,
+				progressDeadline: time.Hour,
+				maxFailures:      intstr.FromInt32(1),
+				maxConcurrency:   &intstr.IntOrString{Type: intstr.String, StrVal: "25%"},
+				mandatoryGroups:  []clusterv1alpha1.MandatoryDecisionGroup{{GroupName: "canary"}},
+			},
+		},
+		{
+			name:     "progressive per group without deadline",
+			strategy: `{"type":"ProgressivePerGroup","progressivePerGroup":{"progressDeadline":"None"}}`,
+			expected: &rolloutConfig{rolloutType: clusterv1alpha1.ProgressivePerGroup},
+		},
+		{
+			name:      "invalid json",
+			strategy:  `{"type":`,
+			expectErr: true,
+		},
+		{
+			name:      "unknown type",
+			strategy:  `{"type":"Canary"}`,
+			expectErr: true,
+		},
+		{
+			name:      "invalid progress deadline",
+			strategy:  `{"type":"Progressive","progressive":{"progressDeadline":"tomorrow"}}`,
+			expectErr: true,
+		},
+		{
+			name:      "invalid max failures",
+			strategy:  `{"type":"Progressive","progressive":{"maxFailures":"half"}}`,
+			expectErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cma := newClusterManagementAddOn(v1.SearchAddonName, v1alpha1.AddonInstallStrategyManual)
+			if len(c.strategy) != 0 {
+				cma.Annotations = map[string]string{common.AnnotationRolloutStrategy: c.strategy}
+			}
+			actual, err := getRolloutConfig(cma)
+			if c.expectErr != (err != nil) {
+				t.Fatalf("expected error %v, but got %v", c.expectErr, err)
+			}
+			if c.expected == nil || actual == nil {
+				if c.expected != actual {
+					t.Errorf("expected %+v, but got %+v", c.expected, actual)
+				}
+				return
+			}
+			if actual.rolloutType != c.expected.rolloutType || actual.groupLabel != c.expected.groupLabel ||
+				actual.minSuccessTime != c.expected.minSuccessTime ||
+				actual.progressDeadline != c.expected.progressDeadline ||
+				actual.maxFailures != c.expected.maxFailures ||
+				len(actual.mandatoryGroups) != len(c.expected.mandatoryGroups) {
+				t.Errorf("expected %+v, but got %+v", c.expected, actual)
+			}
+			if (actual.maxConcurrency == nil) != (c.expected.maxConcurrency == nil) ||
+				(actual.maxConcurrency != nil && *actual.maxConcurrency != *c.expected.maxConcurrency) {
+				t.Errorf("expected maxConcurrency %v, but got %v", c.expected.maxConcurrency, actual.maxConcurrency)
+			}
+		})
+	}
+}
+
+func Test_getRolloutStatus(t *testing.T) {
+	now := time.Now()
+	updatedAt := now.Add(-10 * time.Minute)
+	updated := map[string]string{common.AnnotationRolloutUpdatedAt: updatedAt.Format(time.RFC3339)}
+	config := &rolloutConfig{minSuccessTime: 5 * time.Minute, progressDeadline: 30 * time.Minute}
+
+	cases := []struct {
+		name     string
+		addon    *v1alpha1.ManagedClusterAddOn
+		config   *rolloutConfig
+		expected rolloutStatus
+	}{
+		{
+			name:     "not being updated",
+			addon:    newRolloutAddon("cluster1", "", nil, nil),
+			expected: rolloutNone,
+		},
+		{
+			name: "held",
+			addon: newRolloutAddon("cluster1", "", map[string]string{
+				common.AnnotationRolloutPending: updatedAt.Format(time.RFC3339),
+			}, nil),
+			expected: rolloutToApply,
+		},
+		{
+			name:     "available for minSuccessTime",
+			addon:    newRolloutAddon("cluster1", "", updated, newAvailableCondition(metav1.ConditionTrue, updatedAt)),
+			expected: rolloutSucceeded,
+		},
+		{
+			name:     "available for less than minSuccessTime",
+			addon:    newRolloutAddon("cluster1", "", updated, newAvailableCondition(metav1.ConditionTrue, updatedAt)),
+			config:   &rolloutConfig{minSuccessTime: time.Hour},
+			expected: rolloutProgressing,
+		},
+		{
+			name: "unavailable after the update",
+			addon: newRolloutAddon("cluster1", "", updated,
+				newAvailableCondition(metav1.ConditionFalse, updatedAt.Add(time.Minute))),
+			expected: rolloutFailed,
+		},
+		{
+			name: "unavailable before the update",
+			addon: newRolloutAddon("cluster1", "", updated,
+				newAvailableCondition(metav1.ConditionFalse, updatedAt.Add(-time.Minute))),
+			expected: rolloutProgressing,
+		},
+		{
+			name: "unavailable beyond the progress deadline",
+			addon: newRolloutAddon("cluster1", "", updated,
+				newAvailableCondition(metav1.ConditionFalse, updatedAt.Add(-time.Minute))),
+			config:   &rolloutConfig{progressDeadline: 5 * time.Minute},
+			expected: rolloutTimeout,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rollout := config
+			if c.config != nil {
+				rollout = c.config
+			}
+			if actual, _ := rollout.getRolloutStatus(c.addon, now); actual != c.expected {
+				t.Errorf("expected status %v, but got %v", c.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_canProceed(t *testing.T) {
+	maxConcurrency := intstr.FromInt32(2)
+
+	cases := []struct {
+		name     string
+		config   *rolloutConfig
+		clusters []rolloutCluster
+		cluster  string
+		expected bool
+	}{
+		{
+			name:   "within maxConcurrency",
+			config: &rolloutConfig{rolloutType: clusterv1alpha1.Progressive, maxConcurrency: &maxConcurrency},
+			clusters: []rolloutCluster{
+				{name: "cluster1", status: rolloutProgressing},
+				{name: "cluster2", status: rolloutToApply},
+				{name: "cluster3", status: rolloutToApply},
+			},
+			cluster:  "cluster2",
+			expected: true,
+		},
+		{
+			name:   "beyond maxConcurrency",
+			config: &rolloutConfig{rolloutType: clusterv1alpha1.Progressive, maxConcurrency: &maxConcurrency},
+			clusters: []rolloutCluster{
+				{name: "cluster1", status: rolloutProgressing},
+				{name: "cluster2", status: rolloutToApply},
+				{name: "cluster3", status: rolloutToApply},
+			},
+			cluster: "cluster3",
+		},
+		{
+			name:   "failures exceed maxFailures",
+			config: &rolloutConfig{rolloutType: clusterv1alpha1.Progressive, maxFailures: intstr.FromInt32(1)},
+			clusters: []rolloutCluster{
+				{name: "cluster1", status: rolloutFailed},
+				{name: "cluster2", status: rolloutTimeout},
+				{name: "cluster3", status: rolloutToApply},
+			},
+			cluster: "cluster3",
+		},
+		{
+			name:   "failures within maxFailures",
+			config: &rolloutConfig{rolloutType: clusterv1alpha1.Progressive, maxFailures: intstr.FromString("50%")},
+			clusters: []rolloutCluster{
+				{name: "cluster1", status: rolloutFailed},
+				{name: "cluster2", status: rolloutSucceeded},
+				{name: "cluster3", status: rolloutToApply},
+			},
+			cluster:  "cluster3",
+			expected: true,
+		},
+		{
+			name: "waiting for the mandatory groups",
+			config: &rolloutConfig{
+				rolloutType:     clusterv1alpha1.Progressive,
+				mandatoryGroups: []clusterv1alpha1.MandatoryDecisionGroup{{GroupName: "canary"}},
+			},
+			clusters: []rolloutCluster{
+				{name: "cluster1", group: "canary", status: rolloutProgressing},
+				{name: "cluster2", status: rolloutToApply},
+			},
+			cluster: "cluster2",
+		},
+		{
+			name: "mandatory groups are completed",
+			config: &rolloutConfig{
+				rolloutType:     clusterv1alpha1.Progressive,
+				mandatoryGroups: []clusterv1alpha1.MandatoryDecisionGroup{{GroupName: "canary"}},
+			},
+			clusters: []rolloutCluster{
+				{name: "cluster1", group: "canary", status: rolloutSucceeded},
+				{name: "cluster2", status: rolloutToApply},
+			},
+			cluster:  "cluster2",
+			expected: true,
+		},
+		{
+			name: "mandatory groups tolerate no failures",
+			config: &rolloutConfig{
+				rolloutType:     clusterv1alpha1.Progressive,
+				maxFailures:     intstr.FromInt32(1),
+				mandatoryGroups: []clusterv1alpha1.MandatoryDecisionGroup{{GroupName: "canary"}},
+			},
+			clusters: []rolloutCluster{
+				{name: "cluster1", group: "canary", status: rolloutFailed},
+				{name: "cluster2", group: "canary", status: rolloutToApply},
+			},
+			cluster: "cluster2",
+		},
+		{
+			name:   "the first group is updated first",
+			config: &rolloutConfig{rolloutType: clusterv1alpha1.ProgressivePerGroup},
+			clusters: []rolloutCluster{
+				{name: "cluster1", group: "a", status: rolloutToApply},
+				{name: "cluster2", group: "a", status: rolloutToApply},
+				{name: "cluster3", group: "b", status: rolloutToApply},
+			},
+			cluster:  "cluster2",
+			expected: true,
+		},
+		{
+			name:   "waiting for the previous group",
+			config: &rolloutConfig{rolloutType: clusterv1alpha1.ProgressivePerGroup},
+			clusters: []rolloutCluster{
+				{name: "cluster1", group: "a", status: rolloutProgressing},
+				{name: "cluster3", group: "b", status: rolloutToApply},
+			},
+			cluster: "cluster3",
+		},
+		{
+			name:   "the clusters without the group label are updated last",
+			config: &rolloutConfig{rolloutType: clusterv1alpha1.ProgressivePerGroup},
+			clusters: []rolloutCluster{
+				{name: "cluster1", status: rolloutToApply},
+				{name: "cluster2", group: "z", status: rolloutToApply},
+			},
+			cluster: "cluster1",
+		},
+		{
+			name: "the mandatory group selected by index is updated first",
+			config: &rolloutConfig{
+				rolloutType:     clusterv1alpha1.ProgressivePerGroup,
+				mandatoryGroups: []clusterv1alpha1.MandatoryDecisionGroup{{GroupIndex: 1}},
+			},
+			clusters: []rolloutCluster{
+				{name: "cluster1", group: "a", status: rolloutToApply},
+				{name: "cluster2", group: "b", status: rolloutToApply},
+			},
+			cluster: "cluster1",
+		},
+		{
+			name: "the group failures exceed maxFailures",
+			config: &rolloutConfig{
+				rolloutType: clusterv1alpha1.ProgressivePerGroup,
+				maxFailures: intstr.FromString("50%"),
+			},
+			clusters: []rolloutCluster{
+				{name: "cluster1", group: "a", status: rolloutFailed},
+				{name: "cluster2", group: "a", status: rolloutTimeout},
+				{name: "cluster3", group: "a", status: rolloutSucceeded},
+				{name: "cluster4", group: "b", status: rolloutToApply},
+			},
+			cluster: "cluster4",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, reason := c.config.canProceed(c.clusters, c.cluster)
+			if actual != c.expected {
+				t.Errorf("expected proceed %v, but got %v: %s", c.expected, actual, reason)
+			}
+		})
+	}
+}
+
+func Test_applyManagedClusterAddonRollout(t *testing.T) {
+	testscheme := scheme.Scheme
+	_ = mcv1.AddToScheme(testscheme)
+	_ = v1alpha1.AddToScheme(testscheme)
+	_ = apis.AddToScheme(testscheme)
+
+	oldValues := globalValues{Global: global{ImagePullSecret: "old"}}
+	newValues := globalValues{Global: global{ImagePullSecret: "new"}}
+	oldValuesString, _ := marshalGlobalValues(oldValues)
+	newValuesString, _ := marshalGlobalValues(newValues)
+	settled := map[string]string{
+		common.AnnotationRolloutPending: time.Now().Add(-time.Hour).Format(time.RFC3339),
+	}
+	available := newAvailableCondition(metav1.ConditionTrue, time.Now().Add(-2*time.Hour))
+	maxConcurrency := intstr.FromInt32(1)
+	rollout := &rolloutConfig{
+		rolloutType:    clusterv1alpha1.Progressive,
+		minSuccessTime: 10 * time.Minute,
+		maxConcurrency: &maxConcurrency,
+	}
+
+	cases := []struct {
+		name               string
+		addons             []runtime.Object
+		rollout            *rolloutConfig
+		expectedValues     string
+		expectedHeld       bool
+		expectedUpdated    bool
+		expectedRollingOut bool
+	}{
+		{
+			name:           "update the values without the rollout strategy",
+			addons:         []runtime.Object{newRolloutAddon("cluster1", oldValuesString, nil, available)},
+			expectedValues: newValuesString,
+		},
+		{
+			name:               "hold the update until it settles",
+			addons:             []runtime.Object{newRolloutAddon("cluster1", oldValuesString, nil, available)},
+			rollout:            rollout,
+			expectedValues:     oldValuesString,
+			expectedHeld:       true,
+			expectedRollingOut: true,
+		},
+		{
+			name:               "apply the settled update",
+			addons:             []runtime.Object{newRolloutAddon("cluster1", oldValuesString, settled, available)},
+			rollout:            rollout,
+			expectedValues:     newValuesString,
+			expectedUpdated:    true,
+			expectedRollingOut: true,
+		},
+		{
+			name: "hold the update beyond maxConcurrency",
+			addons: []runtime.Object{
+				newRolloutAddon("cluster1", oldValuesString, settled, available),
+				newRolloutAddon("cluster0", newValuesString, map[string]string{
+					common.AnnotationRolloutUpdatedAt: time.Now().Format(time.RFC3339),
+				}, available),
+			},
+			rollout:            rollout,
+			expectedValues:     oldValuesString,
+			expectedHeld:       true,
+			expectedRollingOut: true,
+		},
+		{
+			name: "complete the succeeded update",
+			addons: []runtime.Object{newRolloutAddon("cluster1", newValuesString, map[string]string{
+				common.AnnotationRolloutUpdatedAt: time.Now().Add(-time.Hour).Format(time.RFC3339),
+			}, available)},
+			rollout:        rollout,
+			expectedValues: newValuesString,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			objs := append([]runtime.Object{
+				newManagedCluster("cluster0", nil, nil),
+				newManagedCluster("cluster1", nil, nil),
+			}, c.addons...)
+			reconciler := &ReconcileKlusterletAddOn{client: newFakeClient(testscheme, objs...)}
+
+			result, err := reconciler.applyManagedClusterAddon(context.TODO(), newValues, v1.SearchAddonName,
+				"cluster1", addonHosting{}, c.rollout, true)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			progress := result.rollout
+			if progress.held != c.expectedHeld || (len(progress.message) != 0) != c.expectedRollingOut {
+				t.Errorf("expected held %v and rolling out %v, but got %+v", c.expectedHeld, c.expectedRollingOut,
+					progress)
+			}
+
+			addon := &v1alpha1.ManagedClusterAddOn{}
+			if err := reconciler.client.Get(context.TODO(),
+				types.NamespacedName{Namespace: "cluster1", Name: v1.SearchAddonName}, addon); err != nil {
+				t.Fatalf("failed to get addon: %v", err)
+			}
+			if values := addon.Annotations[annotationValues]; values != c.expectedValues {
+				t.Errorf("expected values %s, but got %s", c.expectedValues, values)
+			}
+			if _, held := addon.Annotations[common.AnnotationRolloutPending]; held != c.expectedHeld {
+				t.Errorf("expected held annotation %v, but got %v", c.expectedHeld, held)
+			}
+			if _, updated := addon.Annotations[common.AnnotationRolloutUpdatedAt]; updated != c.expectedUpdated {
+				t.Errorf("expected updated annotation %v, but got %v", c.expectedUpdated, updated)
+			}
+		})
+	}
+}
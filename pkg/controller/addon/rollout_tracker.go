@@ -0,0 +1,281 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addon
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+)
+
+// rolloutTracker keeps the rollout state of the addons on the managed clusters of this shard, so the clusters
+// selected by the rollout strategy of an addon are computed once and shared by the reconciles of all the managed
+// clusters, instead of listing the addons and the managed clusters in every reconcile. It is refreshed by the
+// event handlers of the managedClusterAddOns and the managedClusters, and the selection of an addon is computed
+// again only when the addons of the same name or the managed clusters change, or the rollout statuses expire.
+type rolloutTracker struct {
+	shard *common.Shard
+
+	lock sync.Mutex
+	// addons are the rollout annotations and the available conditions of the addons, keyed by the addon names and
+	// the cluster names
+	addons map[string]map[string]*addonv1alpha1.ManagedClusterAddOn
+	// clusters are the labels of the managed clusters of this shard, keyed by the cluster names
+	clusters map[string]map[string]string
+	// updating are the updatedAt annotations of the updates applied by this controller which are not observed by
+	// the event handlers yet, keyed by the addon names and the cluster names
+	updating map[string]map[string]string
+	// selections are the clusters selected by the rollout strategies of the addons, keyed by the addon names
+	selections map[string]*trackedSelection
+
+	// synced are the functions which return true once the existing objects are added to the tracker
+	synced []func() bool
+}
+
+// trackedSelection is the selection of an addon computed by the rollout config until it expires
+type trackedSelection struct {
+	*rolloutSelection
+	config    *rolloutConfig
+	expiresAt time.Time
+}
+
+func newRolloutTracker(shard *common.Shard) *rolloutTracker {
+	return &rolloutTracker{
+		shard:      shard,
+		addons:     map[string]map[string]*addonv1alpha1.ManagedClusterAddOn{},
+		clusters:   map[string]map[string]string{},
+		updating:   map[string]map[string]string{},
+		selections: map[string]*trackedSelection{},
+	}
+}
+
+// register adds the event handlers of the managedClusterAddOns and the managedClusters to the informers of the cache
+func (t *rolloutTracker) register(ctx context.Context, c cache.Cache) error {
+	addonInformer, err := c.GetInformer(ctx, &addonv1alpha1.ManagedClusterAddOn{})
+	if err != nil {
+		return err
+	}
+	registration, err := addonInformer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { t.setAddon(obj) },
+		UpdateFunc: func(_, obj interface{}) { t.setAddon(obj) },
+		DeleteFunc: func(obj interface{}) { t.deleteAddon(obj) },
+	})
+	if err != nil {
+		return err
+	}
+	t.synced = append(t.synced, registration.HasSynced)
+
+	clusterInformer, err := c.GetInformer(ctx, &mcv1.ManagedCluster{})
+	if err != nil {
+		return err
+	}
+	registration, err = clusterInformer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { t.setCluster(obj) },
+		UpdateFunc: func(_, obj interface{}) { t.setCluster(obj) },
+		DeleteFunc: func(obj interface{}) { t.deleteCluster(obj) },
+	})
+	if err != nil {
+		return err
+	}
+	t.synced = append(t.synced, registration.HasSynced)
+	return nil
+}
+
+// hasSynced returns true if the existing addons and managed clusters are added to the tracker
+func (t *rolloutTracker) hasSynced() bool {
+	if len(t.synced) == 0 {
+		return false
+	}
+	for _, synced := range t.synced {
+		if !synced() {
+			return false
+		}
+	}
+	return true
+}
+
+// canProceed returns true if the held values update of the addon on the given cluster can be applied by the
+// rollout config, otherwise the reason why it is still held is returned
+func (t *rolloutTracker) canProceed(config *rolloutConfig, addonName, clusterName string, now time.Time) (bool,
+	string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	selection, ok := t.selections[addonName]
+	if !ok || !now.Before(selection.expiresAt) || !reflect.DeepEqual(selection.config, config) {
+		selection = t.selectClusters(config, addonName, now)
+		t.selections[addonName] = selection
+	}
+	return selection.canProceed(clusterName)
+}
+
+// selectClusters computes the selection of the addon, it expires when the status of an update being rolled out
+// may change without an event, like an addon keeps available for minSuccessTime
+func (t *rolloutTracker) selectClusters(config *rolloutConfig, addonName string, now time.Time) *trackedSelection {
+	expiresAt := now.Add(rolloutRequeuePeriod)
+	clusters := []rolloutCluster{}
+	for clusterName, addon := range t.addons[addonName] {
+		labels, ok := t.clusters[clusterName]
+		if !ok {
+			continue
+		}
+		status, requeueAfter := config.getRolloutStatus(addon, now)
+		if updatedAt, ok := t.updating[addonName][clusterName]; ok {
+			if appliedAt, err := time.Parse(time.RFC3339, updatedAt); err == nil &&
+				now.Sub(appliedAt) < rolloutSettlePeriod {
+				status = rolloutProgressing
+			} else {
+				// the update is not observed within the settle period, it failed to be applied
+				delete(t.updating[addonName], clusterName)
+			}
+		}
+		if status == rolloutProgressing && requeueAfter > 0 && now.Add(requeueAfter).Before(expiresAt) {
+			expiresAt = now.Add(requeueAfter)
+		}
+		clusters = append(clusters, rolloutCluster{
+			name:   clusterName,
+			group:  labels[config.groupLabel],
+			status: status,
+		})
+	}
+	return &trackedSelection{
+		rolloutSelection: config.selectClusters(clusters),
+		config:           config,
+		expiresAt:        expiresAt,
+	}
+}
+
+// markUpdated records the held update applied by this controller, so it is counted as being rolled out before
+// its addon is updated in the cache. The selection is kept, the clusters selected together are still selected.
+func (t *rolloutTracker) markUpdated(addonName, clusterName, updatedAt string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if _, ok := t.updating[addonName]; !ok {
+		t.updating[addonName] = map[string]string{}
+	}
+	t.updating[addonName][clusterName] = updatedAt
+}
+
+func (t *rolloutTracker) setAddon(obj interface{}) {
+	addon, ok := obj.(*addonv1alpha1.ManagedClusterAddOn)
+	if !ok {
+		return
+	}
+	if _, ok := agentv1.KlusterletAddons[addon.Name]; !ok {
+		return
+	}
+	if !addon.DeletionTimestamp.IsZero() {
+		t.deleteAddon(addon)
+		return
+	}
+
+	tracked := newTrackedAddon(addon)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if updatedAt, ok := t.updating[addon.Name][addon.Namespace]; ok &&
+		updatedAt == addon.Annotations[common.AnnotationRolloutUpdatedAt] {
+		delete(t.updating[addon.Name], addon.Namespace)
+	}
+	if existing, ok := t.addons[addon.Name][addon.Namespace]; ok && reflect.DeepEqual(existing, tracked) {
+		return
+	}
+	if _, ok := t.addons[addon.Name]; !ok {
+		t.addons[addon.Name] = map[string]*addonv1alpha1.ManagedClusterAddOn{}
+	}
+	t.addons[addon.Name][addon.Namespace] = tracked
+	delete(t.selections, addon.Name)
+}
+
+func (t *rolloutTracker) deleteAddon(obj interface{}) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	addon, ok := obj.(*addonv1alpha1.ManagedClusterAddOn)
+	if !ok {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if _, ok := t.addons[addon.Name][addon.Namespace]; !ok {
+		return
+	}
+	delete(t.addons[addon.Name], addon.Namespace)
+	delete(t.updating[addon.Name], addon.Namespace)
+	delete(t.selections, addon.Name)
+}
+
+func (t *rolloutTracker) setCluster(obj interface{}) {
+	cluster, ok := obj.(*mcv1.ManagedCluster)
+	if !ok {
+		return
+	}
+	if !t.shard.Contains(cluster) {
+		t.deleteCluster(cluster)
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if existing, ok := t.clusters[cluster.Name]; ok && reflect.DeepEqual(existing, cluster.Labels) {
+		return
+	}
+	t.clusters[cluster.Name] = cluster.Labels
+	t.selections = map[string]*trackedSelection{}
+}
+
+func (t *rolloutTracker) deleteCluster(obj interface{}) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	cluster, ok := obj.(*mcv1.ManagedCluster)
+	if !ok {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if _, ok := t.clusters[cluster.Name]; !ok {
+		return
+	}
+	delete(t.clusters, cluster.Name)
+	t.selections = map[string]*trackedSelection{}
+}
+
+// newTrackedAddon returns the copy of the addon with only the fields the rollout status is computed from
+func newTrackedAddon(addon *addonv1alpha1.ManagedClusterAddOn) *addonv1alpha1.ManagedClusterAddOn {
+	tracked := &addonv1alpha1.ManagedClusterAddOn{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        addon.Name,
+			Namespace:   addon.Namespace,
+			Annotations: map[string]string{},
+		},
+	}
+	for _, key := range []string{common.AnnotationRolloutPending, common.AnnotationRolloutUpdatedAt} {
+		if value, ok := addon.Annotations[key]; ok {
+			tracked.Annotations[key] = value
+		}
+	}
+	available := meta.FindStatusCondition(addon.Status.Conditions, addonv1alpha1.ManagedClusterAddOnConditionAvailable)
+	if available != nil {
+		tracked.Status.Conditions = []metav1.Condition{*available}
+	}
+	return tracked
+}
@@ -0,0 +1,261 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"open-cluster-management.io/api/addon/v1alpha1"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1alpha1 "open-cluster-management.io/api/cluster/v1alpha1"
+
+	"github.com/stolostron/klusterlet-addon-controller/pkg/apis"
+	v1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+)
+
+// newSyncedRolloutTracker returns the rollout tracker which is synced with the given clusters and addons
+func newSyncedRolloutTracker(clusters []*mcv1.ManagedCluster,
+	addons []*v1alpha1.ManagedClusterAddOn) *rolloutTracker {
+	tracker := newRolloutTracker(nil)
+	tracker.synced = []func() bool{func() bool { return true }}
+	for _, cluster := range clusters {
+		tracker.setCluster(cluster)
+	}
+	for _, addon := range addons {
+		tracker.setAddon(addon)
+	}
+	return tracker
+}
+
+// listCountingClient counts the lists of the managedClusterAddOns
+type listCountingClient struct {
+	client.Client
+	lists int
+}
+
+func (c *listCountingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if _, ok := list.(*v1alpha1.ManagedClusterAddOnList); ok {
+		c.lists++
+	}
+	return c.Client.List(ctx, list, opts...)
+}
+
+func Test_rolloutTrackerCanProceed(t *testing.T) {
+	now := time.Now()
+	settled := map[string]string{
+		common.AnnotationRolloutPending: now.Add(-time.Hour).Format(time.RFC3339),
+	}
+	progressing := map[string]string{
+		common.AnnotationRolloutUpdatedAt: now.Format(time.RFC3339),
+	}
+	available := newAvailableCondition(metav1.ConditionTrue, now.Add(-2*time.Hour))
+	maxConcurrency := intstr.FromInt32(1)
+	rollout := &rolloutConfig{
+		rolloutType:    clusterv1alpha1.Progressive,
+		minSuccessTime: 10 * time.Minute,
+		maxConcurrency: &maxConcurrency,
+	}
+
+	cases := []struct {
+		name     string
+		clusters []string
+		addons   []*v1alpha1.ManagedClusterAddOn
+		deleted  []*v1alpha1.ManagedClusterAddOn
+		updated  []string
+		expected bool
+	}{
+		{
+			name:     "within maxConcurrency",
+			clusters: []string{"cluster1", "cluster2"},
+			addons: []*v1alpha1.ManagedClusterAddOn{
+				newRolloutAddon("cluster1", "", settled, available),
+				newRolloutAddon("cluster2", "", settled, available),
+			},
+			expected: true,
+		},
+		{
+			name:     "beyond maxConcurrency",
+			clusters: []string{"cluster0", "cluster1"},
+			addons: []*v1alpha1.ManagedClusterAddOn{
+				newRolloutAddon("cluster0", "", progressing, available),
+				newRolloutAddon("cluster1", "", settled, available),
+			},
+		},
+		{
+			name:     "the applied updates are counted before they are observed",
+			clusters: []string{"cluster0", "cluster1"},
+			addons: []*v1alpha1.ManagedClusterAddOn{
+				newRolloutAddon("cluster0", "", settled, available),
+				newRolloutAddon("cluster1", "", settled, available),
+			},
+			updated: []string{"cluster0"},
+		},
+		{
+			name:     "the addons of the unknown clusters are ignored",
+			clusters: []string{"cluster1"},
+			addons: []*v1alpha1.ManagedClusterAddOn{
+				newRolloutAddon("cluster0", "", progressing, available),
+				newRolloutAddon("cluster1", "", settled, available),
+			},
+			expected: true,
+		},
+		{
+			name:     "the deleted addons are ignored",
+			clusters: []string{"cluster0", "cluster1"},
+			addons: []*v1alpha1.ManagedClusterAddOn{
+				newRolloutAddon("cluster0", "", progressing, available),
+				newRolloutAddon("cluster1", "", settled, available),
+			},
+			deleted:  []*v1alpha1.ManagedClusterAddOn{newRolloutAddon("cluster0", "", progressing, available)},
+			expected: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			clusters := []*mcv1.ManagedCluster{}
+			for _, clusterName := range c.clusters {
+				clusters = append(clusters, newManagedCluster(clusterName, nil, nil))
+			}
+			tracker := newSyncedRolloutTracker(clusters, c.addons)
+			for _, addon := range c.deleted {
+				tracker.deleteAddon(addon)
+			}
+			for _, clusterName := range c.updated {
+				tracker.markUpdated(v1.SearchAddonName, clusterName, now.Format(time.RFC3339))
+			}
+
+			actual, reason := tracker.canProceed(rollout, v1.SearchAddonName, "cluster1", now)
+			if actual != c.expected {
+				t.Errorf("expected %v, but got %v: %s", c.expected, actual, reason)
+			}
+		})
+	}
+}
+
+func Test_rolloutTrackerRefresh(t *testing.T) {
+	now := time.Now()
+	settled := map[string]string{
+		common.AnnotationRolloutPending: now.Add(-time.Hour).Format(time.RFC3339),
+	}
+	available := newAvailableCondition(metav1.ConditionTrue, now.Add(-2*time.Hour))
+	maxConcurrency := intstr.FromInt32(1)
+	rollout := &rolloutConfig{
+		rolloutType:    clusterv1alpha1.Progressive,
+		minSuccessTime: 10 * time.Minute,
+		maxConcurrency: &maxConcurrency,
+	}
+	tracker := newSyncedRolloutTracker(
+		[]*mcv1.ManagedCluster{newManagedCluster("cluster1", nil, nil), newManagedCluster("cluster2", nil, nil)},
+		[]*v1alpha1.ManagedClusterAddOn{
+			newRolloutAddon("cluster1", "", settled, available),
+			newRolloutAddon("cluster2", "", settled, available),
+		})
+
+	if ok, reason := tracker.canProceed(rollout, v1.SearchAddonName, "cluster1", now); !ok {
+		t.Fatalf("expected cluster1 to proceed, but got %s", reason)
+	}
+	if ok, _ := tracker.canProceed(rollout, v1.SearchAddonName, "cluster2", now); ok {
+		t.Fatalf("expected cluster2 to be held")
+	}
+
+	// the selection is shared until the rollout state of the addon changes
+	selection := tracker.selections[v1.SearchAddonName]
+	tracker.setAddon(newRolloutAddon("cluster1", "values", settled, available))
+	if ok, _ := tracker.canProceed(rollout, v1.SearchAddonName, "cluster2", now); ok ||
+		tracker.selections[v1.SearchAddonName] != selection {
+		t.Errorf("expected the selection to be shared")
+	}
+
+	// the selection expires when the update of cluster1 succeeds after minSuccessTime
+	tracker.setAddon(newRolloutAddon("cluster1", "values", map[string]string{
+		common.AnnotationRolloutUpdatedAt: now.Format(time.RFC3339),
+	}, available))
+	if ok, _ := tracker.canProceed(rollout, v1.SearchAddonName, "cluster2", now); ok {
+		t.Errorf("expected cluster2 to be held while cluster1 is being updated")
+	}
+	if ok, reason := tracker.canProceed(rollout, v1.SearchAddonName, "cluster2",
+		now.Add(rollout.minSuccessTime)); !ok {
+		t.Errorf("expected cluster2 to proceed after cluster1 is updated, but got %s", reason)
+	}
+
+	// the selection is computed again when the clusters change
+	tracker.deleteCluster(newManagedCluster("cluster2", nil, nil))
+	if ok, _ := tracker.canProceed(rollout, v1.SearchAddonName, "cluster2",
+		now.Add(rollout.minSuccessTime)); ok {
+		t.Errorf("expected the deleted cluster2 to be held")
+	}
+}
+
+func Test_rolloutValuesWithTracker(t *testing.T) {
+	testscheme := scheme.Scheme
+	_ = mcv1.AddToScheme(testscheme)
+	_ = v1alpha1.AddToScheme(testscheme)
+	_ = apis.AddToScheme(testscheme)
+
+	oldValuesString, _ := marshalGlobalValues(globalValues{Global: global{ImagePullSecret: "old"}})
+	newValues := globalValues{Global: global{ImagePullSecret: "new"}}
+	settled := map[string]string{
+		common.AnnotationRolloutPending: time.Now().Add(-time.Hour).Format(time.RFC3339),
+	}
+	available := newAvailableCondition(metav1.ConditionTrue, time.Now().Add(-2*time.Hour))
+	maxConcurrency := intstr.FromInt32(1)
+	rollout := &rolloutConfig{
+		rolloutType:    clusterv1alpha1.Progressive,
+		minSuccessTime: 10 * time.Minute,
+		maxConcurrency: &maxConcurrency,
+	}
+
+	clusters := []*mcv1.ManagedCluster{newManagedCluster("cluster1", nil, nil), newManagedCluster("cluster2", nil, nil)}
+	addons := []*v1alpha1.ManagedClusterAddOn{
+		newRolloutAddon("cluster1", oldValuesString, settled, available),
+		newRolloutAddon("cluster2", oldValuesString, settled, available),
+	}
+	objs := []runtime.Object{}
+	for _, cluster := range clusters {
+		objs = append(objs, cluster)
+	}
+	for _, addon := range addons {
+		objs = append(objs, addon.DeepCopy())
+	}
+	countingClient := &listCountingClient{Client: newFakeClient(testscheme, objs...)}
+	reconciler := &ReconcileKlusterletAddOn{
+		client:         countingClient,
+		rolloutTracker: newSyncedRolloutTracker(clusters, addons),
+	}
+
+	expectedHeld := map[string]bool{"cluster1": false, "cluster2": true}
+	for _, clusterName := range []string{"cluster1", "cluster2"} {
+		result, err := reconciler.applyManagedClusterAddon(context.TODO(), newValues, v1.SearchAddonName,
+			clusterName, addonHosting{}, rollout, true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.rollout.held != expectedHeld[clusterName] {
+			t.Errorf("expected cluster %s held %v, but got %+v", clusterName, expectedHeld[clusterName],
+				result.rollout)
+		}
+	}
+	if countingClient.lists != 0 {
+		t.Errorf("expected the addons not to be listed, but they are listed %d times", countingClient.lists)
+	}
+
+	addon := &v1alpha1.ManagedClusterAddOn{}
+	if err := reconciler.client.Get(context.TODO(),
+		types.NamespacedName{Namespace: "cluster1", Name: v1.SearchAddonName}, addon); err != nil {
+		t.Fatalf("failed to get addon: %v", err)
+	}
+	if _, ok := addon.Annotations[common.AnnotationRolloutUpdatedAt]; !ok {
+		t.Errorf("expected the update of cluster1 to be applied")
+	}
+}
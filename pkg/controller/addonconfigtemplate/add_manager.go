@@ -0,0 +1,174 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addonconfigtemplate
+
+import (
+	"context"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+)
+
+// Add creates the controllers rendering the klusterletAddonConfigs from the templates and reporting the status of
+// the templates, and adds them to the Manager. The status of the templates is reported by the replica handling
+// all the managed clusters only, so the replicas of different shards do not report different statuses.
+func Add(mgr manager.Manager, kubeClient kubernetes.Interface, opts *common.Options) error {
+	err := addConfigController(mgr, &ReconcileKlusterletAddonConfig{client: mgr.GetClient(), shard: opts.Shard},
+		opts.ConfigTemplate, opts.Shard)
+	if err != nil {
+		return err
+	}
+	if opts.Shard.IsSharded() {
+		return nil
+	}
+	return addStatusController(mgr, &ReconcileTemplateStatus{client: mgr.GetClient()}, opts.ConfigTemplate)
+}
+
+func addConfigController(mgr manager.Manager, r reconcile.Reconciler, opts common.ControllerOptions,
+	shard *common.Shard) error {
+	c, err := controller.New("klusterletaddonconfigtemplate-controller", mgr, opts.ToControllerOptions(r))
+	if err != nil {
+		return err
+	}
+
+	err = c.Watch(source.Kind(mgr.GetCache(), &mcv1.ManagedCluster{},
+		&handler.TypedEnqueueRequestForObject[*mcv1.ManagedCluster]{},
+		predicate.NewTypedPredicateFuncs[*mcv1.ManagedCluster](shard.Contains),
+		predicate.TypedFuncs[*mcv1.ManagedCluster]{
+			GenericFunc: func(e event.TypedGenericEvent[*mcv1.ManagedCluster]) bool { return false },
+			DeleteFunc:  func(e event.TypedDeleteEvent[*mcv1.ManagedCluster]) bool { return false },
+			UpdateFunc:  clusterLabelsChanged,
+		},
+	))
+	if err != nil {
+		return err
+	}
+
+	// the klusterletAddonConfig is rendered again if it is deleted, unless the cluster opts out of it
+	err = c.Watch(source.Kind(mgr.GetCache(), &agentv1.KlusterletAddonConfig{},
+		handler.TypedEnqueueRequestsFromMapFunc[*agentv1.KlusterletAddonConfig](
+			func(ctx context.Context, config *agentv1.KlusterletAddonConfig) []reconcile.Request {
+				return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: config.Namespace}}}
+			}),
+		predicate.TypedFuncs[*agentv1.KlusterletAddonConfig]{
+			GenericFunc: func(e event.TypedGenericEvent[*agentv1.KlusterletAddonConfig]) bool { return false },
+			CreateFunc:  func(e event.TypedCreateEvent[*agentv1.KlusterletAddonConfig]) bool { return false },
+			UpdateFunc:  func(e event.TypedUpdateEvent[*agentv1.KlusterletAddonConfig]) bool { return false },
+			DeleteFunc: func(e event.TypedDeleteEvent[*agentv1.KlusterletAddonConfig]) bool {
+				return shard.ContainsNamespace(context.TODO(), mgr.GetClient(), e.Object.GetNamespace()) &&
+					!common.ClusterOptedOut(context.TODO(), mgr.GetClient(), e.Object.GetNamespace())
+			},
+		},
+	))
+	if err != nil {
+		return err
+	}
+
+	err = c.Watch(source.Kind(mgr.GetCache(), &agentv1.KlusterletAddonConfigTemplate{},
+		handler.TypedEnqueueRequestsFromMapFunc[*agentv1.KlusterletAddonConfigTemplate](
+			enqueueAllClusters[*agentv1.KlusterletAddonConfigTemplate](mgr, shard)),
+		predicate.TypedGenerationChangedPredicate[*agentv1.KlusterletAddonConfigTemplate]{},
+	))
+	if err != nil {
+		return err
+	}
+
+	return c.Watch(source.Kind(mgr.GetCache(), &clusterv1beta2.ManagedClusterSet{},
+		handler.TypedEnqueueRequestsFromMapFunc[*clusterv1beta2.ManagedClusterSet](
+			enqueueAllClusters[*clusterv1beta2.ManagedClusterSet](mgr, shard)),
+		predicate.TypedGenerationChangedPredicate[*clusterv1beta2.ManagedClusterSet]{},
+	))
+}
+
+func addStatusController(mgr manager.Manager, r reconcile.Reconciler, opts common.ControllerOptions) error {
+	c, err := controller.New("klusterletaddonconfigtemplate-status-controller", mgr, opts.ToControllerOptions(r))
+	if err != nil {
+		return err
+	}
+
+	// the conflicts of a template depend on the other templates, so all the templates are reconciled when any
+	// template is changed
+	err = c.Watch(source.Kind(mgr.GetCache(), &agentv1.KlusterletAddonConfigTemplate{},
+		handler.TypedEnqueueRequestsFromMapFunc[*agentv1.KlusterletAddonConfigTemplate](
+			enqueueAllTemplates[*agentv1.KlusterletAddonConfigTemplate](mgr)),
+		predicate.TypedGenerationChangedPredicate[*agentv1.KlusterletAddonConfigTemplate]{},
+	))
+	if err != nil {
+		return err
+	}
+
+	err = c.Watch(source.Kind(mgr.GetCache(), &mcv1.ManagedCluster{},
+		handler.TypedEnqueueRequestsFromMapFunc[*mcv1.ManagedCluster](enqueueAllTemplates[*mcv1.ManagedCluster](mgr)),
+		predicate.TypedFuncs[*mcv1.ManagedCluster]{
+			GenericFunc: func(e event.TypedGenericEvent[*mcv1.ManagedCluster]) bool { return false },
+			UpdateFunc:  clusterLabelsChanged,
+		},
+	))
+	if err != nil {
+		return err
+	}
+
+	return c.Watch(source.Kind(mgr.GetCache(), &clusterv1beta2.ManagedClusterSet{},
+		handler.TypedEnqueueRequestsFromMapFunc[*clusterv1beta2.ManagedClusterSet](
+			enqueueAllTemplates[*clusterv1beta2.ManagedClusterSet](mgr)),
+		predicate.TypedGenerationChangedPredicate[*clusterv1beta2.ManagedClusterSet]{},
+	))
+}
+
+// clusterLabelsChanged returns true if the labels of the managed cluster are changed, the templates and the
+// ManagedClusterSets select the managed clusters by their labels
+func clusterLabelsChanged(e event.TypedUpdateEvent[*mcv1.ManagedCluster]) bool {
+	return !reflect.DeepEqual(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels())
+}
+
+// enqueueAllClusters returns the map function which enqueues all the managed clusters of the shard
+func enqueueAllClusters[T client.Object](mgr manager.Manager, shard *common.Shard) handler.TypedMapFunc[T] {
+	return func(ctx context.Context, _ T) []reconcile.Request {
+		clusters := &mcv1.ManagedClusterList{}
+		if err := mgr.GetClient().List(ctx, clusters); err != nil {
+			klog.Errorf("failed to list managedClusters: %v", err)
+			return nil
+		}
+		requests := []reconcile.Request{}
+		for i := range clusters.Items {
+			if shard.Contains(&clusters.Items[i]) {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{Name: clusters.Items[i].Name},
+				})
+			}
+		}
+		return requests
+	}
+}
+
+// enqueueAllTemplates returns the map function which enqueues all the templates
+func enqueueAllTemplates[T client.Object](mgr manager.Manager) handler.TypedMapFunc[T] {
+	return func(ctx context.Context, _ T) []reconcile.Request {
+		templates := &agentv1.KlusterletAddonConfigTemplateList{}
+		if err := mgr.GetClient().List(ctx, templates); err != nil {
+			klog.Errorf("failed to list klusterletAddonConfigTemplates: %v", err)
+			return nil
+		}
+		requests := []reconcile.Request{}
+		for _, template := range templates.Items {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: template.Name}})
+		}
+		return requests
+	}
+}
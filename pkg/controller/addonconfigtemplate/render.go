@@ -0,0 +1,402 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addonconfigtemplate
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+)
+
+// ignoredFields is the fields of the spec which are never rendered from the templates, they are always set to the
+// name of the managed cluster
+var ignoredFields = map[string]bool{
+	"clusterName":      true,
+	"clusterNamespace": true,
+}
+
+// field is a leaf field of the spec of the klusterletAddonConfig, the maps in the spec are merged field by field and
+// the other values are leaves
+type field struct {
+	path  []string
+	value interface{}
+}
+
+// fields is the leaf fields of a spec keyed by their paths
+type fields map[string]field
+
+// String returns the path of the field in the klusterletAddonConfig
+func (f field) String() string {
+	return "spec." + strings.Join(f.path, ".")
+}
+
+// fieldKey returns the key of the field path, the path elements are escaped like the JSON pointers since the keys of
+// the maps can contain any characters
+func fieldKey(path []string) string {
+	escaped := make([]string, len(path))
+	for i, p := range path {
+		escaped[i] = strings.ReplaceAll(strings.ReplaceAll(p, "~", "~0"), "/", "~1")
+	}
+	return "/" + strings.Join(escaped, "/")
+}
+
+// flatten adds the leaf fields of the object to the fields, the empty maps set no fields
+func (fs fields) flatten(obj map[string]interface{}, prefix []string) {
+	for key, value := range obj {
+		path := append(append([]string{}, prefix...), key)
+		if m, ok := value.(map[string]interface{}); ok {
+			fs.flatten(m, path)
+			continue
+		}
+		fs[fieldKey(path)] = field{path: path, value: value}
+	}
+}
+
+// object returns the object built from the fields
+func (fs fields) object() map[string]interface{} {
+	obj := map[string]interface{}{}
+	for _, f := range fs {
+		setField(obj, f.path, f.value)
+	}
+	return obj
+}
+
+// getField returns the value of the field in the object, false is returned if the field is not set
+func getField(obj map[string]interface{}, path []string) (interface{}, bool) {
+	var value interface{} = obj
+	for _, p := range path {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[p]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// setField sets the value of the field in the object, the missing maps on the path are created
+func setField(obj map[string]interface{}, path []string, value interface{}) {
+	for _, p := range path[:len(path)-1] {
+		m, ok := obj[p].(map[string]interface{})
+		if !ok {
+			m = map[string]interface{}{}
+			obj[p] = m
+		}
+		obj = m
+	}
+	obj[path[len(path)-1]] = value
+}
+
+// templateFields returns the fields set by the template
+func templateFields(template *agentv1.KlusterletAddonConfigTemplate) (fields, error) {
+	fs := fields{}
+	if len(template.Spec.Template.Raw) == 0 {
+		return fs, nil
+	}
+
+	obj := map[string]interface{}{}
+	if err := json.Unmarshal(template.Spec.Template.Raw, &obj); err != nil {
+		return nil, fmt.Errorf("invalid template of %s: %v", template.Name, err)
+	}
+	for key := range ignoredFields {
+		delete(obj, key)
+	}
+	fs.flatten(obj, nil)
+	return fs, nil
+}
+
+// sortTemplates sorts the templates by their precedence, the templates with higher priority are ahead, and the
+// templates with the same priority are sorted by name
+func sortTemplates(templates []*agentv1.KlusterletAddonConfigTemplate) {
+	sort.SliceStable(templates, func(i, j int) bool {
+		if templates[i].Spec.Priority != templates[j].Spec.Priority {
+			return templates[i].Spec.Priority > templates[j].Spec.Priority
+		}
+		return templates[i].Name < templates[j].Name
+	})
+}
+
+// selectsCluster returns true if the template selects the managed cluster, the clusterSets are the
+// ManagedClusterSets keyed by name
+func selectsCluster(template *agentv1.KlusterletAddonConfigTemplate, cluster *mcv1.ManagedCluster,
+	clusterSets map[string]*clusterv1beta2.ManagedClusterSet) (bool, error) {
+	if len(template.Spec.ClusterSets) == 0 && template.Spec.ClusterSelector == nil {
+		return false, nil
+	}
+	clusterLabels := labels.Set(cluster.GetLabels())
+
+	if len(template.Spec.ClusterSets) != 0 {
+		inClusterSets := false
+		for _, name := range template.Spec.ClusterSets {
+			clusterSet, ok := clusterSets[name]
+			if !ok {
+				continue
+			}
+			selector, err := clusterSetSelector(clusterSet)
+			if err != nil {
+				return false, err
+			}
+			if selector.Matches(clusterLabels) {
+				inClusterSets = true
+				break
+			}
+		}
+		if !inClusterSets {
+			return false, nil
+		}
+	}
+
+	if template.Spec.ClusterSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(template.Spec.ClusterSelector)
+		if err != nil {
+			return false, fmt.Errorf("invalid cluster selector of template %s: %v", template.Name, err)
+		}
+		return selector.Matches(clusterLabels), nil
+	}
+	return true, nil
+}
+
+// clusterSetSelector returns the label selector of the managed clusters in the ManagedClusterSet
+func clusterSetSelector(clusterSet *clusterv1beta2.ManagedClusterSet) (labels.Selector, error) {
+	if clusterSet.Spec.ClusterSelector.SelectorType != clusterv1beta2.LabelSelector {
+		return labels.SelectorFromSet(labels.Set{clusterv1beta2.ClusterSetLabel: clusterSet.Name}), nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(clusterSet.Spec.ClusterSelector.LabelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cluster selector of ManagedClusterSet %s: %v", clusterSet.Name, err)
+	}
+	return selector, nil
+}
+
+// selectTemplates returns the templates selecting the managed cluster in the order of their precedence, the
+// templates must be sorted by sortTemplates. The templates with invalid selectors are skipped.
+func selectTemplates(templates []*agentv1.KlusterletAddonConfigTemplate, cluster *mcv1.ManagedCluster,
+	clusterSets map[string]*clusterv1beta2.ManagedClusterSet) []*agentv1.KlusterletAddonConfigTemplate {
+	selected := []*agentv1.KlusterletAddonConfigTemplate{}
+	for _, template := range templates {
+		if !template.DeletionTimestamp.IsZero() {
+			continue
+		}
+		ok, err := selectsCluster(template, cluster, clusterSets)
+		if err != nil {
+			klog.Errorf("failed to select managed cluster %s: %v", cluster.Name, err)
+			continue
+		}
+		if ok {
+			selected = append(selected, template)
+		}
+	}
+	return selected
+}
+
+// fieldConflict is a field set to different values by the templates
+type fieldConflict struct {
+	field field
+	// templates is the names of the templates setting the field in the order of their precedence
+	templates []string
+}
+
+// renderTemplates merges the fields of the templates in the order of their precedence. The field set by a template
+// is a conflict if it is set to a different value by a template ahead of it, or if it is a map in one template and
+// another value in the other, the value of the template ahead is rendered. The conflicts are sorted by the fields.
+func renderTemplates(templates []*agentv1.KlusterletAddonConfigTemplate) (fields, []fieldConflict, error) {
+	rendered := fields{}
+	owners := map[string]string{}
+	conflicts := map[string]*fieldConflict{}
+	addConflict := func(winner string, f field, template string) {
+		key := fieldKey(f.path)
+		if _, ok := conflicts[key]; !ok {
+			conflicts[key] = &fieldConflict{field: f, templates: []string{winner}}
+		}
+		conflicts[key].templates = append(conflicts[key].templates, template)
+	}
+
+	for _, template := range templates {
+		fs, err := templateFields(template)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for key, f := range fs {
+			if existing, ok := rendered[key]; ok {
+				if !reflect.DeepEqual(existing.value, f.value) {
+					addConflict(owners[key], f, template.Name)
+				}
+				continue
+			}
+			if winner, ok := findOverlappingField(rendered, owners, f.path); ok {
+				addConflict(winner, f, template.Name)
+				continue
+			}
+			rendered[key] = f
+			owners[key] = template.Name
+		}
+	}
+
+	sorted := []fieldConflict{}
+	for _, conflict := range conflicts {
+		sorted = append(sorted, *conflict)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].field.String() < sorted[j].field.String()
+	})
+	return rendered, sorted, nil
+}
+
+// findOverlappingField returns the template owning a rendered field which is on the path of the given field, or
+// whose path contains the given field
+func findOverlappingField(rendered fields, owners map[string]string, path []string) (string, bool) {
+	for i := 1; i < len(path); i++ {
+		if _, ok := rendered[fieldKey(path[:i])]; ok {
+			return owners[fieldKey(path[:i])], true
+		}
+	}
+	prefix := fieldKey(path) + "/"
+	for key := range rendered {
+		if strings.HasPrefix(key, prefix) {
+			return owners[key], true
+		}
+	}
+	return "", false
+}
+
+// specObject returns the object of the spec of the klusterletAddonConfig
+func specObject(spec agentv1.KlusterletAddonConfigSpec) (map[string]interface{}, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	obj := map[string]interface{}{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// normalizeFields returns the rendered fields as they are read back from a klusterletAddonConfig, the values are
+// converted to the types of the spec, and the fields omitted by the spec are nil. An error is returned if the
+// rendered fields are not a valid spec.
+func normalizeFields(rendered fields) (fields, error) {
+	data, err := json.Marshal(rendered.object())
+	if err != nil {
+		return nil, err
+	}
+	spec := agentv1.KlusterletAddonConfigSpec{}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("invalid rendered spec: %v", err)
+	}
+	obj, err := specObject(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	normalized := fields{}
+	for key, f := range rendered {
+		value, _ := getField(obj, f.path)
+		normalized[key] = field{path: f.path, value: value}
+	}
+	return normalized, nil
+}
+
+// applyFields sets the rendered fields in the spec, the lastApplied is the fields rendered by the last apply. A
+// field which is changed in the spec since the last apply is a direct override and it is kept. A field which is
+// not applied before, for example all the fields of an existing klusterletAddonConfig adopted by the templates, is
+// only set if it is unset in the spec, the values set on the klusterletAddonConfig win. The fields not rendered any
+// more are kept as they are. It returns whether the spec is changed, the fields applied by the templates to be
+// saved as the last applied fields, and the overridden fields.
+func applyFields(spec *agentv1.KlusterletAddonConfigSpec, rendered, lastApplied fields) (bool, fields, []string,
+	error) {
+	obj, err := specObject(*spec)
+	if err != nil {
+		return false, nil, nil, err
+	}
+
+	changed := false
+	applied := fields{}
+	overridden := []string{}
+	for key, f := range rendered {
+		current, _ := getField(obj, f.path)
+		last, ok := lastApplied[key]
+		switch {
+		case ok && !reflect.DeepEqual(current, last.value):
+			applied[key] = f
+			overridden = append(overridden, f.String())
+			continue
+		case !ok && !isUnset(current) && !reflect.DeepEqual(current, f.value):
+			overridden = append(overridden, f.String())
+			continue
+		}
+		applied[key] = f
+		if !reflect.DeepEqual(current, f.value) {
+			setField(obj, f.path, f.value)
+			changed = true
+		}
+	}
+	sort.Strings(overridden)
+	if !changed {
+		return false, applied, overridden, nil
+	}
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return false, nil, nil, err
+	}
+	newSpec := agentv1.KlusterletAddonConfigSpec{}
+	if err := json.Unmarshal(data, &newSpec); err != nil {
+		return false, nil, nil, err
+	}
+	*spec = newSpec
+	return true, applied, overridden, nil
+}
+
+// isUnset returns true if the value of a field read from the spec is omitted or the zero value of its type
+func isUnset(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
+
+// encodeFields returns the json of the fields saved in the last applied annotation
+func encodeFields(fs fields) (string, error) {
+	data, err := json.Marshal(fs.object())
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// decodeFields returns the fields saved in the last applied annotation, no fields are returned if the annotation is
+// empty or invalid so the rendered fields are applied again
+func decodeFields(value string) fields {
+	fs := fields{}
+	if len(value) == 0 {
+		return fs
+	}
+	obj := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(value), &obj); err != nil {
+		klog.Warningf("invalid last applied template fields %q: %v", value, err)
+		return fs
+	}
+	fs.flatten(obj, nil)
+	return fs
+}
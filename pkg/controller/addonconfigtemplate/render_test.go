@@ -0,0 +1,316 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addonconfigtemplate
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+)
+
+func newTemplate(name string, priority int32, selector map[string]string,
+	template string) *agentv1.KlusterletAddonConfigTemplate {
+	t := &agentv1.KlusterletAddonConfigTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: agentv1.KlusterletAddonConfigTemplateSpec{
+			Priority: priority,
+			Template: runtime.RawExtension{Raw: []byte(template)},
+		},
+	}
+	if selector != nil {
+		t.Spec.ClusterSelector = &metav1.LabelSelector{MatchLabels: selector}
+	}
+	return t
+}
+
+func newCluster(name string, clusterLabels map[string]string) *mcv1.ManagedCluster {
+	return &mcv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: clusterLabels},
+	}
+}
+
+func Test_selectsCluster(t *testing.T) {
+	clusterSets := map[string]*clusterv1beta2.ManagedClusterSet{
+		"exclusive": {
+			ObjectMeta: metav1.ObjectMeta{Name: "exclusive"},
+			Spec: clusterv1beta2.ManagedClusterSetSpec{
+				ClusterSelector: clusterv1beta2.ManagedClusterSelector{
+					SelectorType: clusterv1beta2.ExclusiveClusterSetLabel,
+				},
+			},
+		},
+		"global": {
+			ObjectMeta: metav1.ObjectMeta{Name: "global"},
+			Spec: clusterv1beta2.ManagedClusterSetSpec{
+				ClusterSelector: clusterv1beta2.ManagedClusterSelector{
+					SelectorType:  clusterv1beta2.LabelSelector,
+					LabelSelector: &metav1.LabelSelector{},
+				},
+			},
+		},
+	}
+
+	cases := []struct {
+		name        string
+		clusterSets []string
+		selector    map[string]string
+		labels      map[string]string
+		expected    bool
+	}{
+		{
+			name:   "no cluster sets and selector",
+			labels: map[string]string{"env": "prod"},
+		},
+		{
+			name:     "matches the selector",
+			selector: map[string]string{"env": "prod"},
+			labels:   map[string]string{"env": "prod"},
+			expected: true,
+		},
+		{
+			name:     "does not match the selector",
+			selector: map[string]string{"env": "prod"},
+			labels:   map[string]string{"env": "dev"},
+		},
+		{
+			name:        "in the exclusive cluster set",
+			clusterSets: []string{"exclusive"},
+			labels:      map[string]string{clusterv1beta2.ClusterSetLabel: "exclusive"},
+			expected:    true,
+		},
+		{
+			name:        "not in the exclusive cluster set",
+			clusterSets: []string{"exclusive", "unknown"},
+			labels:      map[string]string{clusterv1beta2.ClusterSetLabel: "default"},
+		},
+		{
+			name:        "in the label selector cluster set but does not match the selector",
+			clusterSets: []string{"global"},
+			selector:    map[string]string{"env": "prod"},
+			labels:      map[string]string{"env": "dev"},
+		},
+		{
+			name:        "in the label selector cluster set and matches the selector",
+			clusterSets: []string{"global"},
+			selector:    map[string]string{"env": "prod"},
+			labels:      map[string]string{"env": "prod"},
+			expected:    true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			template := newTemplate("template", 0, c.selector, "{}")
+			template.Spec.ClusterSets = c.clusterSets
+			actual, err := selectsCluster(template, newCluster("cluster1", c.labels), clusterSets)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual != c.expected {
+				t.Errorf("expected %v, but got %v", c.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_renderTemplates(t *testing.T) {
+	cases := []struct {
+		name              string
+		templates         []*agentv1.KlusterletAddonConfigTemplate
+		expected          map[string]interface{}
+		expectedConflicts map[string][]string
+	}{
+		{
+			name: "maps are merged",
+			templates: []*agentv1.KlusterletAddonConfigTemplate{
+				newTemplate("a", 0, nil, `{"searchCollector":{"enabled":true}}`),
+				newTemplate("b", 0, nil, `{"searchCollector":{"proxyPolicy":"Disabled"},"clusterName":"x"}`),
+			},
+			expected: map[string]interface{}{
+				"searchCollector": map[string]interface{}{"enabled": true, "proxyPolicy": "Disabled"},
+			},
+		},
+		{
+			name: "higher priority wins",
+			templates: []*agentv1.KlusterletAddonConfigTemplate{
+				newTemplate("a", 0, nil, `{"searchCollector":{"enabled":true}}`),
+				newTemplate("b", 10, nil, `{"searchCollector":{"enabled":false}}`),
+			},
+			expected: map[string]interface{}{
+				"searchCollector": map[string]interface{}{"enabled": false},
+			},
+			expectedConflicts: map[string][]string{"spec.searchCollector.enabled": {"b", "a"}},
+		},
+		{
+			name: "smaller name wins with the same priority",
+			templates: []*agentv1.KlusterletAddonConfigTemplate{
+				newTemplate("b", 0, nil, `{"imagePullSecret":"b"}`),
+				newTemplate("a", 0, nil, `{"imagePullSecret":"a"}`),
+			},
+			expected:          map[string]interface{}{"imagePullSecret": "a"},
+			expectedConflicts: map[string][]string{"spec.imagePullSecret": {"a", "b"}},
+		},
+		{
+			name: "same values are not conflicts",
+			templates: []*agentv1.KlusterletAddonConfigTemplate{
+				newTemplate("a", 0, nil, `{"imagePullSecret":"a"}`),
+				newTemplate("b", 0, nil, `{"imagePullSecret":"a"}`),
+			},
+			expected: map[string]interface{}{"imagePullSecret": "a"},
+		},
+		{
+			name: "a value overlaps a map",
+			templates: []*agentv1.KlusterletAddonConfigTemplate{
+				newTemplate("a", 0, nil, `{"nodePlacement":null}`),
+				newTemplate("b", 0, nil, `{"nodePlacement":{"nodeSelector":{"infra":""}}}`),
+			},
+			expected:          map[string]interface{}{"nodePlacement": nil},
+			expectedConflicts: map[string][]string{"spec.nodePlacement.nodeSelector.infra": {"a", "b"}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			templates := append([]*agentv1.KlusterletAddonConfigTemplate{}, c.templates...)
+			sortTemplates(templates)
+			rendered, conflicts, err := renderTemplates(templates)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual := rendered.object(); !reflect.DeepEqual(actual, c.expected) {
+				t.Errorf("expected rendered %v, but got %v", c.expected, actual)
+			}
+			actualConflicts := map[string][]string{}
+			for _, conflict := range conflicts {
+				actualConflicts[conflict.field.String()] = conflict.templates
+			}
+			if len(c.expectedConflicts) == 0 && len(actualConflicts) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(actualConflicts, c.expectedConflicts) {
+				t.Errorf("expected conflicts %v, but got %v", c.expectedConflicts, actualConflicts)
+			}
+		})
+	}
+}
+
+func Test_applyFields(t *testing.T) {
+	render := func(template string) fields {
+		rendered, _, err := renderTemplates([]*agentv1.KlusterletAddonConfigTemplate{
+			newTemplate("a", 0, nil, template),
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		normalized, err := normalizeFields(rendered)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return normalized
+	}
+
+	cases := []struct {
+		name               string
+		spec               agentv1.KlusterletAddonConfigSpec
+		template           string
+		lastApplied        string
+		expected           agentv1.KlusterletAddonConfigSpec
+		expectedChanged    bool
+		expectedApplied    string
+		expectedOverridden []string
+	}{
+		{
+			name: "the fields are adopted without the last applied fields",
+			spec: agentv1.KlusterletAddonConfigSpec{
+				ImagePullSecret:       "pull-secret",
+				SearchCollectorConfig: agentv1.KlusterletAddonAgentConfigSpec{Enabled: true},
+			},
+			template: `{"searchCollector":{"enabled":false},"policyController":{"enabled":true}}`,
+			expected: agentv1.KlusterletAddonConfigSpec{
+				ImagePullSecret:       "pull-secret",
+				SearchCollectorConfig: agentv1.KlusterletAddonAgentConfigSpec{Enabled: true},
+				PolicyController:      agentv1.KlusterletAddonAgentConfigSpec{Enabled: true},
+			},
+			expectedChanged:    true,
+			expectedApplied:    `{"policyController":{"enabled":true}}`,
+			expectedOverridden: []string{"spec.searchCollector.enabled"},
+		},
+		{
+			name:            "the fields equal to the rendered values are adopted",
+			spec:            agentv1.KlusterletAddonConfigSpec{ImagePullSecret: "pull-secret"},
+			template:        `{"imagePullSecret":"pull-secret"}`,
+			expected:        agentv1.KlusterletAddonConfigSpec{ImagePullSecret: "pull-secret"},
+			expectedApplied: `{"imagePullSecret":"pull-secret"}`,
+		},
+		{
+			name: "the fields changed since the last apply are kept",
+			spec: agentv1.KlusterletAddonConfigSpec{
+				SearchCollectorConfig: agentv1.KlusterletAddonAgentConfigSpec{Enabled: true},
+				PolicyController:      agentv1.KlusterletAddonAgentConfigSpec{Enabled: true},
+			},
+			template:    `{"searchCollector":{"enabled":false},"policyController":{"enabled":false}}`,
+			lastApplied: `{"searchCollector":{"enabled":false},"policyController":{"enabled":true}}`,
+			expected: agentv1.KlusterletAddonConfigSpec{
+				SearchCollectorConfig: agentv1.KlusterletAddonAgentConfigSpec{Enabled: true},
+			},
+			expectedChanged:    true,
+			expectedApplied:    `{"policyController":{"enabled":false},"searchCollector":{"enabled":false}}`,
+			expectedOverridden: []string{"spec.searchCollector.enabled"},
+		},
+		{
+			name: "the omitted zero values are not overrides",
+			spec: agentv1.KlusterletAddonConfigSpec{
+				SearchCollectorConfig: agentv1.KlusterletAddonAgentConfigSpec{Enabled: true},
+			},
+			template:    `{"paused":false,"searchCollector":{"enabled":true}}`,
+			lastApplied: `{"paused":null,"searchCollector":{"enabled":true}}`,
+			expected: agentv1.KlusterletAddonConfigSpec{
+				SearchCollectorConfig: agentv1.KlusterletAddonAgentConfigSpec{Enabled: true},
+			},
+			expectedApplied: `{"paused":null,"searchCollector":{"enabled":true}}`,
+		},
+		{
+			name:            "the fields not rendered any more are kept",
+			spec:            agentv1.KlusterletAddonConfigSpec{ImagePullSecret: "pull-secret"},
+			template:        `{}`,
+			lastApplied:     `{"imagePullSecret":"pull-secret"}`,
+			expected:        agentv1.KlusterletAddonConfigSpec{ImagePullSecret: "pull-secret"},
+			expectedApplied: `{}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			spec := c.spec
+			changed, applied, overridden, err := applyFields(&spec, render(c.template), decodeFields(c.lastApplied))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if changed != c.expectedChanged {
+				t.Errorf("expected changed %v, but got %v", c.expectedChanged, changed)
+			}
+			lastApplied, err := encodeFields(applied)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if lastApplied != c.expectedApplied {
+				t.Errorf("expected applied %s, but got %s", c.expectedApplied, lastApplied)
+			}
+			if len(overridden) != 0 || len(c.expectedOverridden) != 0 {
+				if !reflect.DeepEqual(overridden, c.expectedOverridden) {
+					t.Errorf("expected overridden %v, but got %v", c.expectedOverridden, overridden)
+				}
+			}
+			if !reflect.DeepEqual(spec, c.expected) {
+				t.Errorf("expected spec %+v, but got %+v", c.expected, spec)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addonconfigtemplate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+)
+
+// maxStatusConflicts is the maximum number of the conflicts listed in the status of a template
+const maxStatusConflicts = 20
+
+// blank assignment to verify that ReconcileTemplateStatus implements reconcile.Reconciler
+var _ reconcile.Reconciler = &ReconcileTemplateStatus{}
+
+// ReconcileTemplateStatus reports the managed clusters selected by a template and the conflicts between the
+// template and the other templates selecting the same managed clusters
+type ReconcileTemplateStatus struct {
+	client client.Client
+}
+
+func (r *ReconcileTemplateStatus) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result,
+	error) {
+	template := &agentv1.KlusterletAddonConfigTemplate{}
+	if err := r.client.Get(ctx, types.NamespacedName{Name: request.Name}, template); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	if !template.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	templates, clusterSets, err := listTemplates(ctx, r.client)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	clusters := &mcv1.ManagedClusterList{}
+	if err := r.client.List(ctx, clusters); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	sort.Slice(clusters.Items, func(i, j int) bool {
+		return clusters.Items[i].Name < clusters.Items[j].Name
+	})
+
+	var selectedClusters int32
+	var conflicts []agentv1.TemplateFieldConflict
+	for i := range clusters.Items {
+		cluster := &clusters.Items[i]
+		ok, err := selectsCluster(template, cluster, clusterSets)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if !ok {
+			continue
+		}
+		selectedClusters++
+
+		selected := selectTemplates(templates, cluster, clusterSets)
+		if len(selected) < 2 {
+			continue
+		}
+		_, fieldConflicts, err := renderTemplates(selected)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		for _, conflict := range fieldConflicts {
+			for _, name := range conflict.templates {
+				if name == template.Name {
+					conflicts = append(conflicts, agentv1.TemplateFieldConflict{
+						ClusterName: cluster.Name,
+						Field:       conflict.field.String(),
+						Templates:   conflict.templates,
+					})
+					break
+				}
+			}
+		}
+	}
+
+	return reconcile.Result{}, r.updateStatus(ctx, template.Name, selectedClusters, conflicts)
+}
+
+// updateStatus updates the status of the template if it is changed
+func (r *ReconcileTemplateStatus) updateStatus(ctx context.Context, name string, selectedClusters int32,
+	conflicts []agentv1.TemplateFieldConflict) error {
+	condition := metav1.Condition{
+		Type:    agentv1.TemplateConflicted,
+		Status:  metav1.ConditionFalse,
+		Reason:  agentv1.ReasonTemplateNoConflicts,
+		Message: "The template has no conflicts with the other templates.",
+	}
+	if len(conflicts) != 0 {
+		condition = metav1.Condition{
+			Type:   agentv1.TemplateConflicted,
+			Status: metav1.ConditionTrue,
+			Reason: agentv1.ReasonTemplateFieldsConflicted,
+			Message: fmt.Sprintf("The template sets %d fields of the selected clusters to different values than "+
+				"the other templates.", len(conflicts)),
+		}
+	}
+	if len(conflicts) > maxStatusConflicts {
+		conflicts = conflicts[:maxStatusConflicts]
+	}
+
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		template := &agentv1.KlusterletAddonConfigTemplate{}
+		if err := r.client.Get(ctx, types.NamespacedName{Name: name}, template); err != nil {
+			return err
+		}
+
+		newStatus := template.Status.DeepCopy()
+		newStatus.SelectedClusters = selectedClusters
+		newStatus.Conflicts = conflicts
+		meta.SetStatusCondition(&newStatus.Conditions, condition)
+		if equality.Semantic.DeepEqual(template.Status, *newStatus) {
+			return nil
+		}
+
+		template.Status = *newStatus
+		return r.client.Status().Update(ctx, template)
+	})
+}
@@ -0,0 +1,183 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addonconfigtemplate
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/controller/history"
+)
+
+// changeSource is the source of the changes recorded in the history of the klusterletAddonConfigs
+const changeSource = "klusterletaddonconfigtemplate-controller"
+
+// blank assignment to verify that ReconcileKlusterletAddonConfig implements reconcile.Reconciler
+var _ reconcile.Reconciler = &ReconcileKlusterletAddonConfig{}
+
+// ReconcileKlusterletAddonConfig renders the klusterletAddonConfig of a managed cluster from the templates selecting
+// the managed cluster, the request name is the name of the managed cluster
+type ReconcileKlusterletAddonConfig struct {
+	client client.Client
+	// shard selects the managed clusters handled by this reconciler, nil means all clusters
+	shard *common.Shard
+}
+
+func (r *ReconcileKlusterletAddonConfig) Reconcile(ctx context.Context, request reconcile.Request) (
+	reconcile.Result, error) {
+	cluster := &mcv1.ManagedCluster{}
+	if err := r.client.Get(ctx, types.NamespacedName{Name: request.Name}, cluster); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	if !cluster.DeletionTimestamp.IsZero() || !r.shard.Contains(cluster) {
+		return reconcile.Result{}, nil
+	}
+
+	templates, clusterSets, err := listTemplates(ctx, r.client)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	selected := selectTemplates(templates, cluster, clusterSets)
+	if len(selected) == 0 {
+		// the klusterletAddonConfig is kept as it is when no templates select the cluster
+		return reconcile.Result{}, nil
+	}
+
+	rendered, conflicts, err := renderTemplates(selected)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	for _, conflict := range conflicts {
+		klog.V(2).Infof("field %s of cluster %s is set by templates %v, the value of %s is rendered",
+			conflict.field, cluster.Name, conflict.templates, conflict.templates[0])
+	}
+	rendered, err = normalizeFields(rendered)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	config := &agentv1.KlusterletAddonConfig{}
+	err = r.client.Get(ctx, types.NamespacedName{Namespace: cluster.Name, Name: cluster.Name}, config)
+	if errors.IsNotFound(err) {
+		if common.KlusterletAddonConfigOptedOut(cluster) {
+			// the klusterletAddonConfig removed on purpose is not recreated, or the managedcluster controller
+			// deletes it again
+			klog.V(2).Infof("cluster %s opts out of the klusterletAddonConfig, skip creating it", cluster.Name)
+			return reconcile.Result{}, nil
+		}
+		config = &agentv1.KlusterletAddonConfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      cluster.Name,
+				Namespace: cluster.Name,
+			},
+			Spec: agentv1.KlusterletAddonConfigSpec{
+				ClusterName:      cluster.Name,
+				ClusterNamespace: cluster.Name,
+			},
+		}
+		_, applied, _, err := applyFields(&config.Spec, rendered, nil)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		lastApplied, err := encodeFields(applied)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		config.Annotations = map[string]string{common.AnnotationLastAppliedTemplate: lastApplied}
+		klog.Infof("create klusterletAddonConfig %s from templates %v", cluster.Name, templateNames(selected))
+		if err := r.client.Create(ctx, config); err != nil {
+			return reconcile.Result{}, err
+		}
+		// the created klusterletAddonConfig may not be in the cache yet, so its status is updated directly
+		config.Status.History = agentv1.SpecChanges(nil, config, changeSource, metav1.Now())
+		return reconcile.Result{}, r.client.Status().Update(ctx, config)
+	}
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if !config.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	oldConfig := config.DeepCopy()
+	changed, applied, overridden, err := applyFields(&config.Spec,
+		rendered, decodeFields(config.Annotations[common.AnnotationLastAppliedTemplate]))
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	lastApplied, err := encodeFields(applied)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if len(overridden) != 0 {
+		klog.V(2).Infof("fields %v of klusterletAddonConfig %s are overridden", overridden, cluster.Name)
+	}
+	if !changed && config.Annotations[common.AnnotationLastAppliedTemplate] == lastApplied {
+		return reconcile.Result{}, nil
+	}
+
+	if config.Annotations == nil {
+		config.Annotations = map[string]string{}
+	}
+	config.Annotations[common.AnnotationLastAppliedTemplate] = lastApplied
+
+	// the changes are recorded before the spec is changed, since they are not found again once the spec is changed
+	err = history.RecordChangesBeforeUpdate(ctx, r.client, client.ObjectKeyFromObject(config),
+		agentv1.SpecChanges(oldConfig, config, changeSource, metav1.Now())...)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	// the status may be updated by the records, so the klusterletAddonConfig is patched without the stale resource
+	// version
+	klog.Infof("update klusterletAddonConfig %s from templates %v", cluster.Name, templateNames(selected))
+	return reconcile.Result{}, r.client.Patch(ctx, config, client.MergeFrom(oldConfig))
+}
+
+// listTemplates returns the templates sorted by their precedence and the ManagedClusterSets keyed by name
+func listTemplates(ctx context.Context, c client.Reader) ([]*agentv1.KlusterletAddonConfigTemplate,
+	map[string]*clusterv1beta2.ManagedClusterSet, error) {
+	templateList := &agentv1.KlusterletAddonConfigTemplateList{}
+	if err := c.List(ctx, templateList); err != nil {
+		return nil, nil, err
+	}
+	templates := []*agentv1.KlusterletAddonConfigTemplate{}
+	for i := range templateList.Items {
+		templates = append(templates, &templateList.Items[i])
+	}
+	sortTemplates(templates)
+
+	clusterSets := map[string]*clusterv1beta2.ManagedClusterSet{}
+	if len(templates) == 0 {
+		return templates, clusterSets, nil
+	}
+	clusterSetList := &clusterv1beta2.ManagedClusterSetList{}
+	if err := c.List(ctx, clusterSetList); err != nil {
+		return nil, nil, err
+	}
+	for i := range clusterSetList.Items {
+		clusterSets[clusterSetList.Items[i].Name] = &clusterSetList.Items[i]
+	}
+	return templates, clusterSets, nil
+}
+
+func templateNames(templates []*agentv1.KlusterletAddonConfigTemplate) []string {
+	names := []string{}
+	for _, template := range templates {
+		names = append(names, template.Name)
+	}
+	return names
+}
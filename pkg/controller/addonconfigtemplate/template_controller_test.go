@@ -0,0 +1,234 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package addonconfigtemplate
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
+
+	"github.com/stolostron/klusterlet-addon-controller/pkg/apis"
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+)
+
+func newTestScheme() *runtime.Scheme {
+	testscheme := scheme.Scheme
+	_ = mcv1.AddToScheme(testscheme)
+	_ = clusterv1beta2.AddToScheme(testscheme)
+	_ = apis.AddToScheme(testscheme)
+	return testscheme
+}
+
+func Test_ReconcileKlusterletAddonConfig(t *testing.T) {
+	prodTemplate := newTemplate("prod", 0, map[string]string{"env": "prod"},
+		`{"searchCollector":{"enabled":true},"policyController":{"enabled":true}}`)
+	stagingTemplate := newTemplate("staging", 0, map[string]string{"env": "staging"},
+		`{"searchCollector":{"enabled":false},"policyController":{"enabled":true}}`)
+
+	removedCluster := newCluster("cluster1", map[string]string{"env": "prod"})
+	removedCluster.Annotations = map[string]string{common.AnnotationKlusterletAddonConfigRemoved: "true"}
+
+	cases := []struct {
+		name                string
+		cluster             *mcv1.ManagedCluster
+		config              *agentv1.KlusterletAddonConfig
+		expectedExists      bool
+		expectedSearch      bool
+		expectedPolicy      bool
+		expectedLastApplied string
+		expectedHistory     []string
+	}{
+		{
+			name:    "not selected by any template",
+			cluster: newCluster("cluster1", map[string]string{"env": "dev"}),
+		},
+		{
+			name:                "create the klusterletAddonConfig",
+			cluster:             newCluster("cluster1", map[string]string{"env": "prod"}),
+			expectedExists:      true,
+			expectedSearch:      true,
+			expectedPolicy:      true,
+			expectedLastApplied: `{"policyController":{"enabled":true},"searchCollector":{"enabled":true}}`,
+			expectedHistory:     []string{"AddonEnabled config-policy-controller", "AddonEnabled governance-policy-framework", "AddonEnabled search-collector"},
+		},
+		{
+			name:    "do not recreate the klusterletAddonConfig removed on purpose",
+			cluster: removedCluster,
+		},
+		{
+			name:    "keep the fields overridden on the klusterletAddonConfig",
+			cluster: newCluster("cluster1", map[string]string{"env": "prod"}),
+			config: &agentv1.KlusterletAddonConfig{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "cluster1",
+					Namespace: "cluster1",
+					Annotations: map[string]string{
+						common.AnnotationLastAppliedTemplate: `{"searchCollector":{"enabled":true}}`,
+					},
+				},
+			},
+			expectedExists:      true,
+			expectedPolicy:      true,
+			expectedLastApplied: `{"policyController":{"enabled":true},"searchCollector":{"enabled":true}}`,
+			expectedHistory:     []string{"AddonEnabled config-policy-controller", "AddonEnabled governance-policy-framework"},
+		},
+		{
+			name:    "adopt the klusterletAddonConfig without overwriting the fields set on it",
+			cluster: newCluster("cluster1", map[string]string{"env": "staging"}),
+			config: &agentv1.KlusterletAddonConfig{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "cluster1",
+					Namespace: "cluster1",
+				},
+				Spec: agentv1.KlusterletAddonConfigSpec{
+					SearchCollectorConfig: agentv1.KlusterletAddonAgentConfigSpec{Enabled: true},
+				},
+			},
+			expectedExists:      true,
+			expectedSearch:      true,
+			expectedPolicy:      true,
+			expectedLastApplied: `{"policyController":{"enabled":true}}`,
+			expectedHistory:     []string{"AddonEnabled config-policy-controller", "AddonEnabled governance-policy-framework"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			objs := []runtime.Object{c.cluster, prodTemplate.DeepCopy(), stagingTemplate.DeepCopy()}
+			if c.config != nil {
+				objs = append(objs, c.config)
+			}
+			reconciler := &ReconcileKlusterletAddonConfig{
+				client: fake.NewClientBuilder().WithScheme(newTestScheme()).WithRuntimeObjects(objs...).
+					WithStatusSubresource(&agentv1.KlusterletAddonConfig{}).Build(),
+			}
+
+			_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: c.cluster.Name},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			config := &agentv1.KlusterletAddonConfig{}
+			err = reconciler.client.Get(context.TODO(),
+				types.NamespacedName{Namespace: c.cluster.Name, Name: c.cluster.Name}, config)
+			if err != nil && !errors.IsNotFound(err) {
+				t.Fatalf("failed to get klusterletAddonConfig: %v", err)
+			}
+			if exists := err == nil; exists != c.expectedExists {
+				t.Fatalf("expected klusterletAddonConfig exists %v, but got %v", c.expectedExists, exists)
+			}
+			if !c.expectedExists {
+				return
+			}
+
+			if config.Spec.SearchCollectorConfig.Enabled != c.expectedSearch ||
+				config.Spec.PolicyController.Enabled != c.expectedPolicy {
+				t.Errorf("expected search %v and policy %v, but got %+v", c.expectedSearch, c.expectedPolicy,
+					config.Spec)
+			}
+			if config.Spec.ClusterName != c.cluster.Name && c.config == nil {
+				t.Errorf("expected cluster name %s, but got %s", c.cluster.Name, config.Spec.ClusterName)
+			}
+			if actual := config.Annotations[common.AnnotationLastAppliedTemplate]; actual != c.expectedLastApplied {
+				t.Errorf("expected last applied %s, but got %s", c.expectedLastApplied, actual)
+			}
+			history := []string{}
+			for _, record := range config.Status.History {
+				if record.Source != changeSource {
+					t.Errorf("expected the changes recorded by %s, but got %v", changeSource, record)
+				}
+				history = append(history, fmt.Sprintf("%s %s", record.Action, record.AddonName))
+			}
+			if !reflect.DeepEqual(history, c.expectedHistory) {
+				t.Errorf("expected the history %v, but got %v", c.expectedHistory, history)
+			}
+		})
+	}
+}
+
+func Test_ReconcileTemplateStatus(t *testing.T) {
+	objs := []runtime.Object{
+		newCluster("cluster1", map[string]string{"env": "prod"}),
+		newCluster("cluster2", map[string]string{"env": "prod", "region": "east"}),
+		newCluster("cluster3", map[string]string{"env": "dev"}),
+		newTemplate("prod", 0, map[string]string{"env": "prod"}, `{"searchCollector":{"enabled":true}}`),
+		newTemplate("east", 10, map[string]string{"region": "east"}, `{"searchCollector":{"enabled":false}}`),
+		newTemplate("dev", 0, map[string]string{"env": "dev"}, `{"searchCollector":{"enabled":false}}`),
+	}
+	reconciler := &ReconcileTemplateStatus{
+		client: fake.NewClientBuilder().WithScheme(newTestScheme()).WithRuntimeObjects(objs...).
+			WithStatusSubresource(&agentv1.KlusterletAddonConfigTemplate{}).Build(),
+	}
+
+	cases := []struct {
+		name              string
+		expectedSelected  int32
+		expectedConflicts []agentv1.TemplateFieldConflict
+	}{
+		{
+			name:             "prod",
+			expectedSelected: 2,
+			expectedConflicts: []agentv1.TemplateFieldConflict{{
+				ClusterName: "cluster2",
+				Field:       "spec.searchCollector.enabled",
+				Templates:   []string{"east", "prod"},
+			}},
+		},
+		{
+			name:             "dev",
+			expectedSelected: 1,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: c.name},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			template := &agentv1.KlusterletAddonConfigTemplate{}
+			if err := reconciler.client.Get(context.TODO(), types.NamespacedName{Name: c.name},
+				template); err != nil {
+				t.Fatalf("failed to get template: %v", err)
+			}
+			if template.Status.SelectedClusters != c.expectedSelected {
+				t.Errorf("expected %d selected clusters, but got %d", c.expectedSelected,
+					template.Status.SelectedClusters)
+			}
+			if len(template.Status.Conflicts) != len(c.expectedConflicts) {
+				t.Fatalf("expected conflicts %v, but got %v", c.expectedConflicts, template.Status.Conflicts)
+			}
+			for i, conflict := range c.expectedConflicts {
+				actual := template.Status.Conflicts[i]
+				if actual.ClusterName != conflict.ClusterName || actual.Field != conflict.Field ||
+					len(actual.Templates) != 2 || actual.Templates[0] != conflict.Templates[0] {
+					t.Errorf("expected conflict %v, but got %v", conflict, actual)
+				}
+			}
+			expectedConflicted := len(c.expectedConflicts) != 0
+			if conflicted := meta.IsStatusConditionTrue(template.Status.Conditions,
+				agentv1.TemplateConflicted); conflicted != expectedConflicted {
+				t.Errorf("expected conflicted %v, but got %v", expectedConflicted, conflicted)
+			}
+		})
+	}
+}
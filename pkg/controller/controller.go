@@ -13,26 +13,34 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
 	"github.com/stolostron/klusterlet-addon-controller/pkg/controller/addon"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/controller/addonconfigtemplate"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/controller/fleetreport"
 	"github.com/stolostron/klusterlet-addon-controller/pkg/controller/globalproxy"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/controller/history"
 	"github.com/stolostron/klusterlet-addon-controller/pkg/controller/managedcluster"
 )
 
 // AddToManagerFuncs is a list of functions to add all Controllers to the Manager
-var AddToManagerFuncs []func(manager.Manager, kubernetes.Interface) error
+var AddToManagerFuncs []func(manager.Manager, kubernetes.Interface, *common.Options) error
 
 func init() {
 	AddToManagerFuncs = append(AddToManagerFuncs,
 		addon.Add,
 		managedcluster.Add,
 		globalproxy.Add,
+		addonconfigtemplate.Add,
+		fleetreport.Add,
+		history.Add,
 	)
 }
 
 // AddToManager adds all Controllers to the Manager
-func AddToManager(m manager.Manager, kubeClient kubernetes.Interface, dynamicClient dynamic.Interface) error {
+func AddToManager(m manager.Manager, kubeClient kubernetes.Interface, dynamicClient dynamic.Interface,
+	opts *common.Options) error {
 	for _, f := range AddToManagerFuncs {
-		if err := f(m, kubeClient); err != nil {
+		if err := f(m, kubeClient, opts); err != nil {
 			return err
 		}
 	}
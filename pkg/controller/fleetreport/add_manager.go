@@ -0,0 +1,120 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package fleetreport
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	managedclusterv1 "open-cluster-management.io/api/cluster/v1"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+)
+
+// Path is the path of the fleet report
+const Path = "/fleet-report"
+
+const (
+	certName = "tls.crt"
+	keyName  = "tls.key"
+)
+
+// Add feeds the fleet index from the informers of the manager cache, and serves the fleet report over TLS if the
+// fleet report is enabled. The bearer tokens of the requests are never accepted over plain HTTP, so an error is
+// returned if the serving certificate is not configured or can not be loaded.
+func Add(mgr manager.Manager, kubeClient kubernetes.Interface, opts *common.Options) error {
+	if !opts.FleetReport {
+		return nil
+	}
+	if len(opts.FleetReportCertDir) == 0 {
+		return fmt.Errorf("the fleet report is served over TLS only, --fleet-report-cert-dir is required")
+	}
+	watcher, err := certwatcher.New(filepath.Join(opts.FleetReportCertDir, certName),
+		filepath.Join(opts.FleetReportCertDir, keyName))
+	if err != nil {
+		return fmt.Errorf("failed to load the serving certificate of the fleet report: %v", err)
+	}
+
+	index := NewIndex()
+	for _, obj := range []client.Object{
+		&managedclusterv1.ManagedCluster{},
+		&agentv1.KlusterletAddonConfig{},
+		&addonv1alpha1.ManagedClusterAddOn{},
+	} {
+		informer, err := mgr.GetCache().GetInformer(context.TODO(), obj)
+		if err != nil {
+			return err
+		}
+		if _, err := informer.AddEventHandler(index.EventHandler()); err != nil {
+			return err
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(Path, WithAuth(kubeClient, NewHandler(index)))
+	return mgr.Add(&server{
+		bindAddress: opts.FleetReportBindAddress,
+		watcher:     watcher,
+		handler:     mux,
+	})
+}
+
+// server serves the fleet report over TLS with the certificate reloaded by the watcher
+type server struct {
+	bindAddress string
+	watcher     *certwatcher.CertWatcher
+	handler     http.Handler
+}
+
+// NeedLeaderElection returns false, the fleet report is served by all the replicas from their own caches
+func (s *server) NeedLeaderElection() bool {
+	return false
+}
+
+func (s *server) Start(ctx context.Context) error {
+	go func() {
+		if err := s.watcher.Start(ctx); err != nil {
+			klog.Errorf("failed to watch the serving certificate of the fleet report: %v", err)
+		}
+	}()
+
+	listener, err := tls.Listen("tcp", s.bindAddress, &tls.Config{
+		GetCertificate: s.watcher.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	})
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Handler:           s.handler,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			klog.Errorf("failed to shut down the fleet report server: %v", err)
+		}
+	}()
+
+	klog.Infof("serving the fleet report at https://%s%s", listener.Addr(), Path)
+	if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
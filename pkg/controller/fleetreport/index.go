@@ -0,0 +1,291 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package fleetreport
+
+import (
+	"sort"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	toolscache "k8s.io/client-go/tools/cache"
+
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	managedclusterv1 "open-cluster-management.io/api/cluster/v1"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+)
+
+// AddonReport is the state of an addon of a managed cluster in the fleet report
+type AddonReport struct {
+	Cluster string            `json:"cluster"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Addon   string            `json:"addon"`
+
+	// Enabled is true if the addon is enabled in the KlusterletAddonConfig of the cluster
+	Enabled bool `json:"enabled"`
+	// Installed is true if the ManagedClusterAddOn of the addon exists
+	Installed bool `json:"installed"`
+	// Deleting is true if the ManagedClusterAddOn of the addon is being deleted
+	Deleting bool `json:"deleting,omitempty"`
+
+	// Conditions are the status of the conditions of the ManagedClusterAddOn by type
+	Conditions map[string]metav1.ConditionStatus `json:"conditions,omitempty"`
+	// ConfigConditions are the status of the conditions of the KlusterletAddonConfig by type
+	ConfigConditions map[string]metav1.ConditionStatus `json:"configConditions,omitempty"`
+}
+
+// clusterEntry is the state of a managed cluster in the index, the entry is removed once the cluster, the
+// KlusterletAddonConfig and the ManagedClusterAddOns of the cluster are all removed
+type clusterEntry struct {
+	exists bool
+	labels map[string]string
+
+	// config is nil if the cluster has no KlusterletAddonConfig
+	config *configEntry
+	addons map[string]addonEntry
+}
+
+type configEntry struct {
+	enabled    map[string]bool
+	conditions map[string]metav1.ConditionStatus
+}
+
+type addonEntry struct {
+	deleting   bool
+	conditions map[string]metav1.ConditionStatus
+}
+
+func (e *clusterEntry) empty() bool {
+	return !e.exists && e.config == nil && len(e.addons) == 0
+}
+
+// Index is the in-memory index of the state of the KlusterletAddonConfigs and the ManagedClusterAddOns of the
+// fleet, it is fed by the events of the informers of the manager cache
+type Index struct {
+	lock     sync.RWMutex
+	clusters map[string]*clusterEntry
+}
+
+// NewIndex returns an empty Index
+func NewIndex() *Index {
+	return &Index{clusters: map[string]*clusterEntry{}}
+}
+
+// EventHandler returns the handler of the informer events which updates the index
+func (i *Index) EventHandler() toolscache.ResourceEventHandler {
+	return toolscache.ResourceEventHandlerFuncs{
+		AddFunc: i.upsert,
+		UpdateFunc: func(_, newObj interface{}) {
+			i.upsert(newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			i.remove(obj)
+		},
+	}
+}
+
+func (i *Index) upsert(obj interface{}) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	switch obj := obj.(type) {
+	case *managedclusterv1.ManagedCluster:
+		entry := i.entry(obj.Name)
+		entry.exists = true
+		entry.labels = copyLabels(obj.Labels)
+	case *agentv1.KlusterletAddonConfig:
+		config := &configEntry{
+			enabled:    map[string]bool{},
+			conditions: conditionStatus(obj.Status.Conditions),
+		}
+		for addonName, managed := range agentv1.KlusterletAddons {
+			if !managed {
+				continue
+			}
+			if addonConfig := agentv1.GetAddonAgentConfig(addonName, obj); addonConfig != nil {
+				config.enabled[addonName] = addonConfig.Enabled
+			}
+		}
+		i.entry(obj.Namespace).config = config
+	case *addonv1alpha1.ManagedClusterAddOn:
+		if !agentv1.KlusterletAddons[obj.Name] {
+			return
+		}
+		entry := i.entry(obj.Namespace)
+		if entry.addons == nil {
+			entry.addons = map[string]addonEntry{}
+		}
+		entry.addons[obj.Name] = addonEntry{
+			deleting:   !obj.DeletionTimestamp.IsZero(),
+			conditions: conditionStatus(obj.Status.Conditions),
+		}
+	}
+}
+
+func (i *Index) remove(obj interface{}) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	var clusterName string
+	switch obj := obj.(type) {
+	case *managedclusterv1.ManagedCluster:
+		clusterName = obj.Name
+		if entry, ok := i.clusters[clusterName]; ok {
+			entry.exists = false
+			entry.labels = nil
+		}
+	case *agentv1.KlusterletAddonConfig:
+		clusterName = obj.Namespace
+		if entry, ok := i.clusters[clusterName]; ok {
+			entry.config = nil
+		}
+	case *addonv1alpha1.ManagedClusterAddOn:
+		clusterName = obj.Namespace
+		if entry, ok := i.clusters[clusterName]; ok {
+			delete(entry.addons, obj.Name)
+		}
+	default:
+		return
+	}
+
+	if entry, ok := i.clusters[clusterName]; ok && entry.empty() {
+		delete(i.clusters, clusterName)
+	}
+}
+
+// entry returns the entry of the cluster, the entry is created if it does not exist, the lock must be held
+func (i *Index) entry(clusterName string) *clusterEntry {
+	entry, ok := i.clusters[clusterName]
+	if !ok {
+		entry = &clusterEntry{}
+		i.clusters[clusterName] = entry
+	}
+	return entry
+}
+
+// Filter selects the addons of the fleet report
+type Filter struct {
+	// Addons are the names of the selected addons, all the addons are selected if it is empty
+	Addons []string
+	// Conditions are the selected status of the conditions, the condition of an addon is looked up in the
+	// conditions of the ManagedClusterAddOn and then of the KlusterletAddonConfig
+	Conditions []ConditionRequirement
+	// ClusterSelector selects the clusters by labels, all the clusters are selected if it is nil
+	ClusterSelector labels.Selector
+}
+
+// ConditionRequirement requires the status of a condition type to be, or not to be, the given status, the status
+// of a missing condition is empty
+type ConditionRequirement struct {
+	Type   string
+	Status metav1.ConditionStatus
+	Not    bool
+}
+
+func (r ConditionRequirement) matches(report AddonReport) bool {
+	status, ok := report.Conditions[r.Type]
+	if !ok {
+		status = report.ConfigConditions[r.Type]
+	}
+	return (status == r.Status) != r.Not
+}
+
+// Report returns the state of the selected addons of the fleet, sorted by cluster and addon. The addons of a
+// cluster are reported if the cluster has a KlusterletAddonConfig or the ManagedClusterAddOns of the addons.
+func (i *Index) Report(filter Filter) []AddonReport {
+	addonNames := filter.Addons
+	if len(addonNames) == 0 {
+		for addonName, managed := range agentv1.KlusterletAddons {
+			if managed {
+				addonNames = append(addonNames, addonName)
+			}
+		}
+	}
+	sort.Strings(addonNames)
+
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	clusterNames := make([]string, 0, len(i.clusters))
+	for clusterName := range i.clusters {
+		clusterNames = append(clusterNames, clusterName)
+	}
+	sort.Strings(clusterNames)
+
+	reports := []AddonReport{}
+	for _, clusterName := range clusterNames {
+		entry := i.clusters[clusterName]
+		if filter.ClusterSelector != nil && !filter.ClusterSelector.Matches(labels.Set(entry.labels)) {
+			continue
+		}
+
+		for _, addonName := range addonNames {
+			addon, installed := entry.addons[addonName]
+			if !installed && entry.config == nil {
+				continue
+			}
+
+			report := AddonReport{
+				Cluster:    clusterName,
+				Labels:     copyLabels(entry.labels),
+				Addon:      addonName,
+				Installed:  installed,
+				Deleting:   addon.deleting,
+				Conditions: copyConditionStatus(addon.conditions),
+			}
+			if entry.config != nil {
+				report.Enabled = entry.config.enabled[addonName]
+				report.ConfigConditions = copyConditionStatus(entry.config.conditions)
+			}
+
+			matched := true
+			for _, requirement := range filter.Conditions {
+				if !requirement.matches(report) {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				reports = append(reports, report)
+			}
+		}
+	}
+	return reports
+}
+
+func conditionStatus(conditions []metav1.Condition) map[string]metav1.ConditionStatus {
+	if len(conditions) == 0 {
+		return nil
+	}
+	status := make(map[string]metav1.ConditionStatus, len(conditions))
+	for _, condition := range conditions {
+		status[condition.Type] = condition.Status
+	}
+	return status
+}
+
+func copyConditionStatus(in map[string]metav1.ConditionStatus) map[string]metav1.ConditionStatus {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(map[string]metav1.ConditionStatus, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+func copyLabels(in map[string]string) map[string]string {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
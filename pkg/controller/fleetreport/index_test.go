@@ -0,0 +1,208 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package fleetreport
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	toolscache "k8s.io/client-go/tools/cache"
+
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	managedclusterv1 "open-cluster-management.io/api/cluster/v1"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+)
+
+func newManagedCluster(name string, labels map[string]string) *managedclusterv1.ManagedCluster {
+	return &managedclusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func newKlusterletAddonConfig(clusterName string, conditions ...metav1.Condition) *agentv1.KlusterletAddonConfig {
+	return &agentv1.KlusterletAddonConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: clusterName, Namespace: clusterName},
+		Spec: agentv1.KlusterletAddonConfigSpec{
+			PolicyController:      agentv1.KlusterletAddonAgentConfigSpec{Enabled: true},
+			SearchCollectorConfig: agentv1.KlusterletAddonAgentConfigSpec{Enabled: true},
+		},
+		Status: agentv1.KlusterletAddonConfigStatus{Conditions: conditions},
+	}
+}
+
+func newManagedClusterAddon(clusterName, addonName string,
+	available metav1.ConditionStatus) *addonv1alpha1.ManagedClusterAddOn {
+	addon := &addonv1alpha1.ManagedClusterAddOn{
+		ObjectMeta: metav1.ObjectMeta{Name: addonName, Namespace: clusterName},
+	}
+	if len(available) != 0 {
+		addon.Status.Conditions = []metav1.Condition{{
+			Type:   addonv1alpha1.ManagedClusterAddOnConditionAvailable,
+			Status: available,
+		}}
+	}
+	return addon
+}
+
+// newTestIndex returns the index of the fleet
+//   - cluster1 of env=prod, the policy addons are available and search-collector is unavailable
+//   - cluster2 of env=prod, the KlusterletAddonConfig is held and no addon is installed
+//   - cluster3 of env=dev without KlusterletAddonConfig, the governance-policy-framework addon is installed
+func newTestIndex() *Index {
+	index := NewIndex()
+	handler := index.EventHandler()
+	for _, obj := range []interface{}{
+		newManagedCluster("cluster1", map[string]string{"env": "prod"}),
+		newManagedCluster("cluster2", map[string]string{"env": "prod"}),
+		newManagedCluster("cluster3", map[string]string{"env": "dev"}),
+		newKlusterletAddonConfig("cluster1"),
+		newKlusterletAddonConfig("cluster2", metav1.Condition{
+			Type:   agentv1.AddonsDeferred,
+			Status: metav1.ConditionTrue,
+		}),
+		newManagedClusterAddon("cluster1", agentv1.PolicyFrameworkAddonName, metav1.ConditionTrue),
+		newManagedClusterAddon("cluster1", agentv1.ConfigPolicyAddonName, metav1.ConditionTrue),
+		newManagedClusterAddon("cluster1", agentv1.SearchAddonName, metav1.ConditionFalse),
+		newManagedClusterAddon("cluster3", agentv1.PolicyFrameworkAddonName, ""),
+		// the addons not managed by the KlusterletAddonConfig are not indexed
+		newManagedClusterAddon("cluster3", "observability-controller", metav1.ConditionTrue),
+	} {
+		handler.OnAdd(obj, false)
+	}
+	return index
+}
+
+// reportKeys returns the cluster/addon keys of the reports
+func reportKeys(reports []AddonReport) []string {
+	keys := []string{}
+	for _, report := range reports {
+		keys = append(keys, report.Cluster+"/"+report.Addon)
+	}
+	return keys
+}
+
+func Test_IndexReport(t *testing.T) {
+	cases := []struct {
+		name         string
+		filter       Filter
+		expectedKeys []string
+	}{
+		{
+			name: "all the addons",
+			expectedKeys: []string{
+				"cluster1/application-manager",
+				"cluster1/cert-policy-controller",
+				"cluster1/config-policy-controller",
+				"cluster1/governance-policy-framework",
+				"cluster1/search-collector",
+				"cluster2/application-manager",
+				"cluster2/cert-policy-controller",
+				"cluster2/config-policy-controller",
+				"cluster2/governance-policy-framework",
+				"cluster2/search-collector",
+				"cluster3/governance-policy-framework",
+			},
+		},
+		{
+			name: "unhealthy policy framework",
+			filter: Filter{
+				Addons: []string{agentv1.PolicyFrameworkAddonName},
+				Conditions: []ConditionRequirement{{
+					Type:   addonv1alpha1.ManagedClusterAddOnConditionAvailable,
+					Status: metav1.ConditionTrue,
+					Not:    true,
+				}},
+			},
+			expectedKeys: []string{"cluster2/governance-policy-framework", "cluster3/governance-policy-framework"},
+		},
+		{
+			name: "unavailable addons of the prod clusters",
+			filter: Filter{
+				Conditions: []ConditionRequirement{{
+					Type:   addonv1alpha1.ManagedClusterAddOnConditionAvailable,
+					Status: metav1.ConditionFalse,
+				}},
+				ClusterSelector: labels.SelectorFromSet(labels.Set{"env": "prod"}),
+			},
+			expectedKeys: []string{"cluster1/search-collector"},
+		},
+		{
+			name: "conditions of the klusterletaddonconfig",
+			filter: Filter{
+				Addons: []string{agentv1.SearchAddonName},
+				Conditions: []ConditionRequirement{{
+					Type:   agentv1.AddonsDeferred,
+					Status: metav1.ConditionTrue,
+				}},
+			},
+			expectedKeys: []string{"cluster2/search-collector"},
+		},
+	}
+
+	index := newTestIndex()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			keys := reportKeys(index.Report(c.filter))
+			if !reflect.DeepEqual(keys, c.expectedKeys) {
+				t.Errorf("expected %v, but got %v", c.expectedKeys, keys)
+			}
+		})
+	}
+}
+
+func Test_IndexEvents(t *testing.T) {
+	index := newTestIndex()
+	handler := index.EventHandler()
+
+	// the addon is updated and disabled
+	config := newKlusterletAddonConfig("cluster1")
+	config.Spec.SearchCollectorConfig.Enabled = false
+	handler.OnUpdate(newKlusterletAddonConfig("cluster1"), config)
+	deleting := newManagedClusterAddon("cluster1", agentv1.SearchAddonName, metav1.ConditionFalse)
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	handler.OnUpdate(newManagedClusterAddon("cluster1", agentv1.SearchAddonName, metav1.ConditionFalse), deleting)
+
+	reports := index.Report(Filter{Addons: []string{agentv1.SearchAddonName},
+		ClusterSelector: labels.SelectorFromSet(labels.Set{"env": "prod"})})
+	expected := AddonReport{
+		Cluster:    "cluster1",
+		Labels:     map[string]string{"env": "prod"},
+		Addon:      agentv1.SearchAddonName,
+		Enabled:    false,
+		Installed:  true,
+		Deleting:   true,
+		Conditions: map[string]metav1.ConditionStatus{"Available": metav1.ConditionFalse},
+	}
+	if len(reports) == 0 || !reflect.DeepEqual(reports[0], expected) {
+		t.Errorf("expected %v, but got %v", expected, reports)
+	}
+
+	// the cluster is removed once all its objects are removed
+	handler.OnDelete(newManagedCluster("cluster3", nil))
+	handler.OnDelete(toolscache.DeletedFinalStateUnknown{
+		Key: "cluster3/governance-policy-framework",
+		Obj: newManagedClusterAddon("cluster3", agentv1.PolicyFrameworkAddonName, ""),
+	})
+	if _, ok := index.clusters["cluster3"]; ok {
+		t.Errorf("expected cluster3 is removed from the index")
+	}
+
+	// the addons of the cluster without KlusterletAddonConfig are reported only if they are installed
+	handler.OnDelete(newKlusterletAddonConfig("cluster1"))
+	keys := reportKeys(index.Report(Filter{ClusterSelector: labels.SelectorFromSet(labels.Set{"env": "prod"})}))
+	expectedKeys := []string{
+		"cluster1/config-policy-controller",
+		"cluster1/governance-policy-framework",
+		"cluster1/search-collector",
+		"cluster2/application-manager",
+		"cluster2/cert-policy-controller",
+		"cluster2/config-policy-controller",
+		"cluster2/governance-policy-framework",
+		"cluster2/search-collector",
+	}
+	if !reflect.DeepEqual(keys, expectedKeys) {
+		t.Errorf("expected %v, but got %v", expectedKeys, keys)
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package fleetreport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+)
+
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+)
+
+// Report is the JSON body of the fleet report
+type Report struct {
+	Items []AddonReport `json:"items"`
+}
+
+var csvHeader = []string{"cluster", "addon", "enabled", "installed", "deleting", "conditions", "configConditions",
+	"labels"}
+
+// NewHandler returns the handler of the fleet report of the index. The report is filtered by the query parameters
+// addon, condition (in the format type=status or type!=status) and selector (the label selector of the clusters),
+// the addon and condition parameters can be repeated. The report is written as CSV if the format parameter is csv
+// or text/csv is accepted, otherwise as JSON.
+func NewHandler(index *Index) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "only GET is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		filter, err := parseFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		format, err := parseFormat(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		reports := index.Report(filter)
+		switch format {
+		case formatCSV:
+			w.Header().Set("Content-Type", "text/csv")
+			err = writeCSV(w, reports)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(Report{Items: reports})
+		}
+		if err != nil {
+			klog.Errorf("failed to write the fleet report: %v", err)
+		}
+	})
+}
+
+func parseFilter(r *http.Request) (Filter, error) {
+	query := r.URL.Query()
+	filter := Filter{}
+
+	for _, value := range query["addon"] {
+		for _, addonName := range strings.Split(value, ",") {
+			if addonName = strings.TrimSpace(addonName); len(addonName) == 0 {
+				continue
+			}
+			if !agentv1.KlusterletAddons[addonName] {
+				return filter, fmt.Errorf("addon %q is not managed by the KlusterletAddonConfig", addonName)
+			}
+			filter.Addons = append(filter.Addons, addonName)
+		}
+	}
+
+	for _, value := range query["condition"] {
+		requirement, err := parseConditionRequirement(value)
+		if err != nil {
+			return filter, err
+		}
+		filter.Conditions = append(filter.Conditions, requirement)
+	}
+
+	if selector := query.Get("selector"); len(selector) != 0 {
+		clusterSelector, err := labels.Parse(selector)
+		if err != nil {
+			return filter, fmt.Errorf("invalid selector %q: %v", selector, err)
+		}
+		filter.ClusterSelector = clusterSelector
+	}
+	return filter, nil
+}
+
+// parseConditionRequirement parses the condition in the format type=status or type!=status
+func parseConditionRequirement(value string) (ConditionRequirement, error) {
+	requirement := ConditionRequirement{}
+	separator := "="
+	if strings.Contains(value, "!=") {
+		separator = "!="
+		requirement.Not = true
+	}
+	parts := strings.SplitN(value, separator, 2)
+	if len(parts) != 2 || len(parts[0]) == 0 {
+		return requirement, fmt.Errorf("condition %q is not in the format type=status or type!=status", value)
+	}
+	requirement.Type = parts[0]
+	requirement.Status = metav1.ConditionStatus(parts[1])
+	return requirement, nil
+}
+
+func parseFormat(r *http.Request) (string, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case formatJSON, formatCSV:
+		return format, nil
+	case "":
+		if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+			return formatCSV, nil
+		}
+		return formatJSON, nil
+	default:
+		return "", fmt.Errorf("unknown format %q, the formats are json and csv", format)
+	}
+}
+
+func writeCSV(w http.ResponseWriter, reports []AddonReport) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, report := range reports {
+		conditions := map[string]string{}
+		for conditionType, status := range report.Conditions {
+			conditions[conditionType] = string(status)
+		}
+		configConditions := map[string]string{}
+		for conditionType, status := range report.ConfigConditions {
+			configConditions[conditionType] = string(status)
+		}
+		if err := writer.Write([]string{
+			report.Cluster,
+			report.Addon,
+			strconv.FormatBool(report.Enabled),
+			strconv.FormatBool(report.Installed),
+			strconv.FormatBool(report.Deleting),
+			joinMap(conditions),
+			joinMap(configConditions),
+			joinMap(report.Labels),
+		}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// joinMap joins the sorted key=value pairs of the map with semicolons
+func joinMap(m map[string]string) string {
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ";")
+}
+
+const (
+	// authCacheTTL is how long the results of the reviews of a token are reused, it bounds the TokenReviews and the
+	// SubjectAccessReviews sent for a client which polls the report, and how long a revoked token is still accepted
+	authCacheTTL = 10 * time.Second
+	// authCacheSize is the maximum number of the cached results
+	authCacheSize = 1024
+)
+
+// authResult is the status and the error message of the reviews of a token and a request path
+type authResult struct {
+	status  int
+	message string
+}
+
+// WithAuth authenticates the bearer token of the requests with a TokenReview, and authorizes the user to get the
+// non-resource URL of the request path with a SubjectAccessReview. The results are cached by the hash of the token
+// and the path for a short time, the failed reviews are not cached.
+func WithAuth(kubeClient kubernetes.Interface, handler http.Handler) http.Handler {
+	results := cache.NewLRUExpireCache(authCacheSize)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			http.Error(w, "the bearer token is required", http.StatusUnauthorized)
+			return
+		}
+
+		sum := sha256.Sum256([]byte(token))
+		key := hex.EncodeToString(sum[:]) + r.URL.Path
+		cached, ok := results.Get(key)
+		if !ok {
+			result, err := review(r.Context(), kubeClient, token, r.URL.Path)
+			if err != nil {
+				klog.Errorf("failed to review the fleet report request: %v", err)
+				http.Error(w, "failed to authenticate the request", http.StatusInternalServerError)
+				return
+			}
+			results.Add(key, result, authCacheTTL)
+			cached = result
+		}
+		if result := cached.(authResult); result.status != http.StatusOK {
+			http.Error(w, result.message, result.status)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// review authenticates the token and authorizes its user to get the path
+func review(ctx context.Context, kubeClient kubernetes.Interface, token, path string) (authResult, error) {
+	tokenReview, err := kubeClient.AuthenticationV1().TokenReviews().Create(ctx,
+		&authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{Token: token}},
+		metav1.CreateOptions{})
+	if err != nil {
+		return authResult{}, fmt.Errorf("failed to review the token: %v", err)
+	}
+	if !tokenReview.Status.Authenticated {
+		return authResult{status: http.StatusUnauthorized, message: "the bearer token is invalid"}, nil
+	}
+
+	user := tokenReview.Status.User
+	extra := map[string]authorizationv1.ExtraValue{}
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	accessReview, err := kubeClient.AuthorizationV1().SubjectAccessReviews().Create(ctx,
+		&authorizationv1.SubjectAccessReview{Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			Groups: user.Groups,
+			UID:    user.UID,
+			Extra:  extra,
+			NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+				Path: path,
+				Verb: "get",
+			},
+		}}, metav1.CreateOptions{})
+	if err != nil {
+		return authResult{}, fmt.Errorf("failed to review the access of user %q: %v", user.Username, err)
+	}
+	if !accessReview.Status.Allowed {
+		return authResult{
+			status:  http.StatusForbidden,
+			message: fmt.Sprintf("user %q is not allowed to get %s", user.Username, path),
+		}, nil
+	}
+	return authResult{status: http.StatusOK}, nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", false
+	}
+	token := strings.TrimSpace(auth[len(prefix):])
+	return token, len(token) != 0
+}
@@ -0,0 +1,227 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package fleetreport
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+)
+
+func Test_Handler(t *testing.T) {
+	cases := []struct {
+		name           string
+		method         string
+		url            string
+		accept         string
+		expectedStatus int
+		expectedKeys   []string
+		expectedCSV    string
+	}{
+		{
+			name:           "json",
+			url:            Path + "?addon=governance-policy-framework&condition=Available!=True",
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{"cluster2/governance-policy-framework", "cluster3/governance-policy-framework"},
+		},
+		{
+			name:           "csv",
+			url:            Path + "?addon=search-collector,governance-policy-framework&selector=env%3Dprod&format=csv",
+			expectedStatus: http.StatusOK,
+			expectedCSV: `cluster,addon,enabled,installed,deleting,conditions,configConditions,labels
+cluster1,governance-policy-framework,true,true,false,Available=True,,env=prod
+cluster1,search-collector,true,true,false,Available=False,,env=prod
+cluster2,governance-policy-framework,true,false,false,,AddonsDeferred=True,env=prod
+cluster2,search-collector,true,false,false,,AddonsDeferred=True,env=prod
+`,
+		},
+		{
+			name:           "csv accepted",
+			url:            Path + "?addon=search-collector&condition=Available=False",
+			accept:         "text/csv",
+			expectedStatus: http.StatusOK,
+			expectedCSV: `cluster,addon,enabled,installed,deleting,conditions,configConditions,labels
+cluster1,search-collector,true,true,false,Available=False,,env=prod
+`,
+		},
+		{
+			name:           "unknown addon",
+			url:            Path + "?addon=work-manager",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid condition",
+			url:            Path + "?condition=Available",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid selector",
+			url:            Path + "?selector=env%20in",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "unknown format",
+			url:            Path + "?format=xml",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "not get",
+			method:         http.MethodPost,
+			url:            Path,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	handler := NewHandler(newTestIndex())
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			method := c.method
+			if len(method) == 0 {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, c.url, nil)
+			if len(c.accept) != 0 {
+				req.Header.Set("Accept", c.accept)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != c.expectedStatus {
+				t.Fatalf("expected status %d, but got %d: %s", c.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if len(c.expectedCSV) != 0 && rec.Body.String() != c.expectedCSV {
+				t.Errorf("expected csv:\n%s\nbut got:\n%s", c.expectedCSV, rec.Body.String())
+			}
+			if c.expectedKeys != nil {
+				report := Report{}
+				if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+					t.Fatalf("failed to decode the report: %v", err)
+				}
+				if keys := reportKeys(report.Items); !reflect.DeepEqual(keys, c.expectedKeys) {
+					t.Errorf("expected %v, but got %v", c.expectedKeys, keys)
+				}
+			}
+		})
+	}
+}
+
+func Test_WithAuth(t *testing.T) {
+	cases := []struct {
+		name           string
+		authorization  string
+		expectedStatus int
+	}{
+		{
+			name:           "no token",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "invalid token",
+			authorization:  "Bearer invalid",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "not allowed",
+			authorization:  "Bearer viewer",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "allowed",
+			authorization:  "Bearer admin",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	kubeClient := kubefake.NewSimpleClientset()
+	kubeClient.PrependReactor("create", "tokenreviews",
+		func(action clienttesting.Action) (bool, runtime.Object, error) {
+			review := action.(clienttesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+			if review.Spec.Token != "invalid" {
+				review.Status.Authenticated = true
+				review.Status.User.Username = review.Spec.Token
+			}
+			return true, review, nil
+		})
+	kubeClient.PrependReactor("create", "subjectaccessreviews",
+		func(action clienttesting.Action) (bool, runtime.Object, error) {
+			review := action.(clienttesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+			attributes := review.Spec.NonResourceAttributes
+			review.Status.Allowed = review.Spec.User == "admin" && attributes != nil &&
+				attributes.Path == Path && attributes.Verb == "get"
+			return true, review, nil
+		})
+
+	handler := WithAuth(kubeClient, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, Path, nil)
+			if len(c.authorization) != 0 {
+				req.Header.Set("Authorization", c.authorization)
+			}
+			for i := 0; i < 2; i++ {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				if rec.Code != c.expectedStatus {
+					t.Errorf("expected status %d, but got %d: %s", c.expectedStatus, rec.Code,
+						strings.TrimSpace(rec.Body.String()))
+				}
+			}
+		})
+	}
+
+	// the results of the reviews are cached, each token is reviewed once
+	reviews := map[string]int{}
+	for _, action := range kubeClient.Actions() {
+		reviews[action.GetResource().Resource]++
+	}
+	expectedReviews := map[string]int{"tokenreviews": 3, "subjectaccessreviews": 2}
+	if !reflect.DeepEqual(reviews, expectedReviews) {
+		t.Errorf("expected reviews %v, but got %v", expectedReviews, reviews)
+	}
+}
+
+func Test_Add(t *testing.T) {
+	cases := []struct {
+		name        string
+		opts        *common.Options
+		expectedErr bool
+	}{
+		{
+			name: "disabled",
+			opts: &common.Options{},
+		},
+		{
+			name:        "no cert dir",
+			opts:        &common.Options{FleetReport: true, FleetReportBindAddress: ":8443"},
+			expectedErr: true,
+		},
+		{
+			name: "no certificate in the cert dir",
+			opts: &common.Options{FleetReport: true, FleetReportBindAddress: ":8443",
+				FleetReportCertDir: t.TempDir()},
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := Add(nil, kubefake.NewSimpleClientset(), c.opts)
+			if (err != nil) != c.expectedErr {
+				t.Errorf("expected error %v, but got %v", c.expectedErr, err)
+			}
+		})
+	}
+}
@@ -8,20 +8,23 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	managedclusterv1 "open-cluster-management.io/api/cluster/v1"
 
 	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/controller/history"
 )
 
-func Add(mgr manager.Manager, kubeClient kubernetes.Interface) error {
-	return add(mgr, newReconciler(mgr, kubeClient))
+func Add(mgr manager.Manager, kubeClient kubernetes.Interface, opts *common.Options) error {
+	return add(mgr, newReconciler(mgr, kubeClient, opts.Shard), opts.GlobalProxy, opts.Shard)
 }
 
-func add(mgr manager.Manager, r reconcile.Reconciler) error {
-	c, err := controller.New("globalProxy-controller", mgr, controller.Options{Reconciler: r})
+func add(mgr manager.Manager, r reconcile.Reconciler, opts common.ControllerOptions, shard *common.Shard) error {
+	c, err := controller.New("globalProxy-controller", mgr, opts.ToControllerOptions(r))
 	if err != nil {
 		return err
 	}
@@ -39,6 +42,7 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 						},
 					}
 				}),
+			predicate.NewTypedPredicateFuncs[*managedclusterv1.ManagedCluster](shard.Contains),
 		))
 
 	if err != nil {
@@ -62,6 +66,11 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 					}
 					return nil
 				}),
+			predicate.NewTypedPredicateFuncs[*agentv1.KlusterletAddonConfig](
+				func(config *agentv1.KlusterletAddonConfig) bool {
+					return shard.ContainsNamespace(context.TODO(), mgr.GetClient(), config.GetNamespace())
+				}),
+			history.IgnorePendingChangesRemoval,
 		),
 	)
 	if err != nil {
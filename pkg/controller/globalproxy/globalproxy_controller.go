@@ -24,6 +24,8 @@ import (
 	"k8s.io/client-go/util/retry"
 
 	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/controller/history"
 
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -32,21 +34,31 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
+// changeSource is the source of the changes recorded in the history of the KlusterletAddonConfigs
+const changeSource = "globalproxy-controller"
+
 type Reconciler struct {
 	runtimeClient client.Client
 	kubeClient    kubernetes.Interface
 	scheme        *runtime.Scheme
+	// shard selects the managed clusters handled by this reconciler, nil means all clusters
+	shard *common.Shard
 }
 
-func newReconciler(mgr manager.Manager, kubeClient kubernetes.Interface) reconcile.Reconciler {
+func newReconciler(mgr manager.Manager, kubeClient kubernetes.Interface, shard *common.Shard) reconcile.Reconciler {
 	return &Reconciler{
 		runtimeClient: mgr.GetClient(),
 		kubeClient:    kubeClient,
 		scheme:        mgr.GetScheme(),
+		shard:         shard,
 	}
 }
 
 func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	if !r.shard.ContainsNamespace(ctx, r.runtimeClient, req.Namespace) {
+		return reconcile.Result{}, nil
+	}
+
 	klusterletAddonConfig := &agentv1.KlusterletAddonConfig{}
 	if err := r.runtimeClient.Get(ctx, types.NamespacedName{Name: req.Name, Namespace: req.Namespace},
 		klusterletAddonConfig); err != nil {
@@ -111,8 +123,21 @@ func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resu
 	// ApplicationManager is enabled and ProxyPolicy is not set by user.
 	if klusterletAddonConfig.Spec.ApplicationManagerConfig.Enabled &&
 		klusterletAddonConfig.Spec.ApplicationManagerConfig.ProxyPolicy == "" {
+		// the change is recorded before the spec is changed, since it is not found again once the spec is changed
+		err := history.RecordChangesBeforeUpdate(ctx, r.runtimeClient, req.NamespacedName, agentv1.ChangeRecord{
+			Action:    agentv1.ChangeActionProxyPolicySet,
+			AddonName: agentv1.ApplicationAddonName,
+			Source:    changeSource,
+			Time:      metav1.Now(),
+			Message:   "the proxy policy is set to OCPGlobalProxy by the detected cluster-wide proxy config",
+		})
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		// the status is updated by the record, so the spec is patched without the stale resource version
+		patch := client.MergeFrom(klusterletAddonConfig.DeepCopy())
 		klusterletAddonConfig.Spec.ApplicationManagerConfig.ProxyPolicy = agentv1.ProxyPolicyOCPGlobalProxy
-		return ctrl.Result{}, r.runtimeClient.Update(context.TODO(), klusterletAddonConfig)
+		return ctrl.Result{}, r.runtimeClient.Patch(ctx, klusterletAddonConfig, patch)
 	}
 	return ctrl.Result{}, nil
 }
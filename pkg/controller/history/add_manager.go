@@ -0,0 +1,55 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package history
+
+import (
+	"fmt"
+	"os"
+
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+)
+
+// defaultServiceAccountName is the service account of the controllers in the namespace of the pod
+const defaultServiceAccountName = "klusterlet-addon-controller"
+
+// Add serves the change history webhook on the webhook server of the manager, and adds the controller which moves
+// the changes recorded by the webhook to the status, if the change history webhook is enabled
+func Add(mgr manager.Manager, kubeClient kubernetes.Interface, opts *common.Options) error {
+	if !opts.ChangeHistoryWebhook {
+		return nil
+	}
+
+	serviceAccount := opts.ControllerServiceAccountName()
+	if len(serviceAccount.Name) == 0 {
+		serviceAccount.Namespace, serviceAccount.Name = os.Getenv("POD_NAMESPACE"), defaultServiceAccountName
+	}
+	controllerUser := fmt.Sprintf("system:serviceaccount:%s:%s", serviceAccount.Namespace, serviceAccount.Name)
+	mgr.GetWebhookServer().Register(WebhookPath, &webhook.Admission{
+		Handler: NewWebhook(admission.NewDecoder(mgr.GetScheme()), controllerUser),
+	})
+
+	r := &Reconciler{client: mgr.GetClient(), shard: opts.Shard}
+	c, err := controller.New("history-controller", mgr, opts.History.ToControllerOptions(r))
+	if err != nil {
+		return err
+	}
+	return c.Watch(
+		source.Kind(mgr.GetCache(), &agentv1.KlusterletAddonConfig{},
+			&handler.TypedEnqueueRequestForObject[*agentv1.KlusterletAddonConfig]{},
+			predicate.NewTypedPredicateFuncs[*agentv1.KlusterletAddonConfig](
+				func(config *agentv1.KlusterletAddonConfig) bool {
+					_, ok := config.GetAnnotations()[AnnotationPendingChanges]
+					return ok
+				}),
+		))
+}
@@ -0,0 +1,129 @@
+// Copyright Contributors to the Open Cluster Management project
+
+// Package history records the changes of the KlusterletAddonConfigs in their status. The controllers record their
+// own changes, and the changes made by the users are recorded by a mutating webhook in an annotation of the
+// KlusterletAddonConfig, which is moved to the status by the history controller.
+package history
+
+import (
+	"context"
+	"encoding/json"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+)
+
+// AnnotationPendingChanges is the annotation of the KlusterletAddonConfig which contains the json of the changes
+// recorded by the webhook and not yet moved to the status
+const AnnotationPendingChanges = "agent.open-cluster-management.io/pending-changes"
+
+// RecordChanges appends the records to the history in the status of the KlusterletAddonConfig
+func RecordChanges(ctx context.Context, c client.Client, key types.NamespacedName,
+	records ...agentv1.ChangeRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		config := &agentv1.KlusterletAddonConfig{}
+		if err := c.Get(ctx, key, config); err != nil {
+			return err
+		}
+		history := agentv1.AppendChanges(append([]agentv1.ChangeRecord{}, config.Status.History...), records...)
+		if equality.Semantic.DeepEqual(config.Status.History, history) {
+			return nil
+		}
+		config.Status.History = history
+		return c.Status().Update(ctx, config)
+	})
+}
+
+// RecordChangesBeforeUpdate records the changes before the controller makes them, so that the records are not lost
+// if the controller fails after the changes. The changes are not recorded again if they are already the latest
+// records regardless of their time, so that they are recorded once when the controller retries to make them.
+func RecordChangesBeforeUpdate(ctx context.Context, c client.Client, key types.NamespacedName,
+	records ...agentv1.ChangeRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		config := &agentv1.KlusterletAddonConfig{}
+		if err := c.Get(ctx, key, config); err != nil {
+			return err
+		}
+		if latestChanges(config.Status.History, records) {
+			return nil
+		}
+		config.Status.History = agentv1.AppendChanges(append([]agentv1.ChangeRecord{}, config.Status.History...),
+			records...)
+		return c.Status().Update(ctx, config)
+	})
+}
+
+// latestChanges returns true if the history ends with the records, the time of the records is ignored
+func latestChanges(history, records []agentv1.ChangeRecord) bool {
+	if len(history) < len(records) {
+		return false
+	}
+	for i, record := range records {
+		latest := history[len(history)-len(records)+i]
+		latest.Time = record.Time
+		if !equality.Semantic.DeepEqual(latest, record) {
+			return false
+		}
+	}
+	return true
+}
+
+// pendingChanges returns the changes in the pending changes annotation of the KlusterletAddonConfig, the invalid
+// annotation is ignored
+func pendingChanges(config *agentv1.KlusterletAddonConfig) []agentv1.ChangeRecord {
+	value, ok := config.GetAnnotations()[AnnotationPendingChanges]
+	if !ok {
+		return nil
+	}
+	records := []agentv1.ChangeRecord{}
+	if err := json.Unmarshal([]byte(value), &records); err != nil {
+		return nil
+	}
+	return records
+}
+
+// IgnorePendingChangesRemoval filters out the updates of the KlusterletAddonConfigs which only remove the pending
+// changes annotation, so the controllers are not triggered again when the history controller moves the changes
+// recorded by the webhook to the status
+var IgnorePendingChangesRemoval = predicate.TypedFuncs[*agentv1.KlusterletAddonConfig]{
+	UpdateFunc: func(e event.TypedUpdateEvent[*agentv1.KlusterletAddonConfig]) bool {
+		return !pendingChangesRemoved(e.ObjectOld, e.ObjectNew)
+	},
+}
+
+// pendingChangesRemoved returns true if the only change of the KlusterletAddonConfig is the removal of the pending
+// changes annotation
+func pendingChangesRemoved(oldConfig, newConfig *agentv1.KlusterletAddonConfig) bool {
+	if oldConfig == nil || newConfig == nil {
+		return false
+	}
+	if _, ok := oldConfig.GetAnnotations()[AnnotationPendingChanges]; !ok {
+		return false
+	}
+	if _, ok := newConfig.GetAnnotations()[AnnotationPendingChanges]; ok {
+		return false
+	}
+
+	oldConfig, newConfig = oldConfig.DeepCopy(), newConfig.DeepCopy()
+	delete(oldConfig.Annotations, AnnotationPendingChanges)
+	for _, config := range []*agentv1.KlusterletAddonConfig{oldConfig, newConfig} {
+		if len(config.Annotations) == 0 {
+			config.Annotations = nil
+		}
+		config.ResourceVersion = ""
+		config.ManagedFields = nil
+	}
+	return equality.Semantic.DeepEqual(oldConfig, newConfig)
+}
@@ -0,0 +1,62 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package history
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+)
+
+// blank assignment to verify that Reconciler implements reconcile.Reconciler
+var _ reconcile.Reconciler = &Reconciler{}
+
+// Reconciler moves the changes in the pending changes annotation of the KlusterletAddonConfig to its status
+type Reconciler struct {
+	client client.Client
+	// shard selects the managed clusters handled by this reconciler, nil means all clusters
+	shard *common.Shard
+}
+
+// Reconcile records the pending changes in the history and then removes the annotation. The annotation is removed
+// with the resource version of the KlusterletAddonConfig, so that the changes recorded by the webhook meanwhile are
+// not lost, and the changes recorded twice on retries are skipped by the history. The removal is an extra update of
+// the KlusterletAddonConfig for every change made by the users, the other controllers ignore it by the
+// IgnorePendingChangesRemoval predicate.
+func (r *Reconciler) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	if !r.shard.ContainsNamespace(ctx, r.client, request.Namespace) {
+		return reconcile.Result{}, nil
+	}
+
+	config := &agentv1.KlusterletAddonConfig{}
+	if err := r.client.Get(ctx, request.NamespacedName, config); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	recorded, ok := config.GetAnnotations()[AnnotationPendingChanges]
+	if !ok {
+		return reconcile.Result{}, nil
+	}
+
+	if err := RecordChanges(ctx, r.client, request.NamespacedName, pendingChanges(config)...); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// the status update changes the resource version, get the KlusterletAddonConfig again and remove the annotation
+	// only if no changes are recorded by the webhook meanwhile, otherwise they are recorded on the next reconcile
+	if err := r.client.Get(ctx, request.NamespacedName, config); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	if config.GetAnnotations()[AnnotationPendingChanges] != recorded {
+		return reconcile.Result{}, nil
+	}
+	delete(config.Annotations, AnnotationPendingChanges)
+	return reconcile.Result{}, r.client.Update(ctx, config)
+}
@@ -0,0 +1,138 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package history
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+)
+
+func Test_Reconciler_Reconcile(t *testing.T) {
+	testscheme := runtime.NewScheme()
+	_ = scheme.AddToScheme(testscheme)
+	testscheme.AddKnownTypes(agentv1.SchemeGroupVersion, &agentv1.KlusterletAddonConfig{})
+
+	now := metav1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	recorded := agentv1.ChangeRecord{
+		Action:    agentv1.ChangeActionAddonDisabled,
+		AddonName: agentv1.SearchAddonName,
+		Source:    "user1",
+		Time:      now,
+	}
+	pending := agentv1.ChangeRecord{
+		Action:    agentv1.ChangeActionAddonEnabled,
+		AddonName: agentv1.SearchAddonName,
+		Source:    "user2",
+		Time:      now,
+	}
+
+	tests := []struct {
+		name            string
+		existing        *agentv1.KlusterletAddonConfig
+		expectedHistory []agentv1.ChangeRecord
+	}{
+		{
+			name:            "no pending changes",
+			existing:        newKlusterletAddonConfig(true),
+			expectedHistory: nil,
+		},
+		{
+			name:            "pending changes are moved to the history",
+			existing:        newKlusterletAddonConfig(true, pending),
+			expectedHistory: []agentv1.ChangeRecord{pending},
+		},
+		{
+			name: "pending changes already in the history are skipped",
+			existing: func() *agentv1.KlusterletAddonConfig {
+				config := newKlusterletAddonConfig(true, recorded, pending)
+				config.Status.History = []agentv1.ChangeRecord{recorded}
+				return config
+			}(),
+			expectedHistory: []agentv1.ChangeRecord{recorded, pending},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(testscheme).WithObjects(tt.existing).
+				WithStatusSubresource(tt.existing).Build()
+			r := &Reconciler{client: c}
+
+			key := types.NamespacedName{Namespace: tt.existing.Namespace, Name: tt.existing.Name}
+			if _, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: key}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			config := &agentv1.KlusterletAddonConfig{}
+			if err := c.Get(context.TODO(), key, config); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, ok := config.Annotations[AnnotationPendingChanges]; ok {
+				t.Errorf("expected the pending changes annotation is removed")
+			}
+			if !equality.Semantic.DeepEqual(config.Status.History, tt.expectedHistory) {
+				t.Errorf("expected history %v, but got %v", tt.expectedHistory, config.Status.History)
+			}
+		})
+	}
+}
+
+func Test_pendingChangesRemoved(t *testing.T) {
+	pending := agentv1.ChangeRecord{
+		Action:    agentv1.ChangeActionAddonEnabled,
+		AddonName: agentv1.SearchAddonName,
+		Source:    "user1",
+		Time:      metav1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+	}
+
+	tests := []struct {
+		name      string
+		oldConfig *agentv1.KlusterletAddonConfig
+		newConfig *agentv1.KlusterletAddonConfig
+		expected  bool
+	}{
+		{
+			name:      "the annotation is removed",
+			oldConfig: newKlusterletAddonConfig(true, pending),
+			newConfig: func() *agentv1.KlusterletAddonConfig {
+				config := newKlusterletAddonConfig(true)
+				config.ResourceVersion = "2"
+				return config
+			}(),
+			expected: true,
+		},
+		{
+			name:      "the annotation is removed with the spec changed",
+			oldConfig: newKlusterletAddonConfig(true, pending),
+			newConfig: newKlusterletAddonConfig(false),
+		},
+		{
+			name:      "the annotation is updated",
+			oldConfig: newKlusterletAddonConfig(true, pending),
+			newConfig: newKlusterletAddonConfig(true, pending, pending),
+		},
+		{
+			name:      "no annotation",
+			oldConfig: newKlusterletAddonConfig(true),
+			newConfig: newKlusterletAddonConfig(false),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := pendingChangesRemoved(tt.oldConfig, tt.newConfig); actual != tt.expected {
+				t.Errorf("expected %v, but got %v", tt.expected, actual)
+			}
+		})
+	}
+}
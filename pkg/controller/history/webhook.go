@@ -0,0 +1,83 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"gomodules.xyz/jsonpatch/v2"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+)
+
+// WebhookPath is the path of the mutating webhook on the webhook server
+const WebhookPath = "/mutate-klusterletaddonconfig-history"
+
+// Webhook records the changes of the KlusterletAddonConfigs made by the users in the pending changes annotation.
+// The changes made by the ignored users, which are the controllers recording their own changes, are not recorded.
+type Webhook struct {
+	decoder      admission.Decoder
+	ignoredUsers sets.Set[string]
+	now          func() metav1.Time
+}
+
+// NewWebhook returns the Webhook which does not record the changes of the ignored users
+func NewWebhook(decoder admission.Decoder, ignoredUsers ...string) *Webhook {
+	return &Webhook{decoder: decoder, ignoredUsers: sets.New(ignoredUsers...), now: metav1.Now}
+}
+
+// Handle records the changes of the created or updated KlusterletAddonConfig, the request is always allowed
+func (w *Webhook) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if w.ignoredUsers.Has(req.UserInfo.Username) {
+		return admission.Allowed("")
+	}
+
+	config := &agentv1.KlusterletAddonConfig{}
+	if err := w.decoder.DecodeRaw(req.Object, config); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	var oldConfig *agentv1.KlusterletAddonConfig
+	if req.Operation == admissionv1.Update {
+		oldConfig = &agentv1.KlusterletAddonConfig{}
+		if err := w.decoder.DecodeRaw(req.OldObject, oldConfig); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+
+	records := agentv1.SpecChanges(oldConfig, config, req.UserInfo.Username, w.now())
+	if len(records) == 0 {
+		return admission.Allowed("")
+	}
+
+	// the pending changes are kept from the old KlusterletAddonConfig, so that they are not lost if the users
+	// replace the annotations
+	pending := []agentv1.ChangeRecord{}
+	if oldConfig != nil {
+		pending = pendingChanges(oldConfig)
+	}
+	data, err := json.Marshal(agentv1.AppendChanges(pending, records...))
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.Patched("", pendingChangesPatch(config, string(data)))
+}
+
+// pendingChangesPatch returns the JSON patch which only adds or replaces the pending changes annotation, the other
+// fields of the KlusterletAddonConfig are not touched
+func pendingChangesPatch(config *agentv1.KlusterletAddonConfig, value string) jsonpatch.JsonPatchOperation {
+	if config.GetAnnotations() == nil {
+		return jsonpatch.NewOperation("add", "/metadata/annotations", map[string]string{
+			AnnotationPendingChanges: value,
+		})
+	}
+	// the add operation replaces the existing member, "/" in the key is escaped as "~1"
+	key := strings.NewReplacer("~", "~0", "/", "~1").Replace(AnnotationPendingChanges)
+	return jsonpatch.NewOperation("add", "/metadata/annotations/"+key, value)
+}
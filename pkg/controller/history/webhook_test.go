@@ -0,0 +1,172 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+)
+
+const controllerUser = "system:serviceaccount:open-cluster-management:klusterlet-addon-controller"
+
+func newKlusterletAddonConfig(searchEnabled bool, pending ...agentv1.ChangeRecord) *agentv1.KlusterletAddonConfig {
+	config := &agentv1.KlusterletAddonConfig{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: agentv1.SchemeGroupVersion.String(),
+			Kind:       "KlusterletAddonConfig",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cluster1",
+			Namespace: "cluster1",
+		},
+		Spec: agentv1.KlusterletAddonConfigSpec{
+			ApplicationManagerConfig: agentv1.KlusterletAddonAgentConfigSpec{Enabled: true},
+			SearchCollectorConfig:    agentv1.KlusterletAddonAgentConfigSpec{Enabled: searchEnabled},
+		},
+	}
+	if len(pending) != 0 {
+		data, _ := json.Marshal(pending)
+		config.Annotations = map[string]string{AnnotationPendingChanges: string(data)}
+	}
+	return config
+}
+
+func newAdmissionRequest(operation admissionv1.Operation, username string,
+	config, oldConfig *agentv1.KlusterletAddonConfig) admission.Request {
+	req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: operation,
+		UserInfo:  authenticationv1.UserInfo{Username: username},
+	}}
+	req.Object.Raw, _ = json.Marshal(config)
+	if oldConfig != nil {
+		req.OldObject.Raw, _ = json.Marshal(oldConfig)
+	}
+	return req
+}
+
+func Test_Webhook_Handle(t *testing.T) {
+	testscheme := runtime.NewScheme()
+	_ = scheme.AddToScheme(testscheme)
+	testscheme.AddKnownTypes(agentv1.SchemeGroupVersion, &agentv1.KlusterletAddonConfig{})
+
+	now := metav1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	record := func(action agentv1.ChangeAction, addonName, message string) agentv1.ChangeRecord {
+		return agentv1.ChangeRecord{Action: action, AddonName: addonName, Source: "user1", Time: now, Message: message}
+	}
+	earlier := agentv1.ChangeRecord{
+		Action:    agentv1.ChangeActionAddonDisabled,
+		AddonName: agentv1.SearchAddonName,
+		Source:    "user2",
+		Time:      now,
+	}
+
+	tests := []struct {
+		name            string
+		request         admission.Request
+		expectedPending []agentv1.ChangeRecord
+		expectedPath    string
+	}{
+		{
+			name: "changes of the controller are not recorded",
+			request: newAdmissionRequest(admissionv1.Update, controllerUser,
+				newKlusterletAddonConfig(false), newKlusterletAddonConfig(true)),
+		},
+		{
+			name: "spec is not changed",
+			request: newAdmissionRequest(admissionv1.Update, "user1",
+				newKlusterletAddonConfig(true), newKlusterletAddonConfig(true)),
+		},
+		{
+			name:    "created",
+			request: newAdmissionRequest(admissionv1.Create, "user1", newKlusterletAddonConfig(false), nil),
+			expectedPending: []agentv1.ChangeRecord{
+				record(agentv1.ChangeActionAddonEnabled, agentv1.ApplicationAddonName,
+					"the KlusterletAddonConfig is created"),
+			},
+			expectedPath: "/metadata/annotations",
+		},
+		{
+			name: "addon enabled",
+			request: newAdmissionRequest(admissionv1.Update, "user1",
+				newKlusterletAddonConfig(true), newKlusterletAddonConfig(false)),
+			expectedPending: []agentv1.ChangeRecord{
+				record(agentv1.ChangeActionAddonEnabled, agentv1.SearchAddonName, ""),
+			},
+			expectedPath: "/metadata/annotations",
+		},
+		{
+			name: "pending changes of the old KlusterletAddonConfig are kept",
+			request: newAdmissionRequest(admissionv1.Update, "user1",
+				newKlusterletAddonConfig(true), newKlusterletAddonConfig(false, earlier)),
+			expectedPending: []agentv1.ChangeRecord{
+				earlier,
+				record(agentv1.ChangeActionAddonEnabled, agentv1.SearchAddonName, ""),
+			},
+			expectedPath: "/metadata/annotations",
+		},
+		{
+			name: "pending changes annotation is replaced",
+			request: newAdmissionRequest(admissionv1.Update, "user1",
+				newKlusterletAddonConfig(true, earlier, earlier), newKlusterletAddonConfig(false, earlier)),
+			expectedPending: []agentv1.ChangeRecord{
+				earlier,
+				record(agentv1.ChangeActionAddonEnabled, agentv1.SearchAddonName, ""),
+			},
+			expectedPath: "/metadata/annotations/agent.open-cluster-management.io~1pending-changes",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWebhook(admission.NewDecoder(testscheme), controllerUser)
+			w.now = func() metav1.Time { return now }
+
+			resp := w.Handle(context.TODO(), tt.request)
+			if !resp.Allowed {
+				t.Fatalf("expected the request is allowed, but got %v", resp.Result)
+			}
+			if len(tt.expectedPending) == 0 {
+				if len(resp.Patches) != 0 {
+					t.Errorf("expected no patches, but got %v", resp.Patches)
+				}
+				return
+			}
+
+			// only the pending changes annotation is patched
+			if len(resp.Patches) != 1 || resp.Patches[0].Path != tt.expectedPath {
+				t.Errorf("expected a patch of %s, but got %v", tt.expectedPath, resp.Patches)
+			}
+			patchData, err := json.Marshal(resp.Patches)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			patch, err := jsonpatch.DecodePatch(patchData)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			patched, err := patch.Apply(tt.request.Object.Raw)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			config := &agentv1.KlusterletAddonConfig{}
+			if err := json.Unmarshal(patched, config); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if pending := pendingChanges(config); !equality.Semantic.DeepEqual(pending, tt.expectedPending) {
+				t.Errorf("expected pending changes %v, but got %v", tt.expectedPending, pending)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package managedcluster
 
 import (
+	"context"
+
 	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
@@ -11,25 +13,27 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	kacv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
 
 	mcv1 "open-cluster-management.io/api/cluster/v1"
 )
 
 // Add creates a new ManagedCluster Controller and adds it to the Manager. The Manager will set fields on the Controller
 // and Start it when the Manager is Started.
-func Add(mgr manager.Manager, kubeClient kubernetes.Interface) error {
-	return add(mgr, newReconciler(mgr))
+func Add(mgr manager.Manager, kubeClient kubernetes.Interface, opts *common.Options) error {
+	return add(mgr, newReconciler(mgr, opts.Shard), opts.ManagedCluster, opts.Shard)
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
-func add(mgr manager.Manager, r reconcile.Reconciler) error {
+func add(mgr manager.Manager, r reconcile.Reconciler, opts common.ControllerOptions, shard *common.Shard) error {
 	// Create a new controller
-	c, err := controller.New("managedcluster-controller", mgr, controller.Options{Reconciler: r})
+	c, err := controller.New("managedcluster-controller", mgr, opts.ToControllerOptions(r))
 	if err != nil {
 		return err
 	}
 	err = c.Watch(source.Kind(mgr.GetCache(), &mcv1.ManagedCluster{},
 		&handler.TypedEnqueueRequestForObject[*mcv1.ManagedCluster]{},
+		predicate.NewTypedPredicateFuncs[*mcv1.ManagedCluster](shard.Contains),
 		predicate.TypedFuncs[*mcv1.ManagedCluster]{
 			GenericFunc: func(e event.TypedGenericEvent[*mcv1.ManagedCluster]) bool { return false },
 			CreateFunc: func(e event.TypedCreateEvent[*mcv1.ManagedCluster]) bool {
@@ -58,11 +62,17 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 
 	err = c.Watch(source.Kind(mgr.GetCache(), &kacv1.KlusterletAddonConfig{},
 		&handler.TypedEnqueueRequestForObject[*kacv1.KlusterletAddonConfig]{},
+		predicate.NewTypedPredicateFuncs[*kacv1.KlusterletAddonConfig](
+			func(config *kacv1.KlusterletAddonConfig) bool {
+				return shard.ContainsNamespace(context.TODO(), mgr.GetClient(), config.GetNamespace())
+			}),
 		predicate.TypedFuncs[*kacv1.KlusterletAddonConfig]{
 			GenericFunc: func(e event.TypedGenericEvent[*kacv1.KlusterletAddonConfig]) bool { return false },
 			CreateFunc:  func(e event.TypedCreateEvent[*kacv1.KlusterletAddonConfig]) bool { return false },
-			// If the klusterletAddonConfig is deleted, we will recreate it.
-			DeleteFunc: func(e event.TypedDeleteEvent[*kacv1.KlusterletAddonConfig]) bool { return true },
+			// If the klusterletAddonConfig is deleted, we will recreate it unless the cluster opts out of it.
+			DeleteFunc: func(e event.TypedDeleteEvent[*kacv1.KlusterletAddonConfig]) bool {
+				return !common.ClusterOptedOut(context.TODO(), mgr.GetClient(), e.Object.GetNamespace())
+			},
 			UpdateFunc: func(e event.TypedUpdateEvent[*kacv1.KlusterletAddonConfig]) bool { return false },
 		}))
 	if err != nil {
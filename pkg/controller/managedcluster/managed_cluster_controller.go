@@ -23,18 +23,16 @@ import (
 	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
 )
 
-const (
-	provisionerAnnotation = "cluster.open-cluster-management.io/provisioner"
-	// disableAddonAutomaticInstallationAnnotationKey is the annotation key for disabling the functionality of
-	// installing addon automatically
-	disableAddonAutomaticInstallationAnnotationKey = "addon.open-cluster-management.io/disable-automatic-installation"
-)
+const provisionerAnnotation = "cluster.open-cluster-management.io/provisioner"
+
+// changeSource is the source of the changes recorded in the history of the KlusterletAddonConfigs
+const changeSource = "managedcluster-controller"
 
-var log = logf.Log.WithName("managedcluster-controller")
+var log = logf.Log.WithName(changeSource)
 
 // newReconciler returns a new reconcile.Reconciler
-func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &ReconcileManagedCluster{client: mgr.GetClient(), scheme: mgr.GetScheme()}
+func newReconciler(mgr manager.Manager, shard *common.Shard) reconcile.Reconciler {
+	return &ReconcileManagedCluster{client: mgr.GetClient(), scheme: mgr.GetScheme(), shard: shard}
 }
 
 // blank assignment to verify that ReconcileManagedCluster implements reconcile.Reconciler
@@ -46,10 +44,13 @@ type ReconcileManagedCluster struct {
 	// that reads objects from the cache and writes to the apiserver
 	client client.Client
 	scheme *runtime.Scheme
+	// shard selects the managed clusters handled by this reconciler, nil means all clusters
+	shard *common.Shard
 }
 
 // Reconcile reads managed cluster created by hive or hypershift, and create the default
-// klusterlet addon config for them
+// klusterlet addon config for them, the klusterlet addon config is deleted instead if the
+// cluster records its intentional removal
 func (r *ReconcileManagedCluster) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
 	reqLogger := log.WithValues("Request.Name", request.Name)
 	reqLogger.Info("Reconciling ManagedCluster")
@@ -67,13 +68,21 @@ func (r *ReconcileManagedCluster) Reconcile(ctx context.Context, request reconci
 		return reconcile.Result{}, nil
 	}
 
+	if !r.shard.Contains(managedCluster) {
+		return reconcile.Result{}, nil
+	}
+
 	if !hostedAddOnEnabled(managedCluster) && !hypershiftCluster(managedCluster) && !clusterClaimCluster(managedCluster) && !hasAnnotationCreateWithDefaultKAC(managedCluster) {
 		return reconcile.Result{}, nil
 	}
 
-	if value, ok := managedCluster.Annotations[disableAddonAutomaticInstallationAnnotationKey]; ok &&
-		strings.EqualFold(value, "true") {
+	// the klusterletAddonConfig removed on purpose is not recreated until the tombstone is withdrawn
+	if common.KlusterletAddonConfigRemoved(managedCluster) {
+		reqLogger.Info("Cluster has klusterlet addon config removed annotation, remove the klusterlet addon config")
+		return reconcile.Result{}, deleteKlusterletAddonConfig(ctx, r.client, managedCluster.Name)
+	}
 
+	if common.KlusterletAddonConfigOptedOut(managedCluster) {
 		reqLogger.Info("Cluster has disable addon automatic installation annotation, skip addon deploy")
 		return reconcile.Result{}, nil
 	}
@@ -106,12 +115,37 @@ func createKlusterletAddonConfig(client client.Client, cluster *mcv1.ManagedClus
 		if err = client.Create(ctx, kacNew); err != nil {
 			return fmt.Errorf("create KlusterletAddonConfig %s error: %v", name, err)
 		}
+		// the created KlusterletAddonConfig may not be in the cache yet, so its status is updated directly
+		kacNew.Status.History = kacv1.SpecChanges(nil, kacNew, changeSource, metav1.Now())
+		if err = client.Status().Update(ctx, kacNew); err != nil {
+			return fmt.Errorf("record the history of KlusterletAddonConfig %s error: %v", name, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("retrieve KlusterletAddonConfig %s error: %v", name, err)
+	}
+
+	return nil
+}
+
+func deleteKlusterletAddonConfig(ctx context.Context, client client.Client, name string) error {
+	var kac kacv1.KlusterletAddonConfig
+	err := client.Get(ctx, types.NamespacedName{Namespace: name, Name: name}, &kac)
+	if errors.IsNotFound(err) {
 		return nil
 	}
 	if err != nil {
 		return fmt.Errorf("retrieve KlusterletAddonConfig %s error: %v", name, err)
 	}
+	if !kac.DeletionTimestamp.IsZero() {
+		return nil
+	}
 
+	log.Info(fmt.Sprintf("Delete the KlusterletAddonConfig resource %s", name))
+	if err := client.Delete(ctx, &kac); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("delete KlusterletAddonConfig %s error: %v", name, err)
+	}
 	return nil
 }
 
@@ -72,6 +72,16 @@ func TestReconcileManagedCluster(t *testing.T) {
 					kac.Spec.SearchCollectorConfig.Enabled {
 					t.Errorf("expected other add-ons are disabled, but some of them is enabled")
 				}
+
+				// the enabled policy add-ons are recorded in the history
+				if len(kac.Status.History) != 2 {
+					t.Fatalf("expected 2 records in the history, but got %v", kac.Status.History)
+				}
+				for _, record := range kac.Status.History {
+					if record.Action != kacv1.ChangeActionAddonEnabled || record.Source != changeSource {
+						t.Errorf("unexpected record %v", record)
+					}
+				}
 			},
 		},
 		{
@@ -119,8 +129,8 @@ func TestReconcileManagedCluster(t *testing.T) {
 		{
 			name: "do not create klusterlet addon config for hypershift",
 			mc: newManagedCluster(testClusterName, map[string]string{
-				provisionerAnnotation:                          "test.test.HypershiftDeployment.cluster.open-cluster-management.io",
-				disableAddonAutomaticInstallationAnnotationKey: "true",
+				provisionerAnnotation:                              "test.test.HypershiftDeployment.cluster.open-cluster-management.io",
+				common.AnnotationDisableAddonAutomaticInstallation: "true",
 			}),
 			validate: func(t *testing.T, kubeclient client.Client) {
 				var kac kacv1.KlusterletAddonConfig
@@ -134,8 +144,8 @@ func TestReconcileManagedCluster(t *testing.T) {
 		{
 			name: "do not create klusterlet addon config for claim",
 			mc: newManagedCluster(testClusterName, map[string]string{
-				provisionerAnnotation:                          "test.test.ClusterClaim.hive.openshift.io/v1",
-				disableAddonAutomaticInstallationAnnotationKey: "true",
+				provisionerAnnotation:                              "test.test.ClusterClaim.hive.openshift.io/v1",
+				common.AnnotationDisableAddonAutomaticInstallation: "true",
 			}),
 			validate: func(t *testing.T, kubeclient client.Client) {
 				var kac kacv1.KlusterletAddonConfig
@@ -164,7 +174,7 @@ func TestReconcileManagedCluster(t *testing.T) {
 			name: "do not create klusterlet addon config for normal managed cluster with the annotation but disable addon automatic installation",
 			mc: newManagedCluster(testClusterName, map[string]string{
 				common.AnnotationCreateWithDefaultKlusterletAddonConfig: "true",
-				disableAddonAutomaticInstallationAnnotationKey:          "true",
+				common.AnnotationDisableAddonAutomaticInstallation:      "true",
 			}),
 			validate: func(t *testing.T, kubeclient client.Client) {
 				var kac kacv1.KlusterletAddonConfig
@@ -179,7 +189,8 @@ func TestReconcileManagedCluster(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			kubeclient := fake.NewClientBuilder().WithScheme(testscheme).WithObjects(tt.mc).Build()
+			kubeclient := fake.NewClientBuilder().WithScheme(testscheme).WithObjects(tt.mc).
+				WithStatusSubresource(&kacv1.KlusterletAddonConfig{}).Build()
 			reconciler := &ReconcileManagedCluster{
 				client: kubeclient,
 				scheme: testscheme,
@@ -194,3 +205,111 @@ func TestReconcileManagedCluster(t *testing.T) {
 		})
 	}
 }
+
+func TestReconcileManagedClusterRemoved(t *testing.T) {
+	testClusterName := "cluster1"
+	testscheme := scheme.Scheme
+	_ = mcv1.AddToScheme(testscheme)
+	_ = apis.AddToScheme(testscheme)
+
+	request := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name: testClusterName,
+		},
+	}
+
+	provisioners := map[string]map[string]string{
+		"hypershift": {
+			provisionerAnnotation: "test.test.HypershiftDeployment.cluster.open-cluster-management.io",
+		},
+		"claim": {
+			provisionerAnnotation: "test.test.ClusterClaim.hive.openshift.io/v1",
+		},
+		"hosted": {
+			common.AnnotationKlusterletDeployMode:         "Hosted",
+			common.AnnotationKlusterletHostingClusterName: "local-cluster",
+			common.AnnotationEnableHostedModeAddons:       "true",
+		},
+		"create with default": {
+			common.AnnotationCreateWithDefaultKlusterletAddonConfig: "true",
+		},
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		existingKAC bool
+		expectedKAC bool
+	}{
+		{
+			name:        "klusterlet addon config is deleted if it is removed on purpose",
+			annotations: map[string]string{common.AnnotationKlusterletAddonConfigRemoved: "true"},
+			existingKAC: true,
+			expectedKAC: false,
+		},
+		{
+			name:        "klusterlet addon config removed on purpose is not recreated",
+			annotations: map[string]string{common.AnnotationKlusterletAddonConfigRemoved: "true"},
+			existingKAC: false,
+			expectedKAC: false,
+		},
+		{
+			name: "klusterlet addon config is deleted if it is removed on purpose with automatic installation disabled",
+			annotations: map[string]string{
+				common.AnnotationKlusterletAddonConfigRemoved:      "true",
+				common.AnnotationDisableAddonAutomaticInstallation: "true",
+			},
+			existingKAC: true,
+			expectedKAC: false,
+		},
+		{
+			name:        "klusterlet addon config is recreated if the removal is withdrawn",
+			annotations: map[string]string{common.AnnotationKlusterletAddonConfigRemoved: "false"},
+			existingKAC: false,
+			expectedKAC: true,
+		},
+		{
+			name:        "klusterlet addon config is recreated without the removal",
+			existingKAC: false,
+			expectedKAC: true,
+		},
+	}
+
+	for provisioner, provisionerAnnotations := range provisioners {
+		for _, tt := range tests {
+			t.Run(provisioner+"/"+tt.name, func(t *testing.T) {
+				annotations := map[string]string{}
+				for k, v := range provisionerAnnotations {
+					annotations[k] = v
+				}
+				for k, v := range tt.annotations {
+					annotations[k] = v
+				}
+				objs := []client.Object{newManagedCluster(testClusterName, annotations)}
+				if tt.existingKAC {
+					objs = append(objs, defaultKAC(testClusterName))
+				}
+				kubeclient := fake.NewClientBuilder().WithScheme(testscheme).WithObjects(objs...).
+					WithStatusSubresource(&kacv1.KlusterletAddonConfig{}).Build()
+				reconciler := &ReconcileManagedCluster{
+					client: kubeclient,
+					scheme: testscheme,
+				}
+
+				if _, err := reconciler.Reconcile(context.TODO(), request); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+
+				var kac kacv1.KlusterletAddonConfig
+				err := kubeclient.Get(context.TODO(),
+					types.NamespacedName{Namespace: testClusterName, Name: testClusterName}, &kac)
+				switch {
+				case tt.expectedKAC && err != nil:
+					t.Errorf("expected the klusterlet addon config exists, but got error: %v", err)
+				case !tt.expectedKAC && !errors.IsNotFound(err):
+					t.Errorf("expected the klusterlet addon config is not found, but got error: %v", err)
+				}
+			})
+		}
+	}
+}
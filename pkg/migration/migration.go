@@ -0,0 +1,416 @@
+// Copyright Contributors to the Open Cluster Management project
+
+// Package migration migrates the addons installed by the KlusterletAddonConfigs to the addons installed by the
+// Placements of the ClusterManagementAddOns. The clusters are grouped by their enabled addons and the node placement
+// and proxy configurations of the addons, a Placement selects the clusters of a group by the group label, and the
+// configurations are set by the AddOnDeploymentConfigs. The install strategies of the ClusterManagementAddOns are
+// switched to the Placements only after the Placements select the clusters of the KlusterletAddonConfigs.
+package migration
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+	clusterv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
+
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/controller/addon"
+)
+
+const (
+	// LabelAddonGroup is the label of the ManagedClusters of the group name, it is selected by the Placement of
+	// the group
+	LabelAddonGroup = "agent.open-cluster-management.io/addon-group"
+
+	// DefaultNamespace is the default namespace of the Placements and the AddOnDeploymentConfigs
+	DefaultNamespace = "open-cluster-management-global-set"
+	// DefaultClusterSet is the default ManagedClusterSet bound to the namespace
+	DefaultClusterSet = "global"
+
+	// namePrefix is the prefix of the names of the generated Placements and AddOnDeploymentConfigs
+	namePrefix = "kac-"
+)
+
+// addOnDeploymentConfigResource is the group and resource of the AddOnDeploymentConfigs in the configs of the
+// ClusterManagementAddOns
+var addOnDeploymentConfigResource = addonv1alpha1.ConfigGroupResource{
+	Group:    addonv1alpha1.GroupName,
+	Resource: "addondeploymentconfigs",
+}
+
+// Options is the configurations of the migration
+type Options struct {
+	// Namespace is the namespace of the Placements and the AddOnDeploymentConfigs
+	Namespace string
+	// ClusterSet is the ManagedClusterSet of the clusters bound to the namespace
+	ClusterSet string
+	// Addons are the names of the addons to migrate, all the addons are migrated if it is empty
+	Addons []string
+	// Controller is the options of the controller, which decide the hosted mode and the default image pull secret
+	// and policy of the addons, the default options are used if it is nil
+	Controller *common.Options
+}
+
+// Plan is the result of the migration analysis, and the resources generated for the migrated addons
+type Plan struct {
+	// Addons are the names of the migrated addons
+	Addons []string `json:"addons"`
+	// Blocked are the reasons of the addons which can not be migrated by addon name
+	Blocked map[string][]string `json:"blocked,omitempty"`
+	// Groups are the groups of the clusters by the migrated addons and their configurations
+	Groups []Group `json:"groups"`
+
+	ClusterSetBinding *clusterv1beta2.ManagedClusterSetBinding `json:"clusterSetBinding"`
+	Placements        []*clusterv1beta1.Placement              `json:"placements"`
+	DeploymentConfigs []*addonv1alpha1.AddOnDeploymentConfig   `json:"deploymentConfigs"`
+	// InstallStrategies are the install strategies of the ClusterManagementAddOns by addon name
+	InstallStrategies map[string]addonv1alpha1.InstallStrategy `json:"installStrategies"`
+}
+
+// Group is a group of the clusters with the same migrated addons and configurations, the clusters are selected
+// by the Placement of the group name
+type Group struct {
+	Name     string       `json:"name"`
+	Clusters []string     `json:"clusters"`
+	Addons   []GroupAddon `json:"addons"`
+}
+
+// GroupAddon is an addon of a group, DeploymentConfig is the name of its AddOnDeploymentConfig, it is empty if the
+// addon has no configurations
+type GroupAddon struct {
+	Name             string `json:"name"`
+	DeploymentConfig string `json:"deploymentConfig,omitempty"`
+}
+
+// Migrator migrates the addons of the fleet
+type Migrator struct {
+	client client.Client
+	opts   Options
+}
+
+// NewMigrator returns a Migrator with the options, the default namespace and ManagedClusterSet are used if they
+// are not set
+func NewMigrator(c client.Client, opts Options) *Migrator {
+	if len(opts.Namespace) == 0 {
+		opts.Namespace = DefaultNamespace
+	}
+	if len(opts.ClusterSet) == 0 {
+		opts.ClusterSet = DefaultClusterSet
+	}
+	return &Migrator{client: c, opts: opts}
+}
+
+// Plan groups the clusters of the KlusterletAddonConfigs and generates the resources of the migration. An addon is
+// not migrated if its ClusterManagementAddOn does not exist or is already installed by Placements, or if the
+// configurations of the addon of any cluster can not be set by the AddOnDeploymentConfigs. The image pull secrets,
+// the image pull policies and the image overrides are not supported by the AddOnDeploymentConfigs, the registries
+// of an AddOnDeploymentConfig only replace the registries of all the images.
+func (m *Migrator) Plan(ctx context.Context) (*Plan, error) {
+	addonNames, err := m.addonNames()
+	if err != nil {
+		return nil, err
+	}
+
+	configs := &agentv1.KlusterletAddonConfigList{}
+	if err := m.client.List(ctx, configs); err != nil {
+		return nil, fmt.Errorf("failed to list the KlusterletAddonConfigs: %v", err)
+	}
+	sort.Slice(configs.Items, func(i, j int) bool {
+		return configs.Items[i].Namespace < configs.Items[j].Namespace
+	})
+
+	plan := &Plan{Blocked: map[string][]string{}}
+	block := func(addonName, format string, args ...interface{}) {
+		plan.Blocked[addonName] = append(plan.Blocked[addonName], fmt.Sprintf(format, args...))
+	}
+
+	// the ClusterManagementAddOns of the addons
+	supportsDeploymentConfigs := map[string]bool{}
+	cmas := map[string]*addonv1alpha1.ClusterManagementAddOn{}
+	for _, addonName := range addonNames {
+		cma := &addonv1alpha1.ClusterManagementAddOn{}
+		err := m.client.Get(ctx, types.NamespacedName{Name: addonName}, cma)
+		switch {
+		case errors.IsNotFound(err):
+			block(addonName, "the ClusterManagementAddOn does not exist")
+			continue
+		case err != nil:
+			return nil, err
+		case cma.Spec.InstallStrategy.Type == addonv1alpha1.AddonInstallStrategyPlacements:
+			block(addonName, "the addon is already installed by Placements")
+			continue
+		}
+		cmas[addonName] = cma
+		for _, supported := range cma.Spec.SupportedConfigs {
+			if supported.ConfigGroupResource == addOnDeploymentConfigResource {
+				supportsDeploymentConfigs[addonName] = true
+			}
+		}
+	}
+
+	// the AddOnDeploymentConfig specs of the enabled addons of the clusters
+	clusterSpecs := map[string]map[string]*addonv1alpha1.AddOnDeploymentConfigSpec{}
+	for i := range configs.Items {
+		config := &configs.Items[i]
+		clusterName := config.Namespace
+		managedCluster := &clusterv1.ManagedCluster{}
+		if err := m.client.Get(ctx, types.NamespacedName{Name: clusterName}, managedCluster); err != nil {
+			if !errors.IsNotFound(err) {
+				return nil, err
+			}
+			managedCluster = nil
+		}
+
+		clusterSpecs[clusterName] = map[string]*addonv1alpha1.AddOnDeploymentConfigSpec{}
+		for _, addonName := range addonNames {
+			// the addons blocked by their ClusterManagementAddOns are not checked on the clusters
+			cma, ok := cmas[addonName]
+			if !ok {
+				continue
+			}
+			agentConfig := agentv1.GetAddonAgentConfig(addonName, config)
+			if agentConfig == nil || !agentConfig.Enabled {
+				continue
+			}
+
+			deployment, err := addon.GetAddonDeployment(addonName, config, managedCluster, cma,
+				m.opts.Controller)
+			switch {
+			case err != nil:
+				block(addonName, "%s: %v", clusterName, err)
+				continue
+			case config.Spec.DryRun:
+				block(addonName, "%s: the KlusterletAddonConfig is in dry-run mode", clusterName)
+				continue
+			case deployment.Paused:
+				block(addonName, "%s: the addon is paused", clusterName)
+				continue
+			case len(deployment.Resources) != 0:
+				block(addonName, "%s: the resources of the addon are not supported by AddOnDeploymentConfigs",
+					clusterName)
+				continue
+			case len(deployment.HostingClusterName) != 0:
+				block(addonName, "%s: the addon is deployed in hosted mode", clusterName)
+				continue
+			case len(deployment.ImagePullSecret.Name) != 0:
+				block(addonName, "%s: the image pull secret %s of the addon is not supported by "+
+					"AddOnDeploymentConfigs", clusterName, deployment.ImagePullSecret)
+				continue
+			case len(deployment.ImagePullPolicy) != 0:
+				block(addonName, "%s: the image pull policy %s of the addon is not supported by "+
+					"AddOnDeploymentConfigs", clusterName, deployment.ImagePullPolicy)
+				continue
+			case len(deployment.ImageOverrides) != 0:
+				block(addonName, "%s: the image overrides of the addon are not supported by AddOnDeploymentConfigs",
+					clusterName)
+				continue
+			}
+
+			spec := newDeploymentConfigSpec(deployment)
+			if spec != nil && !supportsDeploymentConfigs[addonName] {
+				block(addonName, "%s: the ClusterManagementAddOn does not support AddOnDeploymentConfigs",
+					clusterName)
+				continue
+			}
+			clusterSpecs[clusterName][addonName] = spec
+		}
+	}
+
+	for _, addonName := range addonNames {
+		if _, blocked := plan.Blocked[addonName]; !blocked {
+			plan.Addons = append(plan.Addons, addonName)
+		}
+	}
+	m.generate(plan, clusterSpecs)
+	return plan, nil
+}
+
+// generate groups the clusters by the specs of their migrated addons, and generates the resources of the groups
+func (m *Migrator) generate(plan *Plan, clusterSpecs map[string]map[string]*addonv1alpha1.AddOnDeploymentConfigSpec) {
+	plan.ClusterSetBinding = &clusterv1beta2.ManagedClusterSetBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: clusterv1beta2.GroupVersion.String(),
+			Kind:       "ManagedClusterSetBinding",
+		},
+		ObjectMeta: metav1.ObjectMeta{Namespace: m.opts.Namespace, Name: m.opts.ClusterSet},
+		Spec:       clusterv1beta2.ManagedClusterSetBindingSpec{ClusterSet: m.opts.ClusterSet},
+	}
+	plan.InstallStrategies = map[string]addonv1alpha1.InstallStrategy{}
+
+	groups := map[string]*Group{}
+	deploymentConfigs := map[string]*addonv1alpha1.AddOnDeploymentConfig{}
+	clusterNames := make([]string, 0, len(clusterSpecs))
+	for clusterName := range clusterSpecs {
+		clusterNames = append(clusterNames, clusterName)
+	}
+	sort.Strings(clusterNames)
+
+	for _, clusterName := range clusterNames {
+		groupAddons := []GroupAddon{}
+		for _, addonName := range plan.Addons {
+			spec, enabled := clusterSpecs[clusterName][addonName]
+			if !enabled {
+				continue
+			}
+			groupAddon := GroupAddon{Name: addonName}
+			if spec != nil {
+				groupAddon.DeploymentConfig = namePrefix + hash(spec)
+				deploymentConfigs[groupAddon.DeploymentConfig] = &addonv1alpha1.AddOnDeploymentConfig{
+					TypeMeta: metav1.TypeMeta{
+						APIVersion: addonv1alpha1.GroupVersion.String(),
+						Kind:       "AddOnDeploymentConfig",
+					},
+					ObjectMeta: metav1.ObjectMeta{Namespace: m.opts.Namespace, Name: groupAddon.DeploymentConfig},
+					Spec:       *spec,
+				}
+			}
+			groupAddons = append(groupAddons, groupAddon)
+		}
+		// the clusters without migrated addons are not selected by any Placement
+		if len(groupAddons) == 0 {
+			continue
+		}
+
+		groupName := namePrefix + hash(groupAddons)
+		group, ok := groups[groupName]
+		if !ok {
+			group = &Group{Name: groupName, Addons: groupAddons}
+			groups[groupName] = group
+		}
+		group.Clusters = append(group.Clusters, clusterName)
+	}
+
+	groupNames := make([]string, 0, len(groups))
+	for groupName := range groups {
+		groupNames = append(groupNames, groupName)
+	}
+	sort.Strings(groupNames)
+	placements := map[string][]addonv1alpha1.PlacementStrategy{}
+	for _, groupName := range groupNames {
+		group := groups[groupName]
+		plan.Groups = append(plan.Groups, *group)
+		plan.Placements = append(plan.Placements, m.newPlacement(groupName))
+
+		for _, groupAddon := range group.Addons {
+			strategy := addonv1alpha1.PlacementStrategy{
+				PlacementRef: addonv1alpha1.PlacementRef{Namespace: m.opts.Namespace, Name: groupName},
+			}
+			if len(groupAddon.DeploymentConfig) != 0 {
+				strategy.Configs = []addonv1alpha1.AddOnConfig{{
+					ConfigGroupResource: addOnDeploymentConfigResource,
+					ConfigReferent: addonv1alpha1.ConfigReferent{
+						Namespace: m.opts.Namespace,
+						Name:      groupAddon.DeploymentConfig,
+					},
+				}}
+			}
+			placements[groupAddon.Name] = append(placements[groupAddon.Name], strategy)
+		}
+	}
+
+	for _, addonName := range plan.Addons {
+		plan.InstallStrategies[addonName] = addonv1alpha1.InstallStrategy{
+			Type:       addonv1alpha1.AddonInstallStrategyPlacements,
+			Placements: placements[addonName],
+		}
+	}
+
+	names := make([]string, 0, len(deploymentConfigs))
+	for name := range deploymentConfigs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		plan.DeploymentConfigs = append(plan.DeploymentConfigs, deploymentConfigs[name])
+	}
+}
+
+// newPlacement returns the Placement which selects the clusters of the group, the unavailable and unreachable
+// clusters are tolerated so that their addons are kept
+func (m *Migrator) newPlacement(groupName string) *clusterv1beta1.Placement {
+	return &clusterv1beta1.Placement{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: clusterv1beta1.GroupVersion.String(),
+			Kind:       "Placement",
+		},
+		ObjectMeta: metav1.ObjectMeta{Namespace: m.opts.Namespace, Name: groupName},
+		Spec: clusterv1beta1.PlacementSpec{
+			ClusterSets: []string{m.opts.ClusterSet},
+			Predicates: []clusterv1beta1.ClusterPredicate{{
+				RequiredClusterSelector: clusterv1beta1.ClusterSelector{
+					LabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{LabelAddonGroup: groupName}},
+				},
+			}},
+			Tolerations: []clusterv1beta1.Toleration{
+				{Key: clusterv1.ManagedClusterTaintUnavailable, Operator: clusterv1beta1.TolerationOpExists},
+				{Key: clusterv1.ManagedClusterTaintUnreachable, Operator: clusterv1beta1.TolerationOpExists},
+			},
+		},
+	}
+}
+
+// addonNames returns the sorted names of the addons to migrate
+func (m *Migrator) addonNames() ([]string, error) {
+	addonNames := []string{}
+	for _, addonName := range m.opts.Addons {
+		if !agentv1.KlusterletAddons[addonName] {
+			return nil, fmt.Errorf("addon %q is not managed by the KlusterletAddonConfig", addonName)
+		}
+		addonNames = append(addonNames, addonName)
+	}
+	if len(addonNames) == 0 {
+		for addonName, managed := range agentv1.KlusterletAddons {
+			if managed {
+				addonNames = append(addonNames, addonName)
+			}
+		}
+	}
+	sort.Strings(addonNames)
+	return addonNames, nil
+}
+
+// newDeploymentConfigSpec returns the AddOnDeploymentConfig spec of the addon deployment, nil is returned if the
+// addon deployment has no configurations
+func newDeploymentConfigSpec(deployment addon.AddonDeployment) *addonv1alpha1.AddOnDeploymentConfigSpec {
+	spec := &addonv1alpha1.AddOnDeploymentConfigSpec{
+		ProxyConfig: addonv1alpha1.ProxyConfig{
+			HTTPProxy:  deployment.ProxyConfig[agentv1.HTTPProxy],
+			HTTPSProxy: deployment.ProxyConfig[agentv1.HTTPSProxy],
+			NoProxy:    deployment.ProxyConfig[agentv1.NoProxy],
+		},
+	}
+	if len(deployment.NodePlacement.NodeSelector) != 0 || len(deployment.NodePlacement.Tolerations) != 0 {
+		spec.NodePlacement = &addonv1alpha1.NodePlacement{
+			NodeSelector: deployment.NodePlacement.NodeSelector,
+			Tolerations:  deployment.NodePlacement.Tolerations,
+		}
+	}
+	if spec.NodePlacement == nil && len(spec.ProxyConfig.HTTPProxy) == 0 && len(spec.ProxyConfig.HTTPSProxy) == 0 &&
+		len(spec.ProxyConfig.NoProxy) == 0 {
+		return nil
+	}
+	return spec
+}
+
+// hash returns the short hash of the JSON of the value, it is used in the names of the generated resources
+func hash(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:10]
+}
@@ -0,0 +1,387 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package migration
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+	clusterv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
+
+	"github.com/stolostron/klusterlet-addon-controller/pkg/apis"
+	agentv1 "github.com/stolostron/klusterlet-addon-controller/pkg/apis/agent/v1"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/common"
+	"github.com/stolostron/klusterlet-addon-controller/pkg/controller/addon"
+)
+
+func newTestClient(objs ...runtime.Object) client.Client {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = apis.AddToScheme(scheme)
+	_ = clusterv1.AddToScheme(scheme)
+	_ = clusterv1beta1.AddToScheme(scheme)
+	_ = clusterv1beta2.AddToScheme(scheme)
+	_ = addonv1alpha1.AddToScheme(scheme)
+	return fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objs...).Build()
+}
+
+func newClusterManagementAddOn(name string, supportsDeploymentConfigs bool,
+	strategyType string) *addonv1alpha1.ClusterManagementAddOn {
+	cma := &addonv1alpha1.ClusterManagementAddOn{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: addonv1alpha1.ClusterManagementAddOnSpec{
+			InstallStrategy: addonv1alpha1.InstallStrategy{Type: strategyType},
+		},
+	}
+	if supportsDeploymentConfigs {
+		cma.Spec.SupportedConfigs = []addonv1alpha1.ConfigMeta{{ConfigGroupResource: addOnDeploymentConfigResource}}
+	}
+	return cma
+}
+
+func newManagedCluster(name string) *clusterv1.ManagedCluster {
+	return &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: name}}
+}
+
+func newKlusterletAddonConfig(clusterName string, application, search bool,
+	mutate func(*agentv1.KlusterletAddonConfig)) *agentv1.KlusterletAddonConfig {
+	config := &agentv1.KlusterletAddonConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: clusterName, Namespace: clusterName},
+		Spec: agentv1.KlusterletAddonConfigSpec{
+			ApplicationManagerConfig: agentv1.KlusterletAddonAgentConfigSpec{Enabled: application},
+			SearchCollectorConfig:    agentv1.KlusterletAddonAgentConfigSpec{Enabled: search},
+		},
+	}
+	if mutate != nil {
+		mutate(config)
+	}
+	return config
+}
+
+func withCustomProxy(config *agentv1.KlusterletAddonConfig) {
+	config.Spec.ProxyConfig = agentv1.ProxyConfig{HTTPProxy: "http://proxy", NoProxy: "localhost"}
+	config.Spec.ApplicationManagerConfig.ProxyPolicy = agentv1.ProxyPolicyCustomProxy
+}
+
+func withSearchNodeSelector(config *agentv1.KlusterletAddonConfig) {
+	config.Spec.SearchCollectorConfig.NodePlacement = &agentv1.NodePlacement{
+		NodeSelector: map[string]string{"node-role.kubernetes.io/infra": ""},
+	}
+}
+
+// groupsByCluster returns the addons of the group of each cluster, for example "application-manager(kac-...)"
+func groupsByCluster(plan *Plan) map[string]string {
+	clusters := map[string]string{}
+	for _, group := range plan.Groups {
+		addons := map[string]string{}
+		for _, groupAddon := range group.Addons {
+			addons[groupAddon.Name] = groupAddon.DeploymentConfig
+		}
+		for _, clusterName := range group.Clusters {
+			clusters[clusterName] = formatAddons(addons)
+		}
+	}
+	return clusters
+}
+
+func Test_Plan(t *testing.T) {
+	application := agentv1.ApplicationAddonName
+	search := agentv1.SearchAddonName
+	proxySpec := newDeploymentConfigSpec(addonDeployment(map[string]string{
+		agentv1.HTTPProxy: "http://proxy", agentv1.NoProxy: "localhost"}, nil))
+	nodeSelectorSpec := newDeploymentConfigSpec(addonDeployment(nil,
+		map[string]string{"node-role.kubernetes.io/infra": ""}))
+
+	tests := []struct {
+		name            string
+		addons          []string
+		controller      *common.Options
+		objs            []runtime.Object
+		expectedAddons  []string
+		expectedBlocked map[string][]string
+		expectedGroups  map[string]string
+		expectedConfigs int
+	}{
+		{
+			name:   "group by addons and configurations",
+			addons: []string{application, search},
+			objs: []runtime.Object{
+				newClusterManagementAddOn(application, true, addonv1alpha1.AddonInstallStrategyManual),
+				newClusterManagementAddOn(search, true, ""),
+				newManagedCluster("cluster1"), newManagedCluster("cluster2"), newManagedCluster("cluster3"),
+				newKlusterletAddonConfig("cluster1", true, true, nil),
+				newKlusterletAddonConfig("cluster2", true, true, nil),
+				newKlusterletAddonConfig("cluster3", true, true, withCustomProxy),
+				newKlusterletAddonConfig("cluster4", false, true, withSearchNodeSelector),
+				newKlusterletAddonConfig("cluster5", false, false, nil),
+			},
+			expectedAddons:  []string{application, search},
+			expectedBlocked: map[string][]string{},
+			expectedGroups: map[string]string{
+				"cluster1": "application-manager, search-collector",
+				"cluster2": "application-manager, search-collector",
+				"cluster3": "application-manager(kac-" + hash(proxySpec) + "), search-collector",
+				"cluster4": "search-collector(kac-" + hash(nodeSelectorSpec) + ")",
+			},
+			expectedConfigs: 2,
+		},
+		{
+			name: "blocked addons",
+			objs: []runtime.Object{
+				newClusterManagementAddOn(application, true, ""),
+				newClusterManagementAddOn(search, false, ""),
+				newClusterManagementAddOn(agentv1.ConfigPolicyAddonName, true,
+					addonv1alpha1.AddonInstallStrategyPlacements),
+				newClusterManagementAddOn(agentv1.PolicyFrameworkAddonName, true, ""),
+				newKlusterletAddonConfig("cluster1", true, true, nil),
+				newKlusterletAddonConfig("cluster2", true, true, func(config *agentv1.KlusterletAddonConfig) {
+					withSearchNodeSelector(config)
+					config.Spec.ApplicationManagerConfig.Paused = true
+				}),
+			},
+			expectedAddons: []string{agentv1.PolicyFrameworkAddonName},
+			expectedBlocked: map[string][]string{
+				application:                   {"cluster2: the addon is paused"},
+				agentv1.CertPolicyAddonName:   {"the ClusterManagementAddOn does not exist"},
+				agentv1.ConfigPolicyAddonName: {"the addon is already installed by Placements"},
+				search: {
+					"cluster2: the ClusterManagementAddOn does not support AddOnDeploymentConfigs",
+				},
+			},
+			expectedGroups: map[string]string{},
+		},
+		{
+			name:   "blocked image settings",
+			addons: []string{application, search},
+			objs: []runtime.Object{
+				newClusterManagementAddOn(application, true, ""),
+				newClusterManagementAddOn(search, true, ""),
+				newKlusterletAddonConfig("cluster1", true, false, func(config *agentv1.KlusterletAddonConfig) {
+					config.Spec.ImagePullSecret = "pull-secret"
+				}),
+				newKlusterletAddonConfig("cluster2", false, true, func(config *agentv1.KlusterletAddonConfig) {
+					config.Spec.SearchCollectorConfig.ImagePullPolicy = corev1.PullAlways
+				}),
+				newKlusterletAddonConfig("cluster3", false, true, func(config *agentv1.KlusterletAddonConfig) {
+					config.Spec.SearchCollectorConfig.ImageOverrides = map[string]string{
+						"search_collector": "quay.io/search/collector:latest",
+					}
+				}),
+			},
+			expectedBlocked: map[string][]string{
+				application: {"cluster1: the image pull secret cluster1/pull-secret of the addon is not supported " +
+					"by AddOnDeploymentConfigs"},
+				search: {
+					"cluster2: the image pull policy Always of the addon is not supported by AddOnDeploymentConfigs",
+					"cluster3: the image overrides of the addon are not supported by AddOnDeploymentConfigs",
+				},
+			},
+			expectedGroups: map[string]string{},
+		},
+		{
+			name:   "blocked default image pull secret",
+			addons: []string{application},
+			controller: &common.Options{
+				DefaultImagePullSecret: "open-cluster-management/pull-secret",
+			},
+			objs: []runtime.Object{
+				newClusterManagementAddOn(application, true, ""),
+				newKlusterletAddonConfig("cluster1", true, false, nil),
+			},
+			expectedBlocked: map[string][]string{
+				application: {"cluster1: the image pull secret open-cluster-management/pull-secret of the addon " +
+					"is not supported by AddOnDeploymentConfigs"},
+			},
+			expectedGroups: map[string]string{},
+		},
+		{
+			name:       "blocked hosted addons",
+			addons:     []string{application, search},
+			controller: &common.Options{HostedAddOns: search},
+			objs: []runtime.Object{
+				newClusterManagementAddOn(application, true, ""),
+				newClusterManagementAddOn(search, true, ""),
+				&clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{
+					Name: "cluster1",
+					Annotations: map[string]string{
+						common.AnnotationKlusterletDeployMode:         "Hosted",
+						common.AnnotationEnableHostedModeAddons:       "true",
+						common.AnnotationKlusterletHostingClusterName: "hosting",
+					},
+				}},
+				newKlusterletAddonConfig("cluster1", true, true, nil),
+			},
+			// the application manager is not a hosted addon, it is deployed in default mode
+			expectedAddons:  []string{application},
+			expectedBlocked: map[string][]string{search: {"cluster1: the addon is deployed in hosted mode"}},
+			expectedGroups:  map[string]string{"cluster1": "application-manager"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrator := NewMigrator(newTestClient(tt.objs...), Options{Addons: tt.addons, Controller: tt.controller})
+			plan, err := migrator.Plan(context.TODO())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(plan.Addons, tt.expectedAddons) {
+				t.Errorf("expected addons %v, but got %v", tt.expectedAddons, plan.Addons)
+			}
+			if !reflect.DeepEqual(plan.Blocked, tt.expectedBlocked) {
+				t.Errorf("expected blocked %v, but got %v", tt.expectedBlocked, plan.Blocked)
+			}
+			if groups := groupsByCluster(plan); !reflect.DeepEqual(groups, tt.expectedGroups) {
+				t.Errorf("expected groups %v, but got %v", tt.expectedGroups, groups)
+			}
+			if len(plan.DeploymentConfigs) != tt.expectedConfigs {
+				t.Errorf("expected %d AddOnDeploymentConfigs, but got %d", tt.expectedConfigs,
+					len(plan.DeploymentConfigs))
+			}
+			if len(plan.Placements) != len(plan.Groups) {
+				t.Errorf("expected a Placement of each group, but got %d", len(plan.Placements))
+			}
+			for _, addonName := range plan.Addons {
+				strategy := plan.InstallStrategies[addonName]
+				if strategy.Type != addonv1alpha1.AddonInstallStrategyPlacements {
+					t.Errorf("expected the Placements install strategy of %s, but got %q", addonName, strategy.Type)
+				}
+			}
+		})
+	}
+}
+
+func addonDeployment(proxyConfig, nodeSelector map[string]string) addon.AddonDeployment {
+	return addon.AddonDeployment{
+		ProxyConfig:   proxyConfig,
+		NodePlacement: agentv1.NodePlacement{NodeSelector: nodeSelector},
+	}
+}
+
+func newPlacementDecision(placementName string, clusterNames ...string) *clusterv1beta1.PlacementDecision {
+	decision := &clusterv1beta1.PlacementDecision{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: DefaultNamespace,
+			Name:      placementName + "-decision-1",
+			Labels:    map[string]string{clusterv1beta1.PlacementLabel: placementName},
+		},
+	}
+	for _, clusterName := range clusterNames {
+		decision.Status.Decisions = append(decision.Status.Decisions,
+			clusterv1beta1.ClusterDecision{ClusterName: clusterName})
+	}
+	return decision
+}
+
+func Test_SwitchOver(t *testing.T) {
+	application := agentv1.ApplicationAddonName
+	c := newTestClient(
+		newClusterManagementAddOn(application, true, ""),
+		newManagedCluster("cluster1"), newManagedCluster("cluster2"),
+		newKlusterletAddonConfig("cluster1", true, false, nil),
+		newKlusterletAddonConfig("cluster2", true, false, withCustomProxy),
+	)
+	ctx := context.TODO()
+	migrator := NewMigrator(c, Options{Addons: []string{application}})
+	plan, err := migrator.Plan(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan.Groups) != 2 || len(plan.DeploymentConfigs) != 1 {
+		t.Fatalf("expected 2 groups and 1 AddOnDeploymentConfig, but got %v", plan.Groups)
+	}
+
+	// the Placements are not created yet
+	if err := migrator.SwitchOver(ctx, plan); err == nil {
+		t.Fatalf("expected the switch over to be refused before the resources are applied")
+	}
+
+	if err := migrator.Apply(ctx, plan); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// applying the plan again is a no-op
+	if err := migrator.Apply(ctx, plan); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, group := range plan.Groups {
+		for _, clusterName := range group.Clusters {
+			cluster := &clusterv1.ManagedCluster{}
+			if err := c.Get(ctx, types.NamespacedName{Name: clusterName}, cluster); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cluster.Labels[LabelAddonGroup] != group.Name {
+				t.Errorf("expected cluster %s in group %s, but got labels %v", clusterName, group.Name,
+					cluster.Labels)
+			}
+		}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: DefaultNamespace, Name: group.Name},
+			&clusterv1beta1.Placement{}); err != nil {
+			t.Errorf("expected the Placement of group %s, but got %v", group.Name, err)
+		}
+	}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: DefaultNamespace, Name: DefaultClusterSet},
+		&clusterv1beta2.ManagedClusterSetBinding{}); err != nil {
+		t.Errorf("expected the ManagedClusterSetBinding, but got %v", err)
+	}
+
+	// the Placements select no clusters
+	mismatches, err := migrator.Verify(ctx, plan)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mismatches) != 2 {
+		t.Errorf("expected a mismatch of each cluster, but got %v", mismatches)
+	}
+
+	// the Placement of the first group selects both clusters
+	decision := newPlacementDecision(plan.Groups[0].Name, "cluster1", "cluster2")
+	if err := c.Create(ctx, decision); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Create(ctx, newPlacementDecision(plan.Groups[1].Name, plan.Groups[1].Clusters...)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = migrator.SwitchOver(ctx, plan)
+	if err == nil || !strings.Contains(err.Error(), plan.Groups[1].Clusters[0]) {
+		t.Fatalf("expected the mismatch of cluster %s, but got %v", plan.Groups[1].Clusters[0], err)
+	}
+	cma := &addonv1alpha1.ClusterManagementAddOn{}
+	if err := c.Get(ctx, types.NamespacedName{Name: application}, cma); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cma.Spec.InstallStrategy.Placements) != 0 {
+		t.Errorf("expected the install strategy unchanged, but got %v", cma.Spec.InstallStrategy)
+	}
+
+	decision.Status.Decisions = decision.Status.Decisions[:0]
+	for _, clusterName := range plan.Groups[0].Clusters {
+		decision.Status.Decisions = append(decision.Status.Decisions,
+			clusterv1beta1.ClusterDecision{ClusterName: clusterName})
+	}
+	if err := c.Update(ctx, decision); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := migrator.SwitchOver(ctx, plan); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Get(ctx, types.NamespacedName{Name: application}, cma); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(cma.Spec.InstallStrategy, plan.InstallStrategies[application]) {
+		t.Errorf("expected the install strategy %v, but got %v", plan.InstallStrategies[application],
+			cma.Spec.InstallStrategy)
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package migration
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+	clusterv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
+)
+
+// Apply creates or updates the ManagedClusterSetBinding, the Placements and the AddOnDeploymentConfigs of the plan,
+// and labels the clusters of the groups. The install strategies of the ClusterManagementAddOns are not changed, so
+// the addons are still installed by the KlusterletAddonConfigs.
+func (m *Migrator) Apply(ctx context.Context, plan *Plan) error {
+	objs := []client.Object{plan.ClusterSetBinding.DeepCopy()}
+	for _, placement := range plan.Placements {
+		objs = append(objs, placement.DeepCopy())
+	}
+	for _, deploymentConfig := range plan.DeploymentConfigs {
+		objs = append(objs, deploymentConfig.DeepCopy())
+	}
+	for _, obj := range objs {
+		if err := m.apply(ctx, obj); err != nil {
+			return err
+		}
+	}
+
+	for _, group := range plan.Groups {
+		for _, clusterName := range group.Clusters {
+			cluster := &clusterv1.ManagedCluster{}
+			if err := m.client.Get(ctx, types.NamespacedName{Name: clusterName}, cluster); err != nil {
+				if errors.IsNotFound(err) {
+					continue
+				}
+				return err
+			}
+			if cluster.Labels[LabelAddonGroup] == group.Name {
+				continue
+			}
+			patch := client.MergeFrom(cluster.DeepCopy())
+			if cluster.Labels == nil {
+				cluster.Labels = map[string]string{}
+			}
+			cluster.Labels[LabelAddonGroup] = group.Name
+			if err := m.client.Patch(ctx, cluster, patch); err != nil {
+				return fmt.Errorf("failed to label the ManagedCluster %s: %v", clusterName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// apply creates the object, or updates its spec if it exists
+func (m *Migrator) apply(ctx context.Context, obj client.Object) error {
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	existing := obj.DeepCopyObject().(client.Object)
+	err := m.client.Get(ctx, client.ObjectKeyFromObject(obj), existing)
+	if errors.IsNotFound(err) {
+		if err := m.client.Create(ctx, obj); err != nil {
+			return fmt.Errorf("failed to create the %s %s/%s: %v", kind, obj.GetNamespace(), obj.GetName(), err)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	switch desired := obj.(type) {
+	case *clusterv1beta2.ManagedClusterSetBinding:
+		// the ManagedClusterSet of the binding is immutable and is the name of the binding
+		return nil
+	case *clusterv1beta1.Placement:
+		current := existing.(*clusterv1beta1.Placement)
+		if changed = !equality.Semantic.DeepEqual(current.Spec, desired.Spec); changed {
+			current.Spec = desired.Spec
+		}
+	case *addonv1alpha1.AddOnDeploymentConfig:
+		current := existing.(*addonv1alpha1.AddOnDeploymentConfig)
+		if changed = !equality.Semantic.DeepEqual(current.Spec, desired.Spec); changed {
+			current.Spec = desired.Spec
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if err := m.client.Update(ctx, existing); err != nil {
+		return fmt.Errorf("failed to update the %s %s/%s: %v", kind, obj.GetNamespace(), obj.GetName(), err)
+	}
+	return nil
+}
+
+// Verify returns the mismatches between the addons of the clusters in the plan and the addons the Placements of
+// the plan would install. The decisions of the Placements and the AddOnDeploymentConfigs are read from the hub.
+func (m *Migrator) Verify(ctx context.Context, plan *Plan) ([]string, error) {
+	mismatches := []string{}
+
+	for _, deploymentConfig := range plan.DeploymentConfigs {
+		existing := &addonv1alpha1.AddOnDeploymentConfig{}
+		err := m.client.Get(ctx, client.ObjectKeyFromObject(deploymentConfig), existing)
+		switch {
+		case errors.IsNotFound(err):
+			mismatches = append(mismatches, fmt.Sprintf("the AddOnDeploymentConfig %s/%s does not exist",
+				deploymentConfig.Namespace, deploymentConfig.Name))
+		case err != nil:
+			return nil, err
+		case !equality.Semantic.DeepEqual(existing.Spec, deploymentConfig.Spec):
+			mismatches = append(mismatches, fmt.Sprintf("the AddOnDeploymentConfig %s/%s is changed",
+				deploymentConfig.Namespace, deploymentConfig.Name))
+		}
+	}
+
+	// the addons and their AddOnDeploymentConfigs of the clusters by the KlusterletAddonConfigs
+	expected := map[string]map[string]string{}
+	for _, group := range plan.Groups {
+		for _, clusterName := range group.Clusters {
+			expected[clusterName] = map[string]string{}
+			for _, groupAddon := range group.Addons {
+				expected[clusterName][groupAddon.Name] = groupAddon.DeploymentConfig
+			}
+		}
+	}
+
+	// the addons and their AddOnDeploymentConfigs of the clusters by the decisions of the Placements
+	decisions := map[string][]string{}
+	for _, placement := range plan.Placements {
+		clusterNames, err := m.decisions(ctx, placement)
+		if err != nil {
+			return nil, err
+		}
+		decisions[placement.Name] = clusterNames
+	}
+	actual := map[string]map[string]string{}
+	for _, addonName := range plan.Addons {
+		selectedBy := map[string]string{}
+		for _, placementStrategy := range plan.InstallStrategies[addonName].Placements {
+			deploymentConfig := ""
+			for _, config := range placementStrategy.Configs {
+				if config.ConfigGroupResource == addOnDeploymentConfigResource {
+					deploymentConfig = config.Name
+				}
+			}
+			for _, clusterName := range decisions[placementStrategy.Name] {
+				// the configurations of the addon are ambiguous if the cluster is selected by several Placements
+				if placementName, ok := selectedBy[clusterName]; ok {
+					mismatches = append(mismatches, fmt.Sprintf("%s: the addon %s is selected by the Placements "+
+						"%s and %s", clusterName, addonName, placementName, placementStrategy.Name))
+					continue
+				}
+				selectedBy[clusterName] = placementStrategy.Name
+				if _, ok := actual[clusterName]; !ok {
+					actual[clusterName] = map[string]string{}
+				}
+				actual[clusterName][addonName] = deploymentConfig
+			}
+		}
+	}
+
+	clusterNames := []string{}
+	for clusterName := range expected {
+		clusterNames = append(clusterNames, clusterName)
+	}
+	for clusterName := range actual {
+		if _, ok := expected[clusterName]; !ok {
+			clusterNames = append(clusterNames, clusterName)
+		}
+	}
+	sort.Strings(clusterNames)
+	for _, clusterName := range clusterNames {
+		if !reflect.DeepEqual(expected[clusterName], actual[clusterName]) &&
+			(len(expected[clusterName]) != 0 || len(actual[clusterName]) != 0) {
+			mismatches = append(mismatches, fmt.Sprintf("%s: the KlusterletAddonConfig installs [%s], but the "+
+				"Placements install [%s]", clusterName, formatAddons(expected[clusterName]),
+				formatAddons(actual[clusterName])))
+		}
+	}
+	return mismatches, nil
+}
+
+// decisions returns the names of the clusters selected by the Placement
+func (m *Migrator) decisions(ctx context.Context, placement *clusterv1beta1.Placement) ([]string, error) {
+	decisions := &clusterv1beta1.PlacementDecisionList{}
+	if err := m.client.List(ctx, decisions, client.InNamespace(placement.Namespace),
+		client.MatchingLabels{clusterv1beta1.PlacementLabel: placement.Name}); err != nil {
+		return nil, fmt.Errorf("failed to list the PlacementDecisions of the Placement %s/%s: %v",
+			placement.Namespace, placement.Name, err)
+	}
+	clusterNames := []string{}
+	for _, decision := range decisions.Items {
+		for _, clusterDecision := range decision.Status.Decisions {
+			clusterNames = append(clusterNames, clusterDecision.ClusterName)
+		}
+	}
+	return clusterNames, nil
+}
+
+// formatAddons returns the sorted addons with their AddOnDeploymentConfigs, for example "a, b(kac-1234)"
+func formatAddons(addons map[string]string) string {
+	items := []string{}
+	for addonName, deploymentConfig := range addons {
+		if len(deploymentConfig) != 0 {
+			addonName = fmt.Sprintf("%s(%s)", addonName, deploymentConfig)
+		}
+		items = append(items, addonName)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ", ")
+}
+
+// SwitchOver sets the install strategies of the ClusterManagementAddOns to the Placements of the plan, after
+// verifying that the Placements install the same addons as the KlusterletAddonConfigs. The KlusterletAddonConfigs
+// stop reconciling the addons once their install strategies are switched.
+func (m *Migrator) SwitchOver(ctx context.Context, plan *Plan) error {
+	mismatches, err := m.Verify(ctx, plan)
+	if err != nil {
+		return err
+	}
+	if len(mismatches) != 0 {
+		return fmt.Errorf("the Placements do not install the addons of the KlusterletAddonConfigs:\n  %s",
+			strings.Join(mismatches, "\n  "))
+	}
+
+	for _, addonName := range plan.Addons {
+		cma := &addonv1alpha1.ClusterManagementAddOn{}
+		if err := m.client.Get(ctx, types.NamespacedName{Name: addonName}, cma); err != nil {
+			return err
+		}
+		patch := client.MergeFrom(cma.DeepCopy())
+		cma.Spec.InstallStrategy = plan.InstallStrategies[addonName]
+		if err := m.client.Patch(ctx, cma, patch); err != nil {
+			return fmt.Errorf("failed to switch the install strategy of the ClusterManagementAddOn %s: %v",
+				addonName, err)
+		}
+	}
+	return nil
+}